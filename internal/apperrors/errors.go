@@ -0,0 +1,193 @@
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error is a domain error with a stable machine-readable code.
+// Message is safe to show to clients; the wrapped cause never is.
+type Error struct {
+	Code    string
+	Status  int
+	Message string
+	Fields  []FieldError
+	cause   error
+}
+
+// FieldError describes a single invalid input field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func New(status int, code, message string) *Error {
+	return &Error{Code: code, Status: status, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Code + ": " + e.cause.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is a domain error with the same code,
+// so errors.Is works against the sentinel values below.
+func (e *Error) Is(target error) bool {
+	var t *Error
+	if !errors.As(target, &t) {
+		return false
+	}
+	return t.Code == e.Code
+}
+
+// Wrap returns a copy of e that keeps err as the internal cause
+func (e *Error) Wrap(err error) *Error {
+	cp := *e
+	cp.cause = err
+	return &cp
+}
+
+// WithMessage returns a copy of e with a different client-facing message
+func (e *Error) WithMessage(message string) *Error {
+	cp := *e
+	cp.Message = message
+	return &cp
+}
+
+// WithFields returns a copy of e carrying per-field details
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	cp := *e
+	cp.Fields = append(append([]FieldError(nil), e.Fields...), fields...)
+	return &cp
+}
+
+var (
+	ErrBadRequest   = New(http.StatusBadRequest, "bad_request", "The request could not be understood")
+	ErrInvalidID    = New(http.StatusBadRequest, "invalid_id", "Invalid ID parameter")
+	ErrInvalidBody  = New(http.StatusBadRequest, "invalid_body", "Invalid request body")
+	ErrValidation   = New(http.StatusUnprocessableEntity, "validation_failed", "One or more fields are invalid")
+	ErrUnauthorized = New(http.StatusUnauthorized, "unauthorized", "Missing or invalid token")
+	ErrForbidden    = New(http.StatusForbidden, "forbidden", "You do not have access to this resource")
+	ErrNotFound     = New(http.StatusNotFound, "not_found", "Resource not found")
+	ErrConflict     = New(http.StatusConflict, "conflict", "The request conflicts with the current state")
+	ErrInternal     = New(http.StatusInternalServerError, "internal_error", "An unexpected error occurred")
+
+	ErrInvalidPatch    = New(http.StatusBadRequest, "invalid_patch", "The patch document could not be applied")
+	ErrPatchTestFailed = New(http.StatusConflict, "patch_test_failed", "A test operation in the patch did not match")
+	ErrPatchTooLarge   = New(http.StatusRequestEntityTooLarge, "patch_too_large", "The patch document is too large")
+
+	ErrListNotFound = New(http.StatusNotFound, "list_not_found", "Todo list not found")
+	ErrTaskNotFound = New(http.StatusNotFound, "task_not_found", "Task not found")
+	ErrListInTrash  = New(http.StatusConflict, "list_in_trash", "The task's list is in the trash, restore the list first")
+	ErrTaskChanged  = New(http.StatusConflict, "task_changed", "The task was changed by another request, fetch it and try again")
+
+	ErrStatusTransition = New(http.StatusConflict, "status_transition_not_allowed", "The workflow does not allow this status change")
+	ErrWIPLimit         = New(http.StatusConflict, "wip_limit_reached", "The column has reached its work-in-progress limit")
+
+	ErrDependencyNotFound = New(http.StatusNotFound, "dependency_not_found", "Dependency not found")
+	ErrDependencyCycle    = New(http.StatusConflict, "dependency_cycle", "The dependency would create a cycle")
+	ErrTaskBlocked        = New(http.StatusConflict, "task_blocked", "The task is blocked by open tasks")
+
+	ErrTimeEntryNotFound = New(http.StatusNotFound, "time_entry_not_found", "Time entry not found")
+	ErrTimerRunning      = New(http.StatusConflict, "timer_running", "Another timer is already running, stop it first")
+	ErrNoRunningTimer    = New(http.StatusNotFound, "no_running_timer", "No timer is running")
+
+	ErrCalendarFeedNotFound = New(http.StatusNotFound, "calendar_feed_not_found", "Calendar feed not found")
+	ErrInvalidCalendar      = New(http.StatusUnprocessableEntity, "invalid_calendar", "The file is not a valid iCalendar file")
+	ErrCalendarTooLarge     = New(http.StatusRequestEntityTooLarge, "calendar_too_large", "The calendar file is too large")
+
+	ErrInvalidImport  = New(http.StatusUnprocessableEntity, "invalid_import", "The file is not a valid export")
+	ErrImportTooLarge = New(http.StatusRequestEntityTooLarge, "import_too_large", "The import file is too large")
+
+	ErrImportJobNotFound = New(http.StatusNotFound, "import_job_not_found", "Import job not found")
+	ErrImportJobRunning  = New(http.StatusConflict, "import_job_running", "The import job is running and cannot be deleted")
+	ErrTooManyImports    = New(http.StatusTooManyRequests, "too_many_imports", "Too many imports are waiting, try again when they finish")
+
+	ErrCommentNotFound = New(http.StatusNotFound, "comment_not_found", "Comment not found")
+	ErrVersionNotFound = New(http.StatusNotFound, "version_not_found", "Task version not found")
+	ErrNothingToUndo   = New(http.StatusNotFound, "nothing_to_undo", "There is nothing to undo in this session")
+	ErrUndoConflict    = New(http.StatusConflict, "undo_conflict", "The task was changed afterwards, the change can no longer be undone")
+
+	ErrAttachmentNotFound = New(http.StatusNotFound, "attachment_not_found", "Attachment not found")
+	ErrAttachmentTooLarge = New(http.StatusRequestEntityTooLarge, "attachment_too_large", "The file is too large")
+	ErrAttachmentType     = New(http.StatusUnsupportedMediaType, "attachment_type_not_allowed", "Files of this type are not allowed")
+
+	ErrUserNotFound       = New(http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
+	ErrInvalidCredentials = New(http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
+	ErrUserAlreadyExists  = New(http.StatusConflict, "user_already_exists", "Username already exists")
+	ErrEmailAlreadyUsed   = New(http.StatusConflict, "email_already_used", "The email is used by another account")
+	ErrEmailNotVerified   = New(http.StatusForbidden, "email_not_verified", "Verify your email address first")
+	ErrEmailVerified      = New(http.StatusConflict, "email_already_verified", "The email is already verified")
+	ErrInvalidEmailToken  = New(http.StatusBadRequest, "invalid_verification_token", "The verification link is invalid or has expired")
+	ErrTooManyEmails      = New(http.StatusTooManyRequests, "too_many_emails", "Too many verification emails, try again later")
+	ErrMailUnavailable    = New(http.StatusServiceUnavailable, "mail_unavailable", "The email could not be sent, try again later")
+	ErrAvatarNotFound     = New(http.StatusNotFound, "avatar_not_found", "The user has no avatar")
+	ErrAvatarTooLarge     = New(http.StatusRequestEntityTooLarge, "avatar_too_large", "The image is too large")
+	ErrInvalidImage       = New(http.StatusUnprocessableEntity, "invalid_image", "The file is not a PNG, JPEG or GIF image")
+
+	ErrTwoFactorEnabled      = New(http.StatusConflict, "two_factor_enabled", "Two-factor authentication is already on")
+	ErrTwoFactorDisabled     = New(http.StatusConflict, "two_factor_disabled", "Two-factor authentication is off")
+	ErrTOTPNotEnrolled       = New(http.StatusConflict, "totp_not_enrolled", "Start the authenticator enrollment first")
+	ErrInvalidMFACode        = New(http.StatusUnauthorized, "invalid_mfa_code", "The code is invalid or was already used")
+	ErrInvalidMFAChallenge   = New(http.StatusUnauthorized, "invalid_mfa_token", "The sign-in attempt is invalid or has expired, sign in again")
+	ErrMFALocked             = New(http.StatusTooManyRequests, "mfa_locked", "Too many invalid codes, try again later")
+	ErrTrustedDeviceNotFound = New(http.StatusNotFound, "trusted_device_not_found", "Trusted device not found")
+
+	ErrOIDCProviderNotFound = New(http.StatusNotFound, "identity_provider_not_found", "Identity provider not found")
+	ErrInvalidOIDCState     = New(http.StatusBadRequest, "invalid_oidc_state", "The sign-in is invalid or has expired, start it again")
+	ErrOIDCFailed           = New(http.StatusUnauthorized, "oidc_failed", "The identity provider did not confirm the sign-in")
+	ErrOIDCUnavailable      = New(http.StatusBadGateway, "oidc_unavailable", "The identity provider cannot be reached, try again later")
+	ErrOIDCDomain           = New(http.StatusForbidden, "oidc_domain_not_allowed", "Accounts with this email domain cannot sign in")
+	ErrOIDCNoAccount        = New(http.StatusForbidden, "oidc_no_account", "No account is linked to this identity")
+	ErrOIDCAccountExists    = New(http.StatusConflict, "oidc_account_exists", "An account with this email exists; sign in with its password and link the identity from the profile")
+	ErrIdentityLinked       = New(http.StatusConflict, "identity_already_linked", "The identity is linked to another account")
+	ErrIdentityNotFound     = New(http.StatusNotFound, "identity_not_found", "Linked identity not found")
+	ErrLastSignInMethod     = New(http.StatusConflict, "last_sign_in_method", "The account has no password; this identity is the only way to sign in")
+
+	// Коды ошибок OAuth2 совпадают с RFC 6749, их видят сторонние приложения
+	ErrOAuthInvalidRequest          = New(http.StatusBadRequest, "invalid_request", "The request is missing a parameter or is malformed")
+	ErrOAuthInvalidClient           = New(http.StatusUnauthorized, "invalid_client", "Unknown client or wrong client secret")
+	ErrOAuthInvalidGrant            = New(http.StatusBadRequest, "invalid_grant", "The code or refresh token is invalid, expired or was issued to another client")
+	ErrOAuthUnsupportedGrantType    = New(http.StatusBadRequest, "unsupported_grant_type", "Only authorization_code and refresh_token grants are supported")
+	ErrOAuthUnsupportedResponseType = New(http.StatusBadRequest, "unsupported_response_type", "Only the code response type is supported")
+	ErrOAuthInvalidScope            = New(http.StatusBadRequest, "invalid_scope", "The scope is empty or unknown")
+	ErrOAuthAccessDenied            = New(http.StatusForbidden, "access_denied", "The user denied the authorization")
+	ErrOAuthInvalidRedirect         = New(http.StatusBadRequest, "invalid_redirect_uri", "Unknown client_id or the redirect_uri is not registered for it")
+	ErrOAuthClientNotFound          = New(http.StatusNotFound, "oauth_client_not_found", "OAuth client not found")
+	ErrOAuthClientPublic            = New(http.StatusConflict, "oauth_client_public", "The client is public and has no secret")
+	ErrOAuthAuthorizationNotFound   = New(http.StatusNotFound, "oauth_authorization_not_found", "The app is not authorized")
+	ErrInsufficientScope            = New(http.StatusForbidden, "insufficient_scope", "The access token does not grant access to this resource")
+
+	ErrAccountPendingDeletion = New(http.StatusForbidden, "account_pending_deletion", "The account is scheduled for deletion, restore it to sign in")
+	ErrAccountNotDeleted      = New(http.StatusConflict, "account_not_deleted", "The account is not scheduled for deletion")
+	ErrReauthRequired         = New(http.StatusForbidden, "reauthentication_required", "Sign in again to confirm this action")
+	ErrAccountExportNotFound  = New(http.StatusNotFound, "account_export_not_found", "Account export not found")
+	ErrAccountExportNotReady  = New(http.StatusConflict, "account_export_not_ready", "The account export has not been built yet")
+)
+
+// Validation builds a validation error for the given fields
+func Validation(fields ...FieldError) *Error {
+	return ErrValidation.WithFields(fields...)
+}
+
+// Field is a shorthand for a single FieldError
+func Field(field, code, message string) FieldError {
+	return FieldError{Field: field, Code: code, Message: message}
+}
+
+// From converts any error into a domain error. Unknown errors become
+// ErrInternal with the original kept as the hidden cause.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ErrInternal.Wrap(err)
+}
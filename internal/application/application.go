@@ -2,31 +2,87 @@ package application
 
 import (
 	"RestAPI/internal/database"
+	"RestAPI/internal/jobs"
+	"RestAPI/internal/repository"
+	"RestAPI/internal/responses"
 	"RestAPI/internal/routes"
 	"RestAPI/internal/server"
+	"RestAPI/internal/service"
 	"RestAPI/pkg/validator"
 	"context"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"time"
 )
 
 type Application struct {
-	Server *server.Server
+	Server        *server.Server
+	TrashPurger   *jobs.TrashPurger
+	AuditPurger   *jobs.AuditPurger
+	BlobCleaner   *jobs.BlobCleaner
+	ImportWorker  *jobs.ImportWorker
+	AccountWorker *jobs.AccountWorker
 }
 
 func NewApp() *Application {
 	e := echo.New()
 	database.LoadConfig()
 	database.InitDB()
+	database.InitStorage()
+	database.InitMailer()
 	db := database.DB
+	// ID запроса попадает в журнал аудита
+	e.Use(middleware.RequestID())
 	routes.SetupRoutes(e, db)
 	e.Validator = validator.NewValidator()
+	e.HTTPErrorHandler = responses.HTTPErrorHandler
+
+	transactor := repository.NewTransactor(db)
+	auditRepo := repository.NewAuditRepository(db)
+	auditor := service.NewAuditor(auditRepo, repository.NewHistoryRepository(db))
+	trashRepo := repository.NewTrashRepository(db)
+	trashService := service.NewTrashService(trashRepo, auditor, transactor)
+	trashPurger := jobs.NewTrashPurger(trashService, database.AppConfig.Trash.Retention, database.AppConfig.Trash.PurgeInterval)
+	auditService := service.NewAuditService(auditRepo, transactor)
+	auditPurger := jobs.NewAuditPurger(auditService, database.AppConfig.Audit.Retention, database.AppConfig.Audit.PurgeInterval)
+	taskRepo := repository.NewTaskRepository(db)
+	// Лимиты загрузки очистке не нужны
+	attachmentService := service.NewAttachmentService(repository.NewAttachmentRepository(db), taskRepo,
+		database.Storage, service.AttachmentLimits{}, auditor, transactor)
+	blobCleaner := jobs.NewBlobCleaner(attachmentService, database.AppConfig.Attachments.CleanupInterval)
+	exportService := service.NewExportService(repository.NewExportRepository(db), repository.NewTodoListRepository(db), taskRepo,
+		repository.NewStatusRepository(db), repository.NewDependencyRepository(db), repository.NewCommentRepository(db),
+		repository.NewTimeEntryRepository(db), auditor, transactor)
+	importWorker := jobs.NewImportWorker(service.NewImportJobService(repository.NewImportJobRepository(db), exportService),
+		database.AppConfig.Imports.PollInterval, database.AppConfig.Imports.Retention)
+	accountSettings := service.AccountSettings{
+		DeletionGrace:   database.AppConfig.Accounts.DeletionGrace,
+		ExportRetention: database.AppConfig.Accounts.ExportRetention,
+	}
+	accountService := service.NewAccountService(repository.NewAccountRepository(db), repository.NewUserRepository(db), trashRepo,
+		auditRepo, exportService, database.Storage, accountSettings, auditor, transactor)
+	accountWorker := jobs.NewAccountWorker(accountService, database.AppConfig.Accounts.PollInterval, database.AppConfig.Accounts.PurgeInterval)
 
 	srv := server.NewServer(e, ":8080")
-	return &Application{Server: srv}
+	return &Application{
+		Server:        srv,
+		TrashPurger:   trashPurger,
+		AuditPurger:   auditPurger,
+		BlobCleaner:   blobCleaner,
+		ImportWorker:  importWorker,
+		AccountWorker: accountWorker,
+	}
 }
 
 func (a *Application) Run() {
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go a.TrashPurger.Run(jobsCtx)
+	go a.AuditPurger.Run(jobsCtx)
+	go a.BlobCleaner.Run(jobsCtx)
+	go a.ImportWorker.Run(jobsCtx)
+	go a.AccountWorker.Run(jobsCtx)
+
 	a.Server.Start()
 
 	a.Server.WaitForShutdownSignal()
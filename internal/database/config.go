@@ -1,15 +1,37 @@
 package database
 
 import (
+	"RestAPI/pkg/blobstore"
+	"RestAPI/pkg/mailer"
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
 	"log"
 	"os"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Port string
-	DB   DBConfig
+	Port  string
+	DB    DBConfig
+	Trash TrashConfig
+	Audit AuditConfig
+	// Вложения задач
+	Attachments AttachmentsConfig
+	// Импорт из других приложений
+	Imports ImportsConfig
+	// Выгрузка и удаление аккаунтов
+	Accounts AccountsConfig
+	// Отправка писем
+	Mail MailConfig
+	// Подтверждение email
+	EmailVerification EmailVerificationConfig
+	// Двухфакторная аутентификация
+	TwoFactor TwoFactorConfig
+	// Вход через внешних провайдеров OpenID Connect
+	OIDC OIDCConfig
+	// Доступ сторонних приложений по OAuth2
+	OAuth OAuthConfig
 }
 
 type DBConfig struct {
@@ -21,8 +43,135 @@ type DBConfig struct {
 	SSLMode  string
 }
 
+type TrashConfig struct {
+	// Как долго удалённые списки и задачи хранятся в корзине, 0 - хранить всегда
+	Retention time.Duration
+	// Как часто фоновая задача очищает корзину
+	PurgeInterval time.Duration
+}
+
+type AuditConfig struct {
+	// Сколько хранить записи журнала аудита, 0 - хранить всегда
+	Retention time.Duration
+	// Как часто удалять устаревшие записи
+	PurgeInterval time.Duration
+}
+
+type AttachmentsConfig struct {
+	// Максимальный размер файла в байтах
+	MaxSize int64
+	// Разрешённые MIME-типы; "image/*" разрешает любые изображения
+	AllowedTypes []string
+	// Как часто удалять файлы, на которые не ссылается ни одно вложение
+	CleanupInterval time.Duration
+	Storage         StorageConfig
+}
+
+type StorageConfig struct {
+	// local или s3
+	Driver string
+	// Каталог для драйвера local
+	Path string
+	S3   blobstore.S3Config
+}
+
+type ImportsConfig struct {
+	// Как часто фоновая задача проверяет очередь импорта
+	PollInterval time.Duration
+	// Сколько хранить завершённые задания импорта
+	Retention time.Duration
+}
+
+type AccountsConfig struct {
+	// Сколько удалённый аккаунт можно восстановить
+	DeletionGrace time.Duration
+	// Сколько хранится готовая выгрузка данных аккаунта
+	ExportRetention time.Duration
+	// Как часто проверять очередь выгрузок
+	PollInterval time.Duration
+	// Как часто удалять устаревшие выгрузки и аккаунты после льготного периода
+	PurgeInterval time.Duration
+}
+
+type MailConfig struct {
+	// smtp, file или log
+	Driver string
+	// Адрес отправителя, например "Todo <noreply@example.com>"
+	From string
+	// Каталог для драйвера file
+	Path string
+	SMTP mailer.SMTPConfig
+}
+
+type EmailVerificationConfig struct {
+	// none, restrict или block: что запрещено пользователю без подтверждённого email
+	Policy string
+	// Ссылка из письма, {token} заменяется токеном
+	URL string
+	// Сколько действует ссылка
+	TokenTTL time.Duration
+	// Сколько ждать перед повторной отправкой письма
+	ResendInterval time.Duration
+	// Сколько писем можно отправить пользователю за сутки
+	DailyLimit int
+}
+
+type TwoFactorConfig struct {
+	// Название сервиса в приложении-аутентификаторе
+	Issuer string
+	// Сколько действует токен между вводом пароля и кода
+	ChallengeTTL time.Duration
+	// Сколько запомненное устройство входит без кода, 0 - не запоминать
+	RememberDevice time.Duration
+}
+
+type OIDCConfig struct {
+	// Адрес возврата от провайдера, {provider} заменяется именем провайдера
+	RedirectURL string
+	// Сколько начатый вход ждёт возврата от провайдера
+	StateTTL  time.Duration
+	Providers []OIDCProviderConfig
+}
+
+type OIDCProviderConfig struct {
+	// Имя провайдера в URL, например company
+	Name        string `mapstructure:"name"`
+	DisplayName string `mapstructure:"display_name"`
+	Issuer      string `mapstructure:"issuer"`
+	ClientID    string `mapstructure:"client_id"`
+	// Читается из OIDC_<NAME>_CLIENT_SECRET
+	ClientSecret string   `mapstructure:"-"`
+	Scopes       []string `mapstructure:"scopes"`
+	// Создавать пользователя при первом входе
+	AutoProvision bool `mapstructure:"auto_provision"`
+	// Привязывать вход к пользователю с тем же подтверждённым email
+	LinkByEmail bool `mapstructure:"link_by_email"`
+	// Допустимые домены email, пусто - любые
+	AllowedDomains []string `mapstructure:"allowed_domains"`
+}
+
+type OAuthConfig struct {
+	// Страница веб-приложения, где пользователь разрешает доступ приложению
+	ConsentURL string
+	// Сколько живёт код авторизации
+	CodeTTL time.Duration
+	// Сколько живут токены доступа и обновления
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
 var AppConfig Config
 
+// interval reads how often a background job runs; time.NewTicker panics
+// on a zero or negative interval, so the app stops at startup instead
+func interval(key string) time.Duration {
+	d := viper.GetDuration(key)
+	if d <= 0 {
+		log.Fatalf("Интервал %s должен быть больше нуля, задано %q", key, viper.GetString(key))
+	}
+	return d
+}
+
 func LoadConfig() {
 	err := godotenv.Load()
 	if err != nil {
@@ -32,11 +181,55 @@ func LoadConfig() {
 	viper.SetConfigFile("config.yml")
 	viper.AddConfigPath(".")
 	viper.AutomaticEnv()
+	viper.SetDefault("trash.retention", "720h")
+	viper.SetDefault("trash.purge_interval", "1h")
+	viper.SetDefault("audit.retention", "8760h")
+	viper.SetDefault("audit.purge_interval", "24h")
+	viper.SetDefault("attachments.max_size", "25MB")
+	viper.SetDefault("attachments.allowed_types", []string{"image/*", "application/pdf", "text/plain"})
+	viper.SetDefault("attachments.cleanup_interval", "1h")
+	viper.SetDefault("attachments.storage.driver", "local")
+	viper.SetDefault("attachments.storage.path", "data/attachments")
+	viper.SetDefault("imports.poll_interval", "2s")
+	viper.SetDefault("imports.retention", "168h")
+	viper.SetDefault("accounts.deletion_grace", "720h")
+	viper.SetDefault("accounts.export_retention", "168h")
+	viper.SetDefault("accounts.poll_interval", "5s")
+	viper.SetDefault("accounts.purge_interval", "1h")
+	viper.SetDefault("mail.driver", "log")
+	viper.SetDefault("mail.from", "Todo <noreply@localhost>")
+	viper.SetDefault("mail.path", "data/mail")
+	viper.SetDefault("mail.smtp.port", 587)
+	viper.SetDefault("mail.smtp.security", "starttls")
+	viper.SetDefault("mail.smtp.timeout", "30s")
+	viper.SetDefault("email_verification.policy", "none")
+	viper.SetDefault("email_verification.url", "http://localhost:8080/verify-email?token={token}")
+	viper.SetDefault("email_verification.token_ttl", "24h")
+	viper.SetDefault("email_verification.resend_interval", "1m")
+	viper.SetDefault("email_verification.daily_limit", 5)
+	viper.SetDefault("two_factor.issuer", "Todo")
+	viper.SetDefault("two_factor.challenge_ttl", "5m")
+	viper.SetDefault("two_factor.remember_device", "720h")
+	viper.SetDefault("oidc.redirect_url", "http://localhost:8080/auth/oidc/{provider}/callback")
+	viper.SetDefault("oidc.state_ttl", "10m")
+	viper.SetDefault("oauth.consent_url", "http://localhost:3000/oauth/consent")
+	viper.SetDefault("oauth.code_ttl", "1m")
+	viper.SetDefault("oauth.access_token_ttl", "1h")
+	viper.SetDefault("oauth.refresh_token_ttl", "720h")
 
 	if err := viper.ReadInConfig(); err != nil {
 		log.Printf("Не удалось загрузить config.yml: %v", err)
 	}
 
+	var oidcProviders []OIDCProviderConfig
+	if err := viper.UnmarshalKey("oidc.providers", &oidcProviders); err != nil {
+		log.Printf("Не удалось прочитать провайдеров OIDC: %v", err)
+	}
+	for i := range oidcProviders {
+		env := "OIDC_" + strings.ToUpper(strings.ReplaceAll(oidcProviders[i].Name, "-", "_")) + "_CLIENT_SECRET"
+		oidcProviders[i].ClientSecret = os.Getenv(env)
+	}
+
 	AppConfig = Config{
 		Port: viper.GetString("PORT"),
 		DB: DBConfig{
@@ -47,5 +240,77 @@ func LoadConfig() {
 			DBName:   viper.GetString("db.dbname"),
 			SSLMode:  viper.GetString("db.sslmode"),
 		},
+		Trash: TrashConfig{
+			Retention:     viper.GetDuration("trash.retention"),
+			PurgeInterval: interval("trash.purge_interval"),
+		},
+		Audit: AuditConfig{
+			Retention:     viper.GetDuration("audit.retention"),
+			PurgeInterval: interval("audit.purge_interval"),
+		},
+		Attachments: AttachmentsConfig{
+			MaxSize:         int64(viper.GetSizeInBytes("attachments.max_size")),
+			AllowedTypes:    viper.GetStringSlice("attachments.allowed_types"),
+			CleanupInterval: interval("attachments.cleanup_interval"),
+			Storage: StorageConfig{
+				Driver: viper.GetString("attachments.storage.driver"),
+				Path:   viper.GetString("attachments.storage.path"),
+				S3: blobstore.S3Config{
+					Endpoint:        viper.GetString("attachments.storage.s3.endpoint"),
+					Region:          viper.GetString("attachments.storage.s3.region"),
+					Bucket:          viper.GetString("attachments.storage.s3.bucket"),
+					AccessKeyID:     viper.GetString("attachments.storage.s3.access_key_id"),
+					SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+					PathStyle:       viper.GetBool("attachments.storage.s3.path_style"),
+				},
+			},
+		},
+		Imports: ImportsConfig{
+			PollInterval: interval("imports.poll_interval"),
+			Retention:    viper.GetDuration("imports.retention"),
+		},
+		Accounts: AccountsConfig{
+			DeletionGrace:   viper.GetDuration("accounts.deletion_grace"),
+			ExportRetention: viper.GetDuration("accounts.export_retention"),
+			PollInterval:    interval("accounts.poll_interval"),
+			PurgeInterval:   interval("accounts.purge_interval"),
+		},
+		Mail: MailConfig{
+			Driver: viper.GetString("mail.driver"),
+			From:   viper.GetString("mail.from"),
+			Path:   viper.GetString("mail.path"),
+			SMTP: mailer.SMTPConfig{
+				Host:     viper.GetString("mail.smtp.host"),
+				Port:     viper.GetInt("mail.smtp.port"),
+				Username: viper.GetString("mail.smtp.username"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+				Security: viper.GetString("mail.smtp.security"),
+				From:     viper.GetString("mail.from"),
+				Timeout:  viper.GetDuration("mail.smtp.timeout"),
+			},
+		},
+		EmailVerification: EmailVerificationConfig{
+			Policy:         viper.GetString("email_verification.policy"),
+			URL:            viper.GetString("email_verification.url"),
+			TokenTTL:       viper.GetDuration("email_verification.token_ttl"),
+			ResendInterval: viper.GetDuration("email_verification.resend_interval"),
+			DailyLimit:     viper.GetInt("email_verification.daily_limit"),
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer:         viper.GetString("two_factor.issuer"),
+			ChallengeTTL:   viper.GetDuration("two_factor.challenge_ttl"),
+			RememberDevice: viper.GetDuration("two_factor.remember_device"),
+		},
+		OIDC: OIDCConfig{
+			RedirectURL: viper.GetString("oidc.redirect_url"),
+			StateTTL:    viper.GetDuration("oidc.state_ttl"),
+			Providers:   oidcProviders,
+		},
+		OAuth: OAuthConfig{
+			ConsentURL:      viper.GetString("oauth.consent_url"),
+			CodeTTL:         viper.GetDuration("oauth.code_ttl"),
+			AccessTokenTTL:  viper.GetDuration("oauth.access_token_ttl"),
+			RefreshTokenTTL: viper.GetDuration("oauth.refresh_token_ttl"),
+		},
 	}
 }
@@ -26,7 +26,29 @@ func InitDB() {
 	err = DB.AutoMigrate(
 		&models.User{},
 		&models.TodoList{},
+		&models.Status{},
 		&models.Task{},
+		&models.TaskDependency{},
+		&models.TimeEntry{},
+		&models.CalendarFeed{},
+		&models.ImportJob{},
+		&models.AccountExport{},
+		&models.EmailVerification{},
+		&models.RecoveryCode{},
+		&models.TrustedDevice{},
+		&models.UserIdentity{},
+		&models.OIDCLogin{},
+		&models.OAuthClient{},
+		&models.OAuthCode{},
+		&models.OAuthToken{},
+		&models.OAuthConsent{},
+		&models.AuditEntry{},
+		&models.TaskVersion{},
+		&models.Comment{},
+		&models.CommentRevision{},
+		&models.Mention{},
+		&models.Blob{},
+		&models.Attachment{},
 	)
 	if err != nil {
 		log.Fatalf("Не удалось выполнить миграцию: %v", err)
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"RestAPI/pkg/mailer"
+	"fmt"
+	"log"
+)
+
+// Mailer sends the emails of the application
+var Mailer mailer.Mailer
+
+func InitMailer() {
+	cfg := AppConfig.Mail
+	var err error
+	switch cfg.Driver {
+	case "smtp":
+		Mailer, err = mailer.NewSMTP(cfg.SMTP)
+	case "file":
+		Mailer, err = mailer.NewFile(cfg.Path, cfg.From)
+	case "log":
+		Mailer = mailer.NewLog()
+	default:
+		err = fmt.Errorf("unknown driver %q, expected smtp, file or log", cfg.Driver)
+	}
+	if err != nil {
+		log.Fatalf("Не удалось настроить отправку писем: %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"RestAPI/pkg/blobstore"
+	"fmt"
+	"log"
+)
+
+// Storage holds the files of task attachments
+var Storage blobstore.Store
+
+func InitStorage() {
+	cfg := AppConfig.Attachments.Storage
+	var err error
+	switch cfg.Driver {
+	case "local":
+		Storage, err = blobstore.NewLocal(cfg.Path)
+	case "s3":
+		Storage, err = blobstore.NewS3(cfg.S3)
+	default:
+		err = fmt.Errorf("unknown driver %q, expected local or s3", cfg.Driver)
+	}
+	if err != nil {
+		log.Fatalf("Не удалось подключить хранилище вложений: %v", err)
+	}
+}
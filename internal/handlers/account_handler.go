@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// DeleteAccountRequest confirms the deletion of the caller's account
+// swagger:model
+type DeleteAccountRequest struct {
+	// Current password of the account. Accounts without a password, created by
+	// signing in with an identity provider, sign in with it again instead and
+	// delete the account within 10 minutes.
+	// example: P@ssw0rd!
+	Password string `json:"password"`
+}
+
+// DeleteAccountResponse tells until when a deleted account can be restored
+// swagger:model
+type DeleteAccountResponse struct {
+	DeleteAfter time.Time `json:"delete_after"`
+}
+
+type AccountHandler interface {
+	ExportAccountHandler(c echo.Context) error
+	GetAccountExportHandler(c echo.Context) error
+	DownloadAccountExportHandler(c echo.Context) error
+	DeleteAccountHandler(c echo.Context) error
+	RestoreAccountHandler(c echo.Context) error
+}
+
+type accountHandler struct {
+	accountService service.AccountService
+	userService    service.UserService
+}
+
+func NewAccountHandler(accountService service.AccountService, userService service.UserService) AccountHandler {
+	return &accountHandler{accountService: accountService, userService: userService}
+}
+
+// ExportAccountHandler godoc
+// @Summary Export all account data
+// @Description Start building a zip of everything stored about the caller: account.json with the profile, avatar.png, export.json with the lists and tasks as in GET /export,
+// @Description trash.json, audit_log.json, mentions.json, calendar_feeds.json, import_jobs.json and the files of attachments.
+// @Description An export already in progress is returned instead of a new one. Poll the export in Location and download it once it succeeded.
+// @Tags account
+// @Security Bearer
+// @Produce json
+// @Success 202 {object} models.AccountExport
+// @Header 202 {string} Location "URL of the export"
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/export [get]
+func (h *accountHandler) ExportAccountHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	export, err := h.accountService.RequestExport(userID)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/me/exports/%d", export.ID))
+	return c.JSON(http.StatusAccepted, export)
+}
+
+// GetAccountExportHandler godoc
+// @Summary Get account export
+// @Description Status of an account export. Archives can be downloaded until expires_at.
+// @Tags account
+// @Security Bearer
+// @Produce json
+// @Param id path int true "Export ID"
+// @Success 200 {object} models.AccountExport
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/exports/{id} [get]
+func (h *accountHandler) GetAccountExportHandler(c echo.Context) error {
+	exportID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	export, err := h.accountService.GetExport(exportID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, export)
+}
+
+// DownloadAccountExportHandler godoc
+// @Summary Download account export
+// @Tags account
+// @Security Bearer
+// @Produce application/zip
+// @Param id path int true "Export ID"
+// @Success 200 {file} file "Zip archive"
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/exports/{id}/download [get]
+func (h *accountHandler) DownloadAccountExportHandler(c echo.Context) error {
+	exportID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	export, archive, err := h.accountService.OpenExport(c.Request().Context(), exportID, userID)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	filename := "account-" + export.CreatedAt.Format("20060102-150405") + ".zip"
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "application/zip")
+	header.Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	header.Set("Cache-Control", "private, no-store")
+	http.ServeContent(c.Response(), c.Request(), "", export.UpdatedAt, archive)
+	return nil
+}
+
+// DeleteAccountHandler godoc
+// @Summary Delete account
+// @Description Schedule the caller's account for deletion. Its tokens stop working at once and signing in is refused;
+// @Description until delete_after the account can be restored with POST /me/restore. Afterwards the account is erased
+// @Description with its lists, tasks, comments, attachments, time entries, calendar feeds, jobs, assignments and audit log.
+// @Description Changes the user made to lists of others stay in their audit logs and task histories, with actor_id -1.
+// @Tags account
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param request body DeleteAccountRequest true "Password confirmation"
+// @Success 202 {object} DeleteAccountResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me [delete]
+func (h *accountHandler) DeleteAccountHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	var req DeleteAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	user, err := h.accountService.WithActor(actor(c)).DeleteAccount(userID, req.Password, utils.GetAuthTime(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusAccepted, DeleteAccountResponse{DeleteAfter: *user.DeleteAfter})
+}
+
+// RestoreAccountHandler godoc
+// @Summary Restore deleted account
+// @Description Cancel the deletion of an account during its grace period and sign in. Takes the credentials, as the tokens of the account no longer work.
+// @Description With two-factor authentication on the sign-in is completed at /login/mfa, as after /login.
+// @Description Accounts without a password are restored by signing in at /auth/oidc/{provider}/login?restore=true.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Param request body LoginRequest true "Credentials"
+// @Success 200 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/restore [post]
+func (h *accountHandler) RestoreAccountHandler(c echo.Context) error {
+	var req LoginRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	if err := h.accountService.WithActor(actor(c)).RestoreAccount(req.Username, req.Password); err != nil {
+		return err
+	}
+	result, err := h.userService.LoginUser(req.Username, req.Password, req.DeviceToken)
+	if err != nil {
+		return err
+	}
+	return loginResponse(c, result)
+}
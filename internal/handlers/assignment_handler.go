@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+type AssignmentHandler interface {
+	AssignTaskHandler(c echo.Context) error
+	UnassignTaskHandler(c echo.Context) error
+	GetMyTasksHandler(c echo.Context) error
+}
+
+type assignmentHandler struct {
+	assignmentService service.AssignmentService
+	profileService    service.ProfileService
+}
+
+func NewAssignmentHandler(assignmentService service.AssignmentService, profileService service.ProfileService) AssignmentHandler {
+	return &assignmentHandler{assignmentService: assignmentService, profileService: profileService}
+}
+
+// AssignTaskRequest model
+// swagger:model
+type AssignTaskRequest struct {
+	// ID of the user to assign, or give username instead
+	// example: 4
+	UserID int `json:"user_id"`
+
+	// example: john_doe
+	Username string `json:"username"`
+}
+
+// AssignTaskHandler godoc
+// @Summary Assign task
+// @Description Add a user with access to the list to the task's assignees and record an assign event in the audit log.
+// @Description Assigning an existing assignee again changes nothing.
+// @Tags tasks
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param request body handlers.AssignTaskRequest true "Assignee"
+// @Success 200 {object} models.Task
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/assignees [post]
+func (h *assignmentHandler) AssignTaskHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	var req AssignTaskRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	assigneeID := req.UserID
+	if assigneeID == 0 && req.Username != "" {
+		if assigneeID, err = h.assignmentService.FindAssignee(req.Username); err != nil {
+			return err
+		}
+	}
+
+	task, err := h.assignmentService.WithActor(actor(c)).AssignTask(listID, taskID, userID, assigneeID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, task)
+}
+
+// UnassignTaskHandler godoc
+// @Summary Unassign task
+// @Description Remove a user from the task's assignees and record an unassign event in the audit log
+// @Tags tasks
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param user_id path int true "Assignee user ID"
+// @Success 200 {object} models.Task
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/assignees/{user_id} [delete]
+func (h *assignmentHandler) UnassignTaskHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	assigneeID, err := utils.GetParam(c, "user_id")
+	if err != nil {
+		return err
+	}
+	task, err := h.assignmentService.WithActor(actor(c)).UnassignTask(listID, taskID, userID, assigneeID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, task)
+}
+
+// GetMyTasksHandler godoc
+// @Summary Get my tasks
+// @Description Tasks of every list the caller can access, oldest first unless sorted otherwise.
+// @Description Accepts the filters of the per-list task search.
+// @Tags tasks
+// @Security Bearer
+// @Produce json
+// @Param assigned query bool false "Only tasks assigned (true) or not assigned (false) to the caller"
+// @Param status query string false "open, completed or all"
+// @Param completed query bool false "Only completed or only open tasks"
+// @Param priority query string false "Only tasks with this priority (none, low, medium, high, urgent)"
+// @Param assignee query int false "Only tasks assigned to this user"
+// @Param created_after query string false "Created at or after (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param created_before query string false "Created before (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param updated_after query string false "Updated at or after (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param updated_before query string false "Updated before (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param completed_after query string false "Completed at or after (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param completed_before query string false "Completed before (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param sort query string false "created_at, updated_at, completed_at, title, priority or due_at; prefix with - for descending order"
+// @Param limit query int false "Page size, 50 by default, at most 100"
+// @Param offset query int false "Number of tasks to skip"
+// @Success 200 {array} models.Task
+// @Failure 401 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/tasks [get]
+func (h *assignmentHandler) GetMyTasksHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	settings, err := h.profileService.DateSettings(userID)
+	if err != nil {
+		return err
+	}
+	filter, err := taskFilter(c, settings.Location)
+	if err != nil {
+		return err
+	}
+
+	var fields []apperrors.FieldError
+	var assigned *bool
+	if raw := c.QueryParam("assigned"); raw != "" {
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			fields = append(fields, apperrors.Field("assigned", "boolean", "must be true or false"))
+		} else {
+			assigned = &value
+		}
+	}
+	switch status := c.QueryParam("status"); status {
+	case "", "all":
+	case "open", "completed":
+		completed := status == "completed"
+		if filter.Completed != nil && *filter.Completed != completed {
+			fields = append(fields, apperrors.Field("status", "conflict", "contradicts the completed filter"))
+		}
+		filter.Completed = &completed
+	default:
+		fields = append(fields, apperrors.Field("status", "oneof", "must be one of: open completed all"))
+	}
+	page := map[string]int{}
+	for _, param := range []string{"limit", "offset"} {
+		raw := c.QueryParam(param)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			fields = append(fields, apperrors.Field(param, "integer", "must be an integer"))
+			continue
+		}
+		page[param] = n
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation(fields...)
+	}
+
+	tasks, err := h.assignmentService.GetUserTasks(userID, assigned, filter, page["limit"], page["offset"])
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, tasks)
+}
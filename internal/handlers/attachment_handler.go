@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// attachmentField is the multipart form field carrying the file
+const attachmentField = "file"
+
+type AttachmentHandler interface {
+	GetAttachmentsHandler(c echo.Context) error
+	PostAttachmentHandler(c echo.Context) error
+	DownloadAttachmentHandler(c echo.Context) error
+	DeleteAttachmentHandler(c echo.Context) error
+}
+
+type attachmentHandler struct {
+	attachmentService service.AttachmentService
+}
+
+func NewAttachmentHandler(attachmentService service.AttachmentService) AttachmentHandler {
+	return &attachmentHandler{attachmentService: attachmentService}
+}
+
+// GetAttachmentsHandler godoc
+// @Summary Get attachments
+// @Description Files attached to the task, oldest first
+// @Tags attachments
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Success 200 {array} models.Attachment
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/attachments [get]
+func (h *attachmentHandler) GetAttachmentsHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	attachments, err := h.attachmentService.GetAttachments(listID, taskID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, attachments)
+}
+
+// PostAttachmentHandler godoc
+// @Summary Upload attachment
+// @Description Attach a file to the task. The file is streamed, its type is detected from the content and checked against the configured size and type limits.
+// @Tags attachments
+// @Security Bearer
+// @Accept multipart/form-data
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param file formData file true "The file"
+// @Success 201 {object} models.Attachment
+// @Header 201 {string} Location "URL to download the file"
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 413 {object} responses.Problem
+// @Failure 415 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/attachments [post]
+func (h *attachmentHandler) PostAttachmentHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	// Читаем multipart потоком, чтобы не держать файл в памяти
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		return apperrors.ErrInvalidBody.WithMessage("Expected a multipart/form-data body").Wrap(err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return apperrors.Validation(apperrors.Field(attachmentField, "required", "file is required"))
+		}
+		if err != nil {
+			return apperrors.ErrInvalidBody.Wrap(err)
+		}
+		if part.FormName() != attachmentField {
+			continue
+		}
+
+		attachment, err := h.attachmentService.WithActor(actor(c)).
+			UploadAttachment(c.Request().Context(), listID, taskID, userID, part.FileName(), part)
+		if err != nil {
+			return err
+		}
+		c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/todolists/%d/tasks/%d/attachments/%d", listID, taskID, attachment.ID))
+		return c.JSON(http.StatusCreated, attachment)
+	}
+}
+
+// DownloadAttachmentHandler godoc
+// @Summary Download attachment
+// @Description Stream the file. Single and multiple byte ranges are supported through the Range header; the ETag is the SHA-256 of the content.
+// @Tags attachments
+// @Security Bearer
+// @Produce octet-stream
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param attachment_id path int true "Attachment ID"
+// @Param Range header string false "Byte range, e.g. bytes=0-1023"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 416 {string} string
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/attachments/{attachment_id} [get]
+func (h *attachmentHandler) DownloadAttachmentHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	attachmentID, err := utils.GetParam(c, "attachment_id")
+	if err != nil {
+		return err
+	}
+	attachment, content, err := h.attachmentService.OpenAttachment(c.Request().Context(), listID, taskID, attachmentID, userID)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, attachment.ContentType)
+	header.Set(echo.HeaderContentDisposition, disposition)
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	header.Set("ETag", `"`+attachment.Hash+`"`)
+	header.Set("Cache-Control", "private")
+	// ServeContent обрабатывает Range, If-Range и If-None-Match
+	http.ServeContent(c.Response(), c.Request(), "", attachment.CreatedAt, content)
+	return nil
+}
+
+// DeleteAttachmentHandler godoc
+// @Summary Delete attachment
+// @Tags attachments
+// @Security Bearer
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param attachment_id path int true "Attachment ID"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/attachments/{attachment_id} [delete]
+func (h *attachmentHandler) DeleteAttachmentHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	attachmentID, err := utils.GetParam(c, "attachment_id")
+	if err != nil {
+		return err
+	}
+	if err := h.attachmentService.WithActor(actor(c)).DeleteAttachment(c.Request().Context(), listID, taskID, attachmentID, userID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type AuditHandler interface {
+	GetAuditLogHandler(c echo.Context) error
+	ExportAuditLogHandler(c echo.Context) error
+	AdminAuditLogHandler(c echo.Context) error
+	AdminExportAuditLogHandler(c echo.Context) error
+}
+
+type auditHandler struct {
+	auditService service.AuditService
+}
+
+func NewAuditHandler(auditService service.AuditService) AuditHandler {
+	return &auditHandler{auditService: auditService}
+}
+
+// actor describes the authenticated caller for the audit log
+func actor(c echo.Context) service.Actor {
+	userID, _ := utils.GetUserID(c)
+	sessionID, _ := c.Get("session_id").(string)
+	return service.Actor{
+		UserID:    userID,
+		IP:        c.RealIP(),
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		SessionID: sessionID,
+	}
+}
+
+// GetAuditLogHandler godoc
+// @Summary Get audit log
+// @Description Changes made to the caller's lists and tasks, newest first
+// @Tags audit
+// @Security Bearer
+// @Produce json
+// @Param entity query string false "list, task, comment, attachment, dependency, time_entry or user"
+// @Param id query int false "Entity ID, requires entity"
+// @Param action query string false "create, update, delete, restore or purge"
+// @Param from query string false "Changed at or after (RFC 3339 or YYYY-MM-DD)"
+// @Param to query string false "Changed before (RFC 3339 or YYYY-MM-DD)"
+// @Param limit query int false "Page size, 100 by default, at most 1000"
+// @Param offset query int false "Number of entries to skip"
+// @Success 200 {array} models.AuditEntry
+// @Failure 401 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /audit [get]
+func (h *auditHandler) GetAuditLogHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	filter, err := auditFilter(c, false)
+	if err != nil {
+		return err
+	}
+	entries, err := h.auditService.GetOwnerLog(userID, filter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// ExportAuditLogHandler godoc
+// @Summary Export audit log
+// @Description Download every matching entry about the caller's lists and tasks
+// @Tags audit
+// @Security Bearer
+// @Produce json
+// @Produce text/csv
+// @Param format query string false "json (default) or csv"
+// @Param entity query string false "list, task, comment, attachment, dependency, time_entry or user"
+// @Param id query int false "Entity ID, requires entity"
+// @Param action query string false "create, update, delete, restore or purge"
+// @Param from query string false "Changed at or after (RFC 3339 or YYYY-MM-DD)"
+// @Param to query string false "Changed before (RFC 3339 or YYYY-MM-DD)"
+// @Success 200 {array} models.AuditEntry
+// @Failure 401 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /audit/export [get]
+func (h *auditHandler) ExportAuditLogHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	filter, err := auditFilter(c, false)
+	if err != nil {
+		return err
+	}
+	// Экспорт владельца всегда ограничен его собственными данными
+	filter.OwnerID = userID
+	return h.export(c, filter)
+}
+
+// AdminAuditLogHandler godoc
+// @Summary Search audit log
+// @Description Query the audit log of all users, newest first. Administrators only.
+// @Tags audit
+// @Security Bearer
+// @Produce json
+// @Param entity query string false "list, task, comment, attachment, dependency, time_entry or user"
+// @Param id query int false "Entity ID, requires entity"
+// @Param action query string false "create, update, delete, restore or purge"
+// @Param actor_id query int false "User who made the change"
+// @Param owner_id query int false "Owner of the changed data"
+// @Param from query string false "Changed at or after (RFC 3339 or YYYY-MM-DD)"
+// @Param to query string false "Changed before (RFC 3339 or YYYY-MM-DD)"
+// @Param limit query int false "Page size, 100 by default, at most 1000"
+// @Param offset query int false "Number of entries to skip"
+// @Success 200 {array} models.AuditEntry
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /admin/audit [get]
+func (h *auditHandler) AdminAuditLogHandler(c echo.Context) error {
+	filter, err := auditFilter(c, true)
+	if err != nil {
+		return err
+	}
+	entries, err := h.auditService.Search(filter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// AdminExportAuditLogHandler godoc
+// @Summary Export audit log
+// @Description Download every matching entry of all users. Administrators only.
+// @Tags audit
+// @Security Bearer
+// @Produce json
+// @Produce text/csv
+// @Param format query string false "json (default) or csv"
+// @Param entity query string false "list, task, comment, attachment, dependency, time_entry or user"
+// @Param id query int false "Entity ID, requires entity"
+// @Param action query string false "create, update, delete, restore or purge"
+// @Param actor_id query int false "User who made the change"
+// @Param owner_id query int false "Owner of the changed data"
+// @Param from query string false "Changed at or after (RFC 3339 or YYYY-MM-DD)"
+// @Param to query string false "Changed before (RFC 3339 or YYYY-MM-DD)"
+// @Success 200 {array} models.AuditEntry
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /admin/audit/export [get]
+func (h *auditHandler) AdminExportAuditLogHandler(c echo.Context) error {
+	filter, err := auditFilter(c, true)
+	if err != nil {
+		return err
+	}
+	return h.export(c, filter)
+}
+
+// export streams the entries so large logs are never held in memory
+func (h *auditHandler) export(c echo.Context, filter service.AuditFilter) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		return apperrors.Validation(apperrors.Field("format", "oneof", "must be one of: json csv"))
+	}
+
+	res := c.Response()
+	filename := "audit-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+
+	if format == "csv" {
+		res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		w := csv.NewWriter(res)
+		header := []string{"id", "created_at", "actor_id", "ip", "request_id", "action", "entity", "entity_id", "owner_id", "changes"}
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		return h.auditService.Export(filter, func(entries []models.AuditEntry) error {
+			for _, e := range entries {
+				row := []string{
+					strconv.FormatInt(e.ID, 10),
+					e.CreatedAt.UTC().Format(time.RFC3339),
+					strconv.Itoa(e.ActorID),
+					e.IP,
+					e.RequestID,
+					e.Action,
+					e.Entity,
+					strconv.Itoa(e.EntityID),
+					strconv.Itoa(e.OwnerID),
+					string(e.Changes),
+				}
+				if err := w.Write(row); err != nil {
+					return err
+				}
+			}
+			w.Flush()
+			return w.Error()
+		})
+	}
+
+	res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	enc := json.NewEncoder(res)
+	// Скобка пишется в первом пакете, чтобы ошибка фильтра ещё могла вернуть problem+json
+	separator := "["
+	err := h.auditService.Export(filter, func(entries []models.AuditEntry) error {
+		for _, e := range entries {
+			if _, err := res.Write([]byte(separator)); err != nil {
+				return err
+			}
+			separator = ","
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		res.Flush()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if separator == "[" {
+		_, err = res.Write([]byte("[]\n"))
+		return err
+	}
+	_, err = res.Write([]byte("]\n"))
+	return err
+}
+
+// auditFilter reads the filters from the query string; actor_id and
+// owner_id are only honoured for administrators
+func auditFilter(c echo.Context, admin bool) (service.AuditFilter, error) {
+	filter := service.AuditFilter{
+		Entity: c.QueryParam("entity"),
+		Action: c.QueryParam("action"),
+	}
+	var fields []apperrors.FieldError
+
+	type intParam struct {
+		param string
+		dst   *int
+	}
+	ints := []intParam{
+		{"id", &filter.EntityID},
+		{"limit", &filter.Limit},
+		{"offset", &filter.Offset},
+	}
+	if admin {
+		ints = append(ints, intParam{"actor_id", &filter.ActorID}, intParam{"owner_id", &filter.OwnerID})
+	}
+	for _, p := range ints {
+		raw := c.QueryParam(p.param)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			fields = append(fields, apperrors.Field(p.param, "integer", "must be an integer"))
+			continue
+		}
+		*p.dst = n
+	}
+
+	for _, d := range []struct {
+		param string
+		dst   **time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		raw := c.QueryParam(d.param)
+		if raw == "" {
+			continue
+		}
+		t, err := parseTime(raw)
+		if err != nil {
+			fields = append(fields, apperrors.Field(d.param, "datetime", "must be an RFC 3339 timestamp or a YYYY-MM-DD date"))
+			continue
+		}
+		*d.dst = &t
+	}
+
+	if len(fields) > 0 {
+		return filter, apperrors.Validation(fields...)
+	}
+	return filter, nil
+}
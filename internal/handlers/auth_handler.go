@@ -1,11 +1,10 @@
 package handlers
 
 import (
+	"RestAPI/internal/apperrors"
 	"RestAPI/internal/service"
 	"RestAPI/pkg/utils"
-	"fmt"
 	"github.com/labstack/echo/v4"
-	"log"
 	"net/http"
 )
 
@@ -21,6 +20,10 @@ type RegisterRequest struct {
 	// required: true
 	// example: P@ssw0rd!
 	Password string `json:"password" validate:"required"`
+
+	// Email for verification and notifications; required when the server blocks unverified accounts
+	// example: john@example.com
+	Email string `json:"email"`
 }
 
 // LoginRequest represents user login data
@@ -35,6 +38,32 @@ type LoginRequest struct {
 	// required: true
 	// example: P@ssw0rd!
 	Password string `json:"password" validate:"required"`
+
+	// Token from an earlier login that remembered this device; skips the second factor
+	// example: 9b1f0c7e...
+	DeviceToken string `json:"device_token"`
+}
+
+// MFALoginRequest completes a login that needs a second factor
+// swagger:model
+type MFALoginRequest struct {
+	// Token returned by /login with status mfa_required
+	// required: true
+	MFAToken string `json:"mfa_token" validate:"required"`
+
+	SecondFactorRequest
+
+	// Skip the second factor on this device for a while
+	RememberDevice bool `json:"remember_device"`
+}
+
+// MFALoginResponse carries the token of a completed login
+// swagger:model
+type MFALoginResponse struct {
+	// JWT for the Authorization header
+	Token string `json:"token"`
+	// Send it with later logins from this device; present when remember_device was set
+	DeviceToken string `json:"device_token,omitempty"`
 }
 
 type AuthHandler struct {
@@ -47,33 +76,30 @@ func NewAuthHandler(userService service.UserService) *AuthHandler {
 
 // Register godoc
 // @Summary User registration
-// @Description Create new user account
+// @Description Create new user account. With an email a verification link is sent to it;
+// @Description depending on the server policy unverified accounts cannot sign in or can only read until the email is verified.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body RegisterRequest true "Registration data"
 // @Success 201 {object} responses.Response
-// @Failure 400 {object} responses.Response
-// @Failure 409 {object} responses.Response
-// @Failure 500 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /register [post]
 func (h *AuthHandler) Register(c echo.Context) error {
 	var req RegisterRequest
 	if err := c.Bind(&req); err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid input")
+		return apperrors.ErrInvalidBody.Wrap(err)
 	}
 
 	if err := c.Validate(&req); err != nil {
-		log.Println("Validation error:", err) // Добавьте логирование ошибки
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid input")
+		return err
 	}
 
-	err := h.userService.RegisterUser(req.Username, req.Password)
-	if err != nil {
-		if err == service.ErrUserAlreadyExists {
-			return utils.JSONResponse(c, http.StatusConflict, "error", "Username already exists")
-		}
-		return utils.JSONResponse(c, http.StatusInternalServerError, "error", "Failed to register user")
+	if err := h.userService.RegisterUser(c.Request().Context(), req.Username, req.Password, req.Email); err != nil {
+		return err
 	}
 
 	return utils.JSONResponse(c, http.StatusCreated, "ok", "User registered successfully")
@@ -81,33 +107,77 @@ func (h *AuthHandler) Register(c echo.Context) error {
 
 // Login godoc
 // @Summary User login
-// @Description Authenticate user and get JWT token
+// @Description Authenticate user and get JWT token. With two-factor authentication on the status is mfa_required and the message
+// @Description is a short-lived token to pass to /login/mfa with a code, unless device_token remembers this device.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body LoginRequest true "Credentials"
 // @Success 200 {object} responses.Response
-// @Failure 400 {object} responses.Response
-// @Failure 401 {object} responses.Response
-// @Failure 500 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /login [post]
 func (h *AuthHandler) Login(c echo.Context) error {
 	var req LoginRequest
 	if err := c.Bind(&req); err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid input")
+		return apperrors.ErrInvalidBody.Wrap(err)
 	}
 
 	if err := c.Validate(&req); err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid input")
+		return err
 	}
 
-	token, err := h.userService.LoginUser(req.Username, req.Password)
+	result, err := h.userService.LoginUser(req.Username, req.Password, req.DeviceToken)
 	if err != nil {
-		if err == service.ErrUserNotFound || err == service.ErrInvalidCredentials {
-			return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid credentials")
-		}
-		return utils.JSONResponse(c, http.StatusInternalServerError, "error", "Failed to login")
+		return err
 	}
 
-	return utils.JSONResponse(c, http.StatusOK, "ok", fmt.Sprintf("%s", token))
+	return loginResponse(c, result)
+}
+
+// LoginMFA godoc
+// @Summary Complete login with a second factor
+// @Description Finish a login that returned mfa_required with a code from the authenticator app or an unused recovery code.
+// @Description Five invalid codes in a row lock the second factor for 15 minutes.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body MFALoginRequest true "Challenge and code"
+// @Success 200 {object} MFALoginResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /login/mfa [post]
+func (h *AuthHandler) LoginMFA(c echo.Context) error {
+	var req MFALoginRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+	factor, err := req.secondFactor()
+	if err != nil {
+		return err
+	}
+
+	result, err := h.userService.WithActor(actor(c)).CompleteLogin(req.MFAToken, factor, req.RememberDevice, c.Request().UserAgent())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, MFALoginResponse{Token: result.Token, DeviceToken: result.DeviceToken})
+}
+
+// loginResponse answers with the token, or with the challenge when the
+// login needs a second factor
+func loginResponse(c echo.Context, result *service.LoginResult) error {
+	if result.Challenge != "" {
+		return utils.JSONResponse(c, http.StatusOK, "mfa_required", result.Challenge)
+	}
+	return utils.JSONResponse(c, http.StatusOK, "ok", result.Token)
 }
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// calendarField is the multipart form field carrying an .ics file
+const calendarField = "file"
+
+type CalendarHandler interface {
+	GetFeedHandler(c echo.Context) error
+	GetMyFeedHandler(c echo.Context) error
+	RegenerateMyFeedHandler(c echo.Context) error
+	DeleteMyFeedHandler(c echo.Context) error
+	GetListFeedHandler(c echo.Context) error
+	RegenerateListFeedHandler(c echo.Context) error
+	DeleteListFeedHandler(c echo.Context) error
+	ImportCalendarHandler(c echo.Context) error
+}
+
+type calendarHandler struct {
+	calendarService service.CalendarService
+	profileService  service.ProfileService
+}
+
+func NewCalendarHandler(calendarService service.CalendarService, profileService service.ProfileService) CalendarHandler {
+	return &calendarHandler{calendarService: calendarService, profileService: profileService}
+}
+
+// CalendarFeedResponse model
+// swagger:model
+type CalendarFeedResponse struct {
+	// Null for the feed with the tasks of every list
+	// example: 3
+	ListID *int `json:"list_id"`
+	// Secret URL to subscribe to; anyone who knows it can read the tasks
+	// example: https://api.todolist.com/calendar/4f9c2a0e1b7d.ics
+	URL string `json:"url"`
+	// The same URL with the webcal scheme, which calendar apps open directly
+	// example: webcal://api.todolist.com/calendar/4f9c2a0e1b7d.ics
+	WebcalURL string    `json:"webcal_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func feedResponse(c echo.Context, feed *models.CalendarFeed) CalendarFeedResponse {
+	path := c.Request().Host + "/calendar/" + feed.Token + ".ics"
+	return CalendarFeedResponse{
+		ListID:    feed.ListID,
+		URL:       c.Scheme() + "://" + path,
+		WebcalURL: "webcal://" + path,
+		CreatedAt: feed.CreatedAt,
+	}
+}
+
+// GetFeedHandler godoc
+// @Summary Get calendar feed
+// @Description iCalendar file with the tasks that have a due date, for calendar subscriptions. The token in the URL is the only credential.
+// @Description Tasks are written as VTODOs, or as VEVENTs ending at the due date with as=event for calendar apps without task support.
+// @Tags calendar
+// @Produce text/calendar
+// @Param token path string true "Feed token, followed by .ics"
+// @Param as query string false "todo (default) or event"
+// @Success 200 {string} string "iCalendar data"
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /calendar/{token}.ics [get]
+func (h *calendarHandler) GetFeedHandler(c echo.Context) error {
+	token, ok := strings.CutSuffix(c.Param("file"), ".ics")
+	if !ok {
+		return apperrors.ErrCalendarFeedNotFound
+	}
+	as := c.QueryParam("as")
+	if as != "" && as != "todo" && as != "event" {
+		return apperrors.Validation(apperrors.Field("as", "oneof", "must be one of: todo event"))
+	}
+
+	cal, err := h.calendarService.RenderFeed(token, as == "event")
+	if err != nil {
+		return err
+	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/calendar; charset=utf-8")
+	res.Header().Set(echo.HeaderContentDisposition, `inline; filename="tasks.ics"`)
+	res.WriteHeader(http.StatusOK)
+	return cal.Encode(res)
+}
+
+// GetMyFeedHandler godoc
+// @Summary Get my calendar feed
+// @Description URL of the calendar feed with the tasks of every list; the feed is created on first use
+// @Tags calendar
+// @Security Bearer
+// @Produce json
+// @Success 200 {object} handlers.CalendarFeedResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/calendar [get]
+func (h *calendarHandler) GetMyFeedHandler(c echo.Context) error {
+	return h.getFeed(c, nil)
+}
+
+// RegenerateMyFeedHandler godoc
+// @Summary Regenerate my calendar feed
+// @Description Replace the secret token; the old URL stops working
+// @Tags calendar
+// @Security Bearer
+// @Produce json
+// @Success 200 {object} handlers.CalendarFeedResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/calendar/regenerate [post]
+func (h *calendarHandler) RegenerateMyFeedHandler(c echo.Context) error {
+	return h.regenerateFeed(c, nil)
+}
+
+// DeleteMyFeedHandler godoc
+// @Summary Disable my calendar feed
+// @Tags calendar
+// @Security Bearer
+// @Success 204
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/calendar [delete]
+func (h *calendarHandler) DeleteMyFeedHandler(c echo.Context) error {
+	return h.deleteFeed(c, nil)
+}
+
+// GetListFeedHandler godoc
+// @Summary Get list calendar feed
+// @Description URL of the calendar feed with the tasks of the list; the feed is created on first use
+// @Tags calendar
+// @Security Bearer
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Success 200 {object} handlers.CalendarFeedResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id}/calendar [get]
+func (h *calendarHandler) GetListFeedHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	return h.getFeed(c, &listID)
+}
+
+// RegenerateListFeedHandler godoc
+// @Summary Regenerate list calendar feed
+// @Description Replace the secret token; the old URL stops working
+// @Tags calendar
+// @Security Bearer
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Success 200 {object} handlers.CalendarFeedResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id}/calendar/regenerate [post]
+func (h *calendarHandler) RegenerateListFeedHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	return h.regenerateFeed(c, &listID)
+}
+
+// DeleteListFeedHandler godoc
+// @Summary Disable list calendar feed
+// @Tags calendar
+// @Security Bearer
+// @Param id path int true "Todo List ID"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id}/calendar [delete]
+func (h *calendarHandler) DeleteListFeedHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	return h.deleteFeed(c, &listID)
+}
+
+func (h *calendarHandler) getFeed(c echo.Context, listID *int) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	feed, err := h.calendarService.GetFeed(userID, listID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, feedResponse(c, feed))
+}
+
+func (h *calendarHandler) regenerateFeed(c echo.Context, listID *int) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	feed, err := h.calendarService.RegenerateFeed(userID, listID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, feedResponse(c, feed))
+}
+
+func (h *calendarHandler) deleteFeed(c echo.Context, listID *int) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.calendarService.DeleteFeed(userID, listID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ImportCalendarHandler godoc
+// @Summary Import tasks from iCalendar
+// @Description Create a task in the list for every VTODO of an .ics file, sent as the body (text/calendar) or as the file field of a form.
+// @Description SUMMARY, DESCRIPTION, DUE (or DTSTART), PRIORITY, STATUS, COMPLETED and RRULE are read; VTODOs that cannot be imported are listed in skipped.
+// @Tags calendar
+// @Security Bearer
+// @Accept text/calendar
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Param file formData file false "The .ics file"
+// @Param tz query string false "IANA time zone for times without one, the profile time zone by default"
+// @Success 200 {object} service.CalendarImport
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 413 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id}/calendar/import [post]
+func (h *calendarHandler) ImportCalendarHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	settings, err := h.profileService.DateSettings(userID)
+	if err != nil {
+		return err
+	}
+	loc := settings.Location
+	if tz := c.QueryParam("tz"); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil || tz == "Local" {
+			return apperrors.Validation(apperrors.Field("tz", "timezone", "must be an IANA time zone such as Europe/Moscow"))
+		}
+	}
+
+	var body io.Reader = c.Request().Body
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType == "multipart/form-data" {
+		if body, err = formPart(c, calendarField); err != nil {
+			return err
+		}
+	}
+	result, err := h.calendarService.WithActor(actor(c)).ImportTasks(listID, userID, body, loc)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// formPart finds a field of a multipart body
+func formPart(c echo.Context, field string) (*multipart.Part, error) {
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		return nil, apperrors.ErrInvalidBody.Wrap(err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, apperrors.Validation(apperrors.Field(field, "required", "file is required"))
+		}
+		if err != nil {
+			return nil, apperrors.ErrInvalidBody.Wrap(err)
+		}
+		if part.FormName() == field {
+			return part, nil
+		}
+	}
+}
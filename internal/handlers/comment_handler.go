@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+type CommentHandler interface {
+	GetCommentsHandler(c echo.Context) error
+	GetCommentHandler(c echo.Context) error
+	PostCommentHandler(c echo.Context) error
+	PatchCommentHandler(c echo.Context) error
+	DeleteCommentHandler(c echo.Context) error
+	GetCommentHistoryHandler(c echo.Context) error
+	GetMentionsHandler(c echo.Context) error
+	ReadMentionHandler(c echo.Context) error
+}
+
+type commentHandler struct {
+	commentService service.CommentService
+}
+
+func NewCommentHandler(commentService service.CommentService) CommentHandler {
+	return &commentHandler{commentService: commentService}
+}
+
+// CommentRequest model
+// swagger:model
+type CommentRequest struct {
+	// Markdown text; raw HTML is escaped. Mention users as @username.
+	// required: true
+	// example: Paid, receipt attached. @john_doe please check
+	Body string `json:"body" validate:"required"`
+}
+
+// GetCommentsHandler godoc
+// @Summary Get comments
+// @Description All comments of the task, oldest first
+// @Tags comments
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Success 200 {array} models.Comment
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/comments [get]
+func (h *commentHandler) GetCommentsHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	comments, err := h.commentService.GetComments(listID, taskID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, comments)
+}
+
+// GetCommentHandler godoc
+// @Summary Get comment
+// @Tags comments
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param comment_id path int true "Comment ID"
+// @Success 200 {object} models.Comment
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/comments/{comment_id} [get]
+func (h *commentHandler) GetCommentHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	commentID, err := utils.GetParam(c, "comment_id")
+	if err != nil {
+		return err
+	}
+	comment, err := h.commentService.GetComment(listID, taskID, commentID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, comment)
+}
+
+// PostCommentHandler godoc
+// @Summary Add comment
+// @Description Add a markdown comment to the task; mentioned users get it in their inbox
+// @Tags comments
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param request body handlers.CommentRequest true "Comment"
+// @Success 201 {object} models.Comment
+// @Header 201 {string} Location "URL of the created comment"
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/comments [post]
+func (h *commentHandler) PostCommentHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	var req CommentRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	comment, err := h.commentService.WithActor(actor(c)).CreateComment(listID, taskID, userID, req.Body)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/todolists/%d/tasks/%d/comments/%d", listID, taskID, comment.ID))
+	return c.JSON(http.StatusCreated, comment)
+}
+
+// PatchCommentHandler godoc
+// @Summary Edit comment
+// @Description Replace the body of the caller's own comment; the previous body is kept in the edit history
+// @Tags comments
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param comment_id path int true "Comment ID"
+// @Param request body handlers.CommentRequest true "Comment"
+// @Success 200 {object} models.Comment
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/comments/{comment_id} [patch]
+func (h *commentHandler) PatchCommentHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	commentID, err := utils.GetParam(c, "comment_id")
+	if err != nil {
+		return err
+	}
+	var req CommentRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	comment, err := h.commentService.WithActor(actor(c)).UpdateComment(listID, taskID, commentID, userID, req.Body)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, comment)
+}
+
+// DeleteCommentHandler godoc
+// @Summary Delete comment
+// @Tags comments
+// @Security Bearer
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param comment_id path int true "Comment ID"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/comments/{comment_id} [delete]
+func (h *commentHandler) DeleteCommentHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	commentID, err := utils.GetParam(c, "comment_id")
+	if err != nil {
+		return err
+	}
+	if err := h.commentService.WithActor(actor(c)).DeleteComment(listID, taskID, commentID, userID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetCommentHistoryHandler godoc
+// @Summary Get comment edit history
+// @Description Previous bodies of the comment, newest first
+// @Tags comments
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param comment_id path int true "Comment ID"
+// @Success 200 {array} models.CommentRevision
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/comments/{comment_id}/history [get]
+func (h *commentHandler) GetCommentHistoryHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	commentID, err := utils.GetParam(c, "comment_id")
+	if err != nil {
+		return err
+	}
+	revisions, err := h.commentService.GetCommentHistory(listID, taskID, commentID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// GetMentionsHandler godoc
+// @Summary Get mentions inbox
+// @Description Comments that mention the caller, newest first
+// @Tags comments
+// @Security Bearer
+// @Produce json
+// @Param unread query bool false "Only mentions not marked as read"
+// @Param limit query int false "Page size, 50 by default, at most 100"
+// @Param offset query int false "Number of mentions to skip"
+// @Success 200 {array} models.Mention
+// @Failure 401 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/mentions [get]
+func (h *commentHandler) GetMentionsHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	var fields []apperrors.FieldError
+	unread := false
+	if raw := c.QueryParam("unread"); raw != "" {
+		if unread, err = strconv.ParseBool(raw); err != nil {
+			fields = append(fields, apperrors.Field("unread", "boolean", "must be true or false"))
+		}
+	}
+	page := map[string]int{}
+	for _, param := range []string{"limit", "offset"} {
+		raw := c.QueryParam(param)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			fields = append(fields, apperrors.Field(param, "integer", "must be an integer"))
+			continue
+		}
+		page[param] = n
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation(fields...)
+	}
+
+	mentions, err := h.commentService.GetMentions(userID, unread, page["limit"], page["offset"])
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, mentions)
+}
+
+// ReadMentionHandler godoc
+// @Summary Mark mention as read
+// @Tags comments
+// @Security Bearer
+// @Param id path int true "Mention ID"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/mentions/{id}/read [post]
+func (h *commentHandler) ReadMentionHandler(c echo.Context) error {
+	mentionID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.commentService.MarkMentionRead(mentionID, userID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
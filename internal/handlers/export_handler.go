@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// importField is the multipart form field carrying an import file
+const importField = "file"
+
+type ExportHandler interface {
+	ExportHandler(c echo.Context) error
+	ImportHandler(c echo.Context) error
+}
+
+type exportHandler struct {
+	exportService service.ExportService
+}
+
+func NewExportHandler(exportService service.ExportService) ExportHandler {
+	return &exportHandler{exportService: exportService}
+}
+
+// ExportHandler godoc
+// @Summary Export account
+// @Description Zip with every list of the caller: its workflow and tasks with their dependencies, comments, time entries and attachment details (not contents).
+// @Description json holds export.json; csv holds lists, statuses, tasks, comments, time_entries and attachments CSV files;
+// @Description markdown holds a checklist per list with the task fields, without comments, time entries and attachments.
+// @Tags export
+// @Security Bearer
+// @Produce application/zip
+// @Param format query string false "json (default), csv or markdown"
+// @Success 200 {file} file "Zip archive"
+// @Failure 401 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /export [get]
+func (h *exportHandler) ExportHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	format := c.QueryParam("format")
+	if format == "" {
+		format = service.FormatJSON
+	}
+	if !validExportFormat(format) {
+		return apperrors.Validation(apperrors.Field("format", "oneof", "must be one of: "+strings.Join(service.ExportFormats, " ")))
+	}
+
+	doc, err := h.exportService.Export(userID)
+	if err != nil {
+		return err
+	}
+	res := c.Response()
+	filename := "export-" + doc.ExportedAt.Format("20060102-150405") + "-" + format + ".zip"
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	res.Header().Set(echo.HeaderContentType, "application/zip")
+	res.WriteHeader(http.StatusOK)
+	return service.WriteExport(res, format, doc)
+}
+
+func validExportFormat(format string) bool {
+	for _, f := range service.ExportFormats {
+		if format == f {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportHandler godoc
+// @Summary Import lists and tasks
+// @Description Create the lists and tasks of an export, sent as the body or as the file field of a form: the zip of GET /export, or a single export.json, tasks.csv or Markdown file.
+// @Description IDs of the file are remapped to new ones, dependencies included. With duplicates=skip lists merge into existing lists with the same title
+// @Description and tasks with the same title and due date as an existing one are skipped. Rows that cannot be imported are listed in errors; dry_run reports without saving.
+// @Tags export
+// @Security Bearer
+// @Accept application/zip
+// @Accept application/json
+// @Accept text/csv
+// @Accept text/markdown
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file false "The export file"
+// @Param format query string false "json, csv or markdown; detected from the files of a zip or the content type when omitted"
+// @Param dry_run query bool false "Report what would be imported without saving it"
+// @Param duplicates query string false "skip (default) or create"
+// @Success 200 {object} service.ImportResult
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 413 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /import [post]
+func (h *exportHandler) ImportHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	opts := service.ImportOptions{Format: c.QueryParam("format"), Duplicates: c.QueryParam("duplicates")}
+	if raw := c.QueryParam("dry_run"); raw != "" {
+		if opts.DryRun, err = strconv.ParseBool(raw); err != nil {
+			return apperrors.Validation(apperrors.Field("dry_run", "boolean", "must be true or false"))
+		}
+	}
+
+	var body io.Reader = c.Request().Body
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType == "multipart/form-data" {
+		part, err := formPart(c, importField)
+		if err != nil {
+			return err
+		}
+		body = part
+		if name := part.FileName(); name != "" {
+			opts.Filename = path.Base(name)
+		}
+		mediaType, _, _ = mime.ParseMediaType(part.Header.Get(echo.HeaderContentType))
+	}
+	if opts.Format == "" {
+		opts.Format = importFormat(mediaType, opts.Filename)
+	}
+
+	result, err := h.exportService.WithActor(actor(c)).Import(userID, body, opts)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// importFormat guesses the format of a single file from its content type or
+// extension; zips are left to the service, which looks at their files
+func importFormat(mediaType string, filename string) string {
+	switch mediaType {
+	case echo.MIMEApplicationJSON:
+		return service.FormatJSON
+	case "text/csv":
+		return service.FormatCSV
+	case "text/markdown", "text/x-markdown":
+		return service.FormatMarkdown
+	}
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".json":
+		return service.FormatJSON
+	case ".csv":
+		return service.FormatCSV
+	case ".md", ".markdown":
+		return service.FormatMarkdown
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type HistoryHandler interface {
+	GetTaskHistoryHandler(c echo.Context) error
+	RevertTaskHandler(c echo.Context) error
+	UndoHandler(c echo.Context) error
+}
+
+type historyHandler struct {
+	historyService service.HistoryService
+}
+
+func NewHistoryHandler(historyService service.HistoryService) HistoryHandler {
+	return &historyHandler{historyService: historyService}
+}
+
+// GetTaskHistoryHandler godoc
+// @Summary Get task history
+// @Description Every recorded change of the task, newest first
+// @Tags tasks
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Success 200 {array} models.TaskVersion
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/history [get]
+func (h *historyHandler) GetTaskHistoryHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	versions, err := h.historyService.GetTaskHistory(listID, taskID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+// RevertTaskHandler godoc
+// @Summary Revert task to a version
+// @Description Restore title, description and completion status as they were after the given version
+// @Tags tasks
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param version path int true "Version number"
+// @Success 200 {object} models.Task
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/history/{version}/revert [post]
+func (h *historyHandler) RevertTaskHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	version, err := utils.GetParam(c, "version")
+	if err != nil {
+		return err
+	}
+	task, err := h.historyService.WithActor(actor(c)).RevertTask(listID, taskID, userID, version)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, task)
+}
+
+// UndoHandler godoc
+// @Summary Undo last change
+// @Description Revert the caller's last task change made in the current login session. Changes made by one request (e.g. a batch) are undone together; repeated calls walk further back.
+// @Tags tasks
+// @Security Bearer
+// @Produce json
+// @Success 200 {array} models.TaskVersion
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /undo [post]
+func (h *historyHandler) UndoHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	undone, err := h.historyService.WithActor(actor(c)).Undo(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, undone)
+}
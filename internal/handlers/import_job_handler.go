@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type ImportJobHandler interface {
+	CreateImportJobHandler(c echo.Context) error
+	GetImportJobsHandler(c echo.Context) error
+	GetImportJobHandler(c echo.Context) error
+	DeleteImportJobHandler(c echo.Context) error
+}
+
+type importJobHandler struct {
+	importJobService service.ImportJobService
+}
+
+func NewImportJobHandler(importJobService service.ImportJobService) ImportJobHandler {
+	return &importJobHandler{importJobService: importJobService}
+}
+
+// CreateImportJobHandler godoc
+// @Summary Import from another app
+// @Description Queue an import of an export of Todoist (project CSV, a backup zip of them or sync API JSON), Trello (board JSON or CSV)
+// @Description or Microsoft To Do (Graph API JSON or Outlook tasks CSV), sent as the body or as the file field of a form.
+// @Description Projects, sections, boards and lists become lists; Trello lists become the workflow of their board. Labels and checklists go to the task description,
+// @Description subtasks become tasks their parent depends on, notes and card comments become comments. Poll the job in Location until it has finished.
+// @Tags imports
+// @Security Bearer
+// @Accept application/json
+// @Accept text/csv
+// @Accept application/zip
+// @Accept multipart/form-data
+// @Produce json
+// @Param source query string true "todoist, trello or microsoft_todo"
+// @Param file formData file false "The export file"
+// @Param dry_run query bool false "Report what would be imported without saving it"
+// @Param duplicates query string false "skip (default) or create"
+// @Success 202 {object} models.ImportJob
+// @Header 202 {string} Location "URL of the import job"
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 413 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /imports [post]
+func (h *importJobHandler) CreateImportJobHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	opts := service.ImportJobOptions{Source: strings.ToLower(c.QueryParam("source")), Duplicates: c.QueryParam("duplicates")}
+	if raw := c.QueryParam("dry_run"); raw != "" {
+		if opts.DryRun, err = strconv.ParseBool(raw); err != nil {
+			return apperrors.Validation(apperrors.Field("dry_run", "boolean", "must be true or false"))
+		}
+	}
+
+	var body io.Reader = c.Request().Body
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType == "multipart/form-data" {
+		part, err := formPart(c, importField)
+		if err != nil {
+			return err
+		}
+		body = part
+		if name := part.FileName(); name != "" {
+			opts.Filename = path.Base(name)
+		}
+	}
+
+	job, err := h.importJobService.WithActor(actor(c)).QueueImport(userID, body, opts)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/imports/%d", job.ID))
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// GetImportJobsHandler godoc
+// @Summary List import jobs
+// @Description The latest 50 imports of the caller, newest first. Finished jobs are kept for a week.
+// @Tags imports
+// @Security Bearer
+// @Produce json
+// @Success 200 {array} models.ImportJob
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /imports [get]
+func (h *importJobHandler) GetImportJobsHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	jobs, err := h.importJobService.GetJobs(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// GetImportJobHandler godoc
+// @Summary Get import job
+// @Description Status and progress of an import. The result of a succeeded job is the same report as POST /import returns.
+// @Tags imports
+// @Security Bearer
+// @Produce json
+// @Param id path int true "Import job ID"
+// @Success 200 {object} models.ImportJob
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /imports/{id} [get]
+func (h *importJobHandler) GetImportJobHandler(c echo.Context) error {
+	jobID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	job, err := h.importJobService.GetJob(jobID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// DeleteImportJobHandler godoc
+// @Summary Delete import job
+// @Description Cancel a pending import or forget a finished one. Lists and tasks already imported are kept.
+// @Tags imports
+// @Security Bearer
+// @Param id path int true "Import job ID"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /imports/{id} [delete]
+func (h *importJobHandler) DeleteImportJobHandler(c echo.Context) error {
+	jobID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.importJobService.DeleteJob(jobID, userID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,517 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/url"
+)
+
+// OAuthClientRequest model
+// swagger:model
+type OAuthClientRequest struct {
+	// Shown to users on the consent screen
+	// required: true
+	// example: Calendar Sync
+	Name string `json:"name" validate:"required"`
+	// example: https://sync.example.com
+	Website string `json:"website"`
+	// Exact URIs the app may be redirected back to: https, http on localhost or a private scheme of a native app
+	// required: true
+	// example: ["https://sync.example.com/oauth/callback"]
+	RedirectURIs []string `json:"redirect_uris" validate:"required"`
+	// A confidential client gets a secret to authenticate with; apps that cannot keep one, such as mobile apps, are public
+	Confidential bool `json:"confidential"`
+}
+
+// AuthorizationParams are the parameters of an authorization request as
+// the consent page received them
+// swagger:model
+type AuthorizationParams struct {
+	// required: true
+	// example: code
+	ResponseType string `json:"response_type"`
+	// required: true
+	// example: 3f1c2a9d8e7b6a5f4e3d2c1b0a998877
+	ClientID string `json:"client_id"`
+	// example: https://sync.example.com/oauth/callback
+	RedirectURI string `json:"redirect_uri"`
+	// Space-separated scopes
+	// required: true
+	// example: lists:read tasks:write
+	Scope string `json:"scope"`
+	// example: af0ifjsldkj
+	State string `json:"state"`
+	// required: true
+	// example: E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM
+	CodeChallenge string `json:"code_challenge"`
+	// required: true
+	// example: S256
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// ConsentDecisionRequest model
+// swagger:model
+type ConsentDecisionRequest struct {
+	AuthorizationParams
+	// Whether the user allows the app access
+	Approve bool `json:"approve"`
+}
+
+// ConsentDecisionResponse model
+// swagger:model
+type ConsentDecisionResponse struct {
+	// Send the browser here; the app receives the code or the error
+	// example: https://sync.example.com/oauth/callback?code=4f3e2d1c&state=af0ifjsldkj
+	RedirectTo string `json:"redirect_to"`
+}
+
+// OAuthErrorResponse model (RFC 6749)
+// swagger:model
+type OAuthErrorResponse struct {
+	// example: invalid_grant
+	Error string `json:"error"`
+	// example: The code or refresh token is invalid, expired or was issued to another client
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type OAuthHandler interface {
+	GetScopesHandler(c echo.Context) error
+	AuthorizeHandler(c echo.Context) error
+	TokenHandler(c echo.Context) error
+	IntrospectHandler(c echo.Context) error
+	RevokeHandler(c echo.Context) error
+	GetConsentHandler(c echo.Context) error
+	PostConsentHandler(c echo.Context) error
+	PostClientHandler(c echo.Context) error
+	GetClientsHandler(c echo.Context) error
+	GetClientHandler(c echo.Context) error
+	RotateClientSecretHandler(c echo.Context) error
+	DeleteClientHandler(c echo.Context) error
+	GetAuthorizationsHandler(c echo.Context) error
+	RevokeAuthorizationHandler(c echo.Context) error
+}
+
+type oauthHandler struct {
+	oauthService service.OAuthService
+}
+
+func NewOAuthHandler(oauthService service.OAuthService) OAuthHandler {
+	return &oauthHandler{oauthService: oauthService}
+}
+
+func authorizationRequest(query url.Values) service.AuthorizationRequest {
+	return service.AuthorizationRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+}
+
+// clientCredentials reads the client authentication of a token endpoint
+// request: HTTP Basic with form-encoded values or client_id and
+// client_secret in the form (RFC 6749, section 2.3.1)
+func clientCredentials(c echo.Context) (string, string) {
+	if clientID, secret, ok := c.Request().BasicAuth(); ok {
+		if id, err := url.QueryUnescape(clientID); err == nil {
+			clientID = id
+		}
+		if s, err := url.QueryUnescape(secret); err == nil {
+			secret = s
+		}
+		return clientID, secret
+	}
+	return c.FormValue("client_id"), c.FormValue("client_secret")
+}
+
+// oauthError answers the token endpoints in the format of RFC 6749 that
+// OAuth2 libraries understand, not as a problem
+func oauthError(c echo.Context, err error) error {
+	appErr := apperrors.From(err)
+	if appErr.Status >= http.StatusInternalServerError {
+		return err
+	}
+	if appErr.Status == http.StatusUnauthorized {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+	}
+	return c.JSON(appErr.Status, OAuthErrorResponse{Error: appErr.Code, ErrorDescription: appErr.Message})
+}
+
+// GetScopesHandler godoc
+// @Summary Get OAuth2 scopes
+// @Description Scopes third-party apps can request. A write scope includes reading.
+// @Tags oauth
+// @Produce json
+// @Success 200 {array} service.OAuthScope
+// @Router /oauth/scopes [get]
+func (h *oauthHandler) GetScopesHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.oauthService.Scopes())
+}
+
+// AuthorizeHandler godoc
+// @Summary OAuth2 authorization endpoint
+// @Description Authorization code flow; PKCE with S256 is required. The browser is redirected to the consent page of the
+// @Description web app with the checked parameters, or back to the app with an error. An unknown client_id or redirect_uri
+// @Description is answered with 400 instead.
+// @Tags oauth
+// @Param response_type query string true "code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string false "Registered redirect URI; may be left out when the client has only one"
+// @Param scope query string true "Space-separated scopes"
+// @Param state query string false "Returned to the app unchanged"
+// @Param code_challenge query string true "BASE64URL(SHA256(code_verifier))"
+// @Param code_challenge_method query string true "S256"
+// @Success 302
+// @Header 302 {string} Location "Consent page or redirect URI of the app"
+// @Failure 400 {object} responses.Problem
+// @Router /oauth/authorize [get]
+func (h *oauthHandler) AuthorizeHandler(c echo.Context) error {
+	location, err := h.oauthService.AuthorizeRedirect(authorizationRequest(c.QueryParams()))
+	if err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusFound, location)
+}
+
+// TokenHandler godoc
+// @Summary OAuth2 token endpoint
+// @Description Exchange an authorization code (with the PKCE code_verifier) or a refresh token for tokens. Refreshing
+// @Description replaces the refresh token. Confidential clients authenticate with HTTP Basic or client_secret in the form;
+// @Description public clients send client_id. Errors are answered as in RFC 6749.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code or refresh_token"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect URI of the authorization request; required when that request had it"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "Refresh token"
+// @Param scope formData string false "Narrower scopes for the refreshed tokens"
+// @Param client_id formData string false "Client ID, unless sent with HTTP Basic"
+// @Param client_secret formData string false "Client secret, unless sent with HTTP Basic"
+// @Success 200 {object} service.OAuthTokenResponse
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Router /oauth/token [post]
+func (h *oauthHandler) TokenHandler(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	clientID, clientSecret := clientCredentials(c)
+	tokens, err := h.oauthService.Token(service.TokenRequest{
+		GrantType:    c.FormValue("grant_type"),
+		Code:         c.FormValue("code"),
+		RedirectURI:  c.FormValue("redirect_uri"),
+		CodeVerifier: c.FormValue("code_verifier"),
+		RefreshToken: c.FormValue("refresh_token"),
+		Scope:        c.FormValue("scope"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	})
+	if err != nil {
+		return oauthError(c, err)
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// IntrospectHandler godoc
+// @Summary OAuth2 token introspection
+// @Description Tells a confidential client whether an access or refresh token issued to it is active (RFC 7662).
+// @Description Tokens of other clients are reported as inactive.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Access or refresh token"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Param client_id formData string false "Client ID, unless sent with HTTP Basic"
+// @Param client_secret formData string false "Client secret, unless sent with HTTP Basic"
+// @Success 200 {object} service.OAuthIntrospection
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Router /oauth/introspect [post]
+func (h *oauthHandler) IntrospectHandler(c echo.Context) error {
+	clientID, clientSecret := clientCredentials(c)
+	introspection, err := h.oauthService.Introspect(clientID, clientSecret, c.FormValue("token"))
+	if err != nil {
+		return oauthError(c, err)
+	}
+	return c.JSON(http.StatusOK, introspection)
+}
+
+// RevokeHandler godoc
+// @Summary OAuth2 token revocation
+// @Description Revoke an access or refresh token of the client together with its pair (RFC 7009). Unknown tokens are
+// @Description ignored.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Param token formData string true "Access or refresh token"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Param client_id formData string false "Client ID, unless sent with HTTP Basic"
+// @Param client_secret formData string false "Client secret, unless sent with HTTP Basic"
+// @Success 200
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Router /oauth/revoke [post]
+func (h *oauthHandler) RevokeHandler(c echo.Context) error {
+	clientID, clientSecret := clientCredentials(c)
+	if err := h.oauthService.Revoke(clientID, clientSecret, c.FormValue("token")); err != nil {
+		return oauthError(c, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// GetConsentHandler godoc
+// @Summary Get consent prompt
+// @Description For the consent page of the web app: the app and the scopes it requests. granted means the user already
+// @Description allowed all of them, so the page may approve at once.
+// @Tags oauth
+// @Security Bearer
+// @Produce json
+// @Param response_type query string true "code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string false "Redirect URI, when the authorization request had it"
+// @Param scope query string true "Space-separated scopes"
+// @Param state query string false "State"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "S256"
+// @Success 200 {object} service.OAuthConsentPrompt
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Router /oauth/consent [get]
+func (h *oauthHandler) GetConsentHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	prompt, err := h.oauthService.Prompt(userID, authorizationRequest(c.QueryParams()))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, prompt)
+}
+
+// PostConsentHandler godoc
+// @Summary Answer consent prompt
+// @Description Allow or deny the app access. The answer is where to send the browser: back to the app with an
+// @Description authorization code, or with an error when denied or when the request is invalid.
+// @Tags oauth
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param request body ConsentDecisionRequest true "Authorization request and decision"
+// @Success 200 {object} ConsentDecisionResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Router /oauth/consent [post]
+func (h *oauthHandler) PostConsentHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	var req ConsentDecisionRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	location, err := h.oauthService.WithActor(actor(c)).Decide(userID, service.AuthorizationRequest{
+		ResponseType:        req.ResponseType,
+		ClientID:            req.ClientID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		State:               req.State,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+	}, req.Approve)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ConsentDecisionResponse{RedirectTo: location})
+}
+
+// PostClientHandler godoc
+// @Summary Register OAuth2 client
+// @Description Register a third-party app. The secret of a confidential client is only shown in this response.
+// @Tags oauth
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param client body OAuthClientRequest true "App details"
+// @Success 201 {object} service.OAuthClientCredentials
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Router /oauth/clients [post]
+func (h *oauthHandler) PostClientHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	var req OAuthClientRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+	client, err := h.oauthService.WithActor(actor(c)).RegisterClient(userID, service.OAuthClientInput{
+		Name:         req.Name,
+		Website:      req.Website,
+		RedirectURIs: req.RedirectURIs,
+		Confidential: req.Confidential,
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, client)
+}
+
+// GetClientsHandler godoc
+// @Summary Get registered OAuth2 clients
+// @Tags oauth
+// @Security Bearer
+// @Produce json
+// @Success 200 {array} models.OAuthClient
+// @Failure 401 {object} responses.Problem
+// @Router /oauth/clients [get]
+func (h *oauthHandler) GetClientsHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	clients, err := h.oauthService.GetClients(userID)
+	if err != nil {
+		return err
+	}
+	if clients == nil {
+		clients = []models.OAuthClient{}
+	}
+	return c.JSON(http.StatusOK, clients)
+}
+
+// GetClientHandler godoc
+// @Summary Get OAuth2 client
+// @Tags oauth
+// @Security Bearer
+// @Produce json
+// @Param id path int true "Client ID"
+// @Success 200 {object} models.OAuthClient
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Router /oauth/clients/{id} [get]
+func (h *oauthHandler) GetClientHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	id, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	client, err := h.oauthService.GetClient(userID, id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, client)
+}
+
+// RotateClientSecretHandler godoc
+// @Summary Rotate OAuth2 client secret
+// @Description Replace the secret of a confidential client; the old one stops working at once. Issued tokens stay valid.
+// @Tags oauth
+// @Security Bearer
+// @Produce json
+// @Param id path int true "Client ID"
+// @Success 200 {object} service.OAuthClientCredentials
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Router /oauth/clients/{id}/secret [post]
+func (h *oauthHandler) RotateClientSecretHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	id, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	client, err := h.oauthService.WithActor(actor(c)).RotateSecret(userID, id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, client)
+}
+
+// DeleteClientHandler godoc
+// @Summary Delete OAuth2 client
+// @Description Delete the app and revoke every token issued to it
+// @Tags oauth
+// @Security Bearer
+// @Param id path int true "Client ID"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Router /oauth/clients/{id} [delete]
+func (h *oauthHandler) DeleteClientHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	id, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	if err := h.oauthService.WithActor(actor(c)).DeleteClient(userID, id); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetAuthorizationsHandler godoc
+// @Summary Get authorized apps
+// @Description Third-party apps the caller allowed access, with the granted scopes
+// @Tags account
+// @Security Bearer
+// @Produce json
+// @Success 200 {array} service.OAuthAuthorization
+// @Failure 401 {object} responses.Problem
+// @Router /me/authorizations [get]
+func (h *oauthHandler) GetAuthorizationsHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	authorizations, err := h.oauthService.GetAuthorizations(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, authorizations)
+}
+
+// RevokeAuthorizationHandler godoc
+// @Summary Revoke app authorization
+// @Description The app loses access: its tokens are revoked and it has to ask for consent again
+// @Tags account
+// @Security Bearer
+// @Param client_id path string true "Client ID of the app"
+// @Success 204
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Router /me/authorizations/{client_id} [delete]
+func (h *oauthHandler) RevokeAuthorizationHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.oauthService.WithActor(actor(c)).RevokeAuthorization(userID, c.Param("client_id")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
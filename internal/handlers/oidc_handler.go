@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"RestAPI/internal/models"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// LinkIdentityResponse model
+// swagger:model
+type LinkIdentityResponse struct {
+	// Open it in the browser; the provider redirects back to the callback, which links the identity
+	// example: https://sso.example.com/authorize?client_id=todo&...
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// oidcCookie keeps the secret that binds a sign-in to the browser that
+// started it; the provider redirects back to /auth/oidc/... in the same browser
+const oidcCookie = "oidc_login"
+
+type OIDCHandler interface {
+	GetProvidersHandler(c echo.Context) error
+	LoginHandler(c echo.Context) error
+	CallbackHandler(c echo.Context) error
+	LinkIdentityHandler(c echo.Context) error
+	GetIdentitiesHandler(c echo.Context) error
+	UnlinkIdentityHandler(c echo.Context) error
+}
+
+type oidcHandler struct {
+	oidcService    service.OIDCService
+	userService    service.UserService
+	accountService service.AccountService
+}
+
+func NewOIDCHandler(oidcService service.OIDCService, userService service.UserService, accountService service.AccountService) OIDCHandler {
+	return &oidcHandler{oidcService: oidcService, userService: userService, accountService: accountService}
+}
+
+// GetProvidersHandler godoc
+// @Summary Get identity providers
+// @Description Identity providers users can sign in with instead of a password.
+// @Tags auth
+// @Produce json
+// @Success 200 {array} service.OIDCProviderInfo
+// @Router /auth/oidc/providers [get]
+func (h *oidcHandler) GetProvidersHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.oidcService.Providers())
+}
+
+// LoginHandler godoc
+// @Summary Sign in with an identity provider
+// @Description Redirect the browser to the provider (authorization code flow with PKCE). The provider redirects back
+// @Description to the callback, which answers like /login. A cookie ties the sign-in to this browser.
+// @Description With restore the sign-in also restores the account if it is scheduled for deletion, as POST /me/restore does.
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Param restore query bool false "Restore the account scheduled for deletion"
+// @Success 302
+// @Header 302 {string} Location "Authorization URL of the provider"
+// @Failure 404 {object} responses.Problem
+// @Failure 502 {object} responses.Problem
+// @Router /auth/oidc/{provider}/login [get]
+func (h *oidcHandler) LoginHandler(c echo.Context) error {
+	authURL, binding, err := h.oidcService.Start(c.Request().Context(), c.Param("provider"), 0, c.QueryParam("restore") == "true")
+	if err != nil {
+		return err
+	}
+	setOIDCCookie(c, binding, 0)
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// CallbackHandler godoc
+// @Summary Identity provider callback
+// @Description The provider redirects here after the sign-in. The identity signs in the user it is linked to. An unknown identity
+// @Description is linked to the account with the same verified email or gets a new account, as configured for the provider.
+// @Description The answer is as from /login, including mfa_required; when a signed in user started linking, it is the linked identity.
+// @Description The browser must send the cookie set when the sign-in started.
+// @Tags auth
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param state query string true "State of the sign-in"
+// @Param code query string false "Authorization code"
+// @Param error query string false "Error reported by the provider"
+// @Success 200 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 403 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 502 {object} responses.Problem
+// @Router /auth/oidc/{provider}/callback [get]
+func (h *oidcHandler) CallbackHandler(c echo.Context) error {
+	callback := service.OIDCCallback{
+		State:            c.QueryParam("state"),
+		Code:             c.QueryParam("code"),
+		Error:            c.QueryParam("error"),
+		ErrorDescription: c.QueryParam("error_description"),
+	}
+	if cookie, err := c.Cookie(oidcCookie); err == nil {
+		callback.Binding = cookie.Value
+	}
+	setOIDCCookie(c, "", -1)
+	result, err := h.oidcService.WithActor(actor(c)).Finish(c.Request().Context(), c.Param("provider"), callback)
+	if err != nil {
+		return err
+	}
+	if result.Linked {
+		return c.JSON(http.StatusOK, result.Identity)
+	}
+	if result.Restore {
+		if err := h.accountService.WithActor(actor(c)).RestoreSignedIn(result.User.ID); err != nil {
+			return err
+		}
+	}
+	login, err := h.userService.SignIn(result.User.ID)
+	if err != nil {
+		return err
+	}
+	return loginResponse(c, login)
+}
+
+// LinkIdentityHandler godoc
+// @Summary Link an identity provider account
+// @Description Start linking an account at the provider to the caller. Open authorization_url in the browser
+// @Description that made this request: the response sets a cookie the callback checks.
+// @Description Afterwards the caller can sign in with the provider as well.
+// @Tags account
+// @Security Bearer
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Success 200 {object} LinkIdentityResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 502 {object} responses.Problem
+// @Router /me/identities/{provider} [post]
+func (h *oidcHandler) LinkIdentityHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	authURL, binding, err := h.oidcService.Start(c.Request().Context(), c.Param("provider"), userID, false)
+	if err != nil {
+		return err
+	}
+	setOIDCCookie(c, binding, 0)
+	return c.JSON(http.StatusOK, LinkIdentityResponse{AuthorizationURL: authURL})
+}
+
+// GetIdentitiesHandler godoc
+// @Summary Get linked identities
+// @Tags account
+// @Security Bearer
+// @Produce json
+// @Success 200 {array} models.UserIdentity
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/identities [get]
+func (h *oidcHandler) GetIdentitiesHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	identities, err := h.oidcService.GetIdentities(userID)
+	if err != nil {
+		return err
+	}
+	if identities == nil {
+		identities = []models.UserIdentity{}
+	}
+	return c.JSON(http.StatusOK, identities)
+}
+
+// UnlinkIdentityHandler godoc
+// @Summary Unlink identity
+// @Description The identity can no longer sign in. The last identity of an account without a password cannot be unlinked.
+// @Tags account
+// @Security Bearer
+// @Param id path int true "Identity ID"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/identities/{id} [delete]
+func (h *oidcHandler) UnlinkIdentityHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	identityID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	if err := h.oidcService.WithActor(actor(c)).Unlink(userID, identityID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// setOIDCCookie stores the sign-in binding for the callback; maxAge -1 removes it
+func setOIDCCookie(c echo.Context, value string, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     oidcCookie,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		Secure:   c.Scheme() == "https",
+		HttpOnly: true,
+		// Lax: cookie приходит при переходе по редиректу провайдера
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/pkg/jsonpatch"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"mime"
+	"sort"
+)
+
+const (
+	MIMEMergePatch = "application/merge-patch+json"
+	MIMEJSONPatch  = "application/json-patch+json"
+
+	// MaxPatchSize limits patch documents; the patchable fields are far smaller
+	MaxPatchSize = 1 << 20
+)
+
+// isPatchDocument reports whether the request body is a merge patch or
+// a JSON patch rather than a plain JSON object
+func isPatchDocument(c echo.Context) bool {
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	return mediaType == MIMEMergePatch || mediaType == MIMEJSONPatch
+}
+
+// applyPatchDocument applies the request's patch document to current and
+// decodes the result into dst. current must expose only patchable fields:
+// any field added by the patch is rejected, and fields the patch sets to
+// null or removes are decoded as zero values, which clears them.
+func applyPatchDocument(c echo.Context, current interface{}, dst interface{}) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, MaxPatchSize+1))
+	if err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if len(body) > MaxPatchSize {
+		return apperrors.ErrPatchTooLarge.WithMessage(fmt.Sprintf("Patch documents are limited to %d bytes", MaxPatchSize))
+	}
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var allowed map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &allowed); err != nil {
+		return err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	var patched []byte
+	if mediaType == MIMEMergePatch {
+		patched, err = jsonpatch.MergePatch(doc, body)
+	} else {
+		var ops []jsonpatch.Operation
+		ops, err = jsonpatch.DecodePatch(body)
+		if err == nil {
+			patched, err = jsonpatch.Apply(doc, ops)
+		}
+	}
+	if err != nil {
+		return patchError(err)
+	}
+
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return apperrors.ErrInvalidPatch.WithMessage("The patched document must be a JSON object")
+	}
+	var fields []apperrors.FieldError
+	for key := range result {
+		if _, ok := allowed[key]; !ok {
+			fields = append(fields, apperrors.Field(key, "not_allowed", "field cannot be modified"))
+		}
+	}
+	if len(fields) > 0 {
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+		return apperrors.Validation(fields...)
+	}
+
+	if err := json.Unmarshal(patched, dst); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return apperrors.Validation(apperrors.Field(typeErr.Field, "invalid_type", "must be a "+typeErr.Type.String()))
+		}
+		return apperrors.ErrInvalidPatch.Wrap(err)
+	}
+	return nil
+}
+
+func patchError(err error) error {
+	switch {
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		return apperrors.ErrPatchTestFailed.Wrap(err)
+	case errors.Is(err, jsonpatch.ErrPathNotFound):
+		return apperrors.ErrInvalidPatch.WithMessage(err.Error())
+	case errors.Is(err, jsonpatch.ErrInvalidPatch):
+		return apperrors.ErrInvalidPatch.WithMessage(err.Error())
+	}
+	return apperrors.ErrInvalidPatch.Wrap(err)
+}
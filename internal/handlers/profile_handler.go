@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// avatarField is the multipart form field carrying the avatar image
+const avatarField = "file"
+
+// UpdateProfileRequest model
+// swagger:model
+type UpdateProfileRequest struct {
+	// New username, letters, digits, _, . and -
+	// example: john_doe
+	Username *string `json:"username"`
+	// example: John Doe
+	DisplayName *string `json:"display_name"`
+	// Email address, "" removes it
+	// example: john@example.com
+	Email *string `json:"email"`
+	// IANA time zone
+	// example: Europe/Moscow
+	Timezone *string `json:"timezone"`
+	// BCP 47 language tag
+	// example: en-US
+	Locale      *string             `json:"locale"`
+	Preferences *PreferencesRequest `json:"preferences"`
+}
+
+// PreferencesRequest model
+// swagger:model
+type PreferencesRequest struct {
+	// List opened by default, 0 removes it
+	// example: 1
+	DefaultListID *int `json:"default_list_id"`
+	// monday, sunday or saturday; "" follows the locale
+	// example: monday
+	WeekStart *string `json:"week_start"`
+}
+
+// profileDocument is the profile as patch documents see it
+type profileDocument struct {
+	Username    string              `json:"username"`
+	DisplayName string              `json:"display_name"`
+	Email       *string             `json:"email"`
+	Timezone    string              `json:"timezone"`
+	Locale      string              `json:"locale"`
+	Preferences preferencesDocument `json:"preferences"`
+}
+
+type preferencesDocument struct {
+	DefaultListID *int   `json:"default_list_id"`
+	WeekStart     string `json:"week_start"`
+}
+
+type ProfileHandler interface {
+	GetProfileHandler(c echo.Context) error
+	PatchProfileHandler(c echo.Context) error
+	PutAvatarHandler(c echo.Context) error
+	GetAvatarHandler(c echo.Context) error
+	DeleteAvatarHandler(c echo.Context) error
+}
+
+type profileHandler struct {
+	profileService service.ProfileService
+}
+
+func NewProfileHandler(profileService service.ProfileService) ProfileHandler {
+	return &profileHandler{profileService: profileService}
+}
+
+// GetProfileHandler godoc
+// @Summary Get profile
+// @Description The caller's account: username, display name, email, time zone, locale, avatar and preferences
+// @Tags account
+// @Security Bearer
+// @Produce json
+// @Success 200 {object} models.User
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me [get]
+func (h *profileHandler) GetProfileHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	user, err := h.profileService.GetProfile(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// PatchProfileHandler godoc
+// @Summary Update profile
+// @Description Change the username, display name, email, time zone, locale or preferences. Usernames and emails must not be used by another account.
+// @Description The time zone and the first day of the week apply to dates without a time zone and to time reports; without week_start it follows the locale.
+// @Description Accepts a plain JSON object, an RFC 7386 merge patch (null clears a field) or an RFC 6902 JSON patch.
+// @Tags account
+// @Security Bearer
+// @Accept json
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param request body UpdateProfileRequest true "Profile changes"
+// @Success 200 {object} models.User
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Failure 413 {object} responses.Problem
+// @Router /me [patch]
+func (h *profileHandler) PatchProfileHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	var fields service.ProfileFields
+	if isPatchDocument(c) {
+		if fields, err = h.patchedProfile(c, userID); err != nil {
+			return err
+		}
+	} else {
+		var req UpdateProfileRequest
+		if err := c.Bind(&req); err != nil {
+			return apperrors.ErrInvalidBody.Wrap(err)
+		}
+		fields = service.ProfileFields{
+			Username:    req.Username,
+			DisplayName: req.DisplayName,
+			Email:       req.Email,
+			Timezone:    req.Timezone,
+			Locale:      req.Locale,
+		}
+		if req.Preferences != nil {
+			fields.DefaultListID = req.Preferences.DefaultListID
+			fields.WeekStart = req.Preferences.WeekStart
+		}
+	}
+
+	user, err := h.profileService.WithActor(actor(c)).UpdateProfile(userID, fields)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// patchedProfile applies the request's patch document to the profile
+func (h *profileHandler) patchedProfile(c echo.Context, userID int) (service.ProfileFields, error) {
+	user, err := h.profileService.GetProfile(userID)
+	if err != nil {
+		return service.ProfileFields{}, err
+	}
+	current := profileDocument{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Email:       user.Email,
+		Timezone:    user.Timezone,
+		Locale:      user.Locale,
+		Preferences: preferencesDocument{
+			DefaultListID: user.Preferences.DefaultListID,
+			WeekStart:     user.Preferences.WeekStart,
+		},
+	}
+	var patched profileDocument
+	if err := applyPatchDocument(c, current, &patched); err != nil {
+		return service.ProfileFields{}, err
+	}
+	// Удалённые патчем email и список по умолчанию приходят как nil
+	email, listID := "", 0
+	if patched.Email != nil {
+		email = *patched.Email
+	}
+	if patched.Preferences.DefaultListID != nil {
+		listID = *patched.Preferences.DefaultListID
+	}
+	return service.ProfileFields{
+		Username:      &patched.Username,
+		DisplayName:   &patched.DisplayName,
+		Email:         &email,
+		Timezone:      &patched.Timezone,
+		Locale:        &patched.Locale,
+		DefaultListID: &listID,
+		WeekStart:     &patched.Preferences.WeekStart,
+	}, nil
+}
+
+// PutAvatarHandler godoc
+// @Summary Upload avatar
+// @Description Replace the caller's avatar. PNG, JPEG and GIF images are accepted; the centre square is kept and scaled down to 256×256 pixels.
+// @Tags account
+// @Security Bearer
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "The image"
+// @Success 200 {object} models.User
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 413 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/avatar [put]
+func (h *profileHandler) PutAvatarHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	part, err := formPart(c, avatarField)
+	if err != nil {
+		return err
+	}
+	user, err := h.profileService.WithActor(actor(c)).SetAvatar(c.Request().Context(), userID, part)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// GetAvatarHandler godoc
+// @Summary Get avatar
+// @Tags account
+// @Security Bearer
+// @Produce png
+// @Success 200 {file} file "PNG image"
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/avatar [get]
+func (h *profileHandler) GetAvatarHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	user, avatar, err := h.profileService.OpenAvatar(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	defer avatar.Close()
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "image/png")
+	// Ключ меняется при каждой загрузке, поэтому годится как ETag
+	header.Set("ETag", `"`+user.AvatarKey+`"`)
+	header.Set("Cache-Control", "private, no-cache")
+	http.ServeContent(c.Response(), c.Request(), "", time.Time{}, avatar)
+	return nil
+}
+
+// DeleteAvatarHandler godoc
+// @Summary Delete avatar
+// @Tags account
+// @Security Bearer
+// @Success 204
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/avatar [delete]
+func (h *profileHandler) DeleteAvatarHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.profileService.WithActor(actor(c)).DeleteAvatar(c.Request().Context(), userID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
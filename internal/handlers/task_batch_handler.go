@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+// BatchTasksRequest model
+// swagger:model
+type BatchTasksRequest struct {
+	// atomic rolls back every operation if one fails, partial applies the rest
+	// example: atomic
+	Mode string `json:"mode" validate:"omitempty,oneof=atomic partial"`
+
+	// Operations to execute in order
+	// required: true
+	Operations []BatchOperationRequest `json:"operations" validate:"required,min=1,dive"`
+}
+
+// BatchOperationRequest model
+// swagger:model
+type BatchOperationRequest struct {
+	// One of create, update, delete, complete
+	// example: complete
+	Op string `json:"op" validate:"required,oneof=create update delete complete"`
+
+	// Task ID, required for everything except create
+	// example: 42
+	ID int `json:"id"`
+
+	// example: Buy milk
+	Title *string `json:"title"`
+
+	// example: 2 liters
+	Description *string `json:"description"`
+
+	// example: true
+	Completed *bool `json:"completed"`
+
+	// example: high
+	Priority *string `json:"priority"`
+
+	// example: 3
+	StatusID *int `json:"status_id"`
+
+	// example: 90
+	EstimateMinutes *int `json:"estimate_minutes"`
+
+	// example: 2024-05-20T18:00:00Z
+	DueAt *time.Time `json:"due_at"`
+
+	// example: FREQ=WEEKLY;BYDAY=MO
+	Recurrence *string `json:"recurrence"`
+}
+
+// BatchOperationResult model
+// swagger:model
+type BatchOperationResult struct {
+	Index  int          `json:"index"`
+	Op     string       `json:"op"`
+	Status int          `json:"status"`
+	Task   *models.Task `json:"task,omitempty"`
+	Error  *BatchError  `json:"error,omitempty"`
+}
+
+// BatchError model
+// swagger:model
+type BatchError struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Errors  []apperrors.FieldError `json:"errors,omitempty"`
+}
+
+// BatchTasksResponse model
+// swagger:model
+type BatchTasksResponse struct {
+	Mode      string                 `json:"mode"`
+	Succeeded int                    `json:"succeeded"`
+	Failed    int                    `json:"failed"`
+	Results   []BatchOperationResult `json:"results"`
+}
+
+// AffectedResponse model
+// swagger:model
+type AffectedResponse struct {
+	// Number of tasks changed by the operation
+	// example: 12
+	Affected int64 `json:"affected"`
+}
+
+// BatchTasksHandler godoc
+// @Summary Batch task operations
+// @Description Create, update, delete or complete many tasks of one list in a single transaction.
+// @Description In atomic mode (default) any failure rolls back the whole batch and the response is 409;
+// @Description in partial mode failed operations are skipped and reported per item with 207.
+// @Tags tasks
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param request body handlers.BatchTasksRequest true "Operations"
+// @Success 200 {object} handlers.BatchTasksResponse
+// @Success 207 {object} handlers.BatchTasksResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} handlers.BatchTasksResponse
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks:batch [post]
+func (h *taskHandler) BatchTasksHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "list_id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.todoListService.GetListByID(listID, userID); err != nil {
+		return err
+	}
+
+	var req BatchTasksRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+	if req.Mode == "" {
+		req.Mode = "atomic"
+	}
+
+	ops := make([]service.BatchOperation, len(req.Operations))
+	var fields []apperrors.FieldError
+	for i, op := range req.Operations {
+		ops[i] = service.BatchOperation{
+			Op:     op.Op,
+			TaskID: op.ID,
+			TaskFields: service.TaskFields{
+				Title:       op.Title,
+				Description: op.Description,
+				Completed:   op.Completed,
+				StatusID:    op.StatusID,
+				Estimate:    op.EstimateMinutes,
+				DueAt:       op.DueAt,
+				Recurrence:  op.Recurrence,
+			},
+		}
+		if op.Priority != nil {
+			priority, err := models.ParsePriority(*op.Priority)
+			if err != nil {
+				fields = append(fields, apperrors.Field(fmt.Sprintf("operations[%d].priority", i), "oneof", "must be one of: none low medium high urgent"))
+				continue
+			}
+			ops[i].Priority = &priority
+		}
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation(fields...)
+	}
+
+	results, err := h.taskService.WithActor(actor(c)).BatchTasks(listID, userID, ops, req.Mode == "atomic")
+	if err != nil {
+		return err
+	}
+
+	resp := BatchTasksResponse{Mode: req.Mode, Results: make([]BatchOperationResult, len(results))}
+	for i, r := range results {
+		item := BatchOperationResult{Index: r.Index, Op: r.Op, Status: r.Status, Task: r.Task}
+		if r.Err != nil {
+			item.Error = &BatchError{Code: r.Err.Code, Message: r.Err.Message, Errors: r.Err.Fields}
+			resp.Failed++
+		} else {
+			resp.Succeeded++
+		}
+		resp.Results[i] = item
+	}
+
+	status := http.StatusOK
+	switch {
+	case resp.Failed > 0 && req.Mode == "atomic":
+		status = http.StatusConflict
+	case resp.Failed > 0:
+		status = http.StatusMultiStatus
+	}
+	return c.JSON(status, resp)
+}
+
+// CompleteAllTasksHandler godoc
+// @Summary Complete all tasks
+// @Description Mark every open task of the list as completed
+// @Tags tasks
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Success 200 {object} handlers.AffectedResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks:complete-all [post]
+func (h *taskHandler) CompleteAllTasksHandler(c echo.Context) error {
+	return h.bulkUpdate(c, h.taskService.WithActor(actor(c)).CompleteAllTasks)
+}
+
+// ClearCompletedTasksHandler godoc
+// @Summary Clear completed tasks
+// @Description Delete every completed task of the list
+// @Tags tasks
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Success 200 {object} handlers.AffectedResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks:clear-completed [post]
+func (h *taskHandler) ClearCompletedTasksHandler(c echo.Context) error {
+	return h.bulkUpdate(c, h.taskService.WithActor(actor(c)).ClearCompletedTasks)
+}
+
+func (h *taskHandler) bulkUpdate(c echo.Context, fn func(listID int, userID int) (int64, error)) error {
+	listID, err := utils.GetParam(c, "list_id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.todoListService.GetListByID(listID, userID); err != nil {
+		return err
+	}
+
+	affected, err := fn(listID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, AffectedResponse{Affected: affected})
+}
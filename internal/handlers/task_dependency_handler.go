@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/pkg/utils"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// AddDependencyRequest model
+// swagger:model
+type AddDependencyRequest struct {
+	// Task that has to be completed first, from any list of the user
+	// required: true
+	// example: 12
+	BlockerID int `json:"blocker_id" validate:"required"`
+}
+
+// GetDependenciesHandler godoc
+// @Summary Get task dependencies
+// @Description Tasks this task waits for (blocked_by) and tasks waiting for it (blocks)
+// @Tags tasks
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Success 200 {object} service.TaskDependencies
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/dependencies [get]
+func (h *taskHandler) GetDependenciesHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	deps, err := h.taskService.GetDependencies(listID, taskID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, deps)
+}
+
+// AddDependencyHandler godoc
+// @Summary Add task dependency
+// @Description Make the task wait for another task of any of the user's lists. Dependencies that would form a cycle are refused with 409.
+// @Tags tasks
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param request body handlers.AddDependencyRequest true "Blocking task"
+// @Success 201 {object} models.TaskDependency
+// @Header 201 {string} Location "URL of the dependency"
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/dependencies [post]
+func (h *taskHandler) AddDependencyHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	var req AddDependencyRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	dep, err := h.taskService.WithActor(actor(c)).AddDependency(listID, taskID, userID, req.BlockerID)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/todolists/%d/tasks/%d/dependencies/%d", listID, taskID, dep.BlockerID))
+	return c.JSON(http.StatusCreated, dep)
+}
+
+// RemoveDependencyHandler godoc
+// @Summary Remove task dependency
+// @Tags tasks
+// @Security Bearer
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param blocker_id path int true "Blocking task ID"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/dependencies/{blocker_id} [delete]
+func (h *taskHandler) RemoveDependencyHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	blockerID, err := utils.GetParam(c, "blocker_id")
+	if err != nil {
+		return err
+	}
+	if err := h.taskService.WithActor(actor(c)).RemoveDependency(listID, taskID, userID, blockerID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// TopologicalOrderHandler godoc
+// @Summary Get tasks in dependency order
+// @Description Tasks of the list ordered so that every task comes after the tasks of the same list it waits for.
+// @Description Independent tasks keep their list order.
+// @Tags tasks
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Success 200 {array} models.Task
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks:topological-order [get]
+func (h *taskHandler) TopologicalOrderHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "list_id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	tasks, err := h.taskService.TopologicalOrder(listID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, tasks)
+}
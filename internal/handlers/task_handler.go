@@ -1,29 +1,46 @@
 package handlers
 
 import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
 	service "RestAPI/internal/service"
 	"RestAPI/pkg/utils"
-	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
-	"gorm.io/gorm"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type TaskHandler interface {
 	GetTasksByListHandler(c echo.Context) error
+	GetTaskHandler(c echo.Context) error
 	PostTaskHandler(c echo.Context) error
+	PutTaskHandler(c echo.Context) error
 	PatchTaskHandler(c echo.Context) error
 	DeleteTaskHandler(c echo.Context) error
+	BatchTasksHandler(c echo.Context) error
+	CompleteAllTasksHandler(c echo.Context) error
+	ClearCompletedTasksHandler(c echo.Context) error
+	MoveTaskHandler(c echo.Context) error
+	CopyTaskHandler(c echo.Context) error
+	ReorderTaskHandler(c echo.Context) error
+	GetDependenciesHandler(c echo.Context) error
+	AddDependencyHandler(c echo.Context) error
+	RemoveDependencyHandler(c echo.Context) error
+	TopologicalOrderHandler(c echo.Context) error
 }
 
 type taskHandler struct {
 	taskService     service.TaskService
 	todoListService service.TodoListService
+	profileService  service.ProfileService
 }
 
-func NewTaskHandler(taskService service.TaskService, todoListService service.TodoListService) TaskHandler {
+func NewTaskHandler(taskService service.TaskService, todoListService service.TodoListService, profileService service.ProfileService) TaskHandler {
 	return &taskHandler{taskService: taskService,
-		todoListService: todoListService}
+		todoListService: todoListService,
+		profileService:  profileService}
 }
 
 // CreateTaskRequest represents data for creating new task
@@ -37,6 +54,26 @@ type CreateTaskRequest struct {
 	// Description of the task
 	// example: Milk, eggs, bread
 	Description string `json:"description"`
+
+	// One of none, low, medium, high, urgent
+	// example: high
+	Priority string `json:"priority"`
+
+	// Workflow column, only for lists with a workflow
+	// example: 3
+	StatusID *int `json:"status_id"`
+
+	// Expected effort in minutes
+	// example: 90
+	EstimateMinutes *int `json:"estimate_minutes"`
+
+	// Deadline
+	// example: 2024-05-20T18:00:00Z
+	DueAt *time.Time `json:"due_at"`
+
+	// iCalendar RRULE for a recurring task, requires due_at
+	// example: FREQ=WEEKLY;BYDAY=MO
+	Recurrence string `json:"recurrence"`
 }
 
 // UpdateTaskRequest model
@@ -44,15 +81,122 @@ type CreateTaskRequest struct {
 type UpdateTaskRequest struct {
 	// New title for the task
 	// example: Buy organic milk
-	Title string `json:"title"`
+	Title *string `json:"title"`
 
-	// New description for the task
+	// New description for the task, an empty string clears it
 	// example: 2 liters of organic milk
-	Description string `json:"description"`
+	Description *string `json:"description"`
 
 	// New completion status
 	// example: true
 	Completed *bool `json:"completed"`
+
+	// New priority: none, low, medium, high or urgent
+	// example: urgent
+	Priority *string `json:"priority"`
+
+	// Workflow column to move the task to
+	// example: 4
+	StatusID *int `json:"status_id"`
+
+	// New estimate in minutes, 0 clears it
+	// example: 120
+	EstimateMinutes *int `json:"estimate_minutes"`
+
+	// New deadline; send null in a merge patch to clear it
+	// example: 2024-05-20T18:00:00Z
+	DueAt *time.Time `json:"due_at"`
+
+	// New RRULE, an empty string clears it
+	// example: FREQ=MONTHLY;BYMONTHDAY=1
+	Recurrence *string `json:"recurrence"`
+}
+
+// ReplaceTaskRequest model
+// swagger:model
+type ReplaceTaskRequest struct {
+	// Title of the task
+	// required: true
+	// example: Buy organic milk
+	Title string `json:"title"`
+
+	// Description of the task
+	// example: 2 liters of organic milk
+	Description string `json:"description"`
+
+	// Completion status, defaults to false unless status_id is given
+	// example: false
+	Completed *bool `json:"completed"`
+
+	// One of none, low, medium, high, urgent
+	// example: low
+	Priority string `json:"priority"`
+
+	// Workflow column, only for lists with a workflow
+	// example: 3
+	StatusID *int `json:"status_id"`
+
+	// Estimate in minutes, null or 0 for none
+	// example: 90
+	EstimateMinutes *int `json:"estimate_minutes"`
+
+	// Deadline, null for none
+	// example: 2024-05-20T18:00:00Z
+	DueAt *time.Time `json:"due_at"`
+
+	// iCalendar RRULE for a recurring task, requires due_at
+	// example: FREQ=WEEKLY;BYDAY=MO
+	Recurrence string `json:"recurrence"`
+}
+
+func (r ReplaceTaskRequest) fields() (service.TaskFields, error) {
+	priority, err := parsePriority(r.Priority)
+	if err != nil {
+		return service.TaskFields{}, err
+	}
+	return service.TaskFields{
+		Title:       &r.Title,
+		Description: &r.Description,
+		Completed:   r.Completed,
+		Priority:    &priority,
+		StatusID:    r.StatusID,
+		Estimate:    r.EstimateMinutes,
+		DueAt:       r.DueAt,
+		Recurrence:  &r.Recurrence,
+	}, nil
+}
+
+func parsePriority(name string) (models.Priority, error) {
+	priority, err := models.ParsePriority(name)
+	if err != nil {
+		return 0, apperrors.Validation(apperrors.Field("priority", "oneof", "must be one of: none low medium high urgent"))
+	}
+	return priority, nil
+}
+
+// MoveTaskRequest model
+// swagger:model
+type MoveTaskRequest struct {
+	// Target todo list ID
+	// required: true
+	// example: 7
+	ListID int `json:"list_id" validate:"required"`
+
+	// Place the task right after this task of the target list
+	// example: 12
+	AfterID int `json:"after_id"`
+
+	// Place the task right before this task of the target list
+	// example: 13
+	BeforeID int `json:"before_id"`
+}
+
+// CopyTaskRequest model
+// swagger:model
+type CopyTaskRequest struct {
+	// Target todo list ID, defaults to the task's own list
+	// example: 7
+	ListID int `json:"list_id"`
 }
 
 // GetTasksByListHandler godoc
@@ -62,29 +206,156 @@ type UpdateTaskRequest struct {
 // @Security Bearer
 // @Produce json
 // @Param list_id path int true "Todo List ID"
+// @Param completed query bool false "Only completed or only open tasks"
+// @Param created_after query string false "Created at or after (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param created_before query string false "Created before (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param updated_after query string false "Updated at or after (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param updated_before query string false "Updated before (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param completed_after query string false "Completed at or after (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param completed_before query string false "Completed before (RFC 3339, or YYYY-MM-DD in the profile time zone)"
+// @Param priority query string false "Only tasks with this priority (none, low, medium, high, urgent)"
+// @Param status_id query int false "Only tasks in this workflow column"
+// @Param assignee query int false "Only tasks assigned to this user"
+// @Param sort query string false "position, created_at, updated_at, completed_at, title, priority or due_at; prefix with - for descending order"
 // @Success 200 {array} models.Task
-// @Failure 400 {object} responses.Response
-// @Failure 401 {object} responses.Response
-// @Failure 404 {object} responses.Response
-// @Failure 500 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /todolists/{list_id}/tasks [get]
 func (h *taskHandler) GetTasksByListHandler(c echo.Context) error {
 	listID, err := utils.GetParam(c, "list_id")
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid list ID")
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	settings, err := h.profileService.DateSettings(userID)
+	if err != nil {
+		return err
 	}
-	userID, ok := c.Get("user_id").(float64) // JWT Claims возвращают float64 для чисел
-	if !ok {
-		return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid or missing token")
+	filter, err := taskFilter(c, settings.Location)
+	if err != nil {
+		return err
 	}
-	tasks, err := h.taskService.GetAllTasksForList(listID, int(userID))
+	tasks, err := h.taskService.GetAllTasksForList(listID, userID, filter)
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusInternalServerError, "error", "Could not fetch tasks for the list")
+		return err
 	}
 
 	return c.JSON(http.StatusOK, tasks)
 }
 
+// taskFilter reads the list filters from the query string; dates are
+// taken as midnight in loc
+func taskFilter(c echo.Context, loc *time.Location) (service.TaskFilter, error) {
+	filter := service.TaskFilter{Sort: c.QueryParam("sort")}
+	var fields []apperrors.FieldError
+
+	if raw := c.QueryParam("completed"); raw != "" {
+		completed, err := strconv.ParseBool(raw)
+		if err != nil {
+			fields = append(fields, apperrors.Field("completed", "boolean", "must be true or false"))
+		} else {
+			filter.Completed = &completed
+		}
+	}
+	if raw := c.QueryParam("priority"); raw != "" {
+		priority, err := models.ParsePriority(raw)
+		if err != nil {
+			fields = append(fields, apperrors.Field("priority", "oneof", "must be one of: none low medium high urgent"))
+		} else {
+			filter.Priority = &priority
+		}
+	}
+	if raw := c.QueryParam("status_id"); raw != "" {
+		statusID, err := strconv.Atoi(raw)
+		if err != nil || statusID <= 0 {
+			fields = append(fields, apperrors.Field("status_id", "integer", "must be a positive integer"))
+		} else {
+			filter.StatusID = &statusID
+		}
+	}
+	if raw := c.QueryParam("assignee"); raw != "" {
+		assignee, err := strconv.Atoi(raw)
+		if err != nil || assignee <= 0 {
+			fields = append(fields, apperrors.Field("assignee", "integer", "must be a positive integer"))
+		} else {
+			filter.AssignedTo = &assignee
+		}
+	}
+
+	dates := []struct {
+		param string
+		dst   **time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+		{"updated_after", &filter.UpdatedAfter},
+		{"updated_before", &filter.UpdatedBefore},
+		{"completed_after", &filter.CompletedAfter},
+		{"completed_before", &filter.CompletedBefore},
+	}
+	for _, d := range dates {
+		raw := c.QueryParam(d.param)
+		if raw == "" {
+			continue
+		}
+		t, err := parseTimeIn(raw, loc)
+		if err != nil {
+			fields = append(fields, apperrors.Field(d.param, "datetime", "must be an RFC 3339 timestamp or a YYYY-MM-DD date"))
+			continue
+		}
+		*d.dst = &t
+	}
+
+	if len(fields) > 0 {
+		return filter, apperrors.Validation(fields...)
+	}
+	return filter, nil
+}
+
+// parseTime accepts RFC 3339 timestamps and plain dates (midnight UTC)
+func parseTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+// GetTaskHandler godoc
+// @Summary Get task
+// @Description Get a single task from the specified todo list
+// @Tags tasks
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Success 200 {object} models.Task
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id} [get]
+func (h *taskHandler) GetTaskHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	task, err := h.taskService.GetTaskByID(taskID, userID)
+	if err != nil {
+		return err
+	}
+	if task.ListID != listID {
+		return apperrors.ErrTaskNotFound
+	}
+
+	return c.JSON(http.StatusOK, task)
+}
+
 // PostTaskHandler godoc
 // @Summary Create new task
 // @Description Create new task in specified todo list
@@ -94,107 +365,355 @@ func (h *taskHandler) GetTasksByListHandler(c echo.Context) error {
 // @Produce json
 // @Param list_id path int true "Todo List ID"
 // @Param request body handlers.CreateTaskRequest true "Task data"
-// @Success 201 {object} responses.Response
-// @Failure 400 {object} responses.Response
-// @Failure 401 {object} responses.Response
-// @Failure 404 {object} responses.Response
-// @Failure 500 {object} responses.Response
+// @Success 201 {object} models.Task
+// @Header 201 {string} Location "URL of the created task"
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /todolists/{list_id}/tasks [post]
 func (h *taskHandler) PostTaskHandler(c echo.Context) error {
 	listID, err := utils.GetParam(c, "list_id")
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid list ID")
+		return err
 	}
-	userID, ok := c.Get("user_id").(float64) // JWT Claims возвращают float64 для чисел
-	if !ok {
-		return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid or missing token")
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
 	}
-	_, err = h.todoListService.GetListByID(listID, int(userID)) //На этом уровне идёт проверка, принадлежит ли данный лист этому пользователю
+	_, err = h.todoListService.GetListByID(listID, userID) //На этом уровне идёт проверка, принадлежит ли данный лист этому пользователю
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusNotFound, "error", "TodoList with this ID does not exist")
+		return err
 	}
 
 	var req CreateTaskRequest
 	if err := c.Bind(&req); err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid request body")
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+
+	priority, err := parsePriority(req.Priority)
+	if err != nil {
+		return err
+	}
+	task, err := h.taskService.WithActor(actor(c)).CreateTask(listID, userID, service.TaskFields{
+		Title:       &req.Title,
+		Description: &req.Description,
+		Priority:    &priority,
+		StatusID:    req.StatusID,
+		Estimate:    req.EstimateMinutes,
+		DueAt:       req.DueAt,
+		Recurrence:  &req.Recurrence,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/todolists/%d/tasks/%d", listID, task.ID))
+	return c.JSON(http.StatusCreated, task)
+}
+
+// PutTaskHandler godoc
+// @Summary Replace task
+// @Description Replace all editable fields of a task
+// @Tags tasks
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param request body handlers.ReplaceTaskRequest true "Task data"
+// @Success 200 {object} models.Task
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id} [put]
+func (h *taskHandler) PutTaskHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
 	}
 
-	err = h.taskService.CreateTask(req.Title, req.Description, listID)
+	var req ReplaceTaskRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	fields, err := req.fields()
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusInternalServerError, "error", "Could not create task")
+		return err
+	}
+	task, err := h.taskService.WithActor(actor(c)).ReplaceTask(listID, taskID, userID, fields)
+	if err != nil {
+		return err
 	}
 
-	return utils.JSONResponse(c, http.StatusCreated, "ok", "Task was successfully created")
+	return c.JSON(http.StatusOK, task)
 }
 
 // PatchTaskHandler godoc
 // @Summary Update task
-// @Description Update task details (title, description, completed status)
+// @Description Update task details (title, description, completed status, priority, workflow column, estimate, due date, recurrence).
+// @Description Accepts a plain JSON object, an RFC 7386 merge patch (null clears a field)
+// @Description or an RFC 6902 JSON patch. Only title, description, completed, priority, status_id, estimate_minutes, due_at and recurrence can be patched.
+// @Description A patch document fails with 409 task_changed when another request changes the task meanwhile.
 // @Tags tasks
 // @Security Bearer
 // @Accept json
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
 // @Produce json
 // @Param list_id path int true "Todo List ID"
 // @Param id path int true "Task ID"
 // @Param request body handlers.UpdateTaskRequest true "Task update data"
-// @Success 200 {object} responses.Response
-// @Failure 400 {object} responses.Response
-// @Failure 401 {object} responses.Response
-// @Failure 404 {object} responses.Response
-// @Failure 500 {object} responses.Response
+// @Success 200 {object} models.Task
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 413 {object} responses.Problem
 // @Router /todolists/{list_id}/tasks/{id} [patch]
 func (h *taskHandler) PatchTaskHandler(c echo.Context) error {
-	taskID, err := utils.GetParam(c, "id")
+	listID, taskID, userID, err := taskParams(c)
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid task ID")
+		return err
+	}
+	if isPatchDocument(c) {
+		return h.applyTaskPatch(c, listID, taskID, userID)
 	}
 
 	var req UpdateTaskRequest
 	if err := c.Bind(&req); err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid request body")
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	fields := service.TaskFields{Title: req.Title, Description: req.Description, Completed: req.Completed, StatusID: req.StatusID, Estimate: req.EstimateMinutes, DueAt: req.DueAt, Recurrence: req.Recurrence}
+	if req.Priority != nil {
+		priority, err := parsePriority(*req.Priority)
+		if err != nil {
+			return err
+		}
+		fields.Priority = &priority
 	}
-	userID, ok := c.Get("user_id").(float64) // JWT Claims возвращают float64 для чисел
-	if !ok {
-		return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid or missing token")
+	task, err := h.taskService.WithActor(actor(c)).UpdateTask(listID, taskID, userID, fields)
+	if err != nil {
+		return err
 	}
-	err = h.taskService.UpdateTask(taskID, int(userID), req.Title, req.Description, req.Completed)
+
+	return c.JSON(http.StatusOK, task)
+}
+
+func (h *taskHandler) applyTaskPatch(c echo.Context, listID, taskID, userID int) error {
+	task, err := h.taskService.GetTaskByID(taskID, userID)
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusInternalServerError, "error", err.Error())
+		return err
+	}
+	if task.ListID != listID {
+		return apperrors.ErrTaskNotFound
+	}
+
+	current := ReplaceTaskRequest{
+		Title:           task.Title,
+		Description:     task.Description,
+		Completed:       &task.Completed,
+		Priority:        task.Priority.String(),
+		StatusID:        task.StatusID,
+		EstimateMinutes: task.Estimate,
+		DueAt:           task.DueAt,
+		Recurrence:      task.Recurrence,
+	}
+	var patched ReplaceTaskRequest
+	if err := applyPatchDocument(c, current, &patched); err != nil {
+		return err
+	}
+	// Менять нужно только то поле, которое затронул патч, иначе старое
+	// значение второго поля будет противоречить новому
+	completedChanged := patched.Completed == nil || *patched.Completed != task.Completed
+	if !sameID(patched.StatusID, task.StatusID) && !completedChanged {
+		patched.Completed = nil
+	} else if completedChanged && sameID(patched.StatusID, task.StatusID) {
+		patched.StatusID = nil
 	}
 
-	return utils.JSONResponse(c, http.StatusOK, "ok", "Task updated successfully")
+	fields, err := patched.fields()
+	if err != nil {
+		return err
+	}
+	// Патч применён к прочитанной версии задачи; если её успели изменить,
+	// замена затёрла бы чужое изменение
+	fields.UpdatedAt = &task.UpdatedAt
+	task, err = h.taskService.WithActor(actor(c)).ReplaceTask(listID, taskID, userID, fields)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, task)
 }
 
 // DeleteTaskHandler godoc
 // @Summary Delete task
-// @Description Delete task from todo list
+// @Description Move task to the trash
 // @Tags tasks
 // @Security Bearer
 // @Produce json
 // @Param list_id path int true "Todo List ID"
 // @Param id path int true "Task ID"
 // @Success 200 {object} responses.Response
-// @Failure 400 {object} responses.Response
-// @Failure 401 {object} responses.Response
-// @Failure 404 {object} responses.Response
-// @Failure 500 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /todolists/{list_id}/tasks/{id} [delete]
 func (h *taskHandler) DeleteTaskHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	err = h.taskService.WithActor(actor(c)).DeleteTask(listID, taskID, userID)
+	if err != nil {
+		return err
+	}
+
+	return utils.JSONResponse(c, http.StatusOK, "ok", "Task deleted successfully")
+}
+
+// MoveTaskHandler godoc
+// @Summary Move task
+// @Description Move task to another todo list of the same user.
+// @Description The task is appended to the end unless after_id or before_id is given.
+// @Tags tasks
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param id path int true "Task ID"
+// @Param request body handlers.MoveTaskRequest true "Target list"
+// @Success 200 {object} models.Task
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /tasks/{id}/move [post]
+func (h *taskHandler) MoveTaskHandler(c echo.Context) error {
+	taskID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req MoveTaskRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	task, err := h.taskService.WithActor(actor(c)).MoveTask(taskID, userID, req.ListID, req.AfterID, req.BeforeID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, task)
+}
+
+// CopyTaskHandler godoc
+// @Summary Copy task
+// @Description Copy task into the same or another todo list of the same user
+// @Tags tasks
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param id path int true "Task ID"
+// @Param request body handlers.CopyTaskRequest false "Target list"
+// @Success 201 {object} models.Task
+// @Header 201 {string} Location "URL of the created task"
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /tasks/{id}/copy [post]
+func (h *taskHandler) CopyTaskHandler(c echo.Context) error {
 	taskID, err := utils.GetParam(c, "id")
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid task ID")
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
 	}
-	userID, ok := c.Get("user_id").(float64) // JWT Claims возвращают float64 для чисел
-	if !ok {
-		return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid or missing token")
+
+	var req CopyTaskRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
 	}
-	err = h.taskService.DeleteTask(taskID, int(userID))
+
+	task, err := h.taskService.WithActor(actor(c)).CopyTask(taskID, userID, req.ListID)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return utils.JSONResponse(c, http.StatusNotFound, "error", "Task with this ID does not exist")
-		}
-		return utils.JSONResponse(c, http.StatusInternalServerError, "error", "Could not delete the task")
+		return err
 	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/todolists/%d/tasks/%d", task.ListID, task.ID))
+	return c.JSON(http.StatusCreated, task)
+}
 
-	return utils.JSONResponse(c, http.StatusOK, "ok", "Task deleted successfully")
+// ReorderTaskHandler godoc
+// @Summary Reorder task
+// @Description Move task within its list right after after_id or right before before_id
+// @Tags tasks
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param request body handlers.ReorderRequest true "New neighbours"
+// @Success 200 {object} models.Task
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/reorder [post]
+func (h *taskHandler) ReorderTaskHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+
+	var req ReorderRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+
+	task, err := h.taskService.WithActor(actor(c)).ReorderTask(listID, taskID, userID, req.AfterID, req.BeforeID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, task)
+}
+
+// taskParams extracts list ID, task ID and the authenticated user from the request
+func taskParams(c echo.Context) (int, int, int, error) {
+	listID, err := utils.GetParam(c, "list_id")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	taskID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return listID, taskID, userID, nil
+}
+
+func sameID(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
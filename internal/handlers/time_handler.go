@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"encoding/csv"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type TimeHandler interface {
+	StartTimerHandler(c echo.Context) error
+	StopTaskTimerHandler(c echo.Context) error
+	GetTimerHandler(c echo.Context) error
+	StopTimerHandler(c echo.Context) error
+	GetTimeEntriesHandler(c echo.Context) error
+	PostTimeEntryHandler(c echo.Context) error
+	DeleteTimeEntryHandler(c echo.Context) error
+	GetListTimeHandler(c echo.Context) error
+	GetTimeReportHandler(c echo.Context) error
+}
+
+type timeHandler struct {
+	timeService    service.TimeService
+	profileService service.ProfileService
+}
+
+func NewTimeHandler(timeService service.TimeService, profileService service.ProfileService) TimeHandler {
+	return &timeHandler{timeService: timeService, profileService: profileService}
+}
+
+// StopTimerRequest model
+// swagger:model
+type StopTimerRequest struct {
+	// Note to keep with the entry
+	// example: Fixed the login form
+	Note string `json:"note"`
+}
+
+// TimeEntryRequest model
+// swagger:model
+type TimeEntryRequest struct {
+	// When the work started
+	// required: true
+	// example: 2024-05-17T09:30:00Z
+	StartedAt time.Time `json:"started_at" validate:"required"`
+
+	// Length of the work in seconds, at most 24 hours
+	// required: true
+	// example: 5400
+	DurationSeconds int64 `json:"duration_seconds" validate:"required"`
+
+	// example: Call with the client
+	Note string `json:"note"`
+}
+
+// StartTimerHandler godoc
+// @Summary Start timer
+// @Description Start measuring time on the task. A user can run one timer at a time; starting another is refused with 409.
+// @Tags time
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Success 201 {object} models.TimeEntry
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/timer/start [post]
+func (h *timeHandler) StartTimerHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	entry, err := h.timeService.WithActor(actor(c)).StartTimer(listID, taskID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, entry)
+}
+
+// StopTaskTimerHandler godoc
+// @Summary Stop timer
+// @Description Stop the caller's timer running on the task and keep the measured time as an entry
+// @Tags time
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param request body handlers.StopTimerRequest false "Note"
+// @Success 200 {object} models.TimeEntry
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/timer/stop [post]
+func (h *timeHandler) StopTaskTimerHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	var req StopTimerRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	entry, err := h.timeService.WithActor(actor(c)).StopTaskTimer(listID, taskID, userID, req.Note)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+// GetTimerHandler godoc
+// @Summary Get running timer
+// @Tags time
+// @Security Bearer
+// @Produce json
+// @Success 200 {object} models.TimeEntry
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/timer [get]
+func (h *timeHandler) GetTimerHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	entry, err := h.timeService.GetRunningTimer(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+// StopTimerHandler godoc
+// @Summary Stop running timer
+// @Description Stop the caller's timer whatever task it runs on, including tasks moved to the trash
+// @Tags time
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param request body handlers.StopTimerRequest false "Note"
+// @Success 200 {object} models.TimeEntry
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/timer/stop [post]
+func (h *timeHandler) StopTimerHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	var req StopTimerRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	entry, err := h.timeService.WithActor(actor(c)).StopTimer(userID, req.Note)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+// GetTimeEntriesHandler godoc
+// @Summary Get time entries
+// @Description Time logged on the task, oldest first, with the total of the finished entries
+// @Tags time
+// @Security Bearer
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Success 200 {object} service.TaskTimeEntries
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/time-entries [get]
+func (h *timeHandler) GetTimeEntriesHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	entries, err := h.timeService.GetTaskEntries(listID, taskID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// PostTimeEntryHandler godoc
+// @Summary Log time
+// @Description Add a manual time entry to the task
+// @Tags time
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param request body handlers.TimeEntryRequest true "Time entry"
+// @Success 201 {object} models.TimeEntry
+// @Header 201 {string} Location "URL of the task's time entries"
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/time-entries [post]
+func (h *timeHandler) PostTimeEntryHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	var req TimeEntryRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	entry, err := h.timeService.WithActor(actor(c)).LogTime(listID, taskID, userID, req.StartedAt, duration, req.Note)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/todolists/%d/tasks/%d/time-entries", listID, taskID))
+	return c.JSON(http.StatusCreated, entry)
+}
+
+// DeleteTimeEntryHandler godoc
+// @Summary Delete time entry
+// @Description Delete a time entry; deleting a running timer discards it
+// @Tags time
+// @Security Bearer
+// @Param list_id path int true "Todo List ID"
+// @Param id path int true "Task ID"
+// @Param entry_id path int true "Time entry ID"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{list_id}/tasks/{id}/time-entries/{entry_id} [delete]
+func (h *timeHandler) DeleteTimeEntryHandler(c echo.Context) error {
+	listID, taskID, userID, err := taskParams(c)
+	if err != nil {
+		return err
+	}
+	entryID, err := utils.GetParam(c, "entry_id")
+	if err != nil {
+		return err
+	}
+	if err := h.timeService.WithActor(actor(c)).DeleteEntry(listID, taskID, entryID, userID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetListTimeHandler godoc
+// @Summary Get list time
+// @Description Estimates and logged time of every task of the list, with the list totals. Running timers are not counted.
+// @Tags time
+// @Security Bearer
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Success 200 {object} service.ListTime
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id}/time [get]
+func (h *timeHandler) GetListTimeHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	total, err := h.timeService.GetListTime(listID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, total)
+}
+
+// GetTimeReportHandler godoc
+// @Summary Get time report
+// @Description Time logged on the caller's lists, grouped by list, by day or by week. Weeks start on the first day of the week of the profile.
+// @Description Entries count in the period they started in;
+// @Description running timers and tasks in the trash are left out. Without from the report covers the 30 days before to.
+// @Tags time
+// @Security Bearer
+// @Produce json
+// @Produce text/csv
+// @Param from query string false "Started at or after (RFC 3339, or YYYY-MM-DD in tz)"
+// @Param to query string false "Started before (RFC 3339, or YYYY-MM-DD in tz), now by default"
+// @Param group_by query string true "list, day or week"
+// @Param tz query string false "IANA time zone of the days, the profile time zone by default"
+// @Param format query string false "json (default) or csv"
+// @Success 200 {object} service.TimeReport
+// @Failure 401 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /reports/time [get]
+func (h *timeHandler) GetTimeReportHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	settings, err := h.profileService.DateSettings(userID)
+	if err != nil {
+		return err
+	}
+	filter := service.TimeReportFilter{GroupBy: c.QueryParam("group_by"), Location: settings.Location, WeekStart: settings.WeekStart}
+	var fields []apperrors.FieldError
+	if tz := c.QueryParam("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			fields = append(fields, apperrors.Field("tz", "timezone", "must be an IANA time zone such as Europe/Moscow"))
+		} else {
+			filter.Location = loc
+		}
+	}
+	for _, d := range []struct {
+		param string
+		dst   **time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		raw := c.QueryParam(d.param)
+		if raw == "" {
+			continue
+		}
+		t, err := parseTimeIn(raw, filter.Location)
+		if err != nil {
+			fields = append(fields, apperrors.Field(d.param, "datetime", "must be an RFC 3339 timestamp or a YYYY-MM-DD date"))
+			continue
+		}
+		*d.dst = &t
+	}
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		fields = append(fields, apperrors.Field("format", "oneof", "must be one of: json csv"))
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation(fields...)
+	}
+
+	report, err := h.timeService.GetReport(userID, filter)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		return c.JSON(http.StatusOK, report)
+	}
+
+	res := c.Response()
+	filename := "time-" + report.GroupBy + "-" + time.Now().UTC().Format("20060102-150405") + ".csv"
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(res)
+	header := []string{"list_id", "list_title", "entries", "seconds", "hours"}
+	switch report.GroupBy {
+	case "day":
+		header = []string{"day", "entries", "seconds", "hours"}
+	case "week":
+		header = []string{"week", "entries", "seconds", "hours"}
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, row := range report.Rows {
+		var record []string
+		switch report.GroupBy {
+		case "day":
+			record = []string{*row.Day}
+		case "week":
+			record = []string{*row.Week}
+		default:
+			record = []string{strconv.Itoa(*row.ListID), *row.ListTitle}
+		}
+		record = append(record, strconv.Itoa(row.Entries), strconv.FormatInt(row.TrackedSeconds, 10), hours(row.TrackedSeconds))
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// parseTimeIn is parseTime with dates taken as midnight in loc
+func parseTimeIn(raw string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", raw, loc)
+}
+
+// hours formats seconds as decimal hours for billing, e.g. "1.50"
+func hours(seconds int64) string {
+	return strconv.FormatFloat(float64(seconds)/3600, 'f', 2, 64)
+}
@@ -1,19 +1,23 @@
 package handlers
 
 import (
+	"RestAPI/internal/apperrors"
 	"RestAPI/internal/service"
 	"RestAPI/pkg/utils"
-	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
-	"gorm.io/gorm"
 	"net/http"
 )
 
 type TodoListHandler interface {
 	GetTodoListHandler(c echo.Context) error
+	GetTodoListByIDHandler(c echo.Context) error
 	PostTodoListHandler(c echo.Context) error
+	PutTodoListHandler(c echo.Context) error
 	PatchTodoListHandler(c echo.Context) error
 	DeleteTodoListHandler(c echo.Context) error
+	DuplicateTodoListHandler(c echo.Context) error
+	ReorderTodoListHandler(c echo.Context) error
 }
 
 type todoListHandler struct {
@@ -38,6 +42,50 @@ type UpdateTodoListRequest struct {
 	// required: true
 	// example: Updated Shopping List
 	Title string `json:"title"`
+
+	// Refuse to complete tasks while they wait for open tasks
+	// example: false
+	BlockCompletion bool `json:"block_completion"`
+}
+
+// PatchTodoListRequest model
+// swagger:model
+type PatchTodoListRequest struct {
+	// New title for the list
+	// example: Updated Shopping List
+	Title *string `json:"title"`
+
+	// Refuse to complete tasks while they wait for open tasks
+	// example: true
+	BlockCompletion *bool `json:"block_completion"`
+}
+
+func (r UpdateTodoListRequest) fields() service.ListFields {
+	return service.ListFields{Title: &r.Title, BlockCompletion: &r.BlockCompletion}
+}
+
+// DuplicateTodoListRequest model
+// swagger:model
+type DuplicateTodoListRequest struct {
+	// Title of the copy, defaults to "<title> (copy)"
+	// example: Shopping List for next week
+	Title string `json:"title"`
+
+	// Mark every copied task as not completed
+	// example: true
+	ResetCompleted bool `json:"reset_completed"`
+}
+
+// ReorderRequest describes the new neighbours of a dragged item
+// swagger:model
+type ReorderRequest struct {
+	// Place the item right after this one
+	// example: 3
+	AfterID int `json:"after_id"`
+
+	// Place the item right before this one
+	// example: 4
+	BeforeID int `json:"before_id"`
 }
 
 // GetTodoListHandler godoc
@@ -47,21 +95,50 @@ type UpdateTodoListRequest struct {
 // @Security Bearer
 // @Produce json
 // @Success 200 {array} models.TodoList
-// @Failure 401 {object} responses.Response
-// @Failure 500 {object} responses.Response
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /todolists [get]
 func (h *todoListHandler) GetTodoListHandler(c echo.Context) error {
-	userID, ok := c.Get("user_id").(float64) // JWT Claims возвращают float64 для чисел
-	if !ok {
-		return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid or missing token")
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
 	}
-	todoLists, err := h.todoListService.GetAllLists(int(userID))
+	todoLists, err := h.todoListService.GetAllLists(userID)
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusInternalServerError, "error", "Could not fetch todo lists")
+		return err
 	}
 	return c.JSON(http.StatusOK, todoLists)
 }
 
+// GetTodoListByIDHandler godoc
+// @Summary Get todo list
+// @Description Retrieve a single todo list with its tasks
+// @Tags todolists
+// @Security Bearer
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Success 200 {object} models.TodoList
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id} [get]
+func (h *todoListHandler) GetTodoListByIDHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	list, err := h.todoListService.GetListByID(listID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
 // PostTodoListHandler godoc
 // @Summary Create new todo list
 // @Description Create new todo list for authenticated user
@@ -70,89 +147,230 @@ func (h *todoListHandler) GetTodoListHandler(c echo.Context) error {
 // @Accept json
 // @Produce json
 // @Param request body handlers.CreateTodoListRequest true "List data"
-// @Success 201 {object} responses.Response
-// @Failure 400 {object} responses.Response
-// @Failure 401 {object} responses.Response
-// @Failure 500 {object} responses.Response
+// @Success 201 {object} models.TodoList
+// @Header 201 {string} Location "URL of the created list"
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /todolists [post]
 func (h *todoListHandler) PostTodoListHandler(c echo.Context) error {
 	var req CreateTodoListRequest
 	if err := c.Bind(&req); err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid request body")
+		return apperrors.ErrInvalidBody.Wrap(err)
 	}
-	userID, ok := c.Get("user_id").(float64) // JWT Claims возвращают float64 для чисел
-	if !ok {
-		return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid or missing token")
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
 	}
-	err := h.todoListService.CreateList(req.Title, int(userID))
+	list, err := h.todoListService.WithActor(actor(c)).CreateList(req.Title, userID)
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", err.Error())
+		return err
 	}
-	return utils.JSONResponse(c, http.StatusCreated, "ok", "TodoList was successfully created")
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/todolists/%d", list.ID))
+	return c.JSON(http.StatusCreated, list)
+}
+
+// PutTodoListHandler godoc
+// @Summary Replace todo list
+// @Description Replace all editable fields of an existing todo list
+// @Tags todolists
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Param request body handlers.UpdateTodoListRequest true "List data"
+// @Success 200 {object} models.TodoList
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id} [put]
+func (h *todoListHandler) PutTodoListHandler(c echo.Context) error {
+	var req UpdateTodoListRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	return h.updateList(c, req.fields())
 }
 
 // PatchTodoListHandler godoc
 // @Summary Update todo list
-// @Description Update title of existing todo list
+// @Description Update title or settings of existing todo list.
+// @Description Accepts a plain JSON object, an RFC 7386 merge patch or an RFC 6902 JSON patch.
 // @Tags todolists
 // @Security Bearer
 // @Accept json
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
 // @Produce json
 // @Param id path int true "Todo List ID"
-// @Param request body handlers.UpdateTodoListRequest true "New title"
-// @Success 200 {object} responses.Response
-// @Failure 400 {object} responses.Response
-// @Failure 401 {object} responses.Response
-// @Failure 404 {object} responses.Response
-// @Failure 500 {object} responses.Response
+// @Param request body handlers.PatchTodoListRequest true "Changed fields"
+// @Success 200 {object} models.TodoList
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 413 {object} responses.Problem
 // @Router /todolists/{id} [patch]
 func (h *todoListHandler) PatchTodoListHandler(c echo.Context) error {
+	if isPatchDocument(c) {
+		return h.applyListPatch(c)
+	}
+	var req PatchTodoListRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	return h.updateList(c, service.ListFields{Title: req.Title, BlockCompletion: req.BlockCompletion})
+}
+
+func (h *todoListHandler) applyListPatch(c echo.Context) error {
 	listID, err := utils.GetParam(c, "id")
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Bad ID")
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	list, err := h.todoListService.GetListByID(listID, userID)
+	if err != nil {
+		return err
 	}
 
-	var req UpdateTodoListRequest
-	if err := c.Bind(&req); err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Invalid request body")
+	var patched UpdateTodoListRequest
+	current := UpdateTodoListRequest{Title: list.Title, BlockCompletion: list.BlockCompletion}
+	if err := applyPatchDocument(c, current, &patched); err != nil {
+		return err
+	}
+
+	list, err = h.todoListService.WithActor(actor(c)).UpdateList(listID, userID, patched.fields())
+	if err != nil {
+		return err
 	}
-	userID, ok := c.Get("user_id").(float64) // JWT Claims возвращают float64 для чисел
-	if !ok {
-		return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid or missing token")
+	return c.JSON(http.StatusOK, list)
+}
+
+func (h *todoListHandler) updateList(c echo.Context, fields service.ListFields) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
 	}
-	if err := h.todoListService.UpdateList(listID, int(userID), req.Title); err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return utils.JSONResponse(c, http.StatusNotFound, "error", "Todo list not found")
-		}
-		return utils.JSONResponse(c, http.StatusInternalServerError, "error", err.Error())
+	list, err := h.todoListService.WithActor(actor(c)).UpdateList(listID, userID, fields)
+	if err != nil {
+		return err
 	}
-	return utils.JSONResponse(c, http.StatusOK, "ok", "List updated successfully")
+	return c.JSON(http.StatusOK, list)
 }
 
 // DeleteTodoListHandler godoc
 // @Summary Delete todo list
-// @Description Delete todo list and all its tasks
+// @Description Move todo list and all its tasks to the trash
 // @Tags todolists
 // @Security Bearer
 // @Produce json
 // @Param id path int true "Todo List ID"
 // @Success 200 {object} responses.Response
-// @Failure 400 {object} responses.Response
-// @Failure 401 {object} responses.Response
-// @Failure 404 {object} responses.Response
-// @Failure 500 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
 // @Router /todolists/{id} [delete]
 func (h *todoListHandler) DeleteTodoListHandler(c echo.Context) error {
 	listID, err := utils.GetParam(c, "id")
 	if err != nil {
-		return utils.JSONResponse(c, http.StatusBadRequest, "error", "Bad ID")
+		return err
 	}
-	userID, ok := c.Get("user_id").(float64) // JWT Claims возвращают float64 для чисел
-	if !ok {
-		return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid or missing token")
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
 	}
-	if err := h.todoListService.DeleteList(listID, int(userID)); err != nil {
-		return utils.JSONResponse(c, http.StatusInternalServerError, "error", err.Error())
+	if err := h.todoListService.WithActor(actor(c)).DeleteList(listID, userID); err != nil {
+		return err
 	}
 	return utils.JSONResponse(c, http.StatusOK, "ok", "List deleted successfully")
 }
+
+// DuplicateTodoListHandler godoc
+// @Summary Duplicate todo list
+// @Description Create a deep copy of the todo list with all its tasks
+// @Tags todolists
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Param request body handlers.DuplicateTodoListRequest false "Copy options"
+// @Success 201 {object} models.TodoList
+// @Header 201 {string} Location "URL of the created list"
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id}/duplicate [post]
+func (h *todoListHandler) DuplicateTodoListHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req DuplicateTodoListRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+
+	list, err := h.todoListService.WithActor(actor(c)).DuplicateList(listID, userID, req.Title, req.ResetCompleted)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/todolists/%d", list.ID))
+	return c.JSON(http.StatusCreated, list)
+}
+
+// ReorderTodoListHandler godoc
+// @Summary Reorder todo list
+// @Description Move list among the user's lists right after after_id or right before before_id
+// @Tags todolists
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Param request body handlers.ReorderRequest true "New neighbours"
+// @Success 200 {object} models.TodoList
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id}/reorder [post]
+func (h *todoListHandler) ReorderTodoListHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req ReorderRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+
+	list, err := h.todoListService.WithActor(actor(c)).ReorderList(listID, userID, req.AfterID, req.BeforeID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, list)
+}
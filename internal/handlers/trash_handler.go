@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+type TrashHandler interface {
+	GetTrashHandler(c echo.Context) error
+	RestoreHandler(c echo.Context) error
+	PurgeHandler(c echo.Context) error
+	EmptyTrashHandler(c echo.Context) error
+}
+
+type trashHandler struct {
+	trashService service.TrashService
+	retention    time.Duration
+}
+
+func NewTrashHandler(trashService service.TrashService, retention time.Duration) TrashHandler {
+	return &trashHandler{trashService: trashService, retention: retention}
+}
+
+// TrashItem model
+// swagger:model
+type TrashItem struct {
+	// list or task
+	// example: list
+	Type  string `json:"type"`
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	// List the task belongs to
+	ListID int `json:"list_id,omitempty"`
+	// Number of tasks deleted together with the list
+	TaskCount int       `json:"task_count,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+	// When the item will be permanently deleted
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// TrashResponse model
+// swagger:model
+type TrashResponse struct {
+	Lists []TrashItem `json:"lists"`
+	Tasks []TrashItem `json:"tasks"`
+}
+
+// GetTrashHandler godoc
+// @Summary Get trash
+// @Description List deleted todo lists and tasks that can still be restored
+// @Tags trash
+// @Security Bearer
+// @Produce json
+// @Success 200 {object} handlers.TrashResponse
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /trash [get]
+func (h *trashHandler) GetTrashHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	trash, err := h.trashService.GetTrash(userID)
+	if err != nil {
+		return err
+	}
+
+	resp := TrashResponse{
+		Lists: make([]TrashItem, 0, len(trash.Lists)),
+		Tasks: make([]TrashItem, 0, len(trash.Tasks)),
+	}
+	for _, list := range trash.Lists {
+		resp.Lists = append(resp.Lists, TrashItem{
+			Type:      service.TrashTypeList,
+			ID:        list.ID,
+			Title:     list.Title,
+			TaskCount: len(list.Tasks),
+			DeletedAt: list.DeletedAt.Time,
+			PurgeAt:   list.DeletedAt.Time.Add(h.retention),
+		})
+	}
+	for _, task := range trash.Tasks {
+		resp.Tasks = append(resp.Tasks, TrashItem{
+			Type:      service.TrashTypeTask,
+			ID:        task.ID,
+			Title:     task.Title,
+			ListID:    task.ListID,
+			DeletedAt: task.DeletedAt.Time,
+			PurgeAt:   task.DeletedAt.Time.Add(h.retention),
+		})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// RestoreHandler godoc
+// @Summary Restore from trash
+// @Description Restore a deleted list (together with the tasks deleted with it) or a single task
+// @Tags trash
+// @Security Bearer
+// @Produce json
+// @Param type path string true "Item type" Enums(list, task)
+// @Param id path int true "Item ID"
+// @Success 200 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /trash/{type}/{id}/restore [post]
+func (h *trashHandler) RestoreHandler(c echo.Context) error {
+	id, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.trashService.WithActor(actor(c)).Restore(c.Param("type"), id, userID); err != nil {
+		return err
+	}
+	return utils.JSONResponse(c, http.StatusOK, "ok", "Restored successfully")
+}
+
+// PurgeHandler godoc
+// @Summary Delete permanently
+// @Description Permanently delete a list or task that is in the trash
+// @Tags trash
+// @Security Bearer
+// @Produce json
+// @Param type path string true "Item type" Enums(list, task)
+// @Param id path int true "Item ID"
+// @Success 200 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /trash/{type}/{id} [delete]
+func (h *trashHandler) PurgeHandler(c echo.Context) error {
+	id, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.trashService.WithActor(actor(c)).Purge(c.Param("type"), id, userID); err != nil {
+		return err
+	}
+	return utils.JSONResponse(c, http.StatusOK, "ok", "Deleted permanently")
+}
+
+// EmptyTrashHandler godoc
+// @Summary Empty trash
+// @Description Permanently delete everything in the trash
+// @Tags trash
+// @Security Bearer
+// @Produce json
+// @Success 200 {object} responses.Response
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /trash [delete]
+func (h *trashHandler) EmptyTrashHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.trashService.WithActor(actor(c)).EmptyTrash(userID); err != nil {
+		return err
+	}
+	return utils.JSONResponse(c, http.StatusOK, "ok", "Trash emptied")
+}
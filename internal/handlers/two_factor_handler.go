@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// ConfirmTOTPRequest model
+// swagger:model
+type ConfirmTOTPRequest struct {
+	// Current code from the authenticator app
+	// required: true
+	// example: 123456
+	Code string `json:"code" validate:"required"`
+}
+
+// SecondFactorRequest confirms a change with a code or a recovery code
+// swagger:model
+type SecondFactorRequest struct {
+	// Code from the authenticator app; required unless recovery_code is given
+	// example: 123456
+	Code string `json:"code"`
+	// Unused recovery code, instead of the code
+	// example: k3n7q-x2m4p
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// DisableTwoFactorRequest model
+// swagger:model
+type DisableTwoFactorRequest struct {
+	// Current password of the account
+	// required: true
+	// example: P@ssw0rd!
+	Password string `json:"password" validate:"required"`
+	SecondFactorRequest
+}
+
+// RecoveryCodesResponse lists recovery codes; they are shown only once
+// swagger:model
+type RecoveryCodesResponse struct {
+	// example: ["k3n7q-x2m4p","a9d2f-q7w3e"]
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorHandler interface {
+	GetTwoFactorHandler(c echo.Context) error
+	EnrollTOTPHandler(c echo.Context) error
+	GetTOTPQRCodeHandler(c echo.Context) error
+	ConfirmTOTPHandler(c echo.Context) error
+	DisableTwoFactorHandler(c echo.Context) error
+	RegenerateRecoveryCodesHandler(c echo.Context) error
+	GetTrustedDevicesHandler(c echo.Context) error
+	DeleteTrustedDeviceHandler(c echo.Context) error
+}
+
+type twoFactorHandler struct {
+	twoFactorService service.TwoFactorService
+}
+
+func NewTwoFactorHandler(twoFactorService service.TwoFactorService) TwoFactorHandler {
+	return &twoFactorHandler{twoFactorService: twoFactorService}
+}
+
+// secondFactor binds the code of a request, one of the two fields is required
+func (r SecondFactorRequest) secondFactor() (service.SecondFactor, error) {
+	if r.Code == "" && r.RecoveryCode == "" {
+		return service.SecondFactor{}, apperrors.Validation(apperrors.Field("code", "required", "is required unless recovery_code is given"))
+	}
+	return service.SecondFactor{Code: r.Code, RecoveryCode: r.RecoveryCode}, nil
+}
+
+// GetTwoFactorHandler godoc
+// @Summary Get two-factor authentication status
+// @Tags account
+// @Security Bearer
+// @Produce json
+// @Success 200 {object} service.TwoFactorStatus
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/2fa [get]
+func (h *twoFactorHandler) GetTwoFactorHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	status, err := h.twoFactorService.Status(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// EnrollTOTPHandler godoc
+// @Summary Enroll authenticator app
+// @Description Generate a TOTP secret (RFC 6238, SHA-1, 6 digits, 30 seconds). Add it to an authenticator app by scanning
+// @Description the QR code or typing the secret, then confirm it with a code to turn two-factor authentication on.
+// @Description A new enrollment replaces an unconfirmed one.
+// @Tags account
+// @Security Bearer
+// @Produce json
+// @Success 201 {object} service.TOTPEnrollment
+// @Failure 401 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/2fa/totp [post]
+func (h *twoFactorHandler) EnrollTOTPHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	enrollment, err := h.twoFactorService.Enroll(userID)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusCreated, enrollment)
+}
+
+// GetTOTPQRCodeHandler godoc
+// @Summary Get enrollment QR code
+// @Description QR code of the otpauth link of the unconfirmed enrollment.
+// @Tags account
+// @Security Bearer
+// @Produce png
+// @Success 200 {file} file "PNG image"
+// @Failure 401 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/2fa/totp/qr.png [get]
+func (h *twoFactorHandler) GetTOTPQRCodeHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	png, err := h.twoFactorService.QRCode(userID)
+	if err != nil {
+		return err
+	}
+	// В коде секрет, его не должны сохранять кэши
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Blob(http.StatusOK, "image/png", png)
+}
+
+// ConfirmTOTPHandler godoc
+// @Summary Confirm authenticator app
+// @Description Turn two-factor authentication on with a code from the enrolled app. The answer holds recovery codes;
+// @Description each signs in once instead of a code. They are not shown again, store them somewhere safe.
+// @Tags account
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param request body ConfirmTOTPRequest true "Code from the app"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/2fa/totp/confirm [post]
+func (h *twoFactorHandler) ConfirmTOTPHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	var req ConfirmTOTPRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	codes, err := h.twoFactorService.WithActor(actor(c)).Confirm(userID, req.Code)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactorHandler godoc
+// @Summary Turn two-factor authentication off
+// @Description Needs the password and a code or a recovery code. The recovery codes and trusted devices are forgotten.
+// @Tags account
+// @Security Bearer
+// @Accept json
+// @Param request body DisableTwoFactorRequest true "Password and code"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/2fa/totp [delete]
+func (h *twoFactorHandler) DisableTwoFactorHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	var req DisableTwoFactorRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+	factor, err := req.secondFactor()
+	if err != nil {
+		return err
+	}
+
+	if err := h.twoFactorService.WithActor(actor(c)).Disable(userID, req.Password, factor); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodesHandler godoc
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes with a new set, confirmed with a code or a recovery code.
+// @Tags account
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param request body SecondFactorRequest true "Code"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/2fa/recovery-codes [post]
+func (h *twoFactorHandler) RegenerateRecoveryCodesHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	var req SecondFactorRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	factor, err := req.secondFactor()
+	if err != nil {
+		return err
+	}
+
+	codes, err := h.twoFactorService.WithActor(actor(c)).RegenerateRecoveryCodes(userID, factor)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// GetTrustedDevicesHandler godoc
+// @Summary Get trusted devices
+// @Description Devices remembered at login, which skip the second factor until they expire.
+// @Tags account
+// @Security Bearer
+// @Produce json
+// @Success 200 {array} models.TrustedDevice
+// @Failure 401 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/2fa/devices [get]
+func (h *twoFactorHandler) GetTrustedDevicesHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	devices, err := h.twoFactorService.GetDevices(userID)
+	if err != nil {
+		return err
+	}
+	if devices == nil {
+		devices = []models.TrustedDevice{}
+	}
+	return c.JSON(http.StatusOK, devices)
+}
+
+// DeleteTrustedDeviceHandler godoc
+// @Summary Forget trusted device
+// @Description The device needs the second factor again at its next login.
+// @Tags account
+// @Security Bearer
+// @Param id path int true "Device ID"
+// @Success 204
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /me/2fa/devices/{id} [delete]
+func (h *twoFactorHandler) DeleteTrustedDeviceHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	deviceID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	if err := h.twoFactorService.WithActor(actor(c)).DeleteDevice(userID, deviceID); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
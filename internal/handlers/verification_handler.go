@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// VerifyEmailRequest model
+// swagger:model
+type VerifyEmailRequest struct {
+	// Token from the verification link
+	// required: true
+	// example: 3f6c0a9d...
+	Token string `json:"token" validate:"required"`
+}
+
+// ResendVerificationRequest model
+// swagger:model
+type ResendVerificationRequest struct {
+	// Email of the account
+	// required: true
+	// example: john@example.com
+	Email string `json:"email" validate:"required"`
+}
+
+type VerificationHandler interface {
+	VerifyEmailHandler(c echo.Context) error
+	ResendVerificationHandler(c echo.Context) error
+	SendMyVerificationHandler(c echo.Context) error
+}
+
+type verificationHandler struct {
+	verificationService service.EmailVerificationService
+}
+
+func NewVerificationHandler(verificationService service.EmailVerificationService) VerificationHandler {
+	return &verificationHandler{verificationService: verificationService}
+}
+
+// VerifyEmailHandler godoc
+// @Summary Verify email
+// @Description Confirm the email address a verification link was sent to. The token is taken from the token query parameter
+// @Description of the link or from the body. Links expire, stop working once used and after the email is changed.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token query string false "Token from the link"
+// @Param request body VerifyEmailRequest false "Token from the link"
+// @Success 200 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /verify-email [post]
+// @Router /verify-email [get]
+func (h *verificationHandler) VerifyEmailHandler(c echo.Context) error {
+	req := VerifyEmailRequest{Token: c.QueryParam("token")}
+	if req.Token == "" && c.Request().Method == http.MethodPost {
+		if err := c.Bind(&req); err != nil {
+			return apperrors.ErrInvalidBody.Wrap(err)
+		}
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	if _, err := h.verificationService.WithActor(actor(c)).Verify(req.Token); err != nil {
+		return err
+	}
+	return utils.JSONResponse(c, http.StatusOK, "ok", "Email verified")
+}
+
+// ResendVerificationHandler godoc
+// @Summary Resend verification email
+// @Description Send a new verification link to the email if an account with it waits for verification.
+// @Description The answer is the same whether or not there is such an account.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ResendVerificationRequest true "Email of the account"
+// @Success 202 {object} responses.Response
+// @Failure 400 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /verify-email/resend [post]
+func (h *verificationHandler) ResendVerificationHandler(c echo.Context) error {
+	var req ResendVerificationRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+
+	if err := h.verificationService.SendTo(c.Request().Context(), req.Email); err != nil {
+		return err
+	}
+	return utils.JSONResponse(c, http.StatusAccepted, "ok", "If the email waits for verification, a link was sent to it")
+}
+
+// SendMyVerificationHandler godoc
+// @Summary Send verification email
+// @Description Send a new verification link to the email of the caller's profile. Emails can be requested once per interval and a few times a day.
+// @Tags account
+// @Security Bearer
+// @Produce json
+// @Success 202 {object} responses.Response
+// @Failure 401 {object} responses.Problem
+// @Failure 409 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 429 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Failure 503 {object} responses.Problem
+// @Router /me/email/verification [post]
+func (h *verificationHandler) SendMyVerificationHandler(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.verificationService.Send(c.Request().Context(), userID); err != nil {
+		return err
+	}
+	return utils.JSONResponse(c, http.StatusAccepted, "ok", "Verification email sent")
+}
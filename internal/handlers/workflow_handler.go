@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/service"
+	"RestAPI/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+type WorkflowHandler interface {
+	GetStatusesHandler(c echo.Context) error
+	PutStatusesHandler(c echo.Context) error
+	GetBoardHandler(c echo.Context) error
+}
+
+type workflowHandler struct {
+	workflowService service.WorkflowService
+}
+
+func NewWorkflowHandler(workflowService service.WorkflowService) WorkflowHandler {
+	return &workflowHandler{workflowService: workflowService}
+}
+
+// WorkflowRequest model
+// swagger:model
+type WorkflowRequest struct {
+	// Columns in board order; an empty array removes the workflow
+	Statuses []StatusRequest `json:"statuses" validate:"dive"`
+}
+
+// StatusRequest model
+// swagger:model
+type StatusRequest struct {
+	// ID of an existing status to keep, omitted for a new one
+	// example: 3
+	ID int `json:"id"`
+
+	// required: true
+	// example: In review
+	Name string `json:"name"`
+
+	// Tasks in a terminal status are completed
+	// example: false
+	Terminal bool `json:"terminal"`
+
+	// Maximum number of tasks in the column, 0 means unlimited
+	// example: 5
+	WIPLimit int `json:"wip_limit"`
+
+	// Names of the statuses tasks may move to; empty allows any
+	// example: ["Done"]
+	Next []string `json:"next"`
+}
+
+// GetStatusesHandler godoc
+// @Summary Get list workflow
+// @Description Workflow statuses of the list in board order, empty if the list has no workflow
+// @Tags todolists
+// @Security Bearer
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Success 200 {array} models.Status
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id}/statuses [get]
+func (h *workflowHandler) GetStatusesHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	statuses, err := h.workflowService.GetStatuses(listID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, statuses)
+}
+
+// PutStatusesHandler godoc
+// @Summary Replace list workflow
+// @Description Replace the workflow statuses of the list. Statuses sent with an ID keep it and their tasks;
+// @Description tasks of removed statuses move to the first open or terminal status. A workflow needs
+// @Description at least one open and one terminal status; an empty array turns the workflow off.
+// @Tags todolists
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Param request body handlers.WorkflowRequest true "Statuses"
+// @Success 200 {array} models.Status
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 422 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id}/statuses [put]
+func (h *workflowHandler) PutStatusesHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req WorkflowRequest
+	if err := c.Bind(&req); err != nil {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return err
+	}
+	statuses := make([]models.Status, len(req.Statuses))
+	for i, st := range req.Statuses {
+		statuses[i] = models.Status{ID: st.ID, Name: st.Name, Terminal: st.Terminal, WIPLimit: st.WIPLimit, Next: st.Next}
+	}
+
+	saved, err := h.workflowService.WithActor(actor(c)).ReplaceStatuses(listID, userID, statuses)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, saved)
+}
+
+// GetBoardHandler godoc
+// @Summary Get list board
+// @Description Tasks of the list grouped by workflow column with per-column counts and WIP limits.
+// @Description Lists without a workflow have two columns, Open and Done.
+// @Tags todolists
+// @Security Bearer
+// @Produce json
+// @Param id path int true "Todo List ID"
+// @Success 200 {object} service.Board
+// @Failure 400 {object} responses.Problem
+// @Failure 401 {object} responses.Problem
+// @Failure 404 {object} responses.Problem
+// @Failure 500 {object} responses.Problem
+// @Router /todolists/{id}/board [get]
+func (h *workflowHandler) GetBoardHandler(c echo.Context) error {
+	listID, err := utils.GetParam(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return err
+	}
+	board, err := h.workflowService.GetBoard(listID, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, board)
+}
@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"RestAPI/internal/service"
+	"context"
+	"log"
+	"time"
+)
+
+// AccountWorker builds requested account exports, deletes expired ones and
+// erases accounts whose deletion grace period is over
+type AccountWorker struct {
+	accountService service.AccountService
+	pollInterval   time.Duration
+	purgeInterval  time.Duration
+}
+
+func NewAccountWorker(accountService service.AccountService, pollInterval, purgeInterval time.Duration) *AccountWorker {
+	return &AccountWorker{accountService: accountService, pollInterval: pollInterval, purgeInterval: purgeInterval}
+}
+
+// Run blocks until ctx is cancelled
+func (w *AccountWorker) Run(ctx context.Context) {
+	go runEvery(ctx, w.purgeInterval, func() { w.purge(ctx) })
+	runEvery(ctx, w.pollInterval, func() {
+		for ctx.Err() == nil {
+			ran, err := w.accountService.RunNextExport(ctx)
+			if err != nil {
+				log.Printf("Account export failed: %v", err)
+			}
+			if !ran {
+				return
+			}
+		}
+	})
+}
+
+func (w *AccountWorker) purge(ctx context.Context) {
+	exports, err := w.accountService.PurgeExpiredExports(ctx)
+	if err != nil {
+		log.Printf("Account export purge failed: %v", err)
+	} else if exports > 0 {
+		log.Printf("Account export purge removed %d exports", exports)
+	}
+	accounts, err := w.accountService.PurgeDeletedAccounts(ctx)
+	if err != nil {
+		log.Printf("Account deletion failed: %v", err)
+		return
+	}
+	if accounts > 0 {
+		log.Printf("Account deletion erased %d accounts", accounts)
+	}
+}
@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"RestAPI/internal/service"
+	"context"
+	"log"
+	"time"
+)
+
+// AuditPurger periodically deletes audit entries older than the retention period
+type AuditPurger struct {
+	auditService service.AuditService
+	retention    time.Duration
+	interval     time.Duration
+}
+
+func NewAuditPurger(auditService service.AuditService, retention, interval time.Duration) *AuditPurger {
+	return &AuditPurger{auditService: auditService, retention: retention, interval: interval}
+}
+
+// Run blocks until ctx is cancelled; it returns at once when retention is
+// zero and the log is kept forever
+func (p *AuditPurger) Run(ctx context.Context) {
+	if p.retention <= 0 {
+		return
+	}
+	runEvery(ctx, p.interval, p.purge)
+}
+
+func (p *AuditPurger) purge() {
+	purged, err := p.auditService.PurgeExpired(p.retention)
+	if err != nil {
+		log.Printf("Audit log purge failed: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Audit log purge removed %d entries", purged)
+	}
+}
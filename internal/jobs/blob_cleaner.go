@@ -0,0 +1,33 @@
+package jobs
+
+import (
+	"RestAPI/internal/service"
+	"context"
+	"log"
+	"time"
+)
+
+// BlobCleaner periodically deletes attachment files that no attachment
+// refers to any more, e.g. after their tasks were purged
+type BlobCleaner struct {
+	attachmentService service.AttachmentService
+	interval          time.Duration
+}
+
+func NewBlobCleaner(attachmentService service.AttachmentService, interval time.Duration) *BlobCleaner {
+	return &BlobCleaner{attachmentService: attachmentService, interval: interval}
+}
+
+// Run blocks until ctx is cancelled
+func (b *BlobCleaner) Run(ctx context.Context) {
+	runEvery(ctx, b.interval, func() {
+		deleted, err := b.attachmentService.CleanupBlobs(ctx)
+		if err != nil {
+			log.Printf("Attachment cleanup failed: %v", err)
+			return
+		}
+		if deleted > 0 {
+			log.Printf("Attachment cleanup removed %d files", deleted)
+		}
+	})
+}
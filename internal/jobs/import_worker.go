@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"RestAPI/internal/service"
+	"context"
+	"log"
+	"time"
+)
+
+// ImportWorker runs queued import jobs one at a time and deletes finished
+// jobs older than the retention period
+type ImportWorker struct {
+	importJobService service.ImportJobService
+	pollInterval     time.Duration
+	retention        time.Duration
+}
+
+func NewImportWorker(importJobService service.ImportJobService, pollInterval, retention time.Duration) *ImportWorker {
+	return &ImportWorker{importJobService: importJobService, pollInterval: pollInterval, retention: retention}
+}
+
+// Run blocks until ctx is cancelled
+func (w *ImportWorker) Run(ctx context.Context) {
+	go runEvery(ctx, time.Hour, w.purge)
+	runEvery(ctx, w.pollInterval, func() {
+		// Очередь разбираем подряд, пока в ней есть задания
+		for ctx.Err() == nil {
+			ran, err := w.importJobService.RunNext()
+			if err != nil {
+				log.Printf("Import job failed: %v", err)
+			}
+			if !ran {
+				return
+			}
+		}
+	})
+}
+
+func (w *ImportWorker) purge() {
+	failed, err := w.importJobService.FailStale()
+	if err != nil {
+		log.Printf("Import job check failed: %v", err)
+	} else if failed > 0 {
+		log.Printf("Import job check failed %d interrupted jobs", failed)
+	}
+	purged, err := w.importJobService.PurgeFinished(w.retention)
+	if err != nil {
+		log.Printf("Import job purge failed: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Import job purge removed %d jobs", purged)
+	}
+}
@@ -0,0 +1,21 @@
+package jobs
+
+import (
+	"context"
+	"time"
+)
+
+// runEvery calls fn right away and then every interval until ctx is cancelled
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
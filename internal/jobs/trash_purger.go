@@ -0,0 +1,39 @@
+package jobs
+
+import (
+	"RestAPI/internal/service"
+	"context"
+	"log"
+	"time"
+)
+
+// TrashPurger periodically deletes trash items older than the retention period
+type TrashPurger struct {
+	trashService service.TrashService
+	retention    time.Duration
+	interval     time.Duration
+}
+
+func NewTrashPurger(trashService service.TrashService, retention, interval time.Duration) *TrashPurger {
+	return &TrashPurger{trashService: trashService, retention: retention, interval: interval}
+}
+
+// Run blocks until ctx is cancelled; it returns at once when retention is
+// zero and the trash is kept forever
+func (p *TrashPurger) Run(ctx context.Context) {
+	if p.retention <= 0 {
+		return
+	}
+	runEvery(ctx, p.interval, p.purge)
+}
+
+func (p *TrashPurger) purge() {
+	purged, err := p.trashService.PurgeExpired(p.retention)
+	if err != nil {
+		log.Printf("Trash purge failed: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Trash purge removed %d items", purged)
+	}
+}
@@ -1,5 +1,12 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
 // User model
 // swagger:model
 type User struct {
@@ -9,6 +16,74 @@ type User struct {
 	// example: john_doe
 	Username string `json:"username" gorm:"unique;not null"`
 	Password string `json:"-"` // Хранить хэш пароля
+	// Администраторы видят журнал аудита всех пользователей
+	IsAdmin bool `json:"-" gorm:"not null;default:false"`
+	// Set while the account waits for deletion; it can be restored until DeleteAfter
+	DeletionRequestedAt *time.Time `json:"-"`
+	DeleteAfter         *time.Time `json:"-" gorm:"index"`
+	// Name shown instead of the username, empty when not set
+	// example: John Doe
+	DisplayName string `json:"display_name" gorm:"not null;default:''"`
+	// example: john@example.com
+	Email *string `json:"email" gorm:"uniqueIndex"`
+	// When the current email was confirmed, null until then
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// IANA time zone used for dates without one
+	// example: Europe/Moscow
+	Timezone string `json:"timezone" gorm:"not null;default:'UTC'"`
+	// BCP 47 language tag
+	// example: en-US
+	Locale      string          `json:"locale" gorm:"not null;default:'en'"`
+	Preferences UserPreferences `json:"preferences" gorm:"type:jsonb;not null;default:'{}';serializer:json"`
+	// Blob key of the resized avatar, empty when there is none
+	AvatarKey string `json:"-"`
+	// URL of the avatar image, empty when there is none
+	// example: /me/avatar
+	AvatarURL string `json:"avatar_url,omitempty" gorm:"-"`
+	// When two-factor authentication was turned on, null while it is off
+	TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at"`
+	// Base32 secret of the confirmed authenticator app
+	TOTPSecret string `json:"-"`
+	// Secret waiting for its first code, replaced by every new enrollment
+	TOTPPendingSecret string `json:"-"`
+	// Time step of the last accepted code; a code is accepted only once
+	TOTPLastCounter int64 `json:"-" gorm:"not null;default:0"`
+	// Failed second-factor attempts in a row and the lockout they caused
+	MFAFailures    int        `json:"-" gorm:"not null;default:0"`
+	MFALockedUntil *time.Time `json:"-"`
+}
+
+// Week start preferences
+const (
+	WeekStartMonday   = "monday"
+	WeekStartSunday   = "sunday"
+	WeekStartSaturday = "saturday"
+)
+
+// UserPreferences are the settings clients apply for the user
+// swagger:model
+type UserPreferences struct {
+	// List opened by default, absent when not set
+	// example: 1
+	DefaultListID *int `json:"default_list_id,omitempty"`
+	// First day of the week: monday, sunday or saturday; absent to follow the locale
+	// example: monday
+	WeekStart string `json:"week_start,omitempty"`
+}
+
+// PendingDeletion reports whether the user asked to delete the account
+func (u *User) PendingDeletion() bool {
+	return u.DeleteAfter != nil
+}
+
+// EmailVerified reports whether the user has an email and confirmed it
+func (u *User) EmailVerified() bool {
+	return u.Email != nil && u.EmailVerifiedAt != nil
+}
+
+// TwoFactorEnabled reports whether signing in needs a second factor
+func (u *User) TwoFactorEnabled() bool {
+	return u.TwoFactorEnabledAt != nil && u.TOTPSecret != ""
 }
 
 // TodoList model
@@ -18,9 +93,20 @@ type TodoList struct {
 	// Title of the list
 	// required: true
 	// example: Shopping List
-	Title  string `json:"title"`
-	UserID int    `json:"-" gorm:"index;foreignKey:UserID"`
-	Tasks  []Task `json:"tasks" gorm:"foreignKey:ListID"`
+	Title string `json:"title"`
+	// Fractional-index ordering key, see pkg/rank
+	Position string `json:"position" gorm:"size:64;index"`
+	UserID   int    `json:"-" gorm:"index;foreignKey:UserID"`
+	Tasks    []Task `json:"tasks" gorm:"foreignKey:ListID"`
+	// Refuse to complete tasks of the list while they are blocked
+	BlockCompletion bool `json:"block_completion" gorm:"not null;default:false"`
+	// IDs of the users who created and last changed the list
+	CreatedBy int       `json:"created_by"`
+	UpdatedBy int       `json:"updated_by"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"index"`
+	// Soft delete: deleted lists stay in the trash until purged
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 // Task model
@@ -33,5 +119,574 @@ type Task struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	Completed   bool   `json:"completed"`
-	ListID      int    `json:"-"`
+	// example: high
+	Priority Priority `json:"priority" gorm:"not null;default:0;index" swaggertype:"string" enums:"none,low,medium,high,urgent"`
+	// Column of the list's workflow, null when the list has none.
+	// Completed follows the column: tasks in terminal columns are completed.
+	StatusID *int    `json:"status_id" gorm:"index"`
+	Status   *Status `json:"-" gorm:"constraint:OnDelete:SET NULL"`
+	// IDs of the users the task is assigned to
+	// example: [1,4]
+	Assignees UserIDs `json:"assignees" gorm:"type:jsonb;not null;default:'[]';serializer:json;index:,type:gin" swaggertype:"array,integer"`
+	// Expected effort in minutes, null when not estimated
+	// example: 90
+	Estimate *int `json:"estimate_minutes"`
+	// Deadline of the task, null when it has none
+	DueAt *time.Time `json:"due_at" gorm:"index"`
+	// iCalendar RRULE of a recurring task, repeating from due_at
+	// example: FREQ=WEEKLY;BYDAY=MO
+	Recurrence string `json:"recurrence" gorm:"size:255"`
+	// Computed: true while some task this one depends on is open
+	Blocked bool `json:"blocked" gorm:"-"`
+	// Computed: IDs of the open tasks that block this one
+	BlockedBy []int `json:"blocked_by" gorm:"-"`
+	// Fractional-index ordering key within the list, see pkg/rank
+	Position string `json:"position" gorm:"size:64;index"`
+	ListID   int    `json:"list_id"`
+	// IDs of the users who created and last changed the task
+	CreatedBy int       `json:"created_by"`
+	UpdatedBy int       `json:"updated_by"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"index"`
+	// Set when the task is completed, cleared when it is reopened
+	CompletedAt *time.Time `json:"completed_at" gorm:"index"`
+	// Soft delete: tasks deleted together with their list share its timestamp
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// SetCompleted changes the completion flag and keeps CompletedAt in sync.
+// Setting the flag to its current value keeps the original timestamp.
+func (t *Task) SetCompleted(completed bool, at time.Time) {
+	if completed == t.Completed {
+		return
+	}
+	t.Completed = completed
+	if completed {
+		t.CompletedAt = &at
+	} else {
+		t.CompletedAt = nil
+	}
+}
+
+// UserIDs is a set of user IDs kept in insertion order
+type UserIDs []int
+
+// MarshalJSON writes an empty array instead of null
+func (ids UserIDs) MarshalJSON() ([]byte, error) {
+	if ids == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]int(ids))
+}
+
+func (ids UserIDs) Contains(id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Without returns a copy of the set without id
+func (ids UserIDs) Without(id int) UserIDs {
+	rest := make(UserIDs, 0, len(ids))
+	for _, v := range ids {
+		if v != id {
+			rest = append(rest, v)
+		}
+	}
+	return rest
+}
+
+// Priority of a task. It is stored as a number so that tasks sort by
+// urgency, and written as a name in JSON.
+type Priority int
+
+const (
+	PriorityNone Priority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+var priorityNames = []string{"none", "low", "medium", "high", "urgent"}
+
+// ParsePriority converts a priority name; an empty name means none
+func ParsePriority(name string) (Priority, error) {
+	if name == "" {
+		return PriorityNone, nil
+	}
+	for i, n := range priorityNames {
+		if n == name {
+			return Priority(i), nil
+		}
+	}
+	return PriorityNone, fmt.Errorf("unknown priority %q", name)
+}
+
+func (p Priority) String() string {
+	if p < 0 || int(p) >= len(priorityNames) {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	parsed, err := ParsePriority(name)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Status is a column of a list's kanban workflow
+// swagger:model
+type Status struct {
+	ID     int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	ListID int       `json:"list_id" gorm:"index"`
+	List   *TodoList `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// example: In Progress
+	Name string `json:"name" gorm:"size:50"`
+	// Place of the column on the board, starting at 0
+	Position int `json:"position"`
+	// Tasks in a terminal column count as completed
+	Terminal bool `json:"terminal"`
+	// Maximum number of tasks in the column, 0 for no limit
+	// example: 3
+	WIPLimit int `json:"wip_limit"`
+	// Names of the columns tasks may move to from this one; empty allows any
+	// example: ["Review"]
+	Next []string `json:"next" gorm:"type:jsonb;serializer:json"`
+}
+
+// CanMoveTo reports whether the workflow allows moving a task from s to next
+func (s *Status) CanMoveTo(next *Status) bool {
+	if len(s.Next) == 0 || s.ID == next.ID {
+		return true
+	}
+	for _, name := range s.Next {
+		if name == next.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditEntry is one record of the append-only audit log
+// swagger:model
+type AuditEntry struct {
+	ID        int64     `json:"id" gorm:"primaryKey;autoIncrement"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+	// User who made the change, 0 for background jobs and ErasedActorID
+	// once the user's account is erased
+	ActorID   int    `json:"actor_id" gorm:"index"`
+	IP        string `json:"ip"`
+	RequestID string `json:"request_id" gorm:"index"`
+	// create, update, delete, restore, purge, revert, assign or unassign
+	// example: update
+	Action string `json:"action" gorm:"size:32"`
+	// list, task, comment, attachment, dependency or time_entry
+	// example: task
+	Entity   string `json:"entity" gorm:"size:32;index:idx_audit_entity"`
+	EntityID int    `json:"entity_id" gorm:"index:idx_audit_entity"`
+	// Owner of the changed list, used to scope owner queries
+	OwnerID int `json:"owner_id" gorm:"index"`
+	// Changed fields as {"field": {"old": ..., "new": ...}}
+	Changes json.RawMessage `json:"changes" gorm:"type:jsonb" swaggertype:"object"`
+}
+
+// ErasedActorID replaces the actor of changes made by an erased account
+// in the audit log and task histories of other users
+const ErasedActorID = -1
+
+// TaskVersion is one entry of a task's change history
+// swagger:model
+type TaskVersion struct {
+	ID     int64 `json:"-" gorm:"primaryKey;autoIncrement"`
+	TaskID int   `json:"task_id" gorm:"uniqueIndex:idx_task_version"`
+	// История удаляется вместе с задачей
+	Task *Task `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// Sequential number of the version within the task, starting at 1
+	Version int `json:"version" gorm:"uniqueIndex:idx_task_version"`
+	// create, update, delete, restore, revert, assign or unassign
+	// example: update
+	Action    string    `json:"action" gorm:"size:32"`
+	ActorID   int       `json:"actor_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Changed fields as {"field": {"old": ..., "new": ...}}
+	Changes json.RawMessage `json:"changes" gorm:"type:jsonb" swaggertype:"object"`
+	// The task as it was after this version
+	Snapshot  json.RawMessage `json:"-" gorm:"type:jsonb"`
+	SessionID string          `json:"-" gorm:"size:64;index"`
+	RequestID string          `json:"-"`
+	// Set when the change has been reverted by POST /undo
+	UndoneAt *time.Time `json:"undone_at,omitempty"`
+	// Version ID this entry undid, for entries written by POST /undo
+	UndoOf *int64 `json:"-"`
+}
+
+// TaskDependency means that Task cannot start until Blocker is completed.
+// Both tasks belong to lists of the same user.
+// swagger:model
+type TaskDependency struct {
+	ID int `json:"id" gorm:"primaryKey;autoIncrement"`
+	// The blocked task
+	TaskID int   `json:"task_id" gorm:"uniqueIndex:idx_task_dependency"`
+	Task   *Task `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// The task that has to be completed first
+	BlockerID int       `json:"blocker_id" gorm:"uniqueIndex:idx_task_dependency;index"`
+	Blocker   *Task     `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	CreatedBy int       `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TimeEntry is time a user spent on a task, either measured by a timer or
+// logged by hand. A user has at most one running timer.
+// swagger:model
+type TimeEntry struct {
+	ID     int   `json:"id" gorm:"primaryKey;autoIncrement"`
+	TaskID int   `json:"task_id" gorm:"index"`
+	Task   *Task `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// example: 1
+	UserID    int       `json:"user_id" gorm:"index;uniqueIndex:idx_running_timer,where:ended_at IS NULL"`
+	StartedAt time.Time `json:"started_at" gorm:"index"`
+	// Null while the timer is running
+	EndedAt *time.Time `json:"ended_at"`
+	// Length in seconds, 0 while the timer is running
+	// example: 5400
+	Duration int64 `json:"duration_seconds"`
+	// example: Call with the client
+	Note string `json:"note" gorm:"type:text"`
+	// Logged by hand rather than measured by a timer
+	Manual    bool      `json:"manual"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Running reports whether the entry is a timer that has not been stopped
+func (e *TimeEntry) Running() bool {
+	return e.EndedAt == nil
+}
+
+// CalendarFeed is a secret iCalendar URL with the tasks of all lists of a
+// user, or of one list
+// swagger:model
+type CalendarFeed struct {
+	ID     int `json:"-" gorm:"primaryKey;autoIncrement"`
+	UserID int `json:"-" gorm:"index;uniqueIndex:idx_calendar_user_feed,where:list_id IS NULL"`
+	// Null for the feed with the tasks of every list
+	ListID *int      `json:"list_id" gorm:"uniqueIndex"`
+	List   *TodoList `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// Secret part of the URL; regenerating the feed replaces it
+	Token     string    `json:"-" gorm:"size:64;uniqueIndex"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// States of an ImportJob
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobSucceeded = "succeeded"
+	ImportJobFailed    = "failed"
+)
+
+// ImportJob imports the export file of another app in the background
+// swagger:model
+type ImportJob struct {
+	ID     int `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID int `json:"-" gorm:"index"`
+	// todoist, trello or microsoft_todo
+	// example: trello
+	Source string `json:"source" gorm:"size:32"`
+	// example: board.json
+	Filename string `json:"filename"`
+	// Nothing is saved, the result only reports what would be imported
+	DryRun bool `json:"dry_run"`
+	// skip or create
+	Duplicates string `json:"duplicates" gorm:"size:16"`
+	// pending, running, succeeded or failed
+	// example: running
+	Status string `json:"status" gorm:"size:16;index"`
+	// Tasks processed so far, out of Total once the file has been read
+	Processed int `json:"processed"`
+	Total     int `json:"total"`
+	// Computed: Processed as a share of Total
+	// example: 40
+	Percent int `json:"percent" gorm:"-"`
+	// Import report of a succeeded job
+	Result json.RawMessage `json:"result,omitempty" gorm:"type:jsonb" swaggertype:"object"`
+	// Why a failed job failed
+	Error string `json:"error,omitempty"`
+	// Uploaded file, dropped when the job finishes
+	Data []byte `json:"-"`
+	// How many times a worker has started the job
+	Attempts int `json:"-" gorm:"not null;default:0"`
+	// Request that queued the job, recorded in the audit log of the import
+	IP         string     `json:"-"`
+	RequestID  string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"index"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// Finished reports whether the job has succeeded or failed
+func (j *ImportJob) Finished() bool {
+	return j.Status == ImportJobSucceeded || j.Status == ImportJobFailed
+}
+
+// States of an AccountExport
+const (
+	AccountExportPending   = "pending"
+	AccountExportRunning   = "running"
+	AccountExportSucceeded = "succeeded"
+	AccountExportFailed    = "failed"
+)
+
+// AccountExport is an archive of everything stored about a user, built in
+// the background
+// swagger:model
+type AccountExport struct {
+	ID     int `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID int `json:"-" gorm:"index"`
+	// pending, running, succeeded or failed
+	// example: succeeded
+	Status string `json:"status" gorm:"size:16;index"`
+	// Size of the archive in bytes
+	Size int64 `json:"size,omitempty"`
+	// Key of the archive in the file storage
+	Key string `json:"-" gorm:"size:64"`
+	// Why a failed export failed
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	// The archive is deleted afterwards
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// EmailVerification is a link sent to confirm an email address. Only the
+// SHA-256 of the token is stored.
+type EmailVerification struct {
+	ID        int       `gorm:"primaryKey;autoIncrement"`
+	UserID    int       `gorm:"index"`
+	Email     string    `gorm:"not null"`
+	TokenHash string    `gorm:"size:64;uniqueIndex"`
+	CreatedAt time.Time `gorm:"index"`
+	ExpiresAt time.Time
+}
+
+// RecoveryCode signs in once instead of an authenticator code. Only the
+// SHA-256 of the code is stored.
+type RecoveryCode struct {
+	ID        int    `gorm:"primaryKey;autoIncrement"`
+	UserID    int    `gorm:"index"`
+	CodeHash  string `gorm:"size:64;not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// TrustedDevice skips the second factor on a device the user chose to
+// remember. Only the SHA-256 of its token is stored.
+// swagger:model
+type TrustedDevice struct {
+	ID        int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID    int    `json:"-" gorm:"index"`
+	TokenHash string `json:"-" gorm:"size:64;uniqueIndex"`
+	// User agent of the browser or app that signed in
+	// example: Mozilla/5.0 (X11; Linux x86_64) Firefox/131.0
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// UserIdentity links an account at an external identity provider to a user
+// swagger:model
+type UserIdentity struct {
+	ID     int `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID int `json:"-" gorm:"index"`
+	// Name of the provider in the configuration
+	// example: company
+	Provider string `json:"provider" gorm:"size:64;not null;uniqueIndex:idx_identity_subject"`
+	// Subject claim, the id of the account at the provider
+	// example: 00u1a2b3c4d5e6f7g8h9
+	Subject string `json:"subject" gorm:"not null;uniqueIndex:idx_identity_subject"`
+	// Email the provider reported at the last sign-in
+	// example: john@example.com
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// OIDCLogin is a sign-in sent to an identity provider and waiting for it to
+// redirect back. Only the SHA-256 of the state is stored.
+type OIDCLogin struct {
+	ID           int    `gorm:"primaryKey;autoIncrement"`
+	StateHash    string `gorm:"size:64;uniqueIndex"`
+	Provider     string `gorm:"size:64;not null"`
+	Nonce        string `gorm:"not null"`
+	CodeVerifier string `gorm:"not null"`
+	// SHA-256 of the secret in a cookie of the browser that started the
+	// sign-in; the callback must come from the same browser
+	BrowserHash string `gorm:"size:64;not null;default:''"`
+	// Set when a signed in user links the identity instead of signing in
+	LinkUserID *int
+	// The sign-in restores an account scheduled for deletion
+	Restore   bool `gorm:"not null;default:false"`
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// OAuthClient is a third-party app that acts on behalf of the users who
+// authorize it. Only the SHA-256 of the secret is stored; public clients,
+// such as mobile apps, have none.
+// swagger:model
+type OAuthClient struct {
+	ID int `json:"id" gorm:"primaryKey;autoIncrement"`
+	// Identifier the app sends as client_id
+	// example: 3f1c2a9d8e7b6a5f4e3d2c1b0a998877
+	ClientID string `json:"client_id" gorm:"size:32;uniqueIndex"`
+	// Owner registered the app and manages it
+	OwnerID    int    `json:"-" gorm:"index"`
+	SecretHash string `json:"-" gorm:"size:64"`
+	// Shown to users on the consent screen
+	// example: Calendar Sync
+	Name string `json:"name" gorm:"size:100;not null"`
+	// example: https://sync.example.com
+	Website string `json:"website"`
+	// The app can only be redirected back to these URIs
+	// example: ["https://sync.example.com/oauth/callback"]
+	RedirectURIs []string `json:"redirect_uris" gorm:"type:jsonb;not null;serializer:json"`
+	// A confidential client authenticates with its secret at the token endpoint
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// OAuthCode is an authorization code waiting to be exchanged for tokens.
+// Only the SHA-256 of the code is stored.
+type OAuthCode struct {
+	ID            int    `gorm:"primaryKey;autoIncrement"`
+	CodeHash      string `gorm:"size:64;uniqueIndex"`
+	ClientID      int    `gorm:"index"`
+	UserID        int    `gorm:"index"`
+	RedirectURI   string `gorm:"not null"`
+	Scope         string `gorm:"not null"`
+	CodeChallenge string `gorm:"not null"`
+	CreatedAt     time.Time
+	ExpiresAt     time.Time `gorm:"index"`
+	// The authorization request named the redirect URI instead of relying
+	// on the only registered one; the token request must repeat it then
+	RedirectURIGiven bool `gorm:"not null;default:false"`
+}
+
+// OAuthToken is an access token and the refresh token that renews it.
+// Refreshing replaces both. Only their SHA-256 is stored.
+type OAuthToken struct {
+	ID          int    `gorm:"primaryKey;autoIncrement"`
+	ClientID    int    `gorm:"index"`
+	UserID      int    `gorm:"index"`
+	Scope       string `gorm:"not null"`
+	AccessHash  string `gorm:"size:64;uniqueIndex"`
+	RefreshHash string `gorm:"size:64;uniqueIndex"`
+	// When the code was exchanged; refreshing keeps it
+	CreatedAt        time.Time
+	RefreshedAt      time.Time
+	AccessExpiresAt  time.Time
+	RefreshExpiresAt time.Time `gorm:"index"`
+}
+
+// OAuthConsent remembers the scopes a user granted to an app
+type OAuthConsent struct {
+	ID        int    `gorm:"primaryKey;autoIncrement"`
+	UserID    int    `gorm:"uniqueIndex:idx_oauth_consent"`
+	ClientID  int    `gorm:"uniqueIndex:idx_oauth_consent"`
+	Scope     string `gorm:"not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Comment on a task. Body is markdown, sanitized on the server.
+// swagger:model
+type Comment struct {
+	ID     int   `json:"id" gorm:"primaryKey;autoIncrement"`
+	TaskID int   `json:"task_id" gorm:"index"`
+	Task   *Task `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// example: 1
+	AuthorID int `json:"author_id" gorm:"index"`
+	// example: Done, see the receipt @john_doe
+	Body      string    `json:"body" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Set when the body has been edited at least once
+	EditedAt *time.Time `json:"edited_at"`
+	Mentions []Mention  `json:"mentions" gorm:"foreignKey:CommentID;constraint:OnDelete:CASCADE"`
+}
+
+// CommentRevision keeps a previous body of an edited comment
+// swagger:model
+type CommentRevision struct {
+	ID        int       `json:"id" gorm:"primaryKey;autoIncrement"`
+	CommentID int       `json:"comment_id" gorm:"index"`
+	Comment   *Comment  `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	Body      string    `json:"body" gorm:"type:text"`
+	EditorID  int       `json:"editor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Mention of a user in a comment
+// swagger:model
+type Mention struct {
+	ID        int `json:"id" gorm:"primaryKey;autoIncrement"`
+	CommentID int `json:"comment_id" gorm:"uniqueIndex:idx_mention"`
+	// Mentioned user
+	UserID int `json:"user_id" gorm:"uniqueIndex:idx_mention;index"`
+	// example: john_doe
+	Username  string     `json:"username"`
+	TaskID    int        `json:"task_id"`
+	ListID    int        `json:"list_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+	// Filled in the mentions inbox
+	Comment *Comment `json:"comment,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// Blob is a stored file. Attachments with the same content share one blob,
+// which is deleted once nothing refers to it.
+type Blob struct {
+	// SHA-256 of the content, also the key in the blob storage
+	Hash      string    `gorm:"primaryKey;size:64"`
+	Size      int64     `gorm:"not null"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// Attachment is a file attached to a task
+// swagger:model
+type Attachment struct {
+	ID     int   `json:"id" gorm:"primaryKey;autoIncrement"`
+	TaskID int   `json:"task_id" gorm:"index"`
+	Task   *Task `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	// example: receipt.pdf
+	Filename string `json:"filename"`
+	// Detected from the content, not taken from the client
+	// example: application/pdf
+	ContentType string `json:"content_type"`
+	// example: 48213
+	Size int64 `json:"size"`
+	// SHA-256 of the content
+	Hash       string    `json:"sha256" gorm:"size:64;index"`
+	Blob       *Blob     `json:"-" gorm:"foreignKey:Hash;references:Hash"`
+	UploadedBy int       `json:"uploaded_by"`
+	CreatedAt  time.Time `json:"created_at"`
 }
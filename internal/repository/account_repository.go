@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+// AccountRepository keeps account exports and reads and erases everything
+// stored about a user
+type AccountRepository interface {
+	CreateExport(export *models.AccountExport) error
+	GetExport(exportID int, userID int) (*models.AccountExport, error)
+	// GetActiveExport returns the user's pending or running export; nil when there is none
+	GetActiveExport(userID int) (*models.AccountExport, error)
+	// ClaimExport marks the oldest pending export, or a running one whose
+	// worker stopped before staleBefore, as running; nil when there is none
+	ClaimExport(staleBefore time.Time) (*models.AccountExport, error)
+	FinishExport(export *models.AccountExport) error
+	// GetExpiredExports returns finished exports whose archive expired before the time
+	GetExpiredExports(before time.Time) ([]models.AccountExport, error)
+	DeleteExport(export *models.AccountExport) error
+
+	GetMentions(userID int) ([]models.Mention, error)
+	GetCalendarFeeds(userID int) ([]models.CalendarFeed, error)
+	// GetImportJobs leaves out the uploaded files
+	GetImportJobs(userID int) ([]models.ImportJob, error)
+	// GetAttachments returns the attachments of every task of the user, trashed ones included
+	GetAttachments(userID int) ([]models.Attachment, error)
+
+	ScheduleDeletion(user *models.User, deleteAfter time.Time) error
+	CancelDeletion(user *models.User) error
+	// GetDueDeletions returns the users whose grace period ended before the time
+	GetDueDeletions(before time.Time, limit int) ([]models.User, error)
+	// GetExportKeys returns the archive keys of all exports of the user
+	GetExportKeys(userID int) ([]string, error)
+	// DeleteAccount permanently deletes the user with their lists, tasks and
+	// everything attached to them, their tokens, jobs, mentions and audit
+	// log, and takes them off the tasks they are assigned to
+	DeleteAccount(userID int) error
+	WithTx(tx *gorm.DB) AccountRepository
+}
+
+type accountRepository struct {
+	DB *gorm.DB
+}
+
+func NewAccountRepository(db *gorm.DB) AccountRepository {
+	return &accountRepository{DB: db}
+}
+
+func (r *accountRepository) WithTx(tx *gorm.DB) AccountRepository {
+	return &accountRepository{DB: tx}
+}
+
+func (r *accountRepository) CreateExport(export *models.AccountExport) error {
+	return r.DB.Create(export).Error
+}
+
+func (r *accountRepository) GetExport(exportID int, userID int) (*models.AccountExport, error) {
+	var export models.AccountExport
+	err := r.DB.Where("user_id = ?", userID).First(&export, exportID).Error
+	return &export, err
+}
+
+func (r *accountRepository) GetActiveExport(userID int) (*models.AccountExport, error) {
+	var exports []models.AccountExport
+	err := r.DB.Where("user_id = ? AND status IN ?", userID, []string{models.AccountExportPending, models.AccountExportRunning}).
+		Order("id").Limit(1).Find(&exports).Error
+	if err != nil || len(exports) == 0 {
+		return nil, err
+	}
+	return &exports[0], nil
+}
+
+func (r *accountRepository) ClaimExport(staleBefore time.Time) (*models.AccountExport, error) {
+	var exports []models.AccountExport
+	now := time.Now()
+	err := r.DB.Raw(`UPDATE account_exports SET status = ?, updated_at = ?
+		WHERE id = (
+			SELECT id FROM account_exports
+			WHERE status = ? OR (status = ? AND updated_at < ?)
+			ORDER BY created_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		models.AccountExportRunning, now,
+		models.AccountExportPending, models.AccountExportRunning, staleBefore,
+	).Scan(&exports).Error
+	if err != nil || len(exports) == 0 {
+		return nil, err
+	}
+	return &exports[0], nil
+}
+
+func (r *accountRepository) FinishExport(export *models.AccountExport) error {
+	return r.DB.Model(export).Select("status", "size", "key", "error", "finished_at", "expires_at", "updated_at").Updates(export).Error
+}
+
+func (r *accountRepository) GetExpiredExports(before time.Time) ([]models.AccountExport, error) {
+	var exports []models.AccountExport
+	err := r.DB.Where("status IN ? AND COALESCE(expires_at, finished_at) < ?",
+		[]string{models.AccountExportSucceeded, models.AccountExportFailed}, before).
+		Find(&exports).Error
+	return exports, err
+}
+
+func (r *accountRepository) DeleteExport(export *models.AccountExport) error {
+	return r.DB.Delete(&models.AccountExport{}, export.ID).Error
+}
+
+func (r *accountRepository) GetMentions(userID int) ([]models.Mention, error) {
+	var mentions []models.Mention
+	err := r.DB.Where("user_id = ?", userID).Order("id").Find(&mentions).Error
+	return mentions, err
+}
+
+func (r *accountRepository) GetCalendarFeeds(userID int) ([]models.CalendarFeed, error) {
+	var feeds []models.CalendarFeed
+	err := r.DB.Where("user_id = ?", userID).Order("id").Find(&feeds).Error
+	return feeds, err
+}
+
+func (r *accountRepository) GetImportJobs(userID int) ([]models.ImportJob, error) {
+	var jobs []models.ImportJob
+	err := r.DB.Omit("data").Where("user_id = ?", userID).Order("id").Find(&jobs).Error
+	return jobs, err
+}
+
+func (r *accountRepository) GetAttachments(userID int) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	err := r.DB.Joins("JOIN tasks ON tasks.id = attachments.task_id").
+		Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id").
+		Where("todo_lists.user_id = ?", userID).
+		Order("attachments.id").
+		Find(&attachments).Error
+	return attachments, err
+}
+
+func (r *accountRepository) ScheduleDeletion(user *models.User, deleteAfter time.Time) error {
+	now := time.Now()
+	user.DeletionRequestedAt, user.DeleteAfter = &now, &deleteAfter
+	return r.DB.Model(user).Select("deletion_requested_at", "delete_after").Updates(user).Error
+}
+
+func (r *accountRepository) CancelDeletion(user *models.User) error {
+	user.DeletionRequestedAt, user.DeleteAfter = nil, nil
+	return r.DB.Model(user).Select("deletion_requested_at", "delete_after").Updates(user).Error
+}
+
+func (r *accountRepository) GetDueDeletions(before time.Time, limit int) ([]models.User, error) {
+	var users []models.User
+	err := r.DB.Where("delete_after < ?", before).Order("delete_after").Limit(limit).Find(&users).Error
+	return users, err
+}
+
+func (r *accountRepository) GetExportKeys(userID int) ([]string, error) {
+	var keys []string
+	err := r.DB.Model(&models.AccountExport{}).Where("user_id = ? AND key <> ''", userID).Pluck("key", &keys).Error
+	return keys, err
+}
+
+func (r *accountRepository) DeleteAccount(userID int) error {
+	db := r.DB.Unscoped()
+	lists := db.Model(&models.TodoList{}).Select("id").Where("user_id = ?", userID)
+	// Удаление задач каскадом удаляет зависимости, комментарии, вложения,
+	// записи времени и историю; статусы и ленты списков уходят вместе со списками
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"tasks", func() error { return db.Where("list_id IN (?)", lists).Delete(&models.Task{}).Error }},
+		{"lists", func() error { return db.Where("user_id = ?", userID).Delete(&models.TodoList{}).Error }},
+		{"calendar feeds", func() error { return db.Where("user_id = ?", userID).Delete(&models.CalendarFeed{}).Error }},
+		{"time entries", func() error { return db.Where("user_id = ?", userID).Delete(&models.TimeEntry{}).Error }},
+		{"mentions", func() error { return db.Where("user_id = ?", userID).Delete(&models.Mention{}).Error }},
+		{"comments", func() error { return db.Where("author_id = ?", userID).Delete(&models.Comment{}).Error }},
+		{"assignments", func() error {
+			return db.Exec(`UPDATE tasks SET assignees = COALESCE((
+					SELECT jsonb_agg(a) FROM jsonb_array_elements(assignees) a WHERE a <> to_jsonb(?::int)
+				), '[]'::jsonb)
+				WHERE assignees @> jsonb_build_array(?::int)`, userID, userID).Error
+		}},
+		{"import jobs", func() error { return db.Where("user_id = ?", userID).Delete(&models.ImportJob{}).Error }},
+		{"account exports", func() error { return db.Where("user_id = ?", userID).Delete(&models.AccountExport{}).Error }},
+		{"email verifications", func() error {
+			return db.Where("user_id = ?", userID).Delete(&models.EmailVerification{}).Error
+		}},
+		{"recovery codes", func() error { return db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error }},
+		{"trusted devices", func() error { return db.Where("user_id = ?", userID).Delete(&models.TrustedDevice{}).Error }},
+		{"identities", func() error { return db.Where("user_id = ?", userID).Delete(&models.UserIdentity{}).Error }},
+		{"identity links", func() error { return db.Where("link_user_id = ?", userID).Delete(&models.OIDCLogin{}).Error }},
+		{"oauth grants", func() error {
+			for _, model := range []interface{}{&models.OAuthCode{}, &models.OAuthToken{}, &models.OAuthConsent{}} {
+				if err := db.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"oauth clients", func() error {
+			clients := db.Model(&models.OAuthClient{}).Select("id").Where("owner_id = ?", userID)
+			for _, model := range []interface{}{&models.OAuthCode{}, &models.OAuthToken{}, &models.OAuthConsent{}} {
+				if err := db.Where("client_id IN (?)", clients).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			return db.Where("owner_id = ?", userID).Delete(&models.OAuthClient{}).Error
+		}},
+		// Записи о чужих списках остаются их владельцам, но без автора и его IP
+		{"audit log", func() error {
+			if err := db.Where("owner_id = ?", userID).Delete(&models.AuditEntry{}).Error; err != nil {
+				return err
+			}
+			return db.Model(&models.AuditEntry{}).Where("actor_id = ?", userID).
+				Updates(map[string]interface{}{"actor_id": models.ErasedActorID, "ip": ""}).Error
+		}},
+		{"task history", func() error {
+			return db.Model(&models.TaskVersion{}).Where("actor_id = ?", userID).
+				Updates(map[string]interface{}{"actor_id": models.ErasedActorID, "session_id": ""}).Error
+		}},
+		{"user", func() error { return db.Delete(&models.User{}, userID).Error }},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			return fmt.Errorf("delete %s of user %d: %w", step.name, userID, err)
+		}
+	}
+	return nil
+}
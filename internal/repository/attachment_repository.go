@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+)
+
+type AttachmentRepository interface {
+	GetAttachments(taskID int) ([]models.Attachment, error)
+	GetAttachment(taskID int, attachmentID int) (*models.Attachment, error)
+	CreateAttachment(attachment *models.Attachment) error
+	DeleteAttachment(attachment *models.Attachment) error
+	// LockBlob serializes uploads and cleanup of the same content until the
+	// transaction ends
+	LockBlob(hash string) error
+	GetBlob(hash string) (*models.Blob, error)
+	CreateBlob(blob *models.Blob) error
+	IsBlobReferenced(hash string) (bool, error)
+	DeleteBlob(hash string) error
+	// UnreferencedBlobs returns up to limit blobs no attachment refers to
+	UnreferencedBlobs(limit int) ([]models.Blob, error)
+	WithTx(tx *gorm.DB) AttachmentRepository
+}
+
+type attachmentRepository struct {
+	DB *gorm.DB
+}
+
+func NewAttachmentRepository(db *gorm.DB) AttachmentRepository {
+	return &attachmentRepository{DB: db}
+}
+
+func (r *attachmentRepository) WithTx(tx *gorm.DB) AttachmentRepository {
+	return &attachmentRepository{DB: tx}
+}
+
+func (r *attachmentRepository) GetAttachments(taskID int) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	err := r.DB.Where("task_id = ?", taskID).Order("created_at, id").Find(&attachments).Error
+	return attachments, err
+}
+
+func (r *attachmentRepository) GetAttachment(taskID int, attachmentID int) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := r.DB.Where("task_id = ?", taskID).First(&attachment, attachmentID).Error
+	return &attachment, err
+}
+
+func (r *attachmentRepository) CreateAttachment(attachment *models.Attachment) error {
+	return r.DB.Omit("Blob").Create(attachment).Error
+}
+
+func (r *attachmentRepository) DeleteAttachment(attachment *models.Attachment) error {
+	return r.DB.Delete(&models.Attachment{}, attachment.ID).Error
+}
+
+func (r *attachmentRepository) LockBlob(hash string) error {
+	return r.DB.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", hash).Error
+}
+
+func (r *attachmentRepository) GetBlob(hash string) (*models.Blob, error) {
+	var blob models.Blob
+	err := r.DB.Where("hash = ?", hash).First(&blob).Error
+	return &blob, err
+}
+
+func (r *attachmentRepository) CreateBlob(blob *models.Blob) error {
+	return r.DB.Create(blob).Error
+}
+
+func (r *attachmentRepository) IsBlobReferenced(hash string) (bool, error) {
+	var count int64
+	err := r.DB.Model(&models.Attachment{}).Where("hash = ?", hash).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *attachmentRepository) DeleteBlob(hash string) error {
+	return r.DB.Where("hash = ?", hash).Delete(&models.Blob{}).Error
+}
+
+func (r *attachmentRepository) UnreferencedBlobs(limit int) ([]models.Blob, error) {
+	var blobs []models.Blob
+	err := r.DB.
+		Where("NOT EXISTS (SELECT 1 FROM attachments WHERE attachments.hash = blobs.hash)").
+		Order("created_at").
+		Limit(limit).
+		Find(&blobs).Error
+	return blobs, err
+}
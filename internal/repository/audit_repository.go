@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+// AuditFilter narrows an audit log query; zero fields are ignored
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	Action   string
+	ActorID  int
+	OwnerID  int
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+	Offset   int
+}
+
+// AuditRepository is append-only: entries are never updated, only removed
+// by the retention job
+type AuditRepository interface {
+	CreateEntry(entry *models.AuditEntry) error
+	FindEntries(filter AuditFilter) ([]models.AuditEntry, error)
+	EachEntry(filter AuditFilter, batchSize int, fn func([]models.AuditEntry) error) error
+	DeleteEntriesBefore(before time.Time) (int64, error)
+	WithTx(tx *gorm.DB) AuditRepository
+}
+
+type auditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+func (r *auditRepository) WithTx(tx *gorm.DB) AuditRepository {
+	return &auditRepository{db: tx}
+}
+
+func (r *auditRepository) CreateEntry(entry *models.AuditEntry) error {
+	return r.db.Create(entry).Error
+}
+
+func (r *auditRepository) FindEntries(filter AuditFilter) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	query := r.filtered(filter).Order("id DESC")
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+	err := query.Find(&entries).Error
+	return entries, err
+}
+
+// EachEntry walks all matching entries in id order without loading them at once
+func (r *auditRepository) EachEntry(filter AuditFilter, batchSize int, fn func([]models.AuditEntry) error) error {
+	var batch []models.AuditEntry
+	return r.filtered(filter).FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		return fn(batch)
+	}).Error
+}
+
+func (r *auditRepository) DeleteEntriesBefore(before time.Time) (int64, error) {
+	result := r.db.Where("created_at < ?", before).Delete(&models.AuditEntry{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *auditRepository) filtered(filter AuditFilter) *gorm.DB {
+	query := r.db.Model(&models.AuditEntry{})
+	if filter.Entity != "" {
+		query = query.Where("entity = ?", filter.Entity)
+	}
+	if filter.EntityID > 0 {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.ActorID > 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.OwnerID > 0 {
+		query = query.Where("owner_id = ?", filter.OwnerID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	return query
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CalendarRepository interface {
+	// GetFeed returns the user's feed of listID, or of all lists when listID is nil
+	GetFeed(userID int, listID *int) (*models.CalendarFeed, error)
+	GetFeedByToken(token string) (*models.CalendarFeed, error)
+	// CreateFeed does nothing when the feed already exists
+	CreateFeed(feed *models.CalendarFeed) error
+	UpdateFeed(feed *models.CalendarFeed) error
+	DeleteFeed(feed *models.CalendarFeed) error
+	// GetDueTasks returns the live tasks with a due date of the user's live
+	// lists, or of listID only, earliest first
+	GetDueTasks(userID int, listID *int) ([]models.Task, error)
+	WithTx(tx *gorm.DB) CalendarRepository
+}
+
+type calendarRepository struct {
+	DB *gorm.DB
+}
+
+func NewCalendarRepository(db *gorm.DB) CalendarRepository {
+	return &calendarRepository{DB: db}
+}
+
+func (r *calendarRepository) WithTx(tx *gorm.DB) CalendarRepository {
+	return &calendarRepository{DB: tx}
+}
+
+func (r *calendarRepository) GetFeed(userID int, listID *int) (*models.CalendarFeed, error) {
+	var feed models.CalendarFeed
+	query := r.DB.Where("user_id = ?", userID)
+	if listID == nil {
+		query = query.Where("list_id IS NULL")
+	} else {
+		query = query.Where("list_id = ?", *listID)
+	}
+	err := query.First(&feed).Error
+	return &feed, err
+}
+
+func (r *calendarRepository) GetFeedByToken(token string) (*models.CalendarFeed, error) {
+	var feed models.CalendarFeed
+	// Ленты аккаунтов, ожидающих удаления, не отдаём
+	err := r.DB.Joins("JOIN users ON users.id = calendar_feeds.user_id AND users.delete_after IS NULL").
+		Where("calendar_feeds.token = ?", token).First(&feed).Error
+	return &feed, err
+}
+
+func (r *calendarRepository) CreateFeed(feed *models.CalendarFeed) error {
+	// Параллельный запрос мог создать ленту раньше, тогда её прочитает вызывающий
+	return r.DB.Omit("List").Clauses(clause.OnConflict{DoNothing: true}).Create(feed).Error
+}
+
+func (r *calendarRepository) UpdateFeed(feed *models.CalendarFeed) error {
+	return r.DB.Omit("List").Save(feed).Error
+}
+
+func (r *calendarRepository) DeleteFeed(feed *models.CalendarFeed) error {
+	return r.DB.Delete(&models.CalendarFeed{}, feed.ID).Error
+}
+
+func (r *calendarRepository) GetDueTasks(userID int, listID *int) ([]models.Task, error) {
+	var tasks []models.Task
+	query := r.DB.Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id AND todo_lists.deleted_at IS NULL").
+		Where("todo_lists.user_id = ? AND tasks.due_at IS NOT NULL", userID)
+	if listID != nil {
+		query = query.Where("tasks.list_id = ?", *listID)
+	}
+	err := query.Order("tasks.due_at, tasks.id").Find(&tasks).Error
+	return tasks, err
+}
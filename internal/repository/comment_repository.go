@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type CommentRepository interface {
+	GetComments(taskID int) ([]models.Comment, error)
+	GetComment(taskID int, commentID int) (*models.Comment, error)
+	CreateComment(comment *models.Comment) error
+	UpdateComment(comment *models.Comment) error
+	DeleteComment(comment *models.Comment) error
+	CreateRevision(revision *models.CommentRevision) error
+	GetRevisions(commentID int) ([]models.CommentRevision, error)
+	CreateMentions(mentions []models.Mention) error
+	// GetMentions returns the user's mentions on tasks of lists that still exist, newest first
+	GetMentions(userID int, unreadOnly bool, limit int, offset int) ([]models.Mention, error)
+	MarkMentionRead(mentionID int, userID int, at time.Time) (int64, error)
+	WithTx(tx *gorm.DB) CommentRepository
+}
+
+type commentRepository struct {
+	DB *gorm.DB
+}
+
+func NewCommentRepository(db *gorm.DB) CommentRepository {
+	return &commentRepository{DB: db}
+}
+
+func (r *commentRepository) WithTx(tx *gorm.DB) CommentRepository {
+	return &commentRepository{DB: tx}
+}
+
+func (r *commentRepository) GetComments(taskID int) ([]models.Comment, error) {
+	var comments []models.Comment
+	err := r.DB.Preload("Mentions").
+		Where("task_id = ?", taskID).
+		Order("created_at, id").
+		Find(&comments).Error
+	return comments, err
+}
+
+func (r *commentRepository) GetComment(taskID int, commentID int) (*models.Comment, error) {
+	var comment models.Comment
+	err := r.DB.Preload("Mentions").
+		Where("task_id = ?", taskID).
+		First(&comment, commentID).Error
+	return &comment, err
+}
+
+func (r *commentRepository) CreateComment(comment *models.Comment) error {
+	return r.DB.Omit("Mentions").Create(comment).Error
+}
+
+func (r *commentRepository) UpdateComment(comment *models.Comment) error {
+	return r.DB.Omit("Mentions").Save(comment).Error
+}
+
+// DeleteComment removes the comment; revisions and mentions go with it
+func (r *commentRepository) DeleteComment(comment *models.Comment) error {
+	return r.DB.Delete(&models.Comment{}, comment.ID).Error
+}
+
+func (r *commentRepository) CreateRevision(revision *models.CommentRevision) error {
+	return r.DB.Create(revision).Error
+}
+
+func (r *commentRepository) GetRevisions(commentID int) ([]models.CommentRevision, error) {
+	var revisions []models.CommentRevision
+	err := r.DB.Where("comment_id = ?", commentID).Order("id DESC").Find(&revisions).Error
+	return revisions, err
+}
+
+func (r *commentRepository) CreateMentions(mentions []models.Mention) error {
+	if len(mentions) == 0 {
+		return nil
+	}
+	return r.DB.Omit("Comment").Create(&mentions).Error
+}
+
+func (r *commentRepository) GetMentions(userID int, unreadOnly bool, limit int, offset int) ([]models.Mention, error) {
+	var mentions []models.Mention
+	query := r.DB.Preload("Comment").
+		Joins("JOIN tasks ON tasks.id = mentions.task_id AND tasks.deleted_at IS NULL").
+		Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id AND todo_lists.deleted_at IS NULL").
+		Where("mentions.user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("mentions.read_at IS NULL")
+	}
+	err := query.Order("mentions.id DESC").Limit(limit).Offset(offset).Find(&mentions).Error
+	return mentions, err
+}
+
+func (r *commentRepository) MarkMentionRead(mentionID int, userID int, at time.Time) (int64, error) {
+	// Повторная отметка не сдвигает время прочтения
+	result := r.DB.Model(&models.Mention{}).
+		Where("id = ? AND user_id = ?", mentionID, userID).
+		Update("read_at", gorm.Expr("COALESCE(read_at, ?)", at))
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"fmt"
+	"gorm.io/gorm"
+)
+
+type DependencyRepository interface {
+	GetDependency(taskID int, blockerID int) (*models.TaskDependency, error)
+	CreateDependency(dep *models.TaskDependency) error
+	DeleteDependency(dep *models.TaskDependency) error
+	// LockGraph serializes dependency changes of one user until the
+	// transaction ends, so that concurrent additions cannot form a cycle
+	LockGraph(userID int) error
+	// BlockerIDs returns the blockers of each task, including deleted ones
+	BlockerIDs(taskIDs []int) (map[int][]int, error)
+	// OpenBlockerIDs returns, per task, its live blockers that are not completed
+	OpenBlockerIDs(taskIDs []int) (map[int][]int, error)
+	// GetBlockers returns the live tasks of the user that block taskID
+	GetBlockers(taskID int, userID int) ([]models.Task, error)
+	// GetBlocked returns the live tasks of the user that taskID blocks
+	GetBlocked(taskID int, userID int) ([]models.Task, error)
+	// GetListDependencies returns the dependencies between tasks of the list
+	GetListDependencies(listID int) ([]models.TaskDependency, error)
+	// BlocksCompletion reports whether the list refuses to complete blocked tasks
+	BlocksCompletion(listID int) (bool, error)
+	WithTx(tx *gorm.DB) DependencyRepository
+}
+
+type dependencyRepository struct {
+	DB *gorm.DB
+}
+
+func NewDependencyRepository(db *gorm.DB) DependencyRepository {
+	return &dependencyRepository{DB: db}
+}
+
+func (r *dependencyRepository) WithTx(tx *gorm.DB) DependencyRepository {
+	return &dependencyRepository{DB: tx}
+}
+
+func (r *dependencyRepository) GetDependency(taskID int, blockerID int) (*models.TaskDependency, error) {
+	var dep models.TaskDependency
+	err := r.DB.Where("task_id = ? AND blocker_id = ?", taskID, blockerID).First(&dep).Error
+	return &dep, err
+}
+
+func (r *dependencyRepository) CreateDependency(dep *models.TaskDependency) error {
+	return r.DB.Omit("Task", "Blocker").Create(dep).Error
+}
+
+func (r *dependencyRepository) DeleteDependency(dep *models.TaskDependency) error {
+	return r.DB.Delete(&models.TaskDependency{}, dep.ID).Error
+}
+
+func (r *dependencyRepository) LockGraph(userID int) error {
+	return r.DB.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", fmt.Sprintf("task_dependencies:%d", userID)).Error
+}
+
+func (r *dependencyRepository) BlockerIDs(taskIDs []int) (map[int][]int, error) {
+	var deps []models.TaskDependency
+	err := r.DB.Where("task_id IN ?", taskIDs).Order("id").Find(&deps).Error
+	return groupBlockers(deps), err
+}
+
+func (r *dependencyRepository) OpenBlockerIDs(taskIDs []int) (map[int][]int, error) {
+	var deps []models.TaskDependency
+	err := r.DB.Joins("JOIN tasks ON tasks.id = task_dependencies.blocker_id AND tasks.deleted_at IS NULL").
+		Where("task_dependencies.task_id IN ? AND tasks.completed = ?", taskIDs, false).
+		Order("task_dependencies.id").
+		Find(&deps).Error
+	return groupBlockers(deps), err
+}
+
+func groupBlockers(deps []models.TaskDependency) map[int][]int {
+	blockers := make(map[int][]int)
+	for _, dep := range deps {
+		blockers[dep.TaskID] = append(blockers[dep.TaskID], dep.BlockerID)
+	}
+	return blockers
+}
+
+func (r *dependencyRepository) GetBlockers(taskID int, userID int) ([]models.Task, error) {
+	var tasks []models.Task
+	err := r.ownedTasks(userID).
+		Joins("JOIN task_dependencies ON task_dependencies.blocker_id = tasks.id").
+		Where("task_dependencies.task_id = ?", taskID).
+		Order("task_dependencies.id").
+		Find(&tasks).Error
+	return tasks, err
+}
+
+func (r *dependencyRepository) GetBlocked(taskID int, userID int) ([]models.Task, error) {
+	var tasks []models.Task
+	err := r.ownedTasks(userID).
+		Joins("JOIN task_dependencies ON task_dependencies.task_id = tasks.id").
+		Where("task_dependencies.blocker_id = ?", taskID).
+		Order("task_dependencies.id").
+		Find(&tasks).Error
+	return tasks, err
+}
+
+// ownedTasks restricts a tasks query to live lists belonging to the user
+func (r *dependencyRepository) ownedTasks(userID int) *gorm.DB {
+	return r.DB.Model(&models.Task{}).
+		Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id AND todo_lists.deleted_at IS NULL").
+		Where("todo_lists.user_id = ?", userID)
+}
+
+func (r *dependencyRepository) GetListDependencies(listID int) ([]models.TaskDependency, error) {
+	var deps []models.TaskDependency
+	listTasks := r.DB.Model(&models.Task{}).Select("id").Where("list_id = ?", listID)
+	err := r.DB.Where("task_id IN (?) AND blocker_id IN (?)", listTasks, listTasks).
+		Order("id").
+		Find(&deps).Error
+	return deps, err
+}
+
+func (r *dependencyRepository) BlocksCompletion(listID int) (bool, error) {
+	var blocks []bool
+	err := r.DB.Model(&models.TodoList{}).Where("id = ?", listID).Pluck("block_completion", &blocks).Error
+	return len(blocks) > 0 && blocks[0], err
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type EmailVerificationRepository interface {
+	Create(verification *models.EmailVerification) error
+	// GetByToken returns the unexpired verification with the token hash; nil when there is none
+	GetByToken(tokenHash string, now time.Time) (*models.EmailVerification, error)
+	// GetSentSince returns the verifications sent to the user after the time, newest first
+	GetSentSince(userID int, since time.Time) ([]models.EmailVerification, error)
+	// DeleteForUser deletes all verifications of the user
+	DeleteForUser(userID int) error
+	// DeleteOlder deletes verifications created before the time
+	DeleteOlder(before time.Time) (int64, error)
+	// MarkVerified sets the verification time of the user's email if it is still email
+	MarkVerified(userID int, email string, at time.Time) (int64, error)
+	WithTx(tx *gorm.DB) EmailVerificationRepository
+}
+
+type emailVerificationRepository struct {
+	DB *gorm.DB
+}
+
+func NewEmailVerificationRepository(db *gorm.DB) EmailVerificationRepository {
+	return &emailVerificationRepository{DB: db}
+}
+
+func (r *emailVerificationRepository) WithTx(tx *gorm.DB) EmailVerificationRepository {
+	return &emailVerificationRepository{DB: tx}
+}
+
+func (r *emailVerificationRepository) Create(verification *models.EmailVerification) error {
+	return r.DB.Create(verification).Error
+}
+
+func (r *emailVerificationRepository) GetByToken(tokenHash string, now time.Time) (*models.EmailVerification, error) {
+	var verifications []models.EmailVerification
+	err := r.DB.Where("token_hash = ? AND expires_at > ?", tokenHash, now).Limit(1).Find(&verifications).Error
+	if err != nil || len(verifications) == 0 {
+		return nil, err
+	}
+	return &verifications[0], nil
+}
+
+func (r *emailVerificationRepository) GetSentSince(userID int, since time.Time) ([]models.EmailVerification, error) {
+	var verifications []models.EmailVerification
+	err := r.DB.Where("user_id = ? AND created_at > ?", userID, since).Order("created_at DESC").Find(&verifications).Error
+	return verifications, err
+}
+
+func (r *emailVerificationRepository) DeleteForUser(userID int) error {
+	return r.DB.Where("user_id = ?", userID).Delete(&models.EmailVerification{}).Error
+}
+
+func (r *emailVerificationRepository) DeleteOlder(before time.Time) (int64, error) {
+	res := r.DB.Where("created_at < ?", before).Delete(&models.EmailVerification{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *emailVerificationRepository) MarkVerified(userID int, email string, at time.Time) (int64, error) {
+	res := r.DB.Model(&models.User{}).Where("id = ? AND email = ?", userID, email).Update("email_verified_at", at)
+	return res.RowsAffected, res.Error
+}
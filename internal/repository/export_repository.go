@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+)
+
+// ExportRepository reads everything attached to the live tasks of a user's
+// live lists in bulk, for account exports
+type ExportRepository interface {
+	GetStatuses(userID int) ([]models.Status, error)
+	// GetDependencies returns the dependencies between live tasks of the user
+	GetDependencies(userID int) ([]models.TaskDependency, error)
+	GetComments(userID int) ([]models.Comment, error)
+	GetTimeEntries(userID int) ([]models.TimeEntry, error)
+	GetAttachments(userID int) ([]models.Attachment, error)
+}
+
+type exportRepository struct {
+	DB *gorm.DB
+}
+
+func NewExportRepository(db *gorm.DB) ExportRepository {
+	return &exportRepository{DB: db}
+}
+
+func (r *exportRepository) GetStatuses(userID int) ([]models.Status, error) {
+	var statuses []models.Status
+	err := r.DB.Joins("JOIN todo_lists ON todo_lists.id = statuses.list_id AND todo_lists.deleted_at IS NULL").
+		Where("todo_lists.user_id = ?", userID).
+		Order("statuses.list_id, statuses.position, statuses.id").
+		Find(&statuses).Error
+	return statuses, err
+}
+
+func (r *exportRepository) GetDependencies(userID int) ([]models.TaskDependency, error) {
+	var deps []models.TaskDependency
+	err := r.DB.Where("task_id IN (?) AND blocker_id IN (?)", r.liveTaskIDs(userID), r.liveTaskIDs(userID)).
+		Order("id").
+		Find(&deps).Error
+	return deps, err
+}
+
+func (r *exportRepository) GetComments(userID int) ([]models.Comment, error) {
+	var comments []models.Comment
+	err := r.DB.Where("task_id IN (?)", r.liveTaskIDs(userID)).
+		Order("task_id, created_at, id").
+		Find(&comments).Error
+	return comments, err
+}
+
+func (r *exportRepository) GetTimeEntries(userID int) ([]models.TimeEntry, error) {
+	var entries []models.TimeEntry
+	err := r.DB.Where("task_id IN (?)", r.liveTaskIDs(userID)).
+		Order("task_id, started_at, id").
+		Find(&entries).Error
+	return entries, err
+}
+
+func (r *exportRepository) GetAttachments(userID int) ([]models.Attachment, error) {
+	var attachments []models.Attachment
+	err := r.DB.Where("task_id IN (?)", r.liveTaskIDs(userID)).
+		Order("task_id, created_at, id").
+		Find(&attachments).Error
+	return attachments, err
+}
+
+// liveTaskIDs selects the IDs of the live tasks of the user's live lists
+func (r *exportRepository) liveTaskIDs(userID int) *gorm.DB {
+	return r.DB.Model(&models.Task{}).
+		Select("tasks.id").
+		Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id AND todo_lists.deleted_at IS NULL").
+		Where("todo_lists.user_id = ?", userID)
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type HistoryRepository interface {
+	CreateVersion(version *models.TaskVersion) error
+	LastVersion(taskID int) (int, error)
+	GetVersions(taskID int) ([]models.TaskVersion, error)
+	GetVersion(taskID int, version int) (*models.TaskVersion, error)
+	VersionsAfter(taskID int, version int) ([]models.TaskVersion, error)
+	// LastUndoable returns the actor's latest change in the session that has
+	// not been undone yet, excluding undo entries themselves
+	LastUndoable(userID int, sessionID string) (*models.TaskVersion, error)
+	UndoableByRequest(userID int, sessionID string, requestID string) ([]models.TaskVersion, error)
+	MarkUndone(id int64, at time.Time) error
+	WithTx(tx *gorm.DB) HistoryRepository
+}
+
+type historyRepository struct {
+	DB *gorm.DB
+}
+
+func NewHistoryRepository(db *gorm.DB) HistoryRepository {
+	return &historyRepository{DB: db}
+}
+
+func (r *historyRepository) WithTx(tx *gorm.DB) HistoryRepository {
+	return &historyRepository{DB: tx}
+}
+
+func (r *historyRepository) CreateVersion(version *models.TaskVersion) error {
+	return r.DB.Create(version).Error
+}
+
+func (r *historyRepository) LastVersion(taskID int) (int, error) {
+	var last int
+	err := r.DB.Model(&models.TaskVersion{}).
+		Where("task_id = ?", taskID).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&last).Error
+	return last, err
+}
+
+func (r *historyRepository) GetVersions(taskID int) ([]models.TaskVersion, error) {
+	var versions []models.TaskVersion
+	err := r.DB.Where("task_id = ?", taskID).Order("version DESC").Find(&versions).Error
+	return versions, err
+}
+
+func (r *historyRepository) GetVersion(taskID int, version int) (*models.TaskVersion, error) {
+	var v models.TaskVersion
+	err := r.DB.Where("task_id = ? AND version = ?", taskID, version).First(&v).Error
+	return &v, err
+}
+
+func (r *historyRepository) VersionsAfter(taskID int, version int) ([]models.TaskVersion, error) {
+	var versions []models.TaskVersion
+	err := r.DB.Where("task_id = ? AND version > ?", taskID, version).Order("version").Find(&versions).Error
+	return versions, err
+}
+
+func (r *historyRepository) LastUndoable(userID int, sessionID string) (*models.TaskVersion, error) {
+	var v models.TaskVersion
+	err := r.undoable(userID, sessionID).Order("id DESC").First(&v).Error
+	return &v, err
+}
+
+func (r *historyRepository) UndoableByRequest(userID int, sessionID string, requestID string) ([]models.TaskVersion, error) {
+	var versions []models.TaskVersion
+	err := r.undoable(userID, sessionID).
+		Where("request_id = ?", requestID).
+		Order("id DESC").
+		Find(&versions).Error
+	return versions, err
+}
+
+func (r *historyRepository) MarkUndone(id int64, at time.Time) error {
+	return r.DB.Model(&models.TaskVersion{}).Where("id = ?", id).Update("undone_at", at).Error
+}
+
+func (r *historyRepository) undoable(userID int, sessionID string) *gorm.DB {
+	return r.DB.Model(&models.TaskVersion{}).
+		Where("actor_id = ? AND session_id = ? AND session_id <> ''", userID, sessionID).
+		Where("undone_at IS NULL AND undo_of IS NULL")
+}
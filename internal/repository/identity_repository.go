@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type IdentityRepository interface {
+	// Get returns the identity of the provider's subject; nil when it is not linked
+	Get(provider, subject string) (*models.UserIdentity, error)
+	GetForUser(userID int) ([]models.UserIdentity, error)
+	Create(identity *models.UserIdentity) error
+	// Touch stores the time and email of a sign-in with the identity
+	Touch(identityID int, email string, at time.Time) error
+	Delete(userID, identityID int) (int64, error)
+
+	CreateLogin(login *models.OIDCLogin) error
+	// TakeLogin deletes and returns the unexpired login with the state hash,
+	// so that a state is used once; nil when there is none
+	TakeLogin(stateHash string, now time.Time) (*models.OIDCLogin, error)
+	// DeleteExpiredLogins deletes logins that expired before the time
+	DeleteExpiredLogins(before time.Time) (int64, error)
+
+	WithTx(tx *gorm.DB) IdentityRepository
+}
+
+type identityRepository struct {
+	DB *gorm.DB
+}
+
+func NewIdentityRepository(db *gorm.DB) IdentityRepository {
+	return &identityRepository{DB: db}
+}
+
+func (r *identityRepository) WithTx(tx *gorm.DB) IdentityRepository {
+	return &identityRepository{DB: tx}
+}
+
+func (r *identityRepository) Get(provider, subject string) (*models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := r.DB.Where("provider = ? AND subject = ?", provider, subject).Limit(1).Find(&identities).Error
+	if err != nil || len(identities) == 0 {
+		return nil, err
+	}
+	return &identities[0], nil
+}
+
+func (r *identityRepository) GetForUser(userID int) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := r.DB.Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+func (r *identityRepository) Create(identity *models.UserIdentity) error {
+	return r.DB.Create(identity).Error
+}
+
+func (r *identityRepository) Touch(identityID int, email string, at time.Time) error {
+	return r.DB.Model(&models.UserIdentity{}).Where("id = ?", identityID).
+		Updates(map[string]interface{}{"email": email, "last_login_at": at}).Error
+}
+
+func (r *identityRepository) Delete(userID, identityID int) (int64, error) {
+	res := r.DB.Where("id = ? AND user_id = ?", identityID, userID).Delete(&models.UserIdentity{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *identityRepository) CreateLogin(login *models.OIDCLogin) error {
+	return r.DB.Create(login).Error
+}
+
+func (r *identityRepository) TakeLogin(stateHash string, now time.Time) (*models.OIDCLogin, error) {
+	var logins []models.OIDCLogin
+	err := r.DB.Clauses(clause.Returning{}).
+		Where("state_hash = ? AND expires_at > ?", stateHash, now).
+		Delete(&logins).Error
+	if err != nil || len(logins) == 0 {
+		return nil, err
+	}
+	return &logins[0], nil
+}
+
+func (r *identityRepository) DeleteExpiredLogins(before time.Time) (int64, error) {
+	res := r.DB.Where("expires_at < ?", before).Delete(&models.OIDCLogin{})
+	return res.RowsAffected, res.Error
+}
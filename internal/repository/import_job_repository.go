@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type ImportJobRepository interface {
+	CreateJob(job *models.ImportJob) error
+	// GetJob and GetJobs leave out the uploaded file
+	GetJob(jobID int, userID int) (*models.ImportJob, error)
+	GetJobs(userID int, limit int) ([]models.ImportJob, error)
+	// CountUnfinished counts the user's pending and running jobs
+	CountUnfinished(userID int) (int64, error)
+	// ClaimJob marks the oldest pending job, or a running job whose worker
+	// stopped reporting before staleBefore, as running and returns it with
+	// its file; nil when there is nothing to run. Jobs that were claimed
+	// maxAttempts times are not claimed again.
+	ClaimJob(staleBefore time.Time, maxAttempts int) (*models.ImportJob, error)
+	// FailStale fails the running jobs that stopped reporting before
+	// staleBefore and cannot be claimed again
+	FailStale(staleBefore time.Time, maxAttempts int, reason string) (int64, error)
+	// UpdateProgress also tells other workers that the job is still running
+	UpdateProgress(jobID int, processed int, total int) error
+	// FinishJob saves the outcome of the job and drops its file
+	FinishJob(job *models.ImportJob) error
+	DeleteJob(job *models.ImportJob) error
+	// DeleteFinished deletes jobs that finished before the time
+	DeleteFinished(before time.Time) (int64, error)
+}
+
+type importJobRepository struct {
+	DB *gorm.DB
+}
+
+func NewImportJobRepository(db *gorm.DB) ImportJobRepository {
+	return &importJobRepository{DB: db}
+}
+
+func (r *importJobRepository) CreateJob(job *models.ImportJob) error {
+	return r.DB.Create(job).Error
+}
+
+func (r *importJobRepository) GetJob(jobID int, userID int) (*models.ImportJob, error) {
+	var job models.ImportJob
+	err := r.DB.Omit("data").Where("user_id = ?", userID).First(&job, jobID).Error
+	return &job, err
+}
+
+func (r *importJobRepository) GetJobs(userID int, limit int) ([]models.ImportJob, error) {
+	var jobs []models.ImportJob
+	err := r.DB.Omit("data").Where("user_id = ?", userID).Order("created_at DESC, id DESC").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+func (r *importJobRepository) CountUnfinished(userID int) (int64, error) {
+	var count int64
+	err := r.DB.Model(&models.ImportJob{}).
+		Where("user_id = ? AND status IN ?", userID, []string{models.ImportJobPending, models.ImportJobRunning}).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *importJobRepository) ClaimJob(staleBefore time.Time, maxAttempts int) (*models.ImportJob, error) {
+	var jobs []models.ImportJob
+	now := time.Now()
+	// SKIP LOCKED позволяет нескольким экземплярам разбирать очередь параллельно
+	err := r.DB.Raw(`UPDATE import_jobs SET status = ?, attempts = attempts + 1, processed = 0, started_at = ?, updated_at = ?
+		WHERE id = (
+			SELECT id FROM import_jobs
+			WHERE status = ? OR (status = ? AND updated_at < ? AND attempts < ?)
+			ORDER BY created_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		models.ImportJobRunning, now, now,
+		models.ImportJobPending, models.ImportJobRunning, staleBefore, maxAttempts,
+	).Scan(&jobs).Error
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+func (r *importJobRepository) FailStale(staleBefore time.Time, maxAttempts int, reason string) (int64, error) {
+	result := r.DB.Model(&models.ImportJob{}).
+		Where("status = ? AND updated_at < ? AND attempts >= ?", models.ImportJobRunning, staleBefore, maxAttempts).
+		Updates(map[string]interface{}{
+			"status":      models.ImportJobFailed,
+			"error":       reason,
+			"data":        nil,
+			"finished_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+func (r *importJobRepository) UpdateProgress(jobID int, processed int, total int) error {
+	return r.DB.Model(&models.ImportJob{}).Where("id = ?", jobID).
+		Updates(map[string]interface{}{"processed": processed, "total": total, "updated_at": time.Now()}).Error
+}
+
+func (r *importJobRepository) FinishJob(job *models.ImportJob) error {
+	job.Data = nil
+	return r.DB.Model(job).Select("status", "processed", "total", "result", "error", "data", "finished_at", "updated_at").Updates(job).Error
+}
+
+func (r *importJobRepository) DeleteJob(job *models.ImportJob) error {
+	return r.DB.Delete(&models.ImportJob{}, job.ID).Error
+}
+
+func (r *importJobRepository) DeleteFinished(before time.Time) (int64, error) {
+	result := r.DB.Where("status IN ? AND finished_at < ?", []string{models.ImportJobSucceeded, models.ImportJobFailed}, before).
+		Delete(&models.ImportJob{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type OAuthRepository interface {
+	CreateClient(client *models.OAuthClient) error
+	// GetClient returns the client of the owner; nil when there is none
+	GetClient(ownerID, id int) (*models.OAuthClient, error)
+	// GetClientByClientID returns the client with the client_id; nil when there is none
+	GetClientByClientID(clientID string) (*models.OAuthClient, error)
+	GetClientsByIDs(ids []int) ([]models.OAuthClient, error)
+	GetClients(ownerID int) ([]models.OAuthClient, error)
+	UpdateClientSecret(id int, secretHash string) error
+	// DeleteClient deletes the client with its codes, tokens and consents
+	DeleteClient(id int) error
+
+	CreateCode(code *models.OAuthCode) error
+	// TakeCode deletes and returns the unexpired code with the hash, so that
+	// a code is exchanged once; nil when there is none
+	TakeCode(codeHash string, now time.Time) (*models.OAuthCode, error)
+	// DeleteExpiredCodes deletes codes that expired before the time
+	DeleteExpiredCodes(before time.Time) (int64, error)
+
+	CreateToken(token *models.OAuthToken) error
+	// GetTokenByAccess returns the token with an unexpired access token of the hash; nil when there is none
+	GetTokenByAccess(accessHash string, now time.Time) (*models.OAuthToken, error)
+	// GetTokenByRefresh returns the token with an unexpired refresh token of the hash; nil when there is none
+	GetTokenByRefresh(refreshHash string, now time.Time) (*models.OAuthToken, error)
+	// RotateToken stores the new hashes and expiry times of the token unless
+	// its refresh token was rotated meanwhile; 0 rows in that case
+	RotateToken(token *models.OAuthToken, oldRefreshHash string) (int64, error)
+	DeleteToken(id int) error
+	// DeleteExpiredTokens deletes tokens whose refresh token expired before the time
+	DeleteExpiredTokens(before time.Time) (int64, error)
+
+	// GetConsent returns the scopes the user granted to the client; nil when there are none
+	GetConsent(userID, clientID int) (*models.OAuthConsent, error)
+	// GetConsents returns the consents of the user, latest first
+	GetConsents(userID int) ([]models.OAuthConsent, error)
+	// SaveConsent creates or replaces the consent of the user to the client
+	SaveConsent(consent *models.OAuthConsent) error
+	// DeleteGrant deletes the consent of the user to the client with the
+	// codes and tokens issued for it; 0 rows when there was no consent
+	DeleteGrant(userID, clientID int) (int64, error)
+	WithTx(tx *gorm.DB) OAuthRepository
+}
+
+type oauthRepository struct {
+	DB *gorm.DB
+}
+
+func NewOAuthRepository(db *gorm.DB) OAuthRepository {
+	return &oauthRepository{DB: db}
+}
+
+func (r *oauthRepository) WithTx(tx *gorm.DB) OAuthRepository {
+	return &oauthRepository{DB: tx}
+}
+
+func (r *oauthRepository) CreateClient(client *models.OAuthClient) error {
+	return r.DB.Create(client).Error
+}
+
+func (r *oauthRepository) GetClient(ownerID, id int) (*models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	err := r.DB.Where("id = ? AND owner_id = ?", id, ownerID).Limit(1).Find(&clients).Error
+	if err != nil || len(clients) == 0 {
+		return nil, err
+	}
+	return &clients[0], nil
+}
+
+func (r *oauthRepository) GetClientByClientID(clientID string) (*models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	err := r.DB.Where("client_id = ?", clientID).Limit(1).Find(&clients).Error
+	if err != nil || len(clients) == 0 {
+		return nil, err
+	}
+	return &clients[0], nil
+}
+
+func (r *oauthRepository) GetClientsByIDs(ids []int) ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	if len(ids) == 0 {
+		return clients, nil
+	}
+	err := r.DB.Where("id IN ?", ids).Find(&clients).Error
+	return clients, err
+}
+
+func (r *oauthRepository) GetClients(ownerID int) ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	err := r.DB.Where("owner_id = ?", ownerID).Order("id").Find(&clients).Error
+	return clients, err
+}
+
+func (r *oauthRepository) UpdateClientSecret(id int, secretHash string) error {
+	return r.DB.Model(&models.OAuthClient{}).Where("id = ?", id).Update("secret_hash", secretHash).Error
+}
+
+func (r *oauthRepository) DeleteClient(id int) error {
+	for _, model := range []interface{}{&models.OAuthCode{}, &models.OAuthToken{}, &models.OAuthConsent{}} {
+		if err := r.DB.Where("client_id = ?", id).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return r.DB.Delete(&models.OAuthClient{}, id).Error
+}
+
+func (r *oauthRepository) CreateCode(code *models.OAuthCode) error {
+	return r.DB.Create(code).Error
+}
+
+func (r *oauthRepository) TakeCode(codeHash string, now time.Time) (*models.OAuthCode, error) {
+	var codes []models.OAuthCode
+	err := r.DB.Clauses(clause.Returning{}).
+		Where("code_hash = ? AND expires_at > ?", codeHash, now).
+		Delete(&codes).Error
+	if err != nil || len(codes) == 0 {
+		return nil, err
+	}
+	return &codes[0], nil
+}
+
+func (r *oauthRepository) DeleteExpiredCodes(before time.Time) (int64, error) {
+	res := r.DB.Where("expires_at < ?", before).Delete(&models.OAuthCode{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *oauthRepository) CreateToken(token *models.OAuthToken) error {
+	return r.DB.Create(token).Error
+}
+
+func (r *oauthRepository) GetTokenByAccess(accessHash string, now time.Time) (*models.OAuthToken, error) {
+	var tokens []models.OAuthToken
+	err := r.DB.Where("access_hash = ? AND access_expires_at > ?", accessHash, now).Limit(1).Find(&tokens).Error
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+func (r *oauthRepository) GetTokenByRefresh(refreshHash string, now time.Time) (*models.OAuthToken, error) {
+	var tokens []models.OAuthToken
+	err := r.DB.Where("refresh_hash = ? AND refresh_expires_at > ?", refreshHash, now).Limit(1).Find(&tokens).Error
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return &tokens[0], nil
+}
+
+func (r *oauthRepository) RotateToken(token *models.OAuthToken, oldRefreshHash string) (int64, error) {
+	res := r.DB.Model(&models.OAuthToken{}).Where("id = ? AND refresh_hash = ?", token.ID, oldRefreshHash).
+		Updates(map[string]interface{}{
+			"scope":              token.Scope,
+			"access_hash":        token.AccessHash,
+			"refresh_hash":       token.RefreshHash,
+			"refreshed_at":       token.RefreshedAt,
+			"access_expires_at":  token.AccessExpiresAt,
+			"refresh_expires_at": token.RefreshExpiresAt,
+		})
+	return res.RowsAffected, res.Error
+}
+
+func (r *oauthRepository) DeleteToken(id int) error {
+	return r.DB.Delete(&models.OAuthToken{}, id).Error
+}
+
+func (r *oauthRepository) DeleteExpiredTokens(before time.Time) (int64, error) {
+	res := r.DB.Where("refresh_expires_at < ?", before).Delete(&models.OAuthToken{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *oauthRepository) GetConsent(userID, clientID int) (*models.OAuthConsent, error) {
+	var consents []models.OAuthConsent
+	err := r.DB.Where("user_id = ? AND client_id = ?", userID, clientID).Limit(1).Find(&consents).Error
+	if err != nil || len(consents) == 0 {
+		return nil, err
+	}
+	return &consents[0], nil
+}
+
+func (r *oauthRepository) GetConsents(userID int) ([]models.OAuthConsent, error) {
+	var consents []models.OAuthConsent
+	err := r.DB.Where("user_id = ?", userID).Order("updated_at DESC").Find(&consents).Error
+	return consents, err
+}
+
+func (r *oauthRepository) SaveConsent(consent *models.OAuthConsent) error {
+	return r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scope", "updated_at"}),
+	}).Create(consent).Error
+}
+
+func (r *oauthRepository) DeleteGrant(userID, clientID int) (int64, error) {
+	res := r.DB.Where("user_id = ? AND client_id = ?", userID, clientID).Delete(&models.OAuthConsent{})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	for _, model := range []interface{}{&models.OAuthCode{}, &models.OAuthToken{}} {
+		if err := r.DB.Where("user_id = ? AND client_id = ?", userID, clientID).Delete(model).Error; err != nil {
+			return 0, err
+		}
+	}
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+)
+
+type StatusRepository interface {
+	// GetStatuses returns the workflow columns of the list in board order
+	GetStatuses(listID int) ([]models.Status, error)
+	CreateStatus(status *models.Status) error
+	UpdateStatus(status *models.Status) error
+	DeleteStatus(status *models.Status) error
+	// CountTasks counts live tasks in the column other than exceptTaskID
+	CountTasks(statusID int, exceptTaskID int) (int64, error)
+	// GetListTasks returns every task of the list, including the ones in the trash
+	GetListTasks(listID int) ([]models.Task, error)
+	// SetTaskStatus writes the column and completion of a task, even a deleted one
+	SetTaskStatus(task *models.Task) error
+	WithTx(tx *gorm.DB) StatusRepository
+}
+
+type statusRepository struct {
+	DB *gorm.DB
+}
+
+func NewStatusRepository(db *gorm.DB) StatusRepository {
+	return &statusRepository{DB: db}
+}
+
+func (r *statusRepository) WithTx(tx *gorm.DB) StatusRepository {
+	return &statusRepository{DB: tx}
+}
+
+func (r *statusRepository) GetStatuses(listID int) ([]models.Status, error) {
+	var statuses []models.Status
+	err := r.DB.Where("list_id = ?", listID).Order("position, id").Find(&statuses).Error
+	return statuses, err
+}
+
+func (r *statusRepository) CreateStatus(status *models.Status) error {
+	return r.DB.Omit("List").Create(status).Error
+}
+
+func (r *statusRepository) UpdateStatus(status *models.Status) error {
+	return r.DB.Omit("List").Save(status).Error
+}
+
+func (r *statusRepository) DeleteStatus(status *models.Status) error {
+	return r.DB.Delete(&models.Status{}, status.ID).Error
+}
+
+func (r *statusRepository) CountTasks(statusID int, exceptTaskID int) (int64, error) {
+	var count int64
+	err := r.DB.Model(&models.Task{}).
+		Where("status_id = ? AND id <> ?", statusID, exceptTaskID).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *statusRepository) GetListTasks(listID int) ([]models.Task, error) {
+	var tasks []models.Task
+	err := r.DB.Unscoped().Where("list_id = ?", listID).Order(TaskOrder).Find(&tasks).Error
+	return tasks, err
+}
+
+func (r *statusRepository) SetTaskStatus(task *models.Task) error {
+	return r.DB.Unscoped().Model(&models.Task{}).
+		Where("id = ?", task.ID).
+		Updates(map[string]interface{}{
+			"status_id":    task.StatusID,
+			"completed":    task.Completed,
+			"completed_at": task.CompletedAt,
+			"updated_by":   task.UpdatedBy,
+		}).Error
+}
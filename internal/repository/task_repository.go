@@ -2,17 +2,56 @@ package repository
 
 import (
 	"RestAPI/internal/models"
+	"fmt"
 	"gorm.io/gorm"
+	"strings"
+	"time"
 )
 
+// TaskFilter narrows a task query; nil fields are ignored.
+// Sort is a column name, optionally prefixed with "-" for descending order.
+type TaskFilter struct {
+	Completed       *bool
+	Priority        *models.Priority
+	StatusID        *int
+	AssignedTo      *int
+	NotAssignedTo   *int
+	CreatedAfter    *time.Time
+	CreatedBefore   *time.Time
+	UpdatedAfter    *time.Time
+	UpdatedBefore   *time.Time
+	CompletedAfter  *time.Time
+	CompletedBefore *time.Time
+	Sort            string
+}
+
+// TaskSortFields are the columns tasks can be sorted by
+var TaskSortFields = []string{"position", "created_at", "updated_at", "completed_at", "title", "priority", "due_at"}
+
 type TaskRepository interface {
 	GetAllTasksForThisList(listID int, userID int) ([]models.Task, error)
+	FindTasks(listID int, userID int, filter TaskFilter) ([]models.Task, error)
+	// FindUserTasks searches the tasks of every list the user can access
+	FindUserTasks(userID int, filter TaskFilter, limit int, offset int) ([]models.Task, error)
 	GetTaskByID(taskID int, userID int) (*models.Task, error)
 	CreateTask(task *models.Task) error
 	UpdateTask(task *models.Task) error
+	// UpdateTaskIfUnchanged saves the task unless its row was updated after
+	// updatedAt; 0 rows in that case
+	UpdateTaskIfUnchanged(task *models.Task, updatedAt time.Time) (int64, error)
 	DeleteTask(task *models.Task) error
+	// CompleteAllTasksForThisList also moves the tasks to statusID unless it is nil
+	CompleteAllTasksForThisList(listID int, userID int, at time.Time, statusID *int) (int64, error)
+	DeleteCompletedTasksForThisList(listID int, userID int) (int64, error)
+	LastTaskPosition(listID int) (string, error)
+	UpdateTaskPosition(taskID int, position string) error
+	WithTx(tx *gorm.DB) TaskRepository
 }
 
+// TaskOrder sorts tasks by their ordering key. Keys must compare bytewise,
+// hence the "C" collation.
+const TaskOrder = `tasks.position COLLATE "C", tasks.id`
+
 type taskRepository struct {
 	DB *gorm.DB
 }
@@ -23,17 +62,83 @@ func NewTaskRepository(db *gorm.DB) TaskRepository {
 
 func (r *taskRepository) GetAllTasksForThisList(listID int, userID int) ([]models.Task, error) {
 	var tasks []models.Task
-	err := r.DB.Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id").
-		Where("todo_lists.user_id = ?", userID).
+	err := r.ownedBy(userID).
 		Where("tasks.list_id = ?", listID).
+		Order(TaskOrder).
 		Find(&tasks).Error
 	return tasks, err
 }
 
+func (r *taskRepository) FindTasks(listID int, userID int, filter TaskFilter) ([]models.Task, error) {
+	var tasks []models.Task
+	query := r.ownedBy(userID).Where("tasks.list_id = ?", listID)
+	query = applyTaskFilter(query, filter)
+	err := query.Find(&tasks).Error
+	return tasks, err
+}
+
+func (r *taskRepository) FindUserTasks(userID int, filter TaskFilter, limit int, offset int) ([]models.Task, error) {
+	var tasks []models.Task
+	query := applyTaskFilter(r.ownedBy(userID), filter)
+	err := query.Limit(limit).Offset(offset).Find(&tasks).Error
+	return tasks, err
+}
+
+func applyTaskFilter(query *gorm.DB, filter TaskFilter) *gorm.DB {
+	if filter.Completed != nil {
+		query = query.Where("tasks.completed = ?", *filter.Completed)
+	}
+	if filter.Priority != nil {
+		query = query.Where("tasks.priority = ?", *filter.Priority)
+	}
+	if filter.StatusID != nil {
+		query = query.Where("tasks.status_id = ?", *filter.StatusID)
+	}
+	// Работает по GIN-индексу на assignees
+	if filter.AssignedTo != nil {
+		query = query.Where("tasks.assignees @> ?::jsonb", fmt.Sprintf("[%d]", *filter.AssignedTo))
+	}
+	if filter.NotAssignedTo != nil {
+		query = query.Where("NOT tasks.assignees @> ?::jsonb", fmt.Sprintf("[%d]", *filter.NotAssignedTo))
+	}
+	ranges := []struct {
+		column string
+		op     string
+		value  *time.Time
+	}{
+		{"created_at", ">=", filter.CreatedAfter},
+		{"created_at", "<", filter.CreatedBefore},
+		{"updated_at", ">=", filter.UpdatedAfter},
+		{"updated_at", "<", filter.UpdatedBefore},
+		{"completed_at", ">=", filter.CompletedAfter},
+		{"completed_at", "<", filter.CompletedBefore},
+	}
+	for _, rg := range ranges {
+		if rg.value != nil {
+			query = query.Where(fmt.Sprintf("tasks.%s %s ?", rg.column, rg.op), *rg.value)
+		}
+	}
+
+	column, desc := strings.TrimPrefix(filter.Sort, "-"), strings.HasPrefix(filter.Sort, "-")
+	switch column {
+	case "", "position":
+		if desc {
+			return query.Order(`tasks.position COLLATE "C" DESC, tasks.id DESC`)
+		}
+		return query.Order(TaskOrder)
+	default:
+		// Имя колонки проверено в сервисе по TaskSortFields
+		direction := "ASC"
+		if desc {
+			direction = "DESC"
+		}
+		return query.Order(fmt.Sprintf("tasks.%s %s NULLS LAST, tasks.id", column, direction))
+	}
+}
+
 func (r *taskRepository) GetTaskByID(taskID int, userID int) (*models.Task, error) {
 	var task models.Task
-	err := r.DB.Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id").
-		Where("todo_lists.user_id = ?", userID).
+	err := r.ownedBy(userID).
 		Where("tasks.id = ?", taskID).
 		First(&task).Error
 	return &task, err
@@ -47,6 +152,69 @@ func (r *taskRepository) UpdateTask(task *models.Task) error {
 	return r.DB.Save(&task).Error
 }
 
+func (r *taskRepository) UpdateTaskIfUnchanged(task *models.Task, updatedAt time.Time) (int64, error) {
+	// Save при 0 строк вставил бы задачу заново, поэтому Updates со всеми полями
+	result := r.DB.Model(task).Where("updated_at = ?", updatedAt).Select("*").Updates(task)
+	return result.RowsAffected, result.Error
+}
+
 func (r *taskRepository) DeleteTask(task *models.Task) error {
 	return r.DB.Delete(&task).Error
 }
+
+// ownedBy restricts a tasks query to live lists belonging to the user.
+// GORM adds the soft delete condition only for the main table.
+func (r *taskRepository) ownedBy(userID int) *gorm.DB {
+	return r.DB.Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id AND todo_lists.deleted_at IS NULL").
+		Where("todo_lists.user_id = ?", userID)
+}
+
+// ownedListIDs restricts a tasks query to lists belonging to the user
+func (r *taskRepository) ownedListIDs(userID int) *gorm.DB {
+	return r.DB.Model(&models.TodoList{}).Select("id").Where("user_id = ?", userID)
+}
+
+func (r *taskRepository) CompleteAllTasksForThisList(listID int, userID int, at time.Time, statusID *int) (int64, error) {
+	values := map[string]interface{}{
+		"completed":    true,
+		"completed_at": at,
+		"updated_by":   userID,
+	}
+	if statusID != nil {
+		values["status_id"] = *statusID
+	}
+	result := r.DB.Model(&models.Task{}).
+		Where("list_id = ? AND list_id IN (?)", listID, r.ownedListIDs(userID)).
+		Where("completed = ?", false).
+		Updates(values)
+	return result.RowsAffected, result.Error
+}
+
+func (r *taskRepository) DeleteCompletedTasksForThisList(listID int, userID int) (int64, error) {
+	result := r.DB.
+		Where("list_id = ? AND list_id IN (?)", listID, r.ownedListIDs(userID)).
+		Where("completed = ?", true).
+		Delete(&models.Task{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *taskRepository) LastTaskPosition(listID int) (string, error) {
+	var positions []string
+	err := r.DB.Model(&models.Task{}).
+		Where("list_id = ?", listID).
+		Order(`position COLLATE "C" DESC`).
+		Limit(1).
+		Pluck("position", &positions).Error
+	if err != nil || len(positions) == 0 {
+		return "", err
+	}
+	return positions[0], nil
+}
+
+func (r *taskRepository) UpdateTaskPosition(taskID int, position string) error {
+	return r.DB.Model(&models.Task{}).Where("id = ?", taskID).Update("position", position).Error
+}
+
+func (r *taskRepository) WithTx(tx *gorm.DB) TaskRepository {
+	return &taskRepository{DB: tx}
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+// TaskTime is the time logged on one task of a list
+type TaskTime struct {
+	TaskID int `json:"task_id"`
+	// example: Buy milk
+	Title           string `json:"title"`
+	EstimateMinutes *int   `json:"estimate_minutes" gorm:"column:estimate"`
+	// Sum of the finished entries
+	// example: 5400
+	TrackedSeconds int64 `json:"tracked_seconds"`
+	Entries        int   `json:"entries"`
+}
+
+// TimeReportRow is the time logged in one group of a time report. Only the
+// fields of the requested grouping are set.
+type TimeReportRow struct {
+	ListID *int `json:"list_id,omitempty"`
+	// example: Client A
+	ListTitle *string `json:"list_title,omitempty"`
+	// Day in the report's time zone
+	// example: 2024-05-17
+	Day *string `json:"day,omitempty"`
+	// First day of the week in the report's time zone
+	// example: 2024-05-13
+	Week *string `json:"week,omitempty"`
+	// example: 5400
+	TrackedSeconds int64 `json:"tracked_seconds"`
+	Entries        int   `json:"entries"`
+}
+
+type TimeEntryRepository interface {
+	// GetEntries returns the entries of a task, oldest first
+	GetEntries(taskID int) ([]models.TimeEntry, error)
+	GetEntry(taskID int, entryID int) (*models.TimeEntry, error)
+	// GetRunning returns the user's running timer
+	GetRunning(userID int) (*models.TimeEntry, error)
+	CreateEntry(entry *models.TimeEntry) error
+	UpdateEntry(entry *models.TimeEntry) error
+	DeleteEntry(entry *models.TimeEntry) error
+	// LockTimer serializes timer changes of one user until the transaction ends
+	LockTimer(userID int) error
+	// GetListTimes returns every live task of the list with its logged time
+	GetListTimes(listID int) ([]TaskTime, error)
+	// Report sums the finished entries started in [from, to) on live tasks
+	// of the user's lists, grouped by list or by day in loc
+	Report(userID int, from time.Time, to time.Time, groupBy string, loc *time.Location, weekStart time.Weekday) ([]TimeReportRow, error)
+	WithTx(tx *gorm.DB) TimeEntryRepository
+}
+
+type timeEntryRepository struct {
+	DB *gorm.DB
+}
+
+func NewTimeEntryRepository(db *gorm.DB) TimeEntryRepository {
+	return &timeEntryRepository{DB: db}
+}
+
+func (r *timeEntryRepository) WithTx(tx *gorm.DB) TimeEntryRepository {
+	return &timeEntryRepository{DB: tx}
+}
+
+func (r *timeEntryRepository) GetEntries(taskID int) ([]models.TimeEntry, error) {
+	var entries []models.TimeEntry
+	err := r.DB.Where("task_id = ?", taskID).Order("started_at, id").Find(&entries).Error
+	return entries, err
+}
+
+func (r *timeEntryRepository) GetEntry(taskID int, entryID int) (*models.TimeEntry, error) {
+	var entry models.TimeEntry
+	err := r.DB.Where("task_id = ?", taskID).First(&entry, entryID).Error
+	return &entry, err
+}
+
+func (r *timeEntryRepository) GetRunning(userID int) (*models.TimeEntry, error) {
+	var entry models.TimeEntry
+	err := r.DB.Where("user_id = ? AND ended_at IS NULL", userID).First(&entry).Error
+	return &entry, err
+}
+
+func (r *timeEntryRepository) CreateEntry(entry *models.TimeEntry) error {
+	return r.DB.Omit("Task").Create(entry).Error
+}
+
+func (r *timeEntryRepository) UpdateEntry(entry *models.TimeEntry) error {
+	return r.DB.Omit("Task").Save(entry).Error
+}
+
+func (r *timeEntryRepository) DeleteEntry(entry *models.TimeEntry) error {
+	return r.DB.Delete(&models.TimeEntry{}, entry.ID).Error
+}
+
+func (r *timeEntryRepository) LockTimer(userID int) error {
+	return r.DB.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", fmt.Sprintf("time_entries:%d", userID)).Error
+}
+
+func (r *timeEntryRepository) GetListTimes(listID int) ([]TaskTime, error) {
+	var times []TaskTime
+	// Запущенные таймеры в сумму не входят
+	err := r.DB.Model(&models.Task{}).
+		Select("tasks.id AS task_id, tasks.title, tasks.estimate, COALESCE(SUM(time_entries.duration), 0) AS tracked_seconds, COUNT(time_entries.id) AS entries").
+		Joins("LEFT JOIN time_entries ON time_entries.task_id = tasks.id AND time_entries.ended_at IS NOT NULL").
+		Where("tasks.list_id = ?", listID).
+		Group("tasks.id").
+		Order("tasks.position, tasks.id").
+		Scan(&times).Error
+	return times, err
+}
+
+func (r *timeEntryRepository) Report(userID int, from time.Time, to time.Time, groupBy string, loc *time.Location, weekStart time.Weekday) ([]TimeReportRow, error) {
+	query := r.DB.Model(&models.TimeEntry{}).
+		Joins("JOIN tasks ON tasks.id = time_entries.task_id AND tasks.deleted_at IS NULL").
+		Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id AND todo_lists.deleted_at IS NULL").
+		Where("todo_lists.user_id = ?", userID).
+		Where("time_entries.ended_at IS NOT NULL").
+		Where("time_entries.started_at >= ? AND time_entries.started_at < ?", from, to)
+
+	totals := "COALESCE(SUM(time_entries.duration), 0) AS tracked_seconds, COUNT(time_entries.id) AS entries"
+	switch groupBy {
+	case "list":
+		query = query.Select("todo_lists.id AS list_id, todo_lists.title AS list_title, " + totals).
+			Group("todo_lists.id").
+			Order("todo_lists.position, todo_lists.id")
+	case "day":
+		day := gorm.Expr("to_char(time_entries.started_at AT TIME ZONE ?, 'YYYY-MM-DD')", loc.String())
+		query = query.Select("? AS day, "+totals, day).
+			Group("1").
+			Order("1")
+	case "week":
+		// date_trunc начинает неделю с понедельника: сдвигаем дату так, чтобы
+		// нужный день недели стал понедельником, и возвращаем обратно
+		shift := (8 - int(weekStart)) % 7
+		week := gorm.Expr("to_char(date_trunc('week', (time_entries.started_at AT TIME ZONE ?) + ? * interval '1 day') - ? * interval '1 day', 'YYYY-MM-DD')",
+			loc.String(), shift, shift)
+		query = query.Select("? AS week, "+totals, week).
+			Group("1").
+			Order("1")
+	default:
+		return nil, fmt.Errorf("unknown time report grouping %q", groupBy)
+	}
+
+	var rows []TimeReportRow
+	err := query.Scan(&rows).Error
+	return rows, err
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"RestAPI/internal/models"
 	"gorm.io/gorm"
+	"time"
 )
 
 type TodoListRepository interface {
@@ -10,10 +11,17 @@ type TodoListRepository interface {
 	GetListByID(listID int, userID int) (*models.TodoList, error)
 	CreateList(todoList *models.TodoList) error
 	UpdateList(todoList *models.TodoList) error
-	DeleteList(todoList *models.TodoList) error
-	DeleteAllTasksForThisList(todoList *models.TodoList) error
+	DeleteList(todoList *models.TodoList, deletedAt time.Time) error
+	DeleteAllTasksForThisList(todoList *models.TodoList, deletedAt time.Time) error
+	LastListPosition(userID int) (string, error)
+	UpdateListPosition(listID int, position string) error
+	// HasAccess reports whether the user may see and change the live list
+	HasAccess(listID int, userID int) (bool, error)
+	WithTx(tx *gorm.DB) TodoListRepository
 }
 
+const listOrder = `position COLLATE "C", id`
+
 type todoListRepository struct {
 	DB *gorm.DB
 }
@@ -24,13 +32,13 @@ func NewTodoListRepository(db *gorm.DB) TodoListRepository {
 
 func (r *todoListRepository) GetAllLists(userID int) ([]models.TodoList, error) {
 	var todoLists []models.TodoList
-	err := r.DB.Preload("Tasks").Where("user_id = ?", userID).Find(&todoLists).Error
+	err := r.DB.Preload("Tasks", orderedTasks).Where("user_id = ?", userID).Order(listOrder).Find(&todoLists).Error
 	return todoLists, err
 }
 
 func (r *todoListRepository) GetListByID(listID int, userID int) (*models.TodoList, error) {
 	var todoList models.TodoList
-	err := r.DB.Preload("Tasks").Where("user_id = ?", userID).First(&todoList, listID).Error
+	err := r.DB.Preload("Tasks", orderedTasks).Where("user_id = ?", userID).First(&todoList, listID).Error
 	return &todoList, err
 }
 
@@ -42,10 +50,45 @@ func (r *todoListRepository) UpdateList(todoList *models.TodoList) error {
 	return r.DB.Save(&todoList).Error
 }
 
-func (r *todoListRepository) DeleteList(todoList *models.TodoList) error {
-	return r.DB.Delete(&todoList).Error
+// DeleteList moves the list to the trash
+func (r *todoListRepository) DeleteList(todoList *models.TodoList, deletedAt time.Time) error {
+	return r.DB.Model(&models.TodoList{}).Where("id = ?", todoList.ID).Update("deleted_at", deletedAt).Error
+}
+
+// DeleteAllTasksForThisList moves the list's live tasks to the trash with the
+// list's own timestamp, so restoring the list brings exactly them back
+func (r *todoListRepository) DeleteAllTasksForThisList(todoList *models.TodoList, deletedAt time.Time) error {
+	return r.DB.Model(&models.Task{}).Where("list_id = ?", todoList.ID).Update("deleted_at", deletedAt).Error
+}
+
+func (r *todoListRepository) LastListPosition(userID int) (string, error) {
+	var positions []string
+	err := r.DB.Model(&models.TodoList{}).
+		Where("user_id = ?", userID).
+		Order(`position COLLATE "C" DESC`).
+		Limit(1).
+		Pluck("position", &positions).Error
+	if err != nil || len(positions) == 0 {
+		return "", err
+	}
+	return positions[0], nil
+}
+
+func (r *todoListRepository) UpdateListPosition(listID int, position string) error {
+	return r.DB.Model(&models.TodoList{}).Where("id = ?", listID).Update("position", position).Error
+}
+
+func orderedTasks(db *gorm.DB) *gorm.DB {
+	return db.Order(TaskOrder)
+}
+
+func (r *todoListRepository) WithTx(tx *gorm.DB) TodoListRepository {
+	return &todoListRepository{DB: tx}
 }
 
-func (r *todoListRepository) DeleteAllTasksForThisList(todoList *models.TodoList) error {
-	return r.DB.Delete(&todoList.Tasks).Error
+func (r *todoListRepository) HasAccess(listID int, userID int) (bool, error) {
+	// Пока списками не делятся, доступ есть только у владельца
+	var count int64
+	err := r.DB.Model(&models.TodoList{}).Where("id = ? AND user_id = ?", listID, userID).Count(&count).Error
+	return count > 0, err
 }
@@ -0,0 +1,21 @@
+package repository
+
+import "gorm.io/gorm"
+
+// Transactor runs several repository calls inside one database transaction.
+// Repositories join the transaction through their WithTx methods.
+type Transactor interface {
+	WithinTransaction(fn func(tx *gorm.DB) error) error
+}
+
+type transactor struct {
+	DB *gorm.DB
+}
+
+func NewTransactor(db *gorm.DB) Transactor {
+	return &transactor{DB: db}
+}
+
+func (t *transactor) WithinTransaction(fn func(tx *gorm.DB) error) error {
+	return t.DB.Transaction(fn)
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type TrashRepository interface {
+	GetDeletedLists(userID int) ([]models.TodoList, error)
+	GetDeletedTasks(userID int) ([]models.Task, error)
+	GetDeletedList(listID int, userID int) (*models.TodoList, error)
+	GetDeletedTask(taskID int, userID int) (*models.Task, error)
+	IsListDeleted(listID int) (bool, error)
+	RestoreList(todoList *models.TodoList) error
+	RestoreTask(task *models.Task) error
+	PurgeList(todoList *models.TodoList) error
+	PurgeTask(task *models.Task) error
+	PurgeAll(userID int) error
+	PurgeDeletedBefore(before time.Time) (int64, error)
+	WithTx(tx *gorm.DB) TrashRepository
+}
+
+type trashRepository struct {
+	DB *gorm.DB
+}
+
+func NewTrashRepository(db *gorm.DB) TrashRepository {
+	return &trashRepository{DB: db}
+}
+
+func (r *trashRepository) GetDeletedLists(userID int) ([]models.TodoList, error) {
+	var todoLists []models.TodoList
+	err := r.DB.Unscoped().
+		Preload("Tasks", func(db *gorm.DB) *gorm.DB {
+			// Только задачи, удалённые вместе со списком
+			return db.Unscoped().
+				Where("tasks.deleted_at = (SELECT deleted_at FROM todo_lists WHERE todo_lists.id = tasks.list_id)").
+				Order(TaskOrder)
+		}).
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").
+		Find(&todoLists).Error
+	return todoLists, err
+}
+
+// GetDeletedTasks returns tasks deleted one by one from lists that still exist
+func (r *trashRepository) GetDeletedTasks(userID int) ([]models.Task, error) {
+	var tasks []models.Task
+	err := r.DB.Unscoped().
+		Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id AND todo_lists.deleted_at IS NULL").
+		Where("todo_lists.user_id = ? AND tasks.deleted_at IS NOT NULL", userID).
+		Order("tasks.deleted_at DESC").
+		Find(&tasks).Error
+	return tasks, err
+}
+
+func (r *trashRepository) GetDeletedList(listID int, userID int) (*models.TodoList, error) {
+	var todoList models.TodoList
+	err := r.DB.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		First(&todoList, listID).Error
+	return &todoList, err
+}
+
+func (r *trashRepository) GetDeletedTask(taskID int, userID int) (*models.Task, error) {
+	var task models.Task
+	err := r.DB.Unscoped().
+		Joins("JOIN todo_lists ON todo_lists.id = tasks.list_id").
+		Where("todo_lists.user_id = ? AND tasks.deleted_at IS NOT NULL", userID).
+		Where("tasks.id = ?", taskID).
+		First(&task).Error
+	return &task, err
+}
+
+func (r *trashRepository) IsListDeleted(listID int) (bool, error) {
+	var count int64
+	err := r.DB.Unscoped().Model(&models.TodoList{}).
+		Where("id = ? AND deleted_at IS NOT NULL", listID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// RestoreList brings back the list and the tasks that were deleted with it
+func (r *trashRepository) RestoreList(todoList *models.TodoList) error {
+	err := r.DB.Unscoped().Model(&models.Task{}).
+		Where("list_id = ? AND deleted_at = ?", todoList.ID, todoList.DeletedAt.Time).
+		Update("deleted_at", nil).Error
+	if err != nil {
+		return err
+	}
+	return r.DB.Unscoped().Model(&models.TodoList{}).
+		Where("id = ?", todoList.ID).
+		Update("deleted_at", nil).Error
+}
+
+func (r *trashRepository) RestoreTask(task *models.Task) error {
+	return r.DB.Unscoped().Model(&models.Task{}).
+		Where("id = ?", task.ID).
+		Update("deleted_at", nil).Error
+}
+
+// PurgeList permanently deletes the list with every task it ever had
+func (r *trashRepository) PurgeList(todoList *models.TodoList) error {
+	if err := r.DB.Unscoped().Where("list_id = ?", todoList.ID).Delete(&models.Task{}).Error; err != nil {
+		return err
+	}
+	return r.DB.Unscoped().Delete(&models.TodoList{}, todoList.ID).Error
+}
+
+func (r *trashRepository) PurgeTask(task *models.Task) error {
+	return r.DB.Unscoped().Delete(&models.Task{}, task.ID).Error
+}
+
+// PurgeAll empties the user's trash
+func (r *trashRepository) PurgeAll(userID int) error {
+	deletedLists := r.DB.Unscoped().Model(&models.TodoList{}).
+		Select("id").
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID)
+	ownedLists := r.DB.Unscoped().Model(&models.TodoList{}).
+		Select("id").
+		Where("user_id = ?", userID)
+
+	err := r.DB.Unscoped().
+		Where("list_id IN (?) AND (deleted_at IS NOT NULL OR list_id IN (?))", ownedLists, deletedLists).
+		Delete(&models.Task{}).Error
+	if err != nil {
+		return err
+	}
+	return r.DB.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Delete(&models.TodoList{}).Error
+}
+
+// PurgeDeletedBefore permanently deletes everything trashed before the given time
+func (r *trashRepository) PurgeDeletedBefore(before time.Time) (int64, error) {
+	expiredLists := r.DB.Unscoped().Model(&models.TodoList{}).
+		Select("id").
+		Where("deleted_at < ?", before)
+
+	tasks := r.DB.Unscoped().
+		Where("deleted_at < ? OR list_id IN (?)", before, expiredLists).
+		Delete(&models.Task{})
+	if tasks.Error != nil {
+		return 0, tasks.Error
+	}
+	lists := r.DB.Unscoped().
+		Where("deleted_at < ?", before).
+		Delete(&models.TodoList{})
+	return tasks.RowsAffected + lists.RowsAffected, lists.Error
+}
+
+func (r *trashRepository) WithTx(tx *gorm.DB) TrashRepository {
+	return &trashRepository{DB: tx}
+}
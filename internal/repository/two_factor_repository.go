@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"RestAPI/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+type TwoFactorRepository interface {
+	// SaveTOTP stores the secrets and enabled time of the user
+	SaveTOTP(user *models.User) error
+	// AcceptCounter moves the user's last used time step forward; 0 rows
+	// means the code was already used
+	AcceptCounter(userID int, counter int64) (int64, error)
+	// RecordFailure counts a failed attempt and locks the second factor
+	// until lockUntil once there are maxFailures of them in a row
+	RecordFailure(userID, maxFailures int, lockUntil time.Time) error
+	ResetFailures(userID int) error
+
+	// ReplaceRecoveryCodes deletes the user's recovery codes and stores new ones
+	ReplaceRecoveryCodes(userID int, hashes []string) error
+	// UseRecoveryCode marks the unused code with the hash as used; 0 rows when there is none
+	UseRecoveryCode(userID int, codeHash string, at time.Time) (int64, error)
+	CountRecoveryCodes(userID int) (int64, error)
+
+	CreateDevice(device *models.TrustedDevice) error
+	// GetDevice returns the unexpired device with the token hash; nil when there is none
+	GetDevice(userID int, tokenHash string, now time.Time) (*models.TrustedDevice, error)
+	// GetDevices returns the unexpired devices of the user, last used first
+	GetDevices(userID int, now time.Time) ([]models.TrustedDevice, error)
+	TouchDevice(deviceID int, at time.Time) error
+	DeleteDevice(userID, deviceID int) (int64, error)
+	// DeleteExpiredDevices deletes devices that expired before the time
+	DeleteExpiredDevices(before time.Time) (int64, error)
+
+	// DeleteForUser deletes the recovery codes and trusted devices of the user
+	DeleteForUser(userID int) error
+	WithTx(tx *gorm.DB) TwoFactorRepository
+}
+
+type twoFactorRepository struct {
+	DB *gorm.DB
+}
+
+func NewTwoFactorRepository(db *gorm.DB) TwoFactorRepository {
+	return &twoFactorRepository{DB: db}
+}
+
+func (r *twoFactorRepository) WithTx(tx *gorm.DB) TwoFactorRepository {
+	return &twoFactorRepository{DB: tx}
+}
+
+func (r *twoFactorRepository) SaveTOTP(user *models.User) error {
+	return r.DB.Model(user).
+		Select("totp_secret", "totp_pending_secret", "totp_last_counter", "two_factor_enabled_at", "mfa_failures", "mfa_locked_until").
+		Updates(user).Error
+}
+
+func (r *twoFactorRepository) AcceptCounter(userID int, counter int64) (int64, error) {
+	res := r.DB.Model(&models.User{}).Where("id = ? AND totp_last_counter < ?", userID, counter).Update("totp_last_counter", counter)
+	return res.RowsAffected, res.Error
+}
+
+func (r *twoFactorRepository) RecordFailure(userID, maxFailures int, lockUntil time.Time) error {
+	return r.DB.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"mfa_failures":     gorm.Expr("mfa_failures + 1"),
+		"mfa_locked_until": gorm.Expr("CASE WHEN mfa_failures + 1 >= ? THEN ?::timestamptz ELSE mfa_locked_until END", maxFailures, lockUntil),
+	}).Error
+}
+
+func (r *twoFactorRepository) ResetFailures(userID int) error {
+	return r.DB.Model(&models.User{}).Where("id = ? AND (mfa_failures > 0 OR mfa_locked_until IS NOT NULL)", userID).
+		Updates(map[string]interface{}{"mfa_failures": 0, "mfa_locked_until": nil}).Error
+}
+
+func (r *twoFactorRepository) ReplaceRecoveryCodes(userID int, hashes []string) error {
+	if err := r.DB.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := make([]models.RecoveryCode, len(hashes))
+	for i, hash := range hashes {
+		codes[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+	return r.DB.Create(&codes).Error
+}
+
+func (r *twoFactorRepository) UseRecoveryCode(userID int, codeHash string, at time.Time) (int64, error) {
+	// Одно обновление, чтобы код нельзя было использовать дважды параллельными запросами
+	res := r.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", at)
+	return res.RowsAffected, res.Error
+}
+
+func (r *twoFactorRepository) CountRecoveryCodes(userID int) (int64, error) {
+	var count int64
+	err := r.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *twoFactorRepository) CreateDevice(device *models.TrustedDevice) error {
+	return r.DB.Create(device).Error
+}
+
+func (r *twoFactorRepository) GetDevice(userID int, tokenHash string, now time.Time) (*models.TrustedDevice, error) {
+	var devices []models.TrustedDevice
+	err := r.DB.Where("user_id = ? AND token_hash = ? AND expires_at > ?", userID, tokenHash, now).Limit(1).Find(&devices).Error
+	if err != nil || len(devices) == 0 {
+		return nil, err
+	}
+	return &devices[0], nil
+}
+
+func (r *twoFactorRepository) GetDevices(userID int, now time.Time) ([]models.TrustedDevice, error) {
+	var devices []models.TrustedDevice
+	err := r.DB.Where("user_id = ? AND expires_at > ?", userID, now).Order("last_used_at DESC, id DESC").Find(&devices).Error
+	return devices, err
+}
+
+func (r *twoFactorRepository) TouchDevice(deviceID int, at time.Time) error {
+	return r.DB.Model(&models.TrustedDevice{}).Where("id = ?", deviceID).Update("last_used_at", at).Error
+}
+
+func (r *twoFactorRepository) DeleteDevice(userID, deviceID int) (int64, error) {
+	res := r.DB.Where("id = ? AND user_id = ?", deviceID, userID).Delete(&models.TrustedDevice{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *twoFactorRepository) DeleteExpiredDevices(before time.Time) (int64, error) {
+	res := r.DB.Where("expires_at < ?", before).Delete(&models.TrustedDevice{})
+	return res.RowsAffected, res.Error
+}
+
+func (r *twoFactorRepository) DeleteForUser(userID int) error {
+	if err := r.DB.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	return r.DB.Where("user_id = ?", userID).Delete(&models.TrustedDevice{}).Error
+}
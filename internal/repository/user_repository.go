@@ -8,7 +8,14 @@ import (
 
 type UserRepository interface {
 	FindByUsername(username string) (*models.User, error)
+	FindByID(id int) (*models.User, error)
+	FindByUsernames(usernames []string) ([]models.User, error)
 	CreateUser(user *models.User) error
+	// FindByEmail returns nil when no user has the email
+	FindByEmail(email string) (*models.User, error)
+	// UpdateProfile saves the profile fields, username and avatar of the user
+	UpdateProfile(user *models.User) error
+	WithTx(tx *gorm.DB) UserRepository
 }
 
 type userRepository struct {
@@ -19,6 +26,10 @@ func NewUserRepository(db *gorm.DB) UserRepository {
 	return &userRepository{db: db}
 }
 
+func (r *userRepository) WithTx(tx *gorm.DB) UserRepository {
+	return &userRepository{db: tx}
+}
+
 func (r *userRepository) FindByUsername(username string) (*models.User, error) {
 	var user models.User
 	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
@@ -33,3 +44,40 @@ func (r *userRepository) FindByUsername(username string) (*models.User, error) {
 func (r *userRepository) CreateUser(user *models.User) error {
 	return r.db.Create(user).Error
 }
+
+func (r *userRepository) FindByID(id int) (*models.User, error) {
+	var user models.User
+	if err := r.db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) FindByUsernames(usernames []string) ([]models.User, error) {
+	var users []models.User
+	if len(usernames) == 0 {
+		return users, nil
+	}
+	err := r.db.Where("username IN ?", usernames).Find(&users).Error
+	return users, err
+}
+
+func (r *userRepository) FindByEmail(email string) (*models.User, error) {
+	var users []models.User
+	if err := r.db.Where("email = ?", email).Limit(1).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return &users[0], nil
+}
+
+func (r *userRepository) UpdateProfile(user *models.User) error {
+	return r.db.Model(user).
+		Select("username", "display_name", "email", "email_verified_at", "timezone", "locale", "preferences", "avatar_key").
+		Updates(user).Error
+}
@@ -0,0 +1,89 @@
+package responses
+
+import (
+	"RestAPI/internal/apperrors"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 error response
+// swagger:model
+type Problem struct {
+	Type     string                 `json:"type"`
+	Title    string                 `json:"title"`
+	Status   int                    `json:"status"`
+	Detail   string                 `json:"detail,omitempty"`
+	Instance string                 `json:"instance,omitempty"`
+	Code     string                 `json:"code"`
+	Errors   []apperrors.FieldError `json:"errors,omitempty"`
+}
+
+// HTTPErrorHandler renders every error returned from handlers and middleware
+// as application/problem+json. Causes of internal errors are only logged.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	appErr := toAppError(err)
+	if appErr.Status >= http.StatusInternalServerError {
+		log.Printf("%s %s: %v", c.Request().Method, c.Request().URL.Path, err)
+	}
+
+	problem := Problem{
+		Type:     "/problems/" + strings.ReplaceAll(appErr.Code, "_", "-"),
+		Title:    http.StatusText(appErr.Status),
+		Status:   appErr.Status,
+		Detail:   appErr.Message,
+		Instance: c.Request().URL.Path,
+		Code:     appErr.Code,
+		Errors:   appErr.Fields,
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, ProblemContentType)
+	c.Response().WriteHeader(problem.Status)
+	if c.Request().Method == http.MethodHead {
+		return
+	}
+	if err := c.Echo().JSONSerializer.Serialize(c, problem, ""); err != nil {
+		log.Printf("failed to write problem response: %v", err)
+	}
+}
+
+func toAppError(err error) *apperrors.Error {
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return fromHTTPError(httpErr)
+	}
+	return apperrors.From(err)
+}
+
+// fromHTTPError maps errors produced by Echo itself (unknown routes,
+// wrong methods, malformed bodies) onto the same set of codes.
+func fromHTTPError(httpErr *echo.HTTPError) *apperrors.Error {
+	switch httpErr.Code {
+	case http.StatusNotFound:
+		return apperrors.ErrNotFound.Wrap(httpErr)
+	case http.StatusMethodNotAllowed:
+		return apperrors.New(http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	case http.StatusBadRequest:
+		return apperrors.ErrBadRequest.Wrap(httpErr)
+	case http.StatusUnauthorized:
+		return apperrors.ErrUnauthorized.Wrap(httpErr)
+	case http.StatusForbidden:
+		return apperrors.ErrForbidden.Wrap(httpErr)
+	case http.StatusRequestEntityTooLarge:
+		return apperrors.New(http.StatusRequestEntityTooLarge, "payload_too_large", "Request body is too large")
+	case http.StatusUnsupportedMediaType:
+		return apperrors.New(http.StatusUnsupportedMediaType, "unsupported_media_type", "Unsupported media type")
+	}
+	if httpErr.Code >= http.StatusInternalServerError {
+		return apperrors.ErrInternal.Wrap(httpErr)
+	}
+	return apperrors.New(httpErr.Code, "http_"+strings.ReplaceAll(strings.ToLower(http.StatusText(httpErr.Code)), " ", "_"), http.StatusText(httpErr.Code))
+}
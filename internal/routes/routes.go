@@ -2,13 +2,18 @@ package routes
 
 import (
 	_ "RestAPI/docs" // Импорт сгенерированной документации
+	"RestAPI/internal/database"
 	handlers "RestAPI/internal/handlers"
 	repository "RestAPI/internal/repository"
 	service "RestAPI/internal/service"
 	"RestAPI/pkg/middleware"
+	"RestAPI/pkg/oidc"
 	"github.com/labstack/echo/v4"
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"strings"
 	"time"
 )
 
@@ -41,6 +46,33 @@ import (
 // @tag.name Tasks
 // @tag.description Operations with tasks inside todo lists (create, read, update, delete)
 
+// @tag.name Trash
+// @tag.description Restoring and permanently deleting removed lists and tasks
+
+// @tag.name Audit
+// @tag.description Log of every change to lists and tasks
+
+// @tag.name Comments
+// @tag.description Discussion on tasks and the mentions inbox
+
+// @tag.name Attachments
+// @tag.description Files attached to tasks
+
+// @tag.name Time
+// @tag.description Timers, time entries and time reports
+
+// @tag.name Calendar
+// @tag.description iCalendar feeds of tasks with due dates and .ics import
+
+// @tag.name Export
+// @tag.description Account export and import in JSON, CSV and Markdown
+
+// @tag.name Imports
+// @tag.description Background imports from Todoist, Trello and Microsoft To Do
+
+// @tag.name Account
+// @tag.description Export of all account data and account deletion
+
 const (
 	secretKey   = "triss-merigold"
 	tokenExpiry = time.Hour * 24
@@ -57,38 +89,326 @@ func SetupRoutes(e *echo.Echo, db *gorm.DB) *echo.Echo {
 	todoListRepo := repository.NewTodoListRepository(db)
 	taskRepo := repository.NewTaskRepository(db)
 	userRepo := repository.NewUserRepository(db)
+	trashRepo := repository.NewTrashRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	historyRepo := repository.NewHistoryRepository(db)
+	commentRepo := repository.NewCommentRepository(db)
+	attachmentRepo := repository.NewAttachmentRepository(db)
+	statusRepo := repository.NewStatusRepository(db)
+	dependencyRepo := repository.NewDependencyRepository(db)
+	timeEntryRepo := repository.NewTimeEntryRepository(db)
+	calendarRepo := repository.NewCalendarRepository(db)
+	exportRepo := repository.NewExportRepository(db)
+	importJobRepo := repository.NewImportJobRepository(db)
+	accountRepo := repository.NewAccountRepository(db)
+	verificationRepo := repository.NewEmailVerificationRepository(db)
+	twoFactorRepo := repository.NewTwoFactorRepository(db)
+	identityRepo := repository.NewIdentityRepository(db)
+	oauthRepo := repository.NewOAuthRepository(db)
+	transactor := repository.NewTransactor(db)
+	auditor := service.NewAuditor(auditRepo, historyRepo)
 
 	// Инициализация сервисов
-	todoListService := service.NewTodoListService(todoListRepo)
-	taskService := service.NewTaskService(taskRepo)
-	userService := service.NewUserService(userRepo, secretKey, tokenExpiry)
+	todoListService := service.NewTodoListService(todoListRepo, statusRepo, dependencyRepo, auditor, transactor)
+	taskService := service.NewTaskService(taskRepo, todoListRepo, statusRepo, dependencyRepo, auditor, transactor)
+	verificationConfig := database.AppConfig.EmailVerification
+	verificationSettings := service.VerificationSettings{
+		Policy:         verificationConfig.Policy,
+		URL:            verificationConfig.URL,
+		TokenTTL:       verificationConfig.TokenTTL,
+		ResendInterval: verificationConfig.ResendInterval,
+		DailyLimit:     verificationConfig.DailyLimit,
+	}
+	verificationService := service.NewEmailVerificationService(verificationRepo, userRepo, database.Mailer, verificationSettings, auditor, transactor)
+	twoFactorConfig := database.AppConfig.TwoFactor
+	twoFactorSettings := service.TwoFactorSettings{
+		Issuer:         twoFactorConfig.Issuer,
+		ChallengeTTL:   twoFactorConfig.ChallengeTTL,
+		RememberDevice: twoFactorConfig.RememberDevice,
+	}
+	twoFactorService := service.NewTwoFactorService(twoFactorRepo, userRepo, twoFactorSettings, auditor, transactor)
+	userService := service.NewUserService(userRepo, verificationService, twoFactorService, verificationConfig.Policy,
+		secretKey, tokenExpiry, twoFactorConfig.ChallengeTTL)
+	oidcConfig := database.AppConfig.OIDC
+	oidcSettings := service.OIDCSettings{StateTTL: oidcConfig.StateTTL}
+	// Discovery у провайдера выполняется при первом входе, недоступный провайдер не мешает запуску
+	oidcClient := &http.Client{Timeout: 10 * time.Second}
+	for _, p := range oidcConfig.Providers {
+		client, err := oidc.NewProvider(oidc.Config{
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  strings.ReplaceAll(oidcConfig.RedirectURL, "{provider}", p.Name),
+			Scopes:       p.Scopes,
+			Client:       oidcClient,
+		})
+		if err != nil {
+			log.Fatalf("Провайдер OIDC %q: %v", p.Name, err)
+		}
+		oidcSettings.Providers = append(oidcSettings.Providers, service.OIDCProvider{
+			Name:           p.Name,
+			DisplayName:    p.DisplayName,
+			Client:         client,
+			AutoProvision:  p.AutoProvision,
+			LinkByEmail:    p.LinkByEmail,
+			AllowedDomains: p.AllowedDomains,
+		})
+	}
+	oidcService := service.NewOIDCService(identityRepo, userRepo, oidcSettings, auditor, transactor)
+	oauthSettings := service.OAuthSettings{
+		ConsentURL:      database.AppConfig.OAuth.ConsentURL,
+		CodeTTL:         database.AppConfig.OAuth.CodeTTL,
+		AccessTokenTTL:  database.AppConfig.OAuth.AccessTokenTTL,
+		RefreshTokenTTL: database.AppConfig.OAuth.RefreshTokenTTL,
+	}
+	oauthService := service.NewOAuthService(oauthRepo, userRepo, oauthSettings, auditor, transactor)
+	trashService := service.NewTrashService(trashRepo, auditor, transactor)
+	auditService := service.NewAuditService(auditRepo, transactor)
+	historyService := service.NewHistoryService(historyRepo, taskRepo, todoListRepo, trashRepo, statusRepo, dependencyRepo, auditor, transactor)
+	commentService := service.NewCommentService(commentRepo, taskRepo, userRepo, auditor, transactor)
+	workflowService := service.NewWorkflowService(statusRepo, todoListRepo, dependencyRepo, auditor, transactor)
+	assignmentService := service.NewAssignmentService(taskRepo, todoListRepo, userRepo, dependencyRepo, auditor, transactor)
+	timeService := service.NewTimeService(timeEntryRepo, taskRepo, todoListRepo, auditor, transactor)
+	calendarService := service.NewCalendarService(calendarRepo, taskRepo, todoListRepo, statusRepo, auditor, transactor)
+	exportService := service.NewExportService(exportRepo, todoListRepo, taskRepo, statusRepo, dependencyRepo, commentRepo, timeEntryRepo, auditor, transactor)
+	importJobService := service.NewImportJobService(importJobRepo, exportService)
+	accountSettings := service.AccountSettings{
+		DeletionGrace:   database.AppConfig.Accounts.DeletionGrace,
+		ExportRetention: database.AppConfig.Accounts.ExportRetention,
+	}
+	accountService := service.NewAccountService(accountRepo, userRepo, trashRepo, auditRepo, exportService, database.Storage,
+		accountSettings, auditor, transactor)
+	attachmentLimits := service.AttachmentLimits{
+		MaxSize:      database.AppConfig.Attachments.MaxSize,
+		AllowedTypes: database.AppConfig.Attachments.AllowedTypes,
+	}
+	profileService := service.NewProfileService(userRepo, todoListRepo, verificationService, database.Storage, auditor, transactor)
+	attachmentService := service.NewAttachmentService(attachmentRepo, taskRepo, database.Storage, attachmentLimits, auditor, transactor)
 
 	// Инициализация обработчиков
 	todoListHandler := handlers.NewTodoListHandler(todoListService)
-	taskHandler := handlers.NewTaskHandler(taskService, todoListService)
+	taskHandler := handlers.NewTaskHandler(taskService, todoListService, profileService)
 	authHandler := handlers.NewAuthHandler(userService)
+	trashHandler := handlers.NewTrashHandler(trashService, database.AppConfig.Trash.Retention)
+	auditHandler := handlers.NewAuditHandler(auditService)
+	historyHandler := handlers.NewHistoryHandler(historyService)
+	commentHandler := handlers.NewCommentHandler(commentService)
+	attachmentHandler := handlers.NewAttachmentHandler(attachmentService)
+	workflowHandler := handlers.NewWorkflowHandler(workflowService)
+	assignmentHandler := handlers.NewAssignmentHandler(assignmentService, profileService)
+	timeHandler := handlers.NewTimeHandler(timeService, profileService)
+	calendarHandler := handlers.NewCalendarHandler(calendarService, profileService)
+	exportHandler := handlers.NewExportHandler(exportService)
+	importJobHandler := handlers.NewImportJobHandler(importJobService)
+	accountHandler := handlers.NewAccountHandler(accountService, userService)
+	profileHandler := handlers.NewProfileHandler(profileService)
+	verificationHandler := handlers.NewVerificationHandler(verificationService)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorService)
+	oidcHandler := handlers.NewOIDCHandler(oidcService, userService, accountService)
+	oauthHandler := handlers.NewOAuthHandler(oauthService)
 
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 	// Public routes (no authentication required)
 	// Группа: Authentication
 	e.POST("/register", authHandler.Register)
 	e.POST("/login", authHandler.Login)
+	e.POST("/login/mfa", authHandler.LoginMFA)
+	e.GET("/auth/oidc/providers", oidcHandler.GetProvidersHandler)
+	e.GET("/auth/oidc/:provider/login", oidcHandler.LoginHandler)
+	// Привязка к вошедшему пользователю определяется по state, а не по токену
+	e.GET("/auth/oidc/:provider/callback", oidcHandler.CallbackHandler)
+	e.GET("/verify-email", verificationHandler.VerifyEmailHandler)
+	e.POST("/verify-email", verificationHandler.VerifyEmailHandler)
+	e.POST("/verify-email/resend", verificationHandler.ResendVerificationHandler)
+	// OAuth2 для сторонних приложений; токен, интроспекция и отзыв авторизуют клиента
+	e.GET("/oauth/scopes", oauthHandler.GetScopesHandler)
+	e.GET("/oauth/authorize", oauthHandler.AuthorizeHandler)
+	e.POST("/oauth/token", oauthHandler.TokenHandler)
+	e.POST("/oauth/introspect", oauthHandler.IntrospectHandler)
+	e.POST("/oauth/revoke", oauthHandler.RevokeHandler)
+	// Токены удаляемого аккаунта не работают, восстановление по логину и паролю
+	e.POST("/me/restore", accountHandler.RestoreAccountHandler)
+	// Календарь авторизуется секретным токеном в URL
+	e.GET("/calendar/:file", calendarHandler.GetFeedHandler)
 
 	// Protected routes (JWT authentication required)
 	protected := e.Group("")
-	protected.Use(middleware.JWTMiddleware(secretKey))
+	protected.Use(middleware.JWTMiddleware(secretKey, func(token string) (*middleware.AccessToken, error) {
+		access, err := oauthService.Authenticate(token)
+		if err != nil || access == nil {
+			return nil, err
+		}
+		return &middleware.AccessToken{UserID: access.UserID, ClientID: access.ClientID, Scopes: access.Scopes}, nil
+	}))
+	protected.Use(middleware.RequireActive(userService.IsActive))
+	protected.Use(middleware.RequireScope(oauthScopes))
+	// Без подтверждённого email доступны только профиль и повторная отправка письма
+	switch verificationConfig.Policy {
+	case service.VerificationPolicyNone:
+	case service.VerificationPolicyRestrict, service.VerificationPolicyBlock:
+		readOnly := verificationConfig.Policy == service.VerificationPolicyRestrict
+		protected.Use(middleware.RequireVerified(verificationService.IsVerified, readOnly, "/me", "/me/avatar", "/me/email/verification"))
+	default:
+		log.Fatalf("Неизвестная политика подтверждения email %q, ожидается none, restrict или block", verificationConfig.Policy)
+	}
 
 	// Группа: TodoLists
 	protected.GET("/todolists", todoListHandler.GetTodoListHandler)
 	protected.POST("/todolists", todoListHandler.PostTodoListHandler)
+	protected.GET("/todolists/:id", todoListHandler.GetTodoListByIDHandler)
+	protected.PUT("/todolists/:id", todoListHandler.PutTodoListHandler)
 	protected.PATCH("/todolists/:id", todoListHandler.PatchTodoListHandler)
 	protected.DELETE("/todolists/:id", todoListHandler.DeleteTodoListHandler)
+	protected.POST("/todolists/:id/duplicate", todoListHandler.DuplicateTodoListHandler)
+	protected.POST("/todolists/:id/reorder", todoListHandler.ReorderTodoListHandler)
+	protected.GET("/todolists/:id/statuses", workflowHandler.GetStatusesHandler)
+	protected.PUT("/todolists/:id/statuses", workflowHandler.PutStatusesHandler)
+	protected.GET("/todolists/:id/board", workflowHandler.GetBoardHandler)
 
 	// Группа: Tasks
 	protected.GET("/todolists/:list_id/tasks", taskHandler.GetTasksByListHandler)
 	protected.POST("/todolists/:list_id/tasks", taskHandler.PostTaskHandler)
+	protected.POST("/todolists/:list_id/tasks\\:batch", taskHandler.BatchTasksHandler)
+	protected.POST("/todolists/:list_id/tasks\\:complete-all", taskHandler.CompleteAllTasksHandler)
+	protected.POST("/todolists/:list_id/tasks\\:clear-completed", taskHandler.ClearCompletedTasksHandler)
+	protected.GET("/todolists/:list_id/tasks\\:topological-order", taskHandler.TopologicalOrderHandler)
+	protected.GET("/todolists/:list_id/tasks/:id", taskHandler.GetTaskHandler)
+	protected.PUT("/todolists/:list_id/tasks/:id", taskHandler.PutTaskHandler)
 	protected.PATCH("/todolists/:list_id/tasks/:id", taskHandler.PatchTaskHandler)
 	protected.DELETE("/todolists/:list_id/tasks/:id", taskHandler.DeleteTaskHandler)
+	protected.POST("/todolists/:list_id/tasks/:id/reorder", taskHandler.ReorderTaskHandler)
+	protected.GET("/todolists/:list_id/tasks/:id/history", historyHandler.GetTaskHistoryHandler)
+	protected.POST("/todolists/:list_id/tasks/:id/history/:version/revert", historyHandler.RevertTaskHandler)
+	protected.GET("/todolists/:list_id/tasks/:id/dependencies", taskHandler.GetDependenciesHandler)
+	protected.POST("/todolists/:list_id/tasks/:id/dependencies", taskHandler.AddDependencyHandler)
+	protected.DELETE("/todolists/:list_id/tasks/:id/dependencies/:blocker_id", taskHandler.RemoveDependencyHandler)
+	protected.POST("/undo", historyHandler.UndoHandler)
+	protected.POST("/tasks/:id/move", taskHandler.MoveTaskHandler)
+	protected.POST("/tasks/:id/copy", taskHandler.CopyTaskHandler)
+	protected.POST("/todolists/:list_id/tasks/:id/assignees", assignmentHandler.AssignTaskHandler)
+	protected.DELETE("/todolists/:list_id/tasks/:id/assignees/:user_id", assignmentHandler.UnassignTaskHandler)
+	protected.GET("/me/tasks", assignmentHandler.GetMyTasksHandler)
+
+	// Группа: Comments
+	protected.GET("/todolists/:list_id/tasks/:id/comments", commentHandler.GetCommentsHandler)
+	protected.POST("/todolists/:list_id/tasks/:id/comments", commentHandler.PostCommentHandler)
+	protected.GET("/todolists/:list_id/tasks/:id/comments/:comment_id", commentHandler.GetCommentHandler)
+	protected.PATCH("/todolists/:list_id/tasks/:id/comments/:comment_id", commentHandler.PatchCommentHandler)
+	protected.DELETE("/todolists/:list_id/tasks/:id/comments/:comment_id", commentHandler.DeleteCommentHandler)
+	protected.GET("/todolists/:list_id/tasks/:id/comments/:comment_id/history", commentHandler.GetCommentHistoryHandler)
+	protected.GET("/me/mentions", commentHandler.GetMentionsHandler)
+	protected.POST("/me/mentions/:id/read", commentHandler.ReadMentionHandler)
+
+	// Группа: Attachments
+	protected.GET("/todolists/:list_id/tasks/:id/attachments", attachmentHandler.GetAttachmentsHandler)
+	protected.POST("/todolists/:list_id/tasks/:id/attachments", attachmentHandler.PostAttachmentHandler)
+	protected.GET("/todolists/:list_id/tasks/:id/attachments/:attachment_id", attachmentHandler.DownloadAttachmentHandler)
+	protected.DELETE("/todolists/:list_id/tasks/:id/attachments/:attachment_id", attachmentHandler.DeleteAttachmentHandler)
+
+	// Группа: Time
+	protected.POST("/todolists/:list_id/tasks/:id/timer/start", timeHandler.StartTimerHandler)
+	protected.POST("/todolists/:list_id/tasks/:id/timer/stop", timeHandler.StopTaskTimerHandler)
+	protected.GET("/todolists/:list_id/tasks/:id/time-entries", timeHandler.GetTimeEntriesHandler)
+	protected.POST("/todolists/:list_id/tasks/:id/time-entries", timeHandler.PostTimeEntryHandler)
+	protected.DELETE("/todolists/:list_id/tasks/:id/time-entries/:entry_id", timeHandler.DeleteTimeEntryHandler)
+	protected.GET("/todolists/:id/time", timeHandler.GetListTimeHandler)
+	protected.GET("/me/timer", timeHandler.GetTimerHandler)
+	protected.POST("/me/timer/stop", timeHandler.StopTimerHandler)
+	protected.GET("/reports/time", timeHandler.GetTimeReportHandler)
+
+	// Группа: Calendar
+	protected.GET("/me/calendar", calendarHandler.GetMyFeedHandler)
+	protected.POST("/me/calendar/regenerate", calendarHandler.RegenerateMyFeedHandler)
+	protected.DELETE("/me/calendar", calendarHandler.DeleteMyFeedHandler)
+	protected.GET("/todolists/:id/calendar", calendarHandler.GetListFeedHandler)
+	protected.POST("/todolists/:id/calendar/regenerate", calendarHandler.RegenerateListFeedHandler)
+	protected.DELETE("/todolists/:id/calendar", calendarHandler.DeleteListFeedHandler)
+	protected.POST("/todolists/:id/calendar/import", calendarHandler.ImportCalendarHandler)
+
+	// Группа: Export
+	protected.GET("/export", exportHandler.ExportHandler)
+	protected.POST("/import", exportHandler.ImportHandler)
+
+	// Группа: Account
+	protected.GET("/me", profileHandler.GetProfileHandler)
+	protected.PATCH("/me", profileHandler.PatchProfileHandler)
+	protected.PUT("/me/avatar", profileHandler.PutAvatarHandler)
+	protected.GET("/me/avatar", profileHandler.GetAvatarHandler)
+	protected.DELETE("/me/avatar", profileHandler.DeleteAvatarHandler)
+	protected.POST("/me/email/verification", verificationHandler.SendMyVerificationHandler)
+	protected.GET("/me/2fa", twoFactorHandler.GetTwoFactorHandler)
+	protected.POST("/me/2fa/totp", twoFactorHandler.EnrollTOTPHandler)
+	protected.GET("/me/2fa/totp/qr.png", twoFactorHandler.GetTOTPQRCodeHandler)
+	protected.POST("/me/2fa/totp/confirm", twoFactorHandler.ConfirmTOTPHandler)
+	protected.DELETE("/me/2fa/totp", twoFactorHandler.DisableTwoFactorHandler)
+	protected.POST("/me/2fa/recovery-codes", twoFactorHandler.RegenerateRecoveryCodesHandler)
+	protected.GET("/me/2fa/devices", twoFactorHandler.GetTrustedDevicesHandler)
+	protected.DELETE("/me/2fa/devices/:id", twoFactorHandler.DeleteTrustedDeviceHandler)
+	protected.GET("/me/identities", oidcHandler.GetIdentitiesHandler)
+	protected.POST("/me/identities/:provider", oidcHandler.LinkIdentityHandler)
+	protected.DELETE("/me/identities/:id", oidcHandler.UnlinkIdentityHandler)
+	protected.GET("/me/export", accountHandler.ExportAccountHandler)
+	protected.GET("/me/exports/:id", accountHandler.GetAccountExportHandler)
+	protected.GET("/me/exports/:id/download", accountHandler.DownloadAccountExportHandler)
+	protected.DELETE("/me", accountHandler.DeleteAccountHandler)
+
+	protected.GET("/me/authorizations", oauthHandler.GetAuthorizationsHandler)
+	protected.DELETE("/me/authorizations/:client_id", oauthHandler.RevokeAuthorizationHandler)
+
+	// Группа: OAuth
+	protected.GET("/oauth/consent", oauthHandler.GetConsentHandler)
+	protected.POST("/oauth/consent", oauthHandler.PostConsentHandler)
+	protected.POST("/oauth/clients", oauthHandler.PostClientHandler)
+	protected.GET("/oauth/clients", oauthHandler.GetClientsHandler)
+	protected.GET("/oauth/clients/:id", oauthHandler.GetClientHandler)
+	protected.POST("/oauth/clients/:id/secret", oauthHandler.RotateClientSecretHandler)
+	protected.DELETE("/oauth/clients/:id", oauthHandler.DeleteClientHandler)
+
+	// Группа: Imports
+	protected.POST("/imports", importJobHandler.CreateImportJobHandler)
+	protected.GET("/imports", importJobHandler.GetImportJobsHandler)
+	protected.GET("/imports/:id", importJobHandler.GetImportJobHandler)
+	protected.DELETE("/imports/:id", importJobHandler.DeleteImportJobHandler)
+
+	// Группа: Trash
+	protected.GET("/trash", trashHandler.GetTrashHandler)
+	protected.DELETE("/trash", trashHandler.EmptyTrashHandler)
+	protected.POST("/trash/:type/:id/restore", trashHandler.RestoreHandler)
+	protected.DELETE("/trash/:type/:id", trashHandler.PurgeHandler)
+
+	// Группа: Audit
+	protected.GET("/audit", auditHandler.GetAuditLogHandler)
+	protected.GET("/audit/export", auditHandler.ExportAuditLogHandler)
+
+	admin := protected.Group("/admin")
+	admin.Use(middleware.RequireAdmin(userService.IsAdmin))
+	admin.GET("/audit", auditHandler.AdminAuditLogHandler)
+	admin.GET("/audit/export", auditHandler.AdminExportAuditLogHandler)
 
 	return e
 }
+
+// oauthScopes maps a route onto the scopes of third-party apps that grant
+// it. Routes without scopes, such as account settings, are only open to the
+// user's own login sessions.
+func oauthScopes(method, path string) []string {
+	read := method == http.MethodGet || method == http.MethodHead
+	var resource string
+	switch {
+	// Ссылка на календарь - секрет, который нельзя отдавать приложениям
+	case strings.HasSuffix(path, "/calendar") || strings.Contains(path, "/calendar/"):
+		return nil
+	case strings.HasPrefix(path, "/todolists/:list_id/tasks"), strings.HasPrefix(path, "/tasks/"), path == "/me/tasks":
+		resource = "tasks"
+	case path == "/todolists", strings.HasPrefix(path, "/todolists/:id"):
+		resource = "lists"
+	case path == "/me" && read:
+		return []string{service.ScopeProfile}
+	default:
+		return nil
+	}
+	if read {
+		return []string{resource + ":read", resource + ":write"}
+	}
+	return []string{resource + ":write"}
+}
@@ -0,0 +1,507 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/blobstore"
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	// A running account export that has not finished after this long is
+	// started again by another worker
+	accountExportStale = 30 * time.Minute
+	// How many accounts PurgeDeletedAccounts erases per call
+	accountPurgeBatch = 100
+	// Audit entries are written to the archive in batches of this size
+	accountAuditBatch = 1000
+)
+
+// ReauthWindow is how recently a user without a password must have signed
+// in to delete the account
+const ReauthWindow = 10 * time.Minute
+
+// AccountSettings are the retention periods of account data
+type AccountSettings struct {
+	// How long a deleted account can be restored
+	DeletionGrace time.Duration
+	// How long a built export can be downloaded
+	ExportRetention time.Duration
+}
+
+// AccountProfile is the account itself in an account export
+type AccountProfile struct {
+	ID                  int                    `json:"id"`
+	Username            string                 `json:"username"`
+	DisplayName         string                 `json:"display_name"`
+	Email               *string                `json:"email"`
+	EmailVerifiedAt     *time.Time             `json:"email_verified_at"`
+	Timezone            string                 `json:"timezone"`
+	Locale              string                 `json:"locale"`
+	Preferences         models.UserPreferences `json:"preferences"`
+	IsAdmin             bool                   `json:"is_admin"`
+	TwoFactorEnabledAt  *time.Time             `json:"two_factor_enabled_at"`
+	DeletionRequestedAt *time.Time             `json:"deletion_requested_at"`
+	DeleteAfter         *time.Time             `json:"delete_after"`
+}
+
+// AccountTrash is the content of the trash in an account export
+type AccountTrash struct {
+	Lists []models.TodoList `json:"lists"`
+	Tasks []models.Task     `json:"tasks"`
+}
+
+type AccountService interface {
+	// RequestExport queues an archive of everything stored about the user;
+	// an export already waiting or running is returned instead of a new one
+	RequestExport(userID int) (*models.AccountExport, error)
+	GetExport(exportID int, userID int) (*models.AccountExport, error)
+	// OpenExport opens the archive of a succeeded export
+	OpenExport(ctx context.Context, exportID int, userID int) (*models.AccountExport, blobstore.Object, error)
+	// RunNextExport builds the oldest waiting export; false when there was none
+	RunNextExport(ctx context.Context) (bool, error)
+	// PurgeExpiredExports deletes exports and their archives after the retention period
+	PurgeExpiredExports(ctx context.Context) (int64, error)
+	// DeleteAccount checks the password and schedules the account for
+	// deletion after the grace period. Until then the account cannot be
+	// used, but RestoreAccount brings it back. Accounts without a password,
+	// created through an identity provider, confirm with a session that
+	// signed in at authTime, within ReauthWindow.
+	DeleteAccount(userID int, password string, authTime time.Time) (*models.User, error)
+	RestoreAccount(username, password string) error
+	// RestoreSignedIn restores the account of a user who has just signed in
+	// with an identity provider to do so
+	RestoreSignedIn(userID int) error
+	// PurgeDeletedAccounts erases the accounts whose grace period is over
+	PurgeDeletedAccounts(ctx context.Context) (int64, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) AccountService
+}
+
+type accountService struct {
+	repo      repository.AccountRepository
+	userRepo  repository.UserRepository
+	trashRepo repository.TrashRepository
+	auditRepo repository.AuditRepository
+	exports   ExportService
+	store     blobstore.Store
+	settings  AccountSettings
+	tx        repository.Transactor
+	audit     Auditor
+}
+
+func NewAccountService(repo repository.AccountRepository, userRepo repository.UserRepository, trashRepo repository.TrashRepository,
+	auditRepo repository.AuditRepository, exports ExportService, store blobstore.Store, settings AccountSettings,
+	auditor Auditor, tx repository.Transactor) AccountService {
+	return &accountService{
+		repo:      repo,
+		userRepo:  userRepo,
+		trashRepo: trashRepo,
+		auditRepo: auditRepo,
+		exports:   exports,
+		store:     store,
+		settings:  settings,
+		tx:        tx,
+		audit:     auditor,
+	}
+}
+
+func (s *accountService) WithActor(actor Actor) AccountService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *accountService) RequestExport(userID int) (*models.AccountExport, error) {
+	active, err := s.repo.GetActiveExport(userID)
+	if err != nil || active != nil {
+		return active, err
+	}
+	export := &models.AccountExport{UserID: userID, Status: models.AccountExportPending}
+	if err := s.repo.CreateExport(export); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func (s *accountService) GetExport(exportID int, userID int) (*models.AccountExport, error) {
+	export, err := s.repo.GetExport(exportID, userID)
+	if err != nil {
+		return nil, notFound(err, apperrors.ErrAccountExportNotFound)
+	}
+	return export, nil
+}
+
+func (s *accountService) OpenExport(ctx context.Context, exportID int, userID int) (*models.AccountExport, blobstore.Object, error) {
+	export, err := s.GetExport(exportID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if export.Status != models.AccountExportSucceeded {
+		return nil, nil, apperrors.ErrAccountExportNotReady
+	}
+	archive, err := s.store.Open(ctx, export.Key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, nil, apperrors.ErrAccountExportNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("open account export %d: %w", export.ID, err)
+	}
+	return export, archive, nil
+}
+
+func (s *accountService) RunNextExport(ctx context.Context) (bool, error) {
+	export, err := s.repo.ClaimExport(time.Now().Add(-accountExportStale))
+	if err != nil || export == nil {
+		return false, err
+	}
+
+	buildErr := s.buildExport(ctx, export)
+	now := time.Now()
+	export.FinishedAt = &now
+	if buildErr != nil {
+		export.Status, export.Error = models.AccountExportFailed, "The export failed, request a new one"
+	} else {
+		expires := now.Add(s.settings.ExportRetention)
+		export.Status, export.ExpiresAt = models.AccountExportSucceeded, &expires
+	}
+	if err := s.repo.FinishExport(export); err != nil {
+		return true, err
+	}
+	if buildErr != nil {
+		return true, fmt.Errorf("account export %d: %w", export.ID, buildErr)
+	}
+	return true, nil
+}
+
+// buildExport writes the archive to a temporary file and stores it
+func (s *accountService) buildExport(ctx context.Context, export *models.AccountExport) error {
+	spool, err := os.CreateTemp("", "account-export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if err := s.writeArchive(ctx, spool, export.UserID); err != nil {
+		return err
+	}
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	key, err := newExportKey()
+	if err != nil {
+		return err
+	}
+	if err := s.store.Put(ctx, key, spool, size); err != nil {
+		return err
+	}
+	export.Key, export.Size = key, size
+	return nil
+}
+
+func newExportKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "account-export-" + hex.EncodeToString(b), nil
+}
+
+// writeArchive writes the zip: the profile and avatar, lists and tasks as
+// in GET /export, the trash, audit log, mentions, calendar feeds, import
+// jobs and the files of attachments
+func (s *accountService) writeArchive(ctx context.Context, w io.Writer, userID int) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %d not found", userID)
+	}
+	doc, err := s.exports.Export(userID)
+	if err != nil {
+		return err
+	}
+	now := doc.ExportedAt
+
+	zw := zip.NewWriter(w)
+	writeJSON := func(name string, v interface{}) error {
+		f, err := createFile(zw, name, now)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	profile := AccountProfile{
+		ID:                  user.ID,
+		Username:            user.Username,
+		DisplayName:         user.DisplayName,
+		Email:               user.Email,
+		EmailVerifiedAt:     user.EmailVerifiedAt,
+		Timezone:            user.Timezone,
+		Locale:              user.Locale,
+		Preferences:         user.Preferences,
+		IsAdmin:             user.IsAdmin,
+		TwoFactorEnabledAt:  user.TwoFactorEnabledAt,
+		DeletionRequestedAt: user.DeletionRequestedAt,
+		DeleteAfter:         user.DeleteAfter,
+	}
+	if err := writeJSON("account.json", profile); err != nil {
+		return err
+	}
+	if user.AvatarKey != "" {
+		if err := s.writeBlob(ctx, zw, user.AvatarKey, "avatar.png", now); err != nil {
+			return err
+		}
+	}
+	if err := writeJSONExport(zw, doc); err != nil {
+		return err
+	}
+
+	var trash AccountTrash
+	if trash.Lists, err = s.trashRepo.GetDeletedLists(userID); err != nil {
+		return err
+	}
+	if trash.Tasks, err = s.trashRepo.GetDeletedTasks(userID); err != nil {
+		return err
+	}
+	if err := writeJSON("trash.json", trash); err != nil {
+		return err
+	}
+	if err := s.writeAuditLog(zw, userID, now); err != nil {
+		return err
+	}
+
+	mentions, err := s.repo.GetMentions(userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("mentions.json", orEmpty(mentions)); err != nil {
+		return err
+	}
+	feeds, err := s.repo.GetCalendarFeeds(userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("calendar_feeds.json", orEmpty(feeds)); err != nil {
+		return err
+	}
+	jobs, err := s.repo.GetImportJobs(userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON("import_jobs.json", orEmpty(jobs)); err != nil {
+		return err
+	}
+
+	attachments, err := s.repo.GetAttachments(userID)
+	if err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		if err := s.writeAttachment(ctx, zw, a); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// writeAuditLog streams the entries about the user's data or made by them
+// as a JSON array
+func (s *accountService) writeAuditLog(zw *zip.Writer, userID int, now time.Time) error {
+	f, err := createFile(zw, "audit_log.json", now)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, "["); err != nil {
+		return err
+	}
+	first := true
+	err = s.auditRepo.EachEntry(AuditFilter{OwnerID: userID}, accountAuditBatch, func(entries []models.AuditEntry) error {
+		for _, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			sep := ",\n  "
+			if first {
+				sep, first = "\n  ", false
+			}
+			if _, err := io.WriteString(f, sep); err != nil {
+				return err
+			}
+			if _, err := f.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, "\n]\n")
+	return err
+}
+
+func (s *accountService) writeAttachment(ctx context.Context, zw *zip.Writer, a models.Attachment) error {
+	return s.writeBlob(ctx, zw, a.Hash, fmt.Sprintf("attachments/%d-%s", a.ID, path.Base(a.Filename)), a.CreatedAt)
+}
+
+// writeBlob copies a stored file into the archive; files already gone from
+// the store are left out
+func (s *accountService) writeBlob(ctx context.Context, zw *zip.Writer, key, name string, modified time.Time) error {
+	content, err := s.store.Open(ctx, key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		// Файл уже удалён из хранилища, его описание есть в остальных файлах
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	f, err := createFile(zw, name, modified)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, content)
+	return err
+}
+
+func (s *accountService) PurgeExpiredExports(ctx context.Context) (int64, error) {
+	exports, err := s.repo.GetExpiredExports(time.Now())
+	if err != nil {
+		return 0, err
+	}
+	var purged int64
+	for i := range exports {
+		if exports[i].Key != "" {
+			if err := s.store.Delete(ctx, exports[i].Key); err != nil {
+				return purged, err
+			}
+		}
+		if err := s.repo.DeleteExport(&exports[i]); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (s *accountService) DeleteAccount(userID int, password string, authTime time.Time) (*models.User, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, apperrors.ErrUnauthorized
+	}
+	if user.Password == "" {
+		// Пароля нет, подтверждением служит свежий вход через провайдера
+		if time.Since(authTime) > ReauthWindow {
+			return nil, apperrors.ErrReauthRequired.WithMessage("Sign in with your identity provider again, then delete the account within 10 minutes")
+		}
+	} else if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return nil, apperrors.Validation(apperrors.Field("password", "password", "is not the password of the account"))
+	}
+
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.repo.WithTx(tx).ScheduleDeletion(user, time.Now().Add(s.settings.DeletionGrace)); err != nil {
+			return err
+		}
+		return s.audit.recordAccount(tx, AuditDelete, user, nil, map[string]interface{}{"delete_after": user.DeleteAfter})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *accountService) RestoreAccount(username, password string) error {
+	user, err := s.userRepo.FindByUsername(username)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return apperrors.ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return apperrors.ErrInvalidCredentials
+	}
+	return s.restore(user)
+}
+
+func (s *accountService) RestoreSignedIn(userID int) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return apperrors.ErrUnauthorized
+	}
+	return s.restore(user)
+}
+
+func (s *accountService) restore(user *models.User) error {
+	if !user.PendingDeletion() {
+		return apperrors.ErrAccountNotDeleted
+	}
+
+	deleteAfter := user.DeleteAfter
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.repo.WithTx(tx).CancelDeletion(user); err != nil {
+			return err
+		}
+		return s.audit.orUser(user.ID).recordAccount(tx, AuditRestore, user, map[string]interface{}{"delete_after": deleteAfter}, nil)
+	})
+}
+
+func (s *accountService) PurgeDeletedAccounts(ctx context.Context) (int64, error) {
+	users, err := s.repo.GetDueDeletions(time.Now(), accountPurgeBatch)
+	if err != nil {
+		return 0, err
+	}
+	var purged int64
+	for _, user := range users {
+		keys, err := s.repo.GetExportKeys(user.ID)
+		if err != nil {
+			return purged, err
+		}
+		if user.AvatarKey != "" {
+			keys = append(keys, user.AvatarKey)
+		}
+		if err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+			return s.repo.WithTx(tx).DeleteAccount(user.ID)
+		}); err != nil {
+			return purged, err
+		}
+		// Файлы вложений удалит очистка хранилища, архивы выгрузок и аватар удаляем сами
+		for _, key := range keys {
+			if err := s.store.Delete(ctx, key); err != nil {
+				return purged, err
+			}
+		}
+		purged++
+	}
+	return purged, nil
+}
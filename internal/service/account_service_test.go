@@ -0,0 +1,92 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type fakeAccountRepo struct {
+	repository.AccountRepository
+}
+
+func (r *fakeAccountRepo) WithTx(*gorm.DB) repository.AccountRepository { return r }
+
+func (r *fakeAccountRepo) ScheduleDeletion(user *models.User, deleteAfter time.Time) error {
+	now := time.Now()
+	user.DeletionRequestedAt, user.DeleteAfter = &now, &deleteAfter
+	return nil
+}
+
+func (r *fakeAccountRepo) CancelDeletion(user *models.User) error {
+	user.DeletionRequestedAt, user.DeleteAfter = nil, nil
+	return nil
+}
+
+func newAccountTest(users ...*models.User) AccountService {
+	settings := AccountSettings{DeletionGrace: 30 * 24 * time.Hour}
+	return NewAccountService(&fakeAccountRepo{}, &fakeUserRepo{users: users}, nil, nil, nil, nil, settings,
+		NewAuditor(&fakeAuditRepo{}, nil), fakeTransactor{})
+}
+
+func TestDeleteAccountWithPassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: 1, Username: "john", Password: string(hash)}
+	s := newAccountTest(user)
+
+	// Давний вход не заменяет пароль
+	_, err = s.DeleteAccount(1, "wrong", time.Now())
+	wantErr(t, err, apperrors.ErrValidation)
+	if _, err := s.DeleteAccount(1, "secret", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if !user.PendingDeletion() {
+		t.Fatal("account is not scheduled for deletion")
+	}
+	wantErr(t, s.RestoreAccount("john", "wrong"), apperrors.ErrInvalidCredentials)
+	if err := s.RestoreAccount("john", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if user.PendingDeletion() {
+		t.Error("account is still scheduled for deletion")
+	}
+}
+
+// Users created through an identity provider have no password; they confirm
+// with a recent sign-in and restore by signing in again
+func TestDeleteAccountWithoutPassword(t *testing.T) {
+	user := &models.User{ID: 1, Username: "john"}
+	s := newAccountTest(user)
+
+	for _, authTime := range []time.Time{{}, time.Now().Add(-ReauthWindow - time.Minute)} {
+		_, err := s.DeleteAccount(1, "", authTime)
+		wantErr(t, err, apperrors.ErrReauthRequired)
+	}
+	if user.PendingDeletion() {
+		t.Fatal("deleted without a recent sign-in")
+	}
+	if _, err := s.DeleteAccount(1, "", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !user.PendingDeletion() {
+		t.Fatal("account is not scheduled for deletion")
+	}
+
+	// Пустой пароль не подходит к пустому хэшу
+	wantErr(t, s.RestoreAccount("john", ""), apperrors.ErrInvalidCredentials)
+	if err := s.RestoreSignedIn(1); err != nil {
+		t.Fatal(err)
+	}
+	if user.PendingDeletion() {
+		t.Error("account is still scheduled for deletion")
+	}
+	wantErr(t, s.RestoreSignedIn(1), apperrors.ErrAccountNotDeleted)
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+)
+
+const (
+	MaxAssignees   = 20
+	MaxMyTasksPage = 100
+)
+
+type AssignmentService interface {
+	// AssignTask adds assigneeID to the task's assignees. The assignee must
+	// have access to the list. Assigning an assignee again changes nothing.
+	AssignTask(listID int, taskID int, userID int, assigneeID int) (*models.Task, error)
+	// UnassignTask removes assigneeID from the task's assignees
+	UnassignTask(listID int, taskID int, userID int, assigneeID int) (*models.Task, error)
+	// FindAssignee resolves a username to a user ID for AssignTask
+	FindAssignee(username string) (int, error)
+	// GetUserTasks searches the tasks of every list the user can access.
+	// assigned narrows them to the tasks assigned (true) or not assigned
+	// (false) to the user; nil returns all of them.
+	GetUserTasks(userID int, assigned *bool, filter TaskFilter, limit int, offset int) ([]models.Task, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) AssignmentService
+}
+
+type assignmentService struct {
+	taskRepo repository.TaskRepository
+	listRepo repository.TodoListRepository
+	userRepo repository.UserRepository
+	depRepo  repository.DependencyRepository
+	tx       repository.Transactor
+	audit    Auditor
+}
+
+func NewAssignmentService(taskRepo repository.TaskRepository, listRepo repository.TodoListRepository, userRepo repository.UserRepository, depRepo repository.DependencyRepository, auditor Auditor, tx repository.Transactor) AssignmentService {
+	return &assignmentService{taskRepo: taskRepo, listRepo: listRepo, userRepo: userRepo, depRepo: depRepo, tx: tx, audit: auditor}
+}
+
+func (s *assignmentService) WithActor(actor Actor) AssignmentService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *assignmentService) FindAssignee(username string) (int, error) {
+	user, err := s.userRepo.FindByUsername(username)
+	if err != nil {
+		return 0, err
+	}
+	if user == nil {
+		return 0, apperrors.Validation(apperrors.Field("username", "exists", "no user with this name"))
+	}
+	return user.ID, nil
+}
+
+func (s *assignmentService) AssignTask(listID int, taskID int, userID int, assigneeID int) (*models.Task, error) {
+	if assigneeID <= 0 {
+		return nil, apperrors.Validation(apperrors.Field("user_id", "required", "assignee is required"))
+	}
+	return s.change(listID, taskID, userID, AuditAssign, func(tx *gorm.DB, task *models.Task) (bool, error) {
+		if task.Assignees.Contains(assigneeID) {
+			return false, nil
+		}
+		if len(task.Assignees) >= MaxAssignees {
+			return false, apperrors.Validation(apperrors.Field("user_id", "max", fmt.Sprintf("a task can have at most %d assignees", MaxAssignees)))
+		}
+		ok, err := s.listRepo.WithTx(tx).HasAccess(task.ListID, assigneeID)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, apperrors.Validation(apperrors.Field("user_id", "access", "the user has no access to this list"))
+		}
+		task.Assignees = append(append(models.UserIDs{}, task.Assignees...), assigneeID)
+		return true, nil
+	})
+}
+
+func (s *assignmentService) UnassignTask(listID int, taskID int, userID int, assigneeID int) (*models.Task, error) {
+	return s.change(listID, taskID, userID, AuditUnassign, func(_ *gorm.DB, task *models.Task) (bool, error) {
+		if !task.Assignees.Contains(assigneeID) {
+			return false, nil
+		}
+		task.Assignees = task.Assignees.Without(assigneeID)
+		return true, nil
+	})
+}
+
+// change applies fn to the task and, if it reports a change, saves the task
+// and records the assignment event
+func (s *assignmentService) change(listID int, taskID int, userID int, action string, fn func(tx *gorm.DB, task *models.Task) (bool, error)) (*models.Task, error) {
+	var changed *models.Task
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.taskRepo.WithTx(tx)
+		task, err := taskInList(repo, listID, taskID, userID)
+		if err != nil {
+			return err
+		}
+		before := *task
+		ok, err := fn(tx, task)
+		if err != nil {
+			return err
+		}
+		changed = task
+		if !ok {
+			return nil
+		}
+		task.UpdatedBy = userID
+		if err := repo.UpdateTask(task); err != nil {
+			return err
+		}
+		return s.audit.recordTask(tx, action, userID, &before, task)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := markBlocked(s.depRepo, changed); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+func (s *assignmentService) GetUserTasks(userID int, assigned *bool, filter TaskFilter, limit int, offset int) ([]models.Task, error) {
+	if limit < 0 || limit > MaxMyTasksPage {
+		return nil, apperrors.Validation(apperrors.Field("limit", "max", fmt.Sprintf("must be between 1 and %d", MaxMyTasksPage)))
+	}
+	if offset < 0 {
+		return nil, apperrors.Validation(apperrors.Field("offset", "min", "cannot be negative"))
+	}
+	if !validSort(filter.Sort) {
+		return nil, apperrors.Validation(apperrors.Field("sort", "oneof",
+			"must be one of: "+strings.Join(repository.TaskSortFields, " ")+", optionally prefixed with -"))
+	}
+	if limit == 0 {
+		limit = 50
+	}
+	if assigned != nil && *assigned {
+		filter.AssignedTo = &userID
+	} else if assigned != nil {
+		filter.NotAssignedTo = &userID
+	}
+	// Позиции разных списков между собой не сравнимы
+	if filter.Sort == "" {
+		filter.Sort = "created_at"
+	}
+	tasks, err := s.taskRepo.FindUserTasks(userID, filter, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err := markBlockedAll(s.depRepo, tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
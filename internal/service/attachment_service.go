@@ -0,0 +1,285 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/blobstore"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxFilenameLength = 255
+	// Сколько файлов удаляется за один проход очистки
+	blobCleanupBatch = 500
+)
+
+// AttachmentLimits restricts what can be uploaded
+type AttachmentLimits struct {
+	// Maximum file size in bytes
+	MaxSize int64
+	// Allowed MIME types; "image/*" allows every image type
+	AllowedTypes []string
+}
+
+type AttachmentService interface {
+	GetAttachments(listID int, taskID int, userID int) ([]models.Attachment, error)
+	// OpenAttachment returns the attachment and its content; the caller closes the content
+	OpenAttachment(ctx context.Context, listID int, taskID int, attachmentID int, userID int) (*models.Attachment, blobstore.Object, error)
+	// UploadAttachment stores content as a new attachment of the task. Files
+	// with the same content are stored once.
+	UploadAttachment(ctx context.Context, listID int, taskID int, userID int, filename string, content io.Reader) (*models.Attachment, error)
+	DeleteAttachment(ctx context.Context, listID int, taskID int, attachmentID int, userID int) error
+	// CleanupBlobs deletes stored files no attachment refers to any more,
+	// e.g. after their tasks were purged from the trash
+	CleanupBlobs(ctx context.Context) (int64, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) AttachmentService
+}
+
+type attachmentService struct {
+	repo     repository.AttachmentRepository
+	taskRepo repository.TaskRepository
+	store    blobstore.Store
+	limits   AttachmentLimits
+	tx       repository.Transactor
+	audit    Auditor
+}
+
+func NewAttachmentService(repo repository.AttachmentRepository, taskRepo repository.TaskRepository, store blobstore.Store, limits AttachmentLimits, auditor Auditor, tx repository.Transactor) AttachmentService {
+	return &attachmentService{repo: repo, taskRepo: taskRepo, store: store, limits: limits, tx: tx, audit: auditor}
+}
+
+func (s *attachmentService) WithActor(actor Actor) AttachmentService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *attachmentService) GetAttachments(listID int, taskID int, userID int) ([]models.Attachment, error) {
+	if _, err := taskInList(s.taskRepo, listID, taskID, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetAttachments(taskID)
+}
+
+func (s *attachmentService) OpenAttachment(ctx context.Context, listID int, taskID int, attachmentID int, userID int) (*models.Attachment, blobstore.Object, error) {
+	if _, err := taskInList(s.taskRepo, listID, taskID, userID); err != nil {
+		return nil, nil, err
+	}
+	attachment, err := s.repo.GetAttachment(taskID, attachmentID)
+	if err != nil {
+		return nil, nil, notFound(err, apperrors.ErrAttachmentNotFound)
+	}
+	content, err := s.store.Open(ctx, attachment.Hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open blob %s: %w", attachment.Hash, err)
+	}
+	return attachment, content, nil
+}
+
+func (s *attachmentService) UploadAttachment(ctx context.Context, listID int, taskID int, userID int, filename string, content io.Reader) (*models.Attachment, error) {
+	// Проверяем доступ до того, как принимать файл
+	if _, err := taskInList(s.taskRepo, listID, taskID, userID); err != nil {
+		return nil, err
+	}
+
+	spool, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), io.LimitReader(content, s.limits.MaxSize+1))
+	if err != nil {
+		return nil, apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if size > s.limits.MaxSize {
+		return nil, apperrors.ErrAttachmentTooLarge.WithMessage(fmt.Sprintf("Attachments are limited to %d bytes", s.limits.MaxSize))
+	}
+	if size == 0 {
+		return nil, apperrors.Validation(apperrors.Field("file", "required", "file cannot be empty"))
+	}
+	contentType, err := s.detectType(spool)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment := &models.Attachment{
+		TaskID:      taskID,
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Size:        size,
+		Hash:        hex.EncodeToString(hash.Sum(nil)),
+		UploadedBy:  userID,
+	}
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if _, err := taskInList(s.taskRepo.WithTx(tx), listID, taskID, userID); err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		if err := s.storeBlob(ctx, repo, attachment.Hash, spool, size); err != nil {
+			return err
+		}
+		if err := repo.CreateAttachment(attachment); err != nil {
+			return err
+		}
+		return s.audit.recordAttachment(tx, AuditCreate, userID, nil, attachment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// storeBlob makes sure the content is in the blob storage, uploading it
+// only if no earlier attachment has the same hash
+func (s *attachmentService) storeBlob(ctx context.Context, repo repository.AttachmentRepository, hash string, content io.ReadSeeker, size int64) error {
+	if err := repo.LockBlob(hash); err != nil {
+		return err
+	}
+	_, err := repo.GetBlob(hash)
+	known := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if known {
+		// Запись есть, но файл мог пропасть, если очистка упала на полпути
+		stored, err := s.store.Exists(ctx, hash)
+		if err != nil || stored {
+			return err
+		}
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.store.Put(ctx, hash, content, size); err != nil {
+		return err
+	}
+	if known {
+		return nil
+	}
+	return repo.CreateBlob(&models.Blob{Hash: hash, Size: size})
+}
+
+func (s *attachmentService) DeleteAttachment(ctx context.Context, listID int, taskID int, attachmentID int, userID int) error {
+	var hash string
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if _, err := taskInList(s.taskRepo.WithTx(tx), listID, taskID, userID); err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		attachment, err := repo.GetAttachment(taskID, attachmentID)
+		if err != nil {
+			return notFound(err, apperrors.ErrAttachmentNotFound)
+		}
+		if err := repo.DeleteAttachment(attachment); err != nil {
+			return err
+		}
+		hash = attachment.Hash
+		return s.audit.recordAttachment(tx, AuditDelete, userID, attachment, nil)
+	})
+	if err != nil {
+		return err
+	}
+	// Ошибку не возвращаем: вложение уже удалено, файл подберёт фоновая очистка
+	_, _ = s.deleteBlob(ctx, hash)
+	return nil
+}
+
+func (s *attachmentService) CleanupBlobs(ctx context.Context) (int64, error) {
+	var deleted int64
+	for {
+		blobs, err := s.repo.UnreferencedBlobs(blobCleanupBatch)
+		if err != nil {
+			return deleted, err
+		}
+		for _, blob := range blobs {
+			removed, err := s.deleteBlob(ctx, blob.Hash)
+			if err != nil {
+				return deleted, err
+			}
+			if removed {
+				deleted++
+			}
+		}
+		if len(blobs) < blobCleanupBatch {
+			return deleted, nil
+		}
+	}
+}
+
+// deleteBlob removes the blob unless an attachment still refers to it
+func (s *attachmentService) deleteBlob(ctx context.Context, hash string) (bool, error) {
+	removed := false
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.LockBlob(hash); err != nil {
+			return err
+		}
+		referenced, err := repo.IsBlobReferenced(hash)
+		if err != nil || referenced {
+			return err
+		}
+		if err := s.store.Delete(ctx, hash); err != nil {
+			return err
+		}
+		removed = true
+		return repo.DeleteBlob(hash)
+	})
+	return removed, err
+}
+
+// detectType sniffs the MIME type from the first bytes of the file and
+// checks it against the allowed types
+func (s *attachmentService) detectType(file io.ReaderAt) (string, error) {
+	head := make([]byte, 512)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		contentType = "application/octet-stream"
+	}
+	for _, allowed := range s.limits.AllowedTypes {
+		if allowed == contentType || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*")) {
+			return contentType, nil
+		}
+	}
+	return "", apperrors.ErrAttachmentType.WithMessage(fmt.Sprintf("Files of type %s are not allowed", contentType))
+}
+
+// cleanFilename keeps only the base name without control characters
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	for utf8.RuneCountInString(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
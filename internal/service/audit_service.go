@@ -0,0 +1,106 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"time"
+)
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+	AuditRevert  = "revert"
+	// Assignment events, recorded with the changed assignees
+	AuditAssign   = "assign"
+	AuditUnassign = "unassign"
+
+	AuditEntityList       = "list"
+	AuditEntityTask       = "task"
+	AuditEntityComment    = "comment"
+	AuditEntityAttachment = "attachment"
+	AuditEntityDependency = "dependency"
+	AuditEntityTimeEntry  = "time_entry"
+	AuditEntityUser       = "user"
+
+	MaxAuditPageSize = 1000
+)
+
+// AuditFilter narrows audit log queries
+type AuditFilter = repository.AuditFilter
+
+type AuditService interface {
+	// GetOwnerLog returns entries about the lists and tasks the user owns
+	GetOwnerLog(userID int, filter AuditFilter) ([]models.AuditEntry, error)
+	// Search queries the whole log, for administrators
+	Search(filter AuditFilter) ([]models.AuditEntry, error)
+	Export(filter AuditFilter, fn func([]models.AuditEntry) error) error
+	PurgeExpired(retention time.Duration) (int64, error)
+}
+
+type auditService struct {
+	repo repository.AuditRepository
+	tx   repository.Transactor
+}
+
+func NewAuditService(repo repository.AuditRepository, tx repository.Transactor) AuditService {
+	return &auditService{repo: repo, tx: tx}
+}
+
+func (s *auditService) GetOwnerLog(userID int, filter AuditFilter) ([]models.AuditEntry, error) {
+	if userID <= 0 {
+		return nil, apperrors.ErrUnauthorized
+	}
+	filter.OwnerID = userID
+	return s.Search(filter)
+}
+
+func (s *auditService) Search(filter AuditFilter) ([]models.AuditEntry, error) {
+	if err := validateAuditFilter(&filter); err != nil {
+		return nil, err
+	}
+	return s.repo.FindEntries(filter)
+}
+
+func (s *auditService) Export(filter AuditFilter, fn func([]models.AuditEntry) error) error {
+	if err := validateAuditFilter(&filter); err != nil {
+		return err
+	}
+	return s.repo.EachEntry(filter, 500, fn)
+}
+
+// PurgeExpired deletes entries older than retention; zero keeps them forever
+func (s *auditService) PurgeExpired(retention time.Duration) (int64, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+	return s.repo.DeleteEntriesBefore(time.Now().Add(-retention))
+}
+
+func validateAuditFilter(filter *AuditFilter) error {
+	var fields []apperrors.FieldError
+	switch filter.Entity {
+	case "", AuditEntityList, AuditEntityTask, AuditEntityComment, AuditEntityAttachment, AuditEntityDependency, AuditEntityTimeEntry, AuditEntityUser:
+	default:
+		fields = append(fields, apperrors.Field("entity", "oneof", "must be one of: list task comment attachment dependency time_entry user"))
+	}
+	if filter.EntityID > 0 && filter.Entity == "" {
+		fields = append(fields, apperrors.Field("entity", "required", "is required when id is set"))
+	}
+	if filter.Limit < 0 || filter.Limit > MaxAuditPageSize {
+		fields = append(fields, apperrors.Field("limit", "max", "must be between 1 and 1000"))
+	}
+	if filter.Offset < 0 {
+		fields = append(fields, apperrors.Field("offset", "min", "cannot be negative"))
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation(fields...)
+	}
+	if filter.Limit == 0 {
+		filter.Limit = 100
+	}
+	return nil
+}
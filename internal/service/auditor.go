@@ -0,0 +1,274 @@
+package service
+
+import (
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"encoding/json"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+// Actor describes who makes a change; it is recorded in the audit log.
+// The zero value stands for the system itself (background jobs).
+type Actor struct {
+	UserID    int
+	IP        string
+	RequestID string
+	// Login session the request belongs to, scopes undo
+	SessionID string
+}
+
+// Auditor records changes on behalf of the services: an audit log entry for
+// every change and a history version for every task change. Both are written
+// with the same tx as the change they describe.
+type Auditor struct {
+	audit   repository.AuditRepository
+	history repository.HistoryRepository
+	actor   Actor
+}
+
+func NewAuditor(audit repository.AuditRepository, history repository.HistoryRepository) Auditor {
+	return Auditor{audit: audit, history: history}
+}
+
+// As returns a copy of the auditor that records changes as made by actor
+func (a Auditor) As(actor Actor) Auditor {
+	a.actor = actor
+	return a
+}
+
+// orUser returns an auditor that records changes as made by the user when
+// the request is not authenticated, as while signing in
+func (a Auditor) orUser(userID int) Auditor {
+	if a.actor.UserID == 0 {
+		a.actor = Actor{UserID: userID, IP: a.actor.IP, RequestID: a.actor.RequestID}
+	}
+	return a
+}
+
+// record stores one audit entry
+func (a Auditor) record(tx *gorm.DB, action, entity string, entityID int, ownerID int, changes json.RawMessage) error {
+	return a.audit.WithTx(tx).CreateEntry(&models.AuditEntry{
+		ActorID:   a.actor.UserID,
+		IP:        a.actor.IP,
+		RequestID: a.actor.RequestID,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		OwnerID:   ownerID,
+		Changes:   changes,
+	})
+}
+
+// recordTask records a task change. before is nil for creations and after
+// is nil for deletions.
+func (a Auditor) recordTask(tx *gorm.DB, action string, ownerID int, before, after *models.Task) error {
+	return a.recordTaskChange(tx, action, ownerID, before, after, 0)
+}
+
+// recordTaskChange also appends a history version; undoOf is the version
+// an undo reverts, zero for ordinary changes
+func (a Auditor) recordTaskChange(tx *gorm.DB, action string, ownerID int, before, after *models.Task, undoOf int64) error {
+	task := after
+	if task == nil {
+		task = before
+	}
+	changes, err := auditDiff(taskOrNil(before), taskOrNil(after))
+	if err != nil {
+		return err
+	}
+	if err := a.record(tx, action, AuditEntityTask, task.ID, ownerID, changes); err != nil {
+		return err
+	}
+	// Удалённая навсегда задача уносит с собой и историю
+	if action == AuditPurge {
+		return nil
+	}
+
+	snapshot, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	history := a.history.WithTx(tx)
+	last, err := history.LastVersion(task.ID)
+	if err != nil {
+		return err
+	}
+	version := &models.TaskVersion{
+		TaskID:    task.ID,
+		Version:   last + 1,
+		Action:    action,
+		ActorID:   a.actor.UserID,
+		SessionID: a.actor.SessionID,
+		RequestID: a.actor.RequestID,
+		Changes:   changes,
+		Snapshot:  snapshot,
+	}
+	if undoOf != 0 {
+		version.UndoOf = &undoOf
+	}
+	return history.CreateVersion(version)
+}
+
+func (a Auditor) recordList(tx *gorm.DB, action string, before, after *models.TodoList) error {
+	list := after
+	if list == nil {
+		list = before
+	}
+	changes, err := auditDiff(listOrNil(before), listOrNil(after))
+	if err != nil {
+		return err
+	}
+	return a.record(tx, action, AuditEntityList, list.ID, list.UserID, changes)
+}
+
+func (a Auditor) recordComment(tx *gorm.DB, action string, ownerID int, before, after *models.Comment) error {
+	comment := after
+	if comment == nil {
+		comment = before
+	}
+	var old, updated interface{}
+	if before != nil {
+		old = before
+	}
+	if after != nil {
+		updated = after
+	}
+	changes, err := auditDiff(old, updated)
+	if err != nil {
+		return err
+	}
+	return a.record(tx, action, AuditEntityComment, comment.ID, ownerID, changes)
+}
+
+func (a Auditor) recordDependency(tx *gorm.DB, action string, ownerID int, dep *models.TaskDependency) error {
+	var old, updated interface{}
+	if action == AuditDelete {
+		old = dep
+	} else {
+		updated = dep
+	}
+	changes, err := auditDiff(old, updated)
+	if err != nil {
+		return err
+	}
+	return a.record(tx, action, AuditEntityDependency, dep.ID, ownerID, changes)
+}
+
+func (a Auditor) recordAttachment(tx *gorm.DB, action string, ownerID int, before, after *models.Attachment) error {
+	attachment := after
+	var old, updated interface{}
+	if before != nil {
+		old = before
+		attachment = before
+	}
+	if after != nil {
+		updated = after
+	}
+	changes, err := auditDiff(old, updated)
+	if err != nil {
+		return err
+	}
+	return a.record(tx, action, AuditEntityAttachment, attachment.ID, ownerID, changes)
+}
+
+func (a Auditor) recordTimeEntry(tx *gorm.DB, action string, ownerID int, before, after *models.TimeEntry) error {
+	entry := after
+	var old, updated interface{}
+	if before != nil {
+		old = before
+		entry = before
+	}
+	if after != nil {
+		updated = after
+	}
+	changes, err := auditDiff(old, updated)
+	if err != nil {
+		return err
+	}
+	return a.record(tx, action, AuditEntityTimeEntry, entry.ID, ownerID, changes)
+}
+
+// recordAccount records a profile change, deletion request or restore of
+// the user's account; the user is both the entity and the owner
+func (a Auditor) recordAccount(tx *gorm.DB, action string, user *models.User, before, after interface{}) error {
+	changes, err := auditDiff(before, after)
+	if err != nil {
+		return err
+	}
+	return a.record(tx, action, AuditEntityUser, user.ID, user.ID, changes)
+}
+
+// recordWorkflow records a change of the list's status columns as an
+// update of the list
+func (a Auditor) recordWorkflow(tx *gorm.DB, list *models.TodoList, before, after []models.Status) error {
+	changes, err := auditDiff(map[string]interface{}{"statuses": before}, map[string]interface{}{"statuses": after})
+	if err != nil {
+		return err
+	}
+	return a.record(tx, AuditUpdate, AuditEntityList, list.ID, list.UserID, changes)
+}
+
+// taskOrNil avoids storing a typed nil pointer in an interface
+func taskOrNil(task *models.Task) interface{} {
+	if task == nil {
+		return nil
+	}
+	return task
+}
+
+func listOrNil(list *models.TodoList) interface{} {
+	if list == nil {
+		return nil
+	}
+	return list
+}
+
+// auditIgnored are fields that change on every write or are computed and
+// only add noise
+var auditIgnored = map[string]bool{"updated_at": true, "updated_by": true, "tasks": true, "mentions": true, "blocked": true, "blocked_by": true}
+
+type auditChange struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// auditDiff compares the JSON representations of before and after field by field
+func auditDiff(before, after interface{}) (json.RawMessage, error) {
+	old, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]auditChange{}
+	for key, value := range old {
+		if auditIgnored[key] {
+			continue
+		}
+		if newValue, ok := updated[key]; !ok || !reflect.DeepEqual(value, newValue) {
+			changes[key] = auditChange{Old: value, New: updated[key]}
+		}
+	}
+	for key, value := range updated {
+		if _, ok := old[key]; !ok && !auditIgnored[key] {
+			changes[key] = auditChange{New: value}
+		}
+	}
+	return json.Marshal(changes)
+}
+
+func jsonFields(v interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if v == nil {
+		return fields, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return fields, json.Unmarshal(data, &fields)
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	// Uploaded avatar images are limited to this size
+	MaxAvatarUploadSize = 5 << 20
+	// Avatars are stored as squares of this many pixels, smaller images are not enlarged
+	AvatarSize = 256
+	// Images with more pixels are refused before they are decoded
+	maxAvatarPixels = 40_000_000
+)
+
+// resizeAvatar decodes a PNG, JPEG or GIF image, crops the centre square and
+// scales it down to AvatarSize. The result is encoded as PNG.
+func resizeAvatar(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxAvatarUploadSize+1))
+	if err != nil {
+		return nil, apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if len(data) > MaxAvatarUploadSize {
+		return nil, apperrors.ErrAvatarTooLarge.WithMessage(fmt.Sprintf("Avatar images are limited to %d bytes", MaxAvatarUploadSize))
+	}
+	// Размеры проверяем до декодирования, чтобы маленький файл не занял
+	// гигабайты памяти
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, apperrors.ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxAvatarPixels {
+		return nil, apperrors.ErrInvalidImage.WithMessage(fmt.Sprintf("Avatar images are limited to %d pixels", maxAvatarPixels))
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, apperrors.ErrInvalidImage
+	}
+
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	crop := image.Rect(0, 0, side, side)
+	src := image.NewRGBA(crop)
+	offset := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+	draw.Draw(src, crop, img, offset, draw.Src)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleDown(src, min(side, AvatarSize))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown resizes a square image to size×size pixels, averaging the
+// source pixels that fall into each destination pixel
+func scaleDown(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, (y+1)*side/size
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, (x+1)*side/size
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,406 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/ical"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CalendarProdID = "-//RestAPI//TodoList//EN"
+	// MaxCalendarImportSize limits uploaded .ics files, in bytes
+	MaxCalendarImportSize  = 1 << 20
+	MaxCalendarImportTasks = 1000
+	// DefaultEventDuration is the length of VEVENTs of tasks without an estimate
+	DefaultEventDuration = 30 * time.Minute
+)
+
+// CalendarImportSkip explains why a VTODO of an imported file was not imported
+type CalendarImportSkip struct {
+	// Position of the VTODO in the file, starting at 0
+	Index int `json:"index"`
+	// example: 2f1c0a4e-6d0b-4a7e-9c39-5f6f3d1d2b1a
+	UID string `json:"uid"`
+	// example: SUMMARY is missing
+	Reason string `json:"reason"`
+}
+
+// CalendarImport is the outcome of an .ics import
+type CalendarImport struct {
+	Imported int                  `json:"imported"`
+	Tasks    []models.Task        `json:"tasks"`
+	Skipped  []CalendarImportSkip `json:"skipped"`
+}
+
+type CalendarService interface {
+	// GetFeed returns the user's feed of listID, or of every list when listID
+	// is nil, creating it on first use
+	GetFeed(userID int, listID *int) (*models.CalendarFeed, error)
+	// RegenerateFeed gives the feed a new token; the old URL stops working
+	RegenerateFeed(userID int, listID *int) (*models.CalendarFeed, error)
+	DeleteFeed(userID int, listID *int) error
+	// RenderFeed builds the calendar of the feed with the token. asEvents
+	// writes tasks as VEVENTs for calendar apps that ignore VTODOs.
+	RenderFeed(token string, asEvents bool) (*ical.Component, error)
+	// ImportTasks creates a task in the list for every VTODO of the file.
+	// Floating times are read in loc.
+	ImportTasks(listID int, userID int, r io.Reader, loc *time.Location) (*CalendarImport, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) CalendarService
+}
+
+type calendarService struct {
+	repo       repository.CalendarRepository
+	taskRepo   repository.TaskRepository
+	listRepo   repository.TodoListRepository
+	statusRepo repository.StatusRepository
+	tx         repository.Transactor
+	audit      Auditor
+}
+
+func NewCalendarService(repo repository.CalendarRepository, taskRepo repository.TaskRepository, listRepo repository.TodoListRepository, statusRepo repository.StatusRepository, auditor Auditor, tx repository.Transactor) CalendarService {
+	return &calendarService{repo: repo, taskRepo: taskRepo, listRepo: listRepo, statusRepo: statusRepo, tx: tx, audit: auditor}
+}
+
+func (s *calendarService) WithActor(actor Actor) CalendarService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *calendarService) GetFeed(userID int, listID *int) (*models.CalendarFeed, error) {
+	if err := s.checkList(userID, listID); err != nil {
+		return nil, err
+	}
+	feed, err := s.repo.GetFeed(userID, listID)
+	if err == nil {
+		return feed, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	token, err := newFeedToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateFeed(&models.CalendarFeed{UserID: userID, ListID: listID, Token: token}); err != nil {
+		return nil, err
+	}
+	return s.repo.GetFeed(userID, listID)
+}
+
+func (s *calendarService) RegenerateFeed(userID int, listID *int) (*models.CalendarFeed, error) {
+	feed, err := s.GetFeed(userID, listID)
+	if err != nil {
+		return nil, err
+	}
+	if feed.Token, err = newFeedToken(); err != nil {
+		return nil, err
+	}
+	feed.CreatedAt = time.Now()
+	if err := s.repo.UpdateFeed(feed); err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+func (s *calendarService) DeleteFeed(userID int, listID *int) error {
+	if err := s.checkList(userID, listID); err != nil {
+		return err
+	}
+	feed, err := s.repo.GetFeed(userID, listID)
+	if err != nil {
+		return notFound(err, apperrors.ErrCalendarFeedNotFound)
+	}
+	return s.repo.DeleteFeed(feed)
+}
+
+func (s *calendarService) checkList(userID int, listID *int) error {
+	if listID == nil {
+		return nil
+	}
+	ok, err := s.listRepo.HasAccess(*listID, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return apperrors.ErrListNotFound
+	}
+	return nil
+}
+
+func (s *calendarService) RenderFeed(token string, asEvents bool) (*ical.Component, error) {
+	if token == "" {
+		return nil, apperrors.ErrCalendarFeedNotFound
+	}
+	feed, err := s.repo.GetFeedByToken(token)
+	if err != nil {
+		return nil, notFound(err, apperrors.ErrCalendarFeedNotFound)
+	}
+	name := "Tasks"
+	if feed.ListID != nil {
+		list, err := s.listRepo.GetListByID(*feed.ListID, feed.UserID)
+		if err != nil {
+			// Лента списка из корзины не отдаётся
+			return nil, notFound(err, apperrors.ErrCalendarFeedNotFound)
+		}
+		name = list.Title
+	}
+	tasks, err := s.repo.GetDueTasks(feed.UserID, feed.ListID)
+	if err != nil {
+		return nil, err
+	}
+
+	cal := ical.NewCalendar(CalendarProdID)
+	cal.AddText("X-WR-CALNAME", name)
+	cal.Add("X-PUBLISHED-TTL", "PT15M")
+	cal.Add("REFRESH-INTERVAL", "PT15M", "VALUE", "DURATION")
+	for i := range tasks {
+		cal.Components = append(cal.Components, taskComponent(&tasks[i], asEvents))
+	}
+	return cal, nil
+}
+
+// taskComponent writes a task with a due date as a VTODO, or as a VEVENT
+// ending at the due date and lasting the estimate
+func taskComponent(task *models.Task, asEvent bool) *ical.Component {
+	comp := &ical.Component{Name: "VTODO"}
+	if asEvent {
+		comp.Name = "VEVENT"
+	}
+	comp.Add("UID", fmt.Sprintf("task-%d@restapi", task.ID))
+	comp.AddTime("DTSTAMP", task.UpdatedAt)
+	comp.AddTime("CREATED", task.CreatedAt)
+	comp.AddTime("LAST-MODIFIED", task.UpdatedAt)
+	comp.AddText("SUMMARY", task.Title)
+	if task.Description != "" {
+		comp.AddText("DESCRIPTION", task.Description)
+	}
+	if p := icalPriority(task.Priority); p > 0 {
+		comp.Add("PRIORITY", strconv.Itoa(p))
+	}
+
+	if asEvent {
+		duration := DefaultEventDuration
+		if task.Estimate != nil {
+			duration = time.Duration(*task.Estimate) * time.Minute
+		}
+		comp.AddTime("DTSTART", task.DueAt.Add(-duration))
+		comp.AddTime("DTEND", *task.DueAt)
+		if task.Completed {
+			// Выполненные задачи не занимают время в календаре
+			comp.Add("TRANSP", "TRANSPARENT")
+		}
+	} else {
+		comp.AddTime("DUE", *task.DueAt)
+		if task.Completed {
+			comp.Add("STATUS", "COMPLETED")
+			comp.Add("PERCENT-COMPLETE", "100")
+			if task.CompletedAt != nil {
+				comp.AddTime("COMPLETED", *task.CompletedAt)
+			}
+		} else {
+			comp.Add("STATUS", "NEEDS-ACTION")
+		}
+	}
+	if task.Recurrence != "" {
+		// RRULE отсчитывается от DTSTART, поэтому у VTODO он тоже нужен
+		if !asEvent {
+			comp.AddTime("DTSTART", *task.DueAt)
+		}
+		comp.Add("RRULE", task.Recurrence)
+	}
+	return comp
+}
+
+// icalPriority maps priorities onto the iCalendar scale, where 1 is the
+// highest, 9 the lowest and 0 means undefined
+func icalPriority(p models.Priority) int {
+	switch p {
+	case models.PriorityUrgent:
+		return 1
+	case models.PriorityHigh:
+		return 3
+	case models.PriorityMedium:
+		return 5
+	case models.PriorityLow:
+		return 7
+	}
+	return 0
+}
+
+func priorityFromICal(value string) models.Priority {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	switch {
+	case err != nil || n <= 0 || n > 9:
+		return models.PriorityNone
+	case n == 1:
+		return models.PriorityUrgent
+	case n <= 4:
+		return models.PriorityHigh
+	case n == 5:
+		return models.PriorityMedium
+	}
+	return models.PriorityLow
+}
+
+func (s *calendarService) ImportTasks(listID int, userID int, r io.Reader, loc *time.Location) (*CalendarImport, error) {
+	if listID <= 0 {
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid list ID")
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	data, err := io.ReadAll(io.LimitReader(r, MaxCalendarImportSize+1))
+	if err != nil {
+		return nil, apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if len(data) > MaxCalendarImportSize {
+		return nil, apperrors.ErrCalendarTooLarge.WithMessage(fmt.Sprintf("Calendar files are limited to %d bytes", MaxCalendarImportSize))
+	}
+	cal, err := ical.Decode(strings.NewReader(string(data)))
+	if err != nil {
+		return nil, apperrors.ErrInvalidCalendar.WithMessage(err.Error())
+	}
+	if cal.Name != "VCALENDAR" {
+		return nil, apperrors.ErrInvalidCalendar.WithMessage("The file does not contain a VCALENDAR")
+	}
+	todos := cal.Children("VTODO")
+	if len(todos) > MaxCalendarImportTasks {
+		return nil, apperrors.ErrInvalidCalendar.WithMessage(fmt.Sprintf("At most %d tasks can be imported at once", MaxCalendarImportTasks))
+	}
+
+	result := &CalendarImport{Tasks: []models.Task{}, Skipped: []CalendarImportSkip{}}
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		list, err := s.listRepo.WithTx(tx).GetListByID(listID, userID)
+		if err != nil {
+			return notFound(err, apperrors.ErrListNotFound)
+		}
+		wf, err := loadWorkflow(s.statusRepo.WithTx(tx), list.ID)
+		if err != nil {
+			return err
+		}
+		repo := s.taskRepo.WithTx(tx)
+		last, err := repo.LastTaskPosition(list.ID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for i, todo := range todos {
+			skip := func(reason string) {
+				result.Skipped = append(result.Skipped, CalendarImportSkip{Index: i, UID: todo.Text("UID"), Reason: reason})
+			}
+			fields, completedAt, reason := todoFields(todo, loc)
+			if reason != "" {
+				skip(reason)
+				continue
+			}
+			task := &models.Task{ListID: list.ID, CreatedBy: userID, UpdatedBy: userID}
+			if err := fields.apply(task, wf, now); err != nil {
+				var appErr *apperrors.Error
+				if !errors.As(err, &appErr) {
+					return err
+				}
+				skip(appErr.Message + fieldMessages(appErr))
+				continue
+			}
+			if task.Completed && completedAt != nil {
+				task.CompletedAt = completedAt
+			}
+			last = nextPosition(last)
+			task.Position = last
+			if err := repo.CreateTask(task); err != nil {
+				return err
+			}
+			if err := s.audit.recordTask(tx, AuditCreate, userID, nil, task); err != nil {
+				return err
+			}
+			// У новых задач нет блокирующих
+			task.BlockedBy = []int{}
+			result.Tasks = append(result.Tasks, *task)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Imported = len(result.Tasks)
+	return result, nil
+}
+
+// todoFields reads the task fields of a VTODO. A non-empty reason means
+// the VTODO cannot be imported.
+func todoFields(todo *ical.Component, loc *time.Location) (fields TaskFields, completedAt *time.Time, reason string) {
+	title := strings.TrimSpace(todo.Text("SUMMARY"))
+	if title == "" {
+		return fields, nil, "SUMMARY is missing"
+	}
+	status := strings.ToUpper(todo.Text("STATUS"))
+	if status == "CANCELLED" {
+		return fields, nil, "the task is cancelled"
+	}
+	description := todo.Text("DESCRIPTION")
+	priority := models.PriorityNone
+	if p := todo.Prop("PRIORITY"); p != nil {
+		priority = priorityFromICal(p.Value)
+	}
+	fields = TaskFields{Title: &title, Description: &description, Priority: &priority}
+
+	due := todo.Prop("DUE")
+	if due == nil {
+		due = todo.Prop("DTSTART")
+	}
+	if due != nil {
+		t, allDay, err := due.Time(loc)
+		if err != nil {
+			return fields, nil, fmt.Sprintf("%s is not a valid date", due.Name)
+		}
+		if allDay {
+			// Задача на весь день должна быть выполнена до конца этого дня
+			t = t.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		fields.DueAt = &t
+	}
+	if rule := todo.Prop("RRULE"); rule != nil {
+		fields.Recurrence = &rule.Value
+	}
+
+	completed := status == "COMPLETED"
+	if p := todo.Prop("COMPLETED"); p != nil {
+		completed = true
+		if t, _, err := p.Time(loc); err == nil {
+			completedAt = &t
+		}
+	}
+	fields.Completed = &completed
+	return fields, completedAt, ""
+}
+
+// fieldMessages appends the per-field details of a validation error
+func fieldMessages(err *apperrors.Error) string {
+	var b strings.Builder
+	for _, f := range err.Fields {
+		b.WriteString("; " + f.Field + ": " + f.Message)
+	}
+	return b.String()
+}
+
+func newFeedToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
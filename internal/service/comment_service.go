@@ -0,0 +1,251 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/markdown"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxCommentLength = 10000
+	MaxMentionsPage  = 100
+)
+
+type CommentService interface {
+	GetComments(listID int, taskID int, userID int) ([]models.Comment, error)
+	GetComment(listID int, taskID int, commentID int, userID int) (*models.Comment, error)
+	GetCommentHistory(listID int, taskID int, commentID int, userID int) ([]models.CommentRevision, error)
+	CreateComment(listID int, taskID int, userID int, body string) (*models.Comment, error)
+	// UpdateComment replaces the body; only the author may edit a comment
+	UpdateComment(listID int, taskID int, commentID int, userID int, body string) (*models.Comment, error)
+	DeleteComment(listID int, taskID int, commentID int, userID int) error
+	GetMentions(userID int, unreadOnly bool, limit int, offset int) ([]models.Mention, error)
+	MarkMentionRead(mentionID int, userID int) error
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) CommentService
+}
+
+type commentService struct {
+	repo     repository.CommentRepository
+	taskRepo repository.TaskRepository
+	userRepo repository.UserRepository
+	tx       repository.Transactor
+	audit    Auditor
+}
+
+func NewCommentService(repo repository.CommentRepository, taskRepo repository.TaskRepository, userRepo repository.UserRepository, auditor Auditor, tx repository.Transactor) CommentService {
+	return &commentService{repo: repo, taskRepo: taskRepo, userRepo: userRepo, tx: tx, audit: auditor}
+}
+
+func (s *commentService) WithActor(actor Actor) CommentService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *commentService) GetComments(listID int, taskID int, userID int) ([]models.Comment, error) {
+	if _, err := taskInList(s.taskRepo, listID, taskID, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetComments(taskID)
+}
+
+func (s *commentService) GetComment(listID int, taskID int, commentID int, userID int) (*models.Comment, error) {
+	if _, err := taskInList(s.taskRepo, listID, taskID, userID); err != nil {
+		return nil, err
+	}
+	comment, err := s.repo.GetComment(taskID, commentID)
+	if err != nil {
+		return nil, notFound(err, apperrors.ErrCommentNotFound)
+	}
+	return comment, nil
+}
+
+func (s *commentService) GetCommentHistory(listID int, taskID int, commentID int, userID int) ([]models.CommentRevision, error) {
+	if _, err := s.GetComment(listID, taskID, commentID, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetRevisions(commentID)
+}
+
+func (s *commentService) CreateComment(listID int, taskID int, userID int, body string) (*models.Comment, error) {
+	body, err := cleanCommentBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var comment *models.Comment
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		task, err := taskInList(s.taskRepo.WithTx(tx), listID, taskID, userID)
+		if err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		comment = &models.Comment{TaskID: task.ID, AuthorID: userID, Body: body}
+		if err := repo.CreateComment(comment); err != nil {
+			return err
+		}
+		if comment.Mentions, err = s.mention(repo, comment, task, nil); err != nil {
+			return err
+		}
+		return s.audit.recordComment(tx, AuditCreate, userID, nil, comment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+func (s *commentService) UpdateComment(listID int, taskID int, commentID int, userID int, body string) (*models.Comment, error) {
+	body, err := cleanCommentBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var comment *models.Comment
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		task, err := taskInList(s.taskRepo.WithTx(tx), listID, taskID, userID)
+		if err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		comment, err = repo.GetComment(taskID, commentID)
+		if err != nil {
+			return notFound(err, apperrors.ErrCommentNotFound)
+		}
+		if comment.AuthorID != userID {
+			return apperrors.ErrForbidden.WithMessage("Only the author can edit a comment")
+		}
+		if comment.Body == body {
+			return nil
+		}
+
+		before := *comment
+		err = repo.CreateRevision(&models.CommentRevision{
+			CommentID: comment.ID,
+			Body:      comment.Body,
+			EditorID:  userID,
+		})
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		comment.Body = body
+		comment.EditedAt = &now
+		if err := repo.UpdateComment(comment); err != nil {
+			return err
+		}
+		added, err := s.mention(repo, comment, task, comment.Mentions)
+		if err != nil {
+			return err
+		}
+		comment.Mentions = append(comment.Mentions, added...)
+		return s.audit.recordComment(tx, AuditUpdate, userID, &before, comment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// DeleteComment lets the author or the list owner remove a comment
+func (s *commentService) DeleteComment(listID int, taskID int, commentID int, userID int) error {
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		// taskInList проверяет, что список принадлежит пользователю
+		if _, err := taskInList(s.taskRepo.WithTx(tx), listID, taskID, userID); err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		comment, err := repo.GetComment(taskID, commentID)
+		if err != nil {
+			return notFound(err, apperrors.ErrCommentNotFound)
+		}
+		if err := repo.DeleteComment(comment); err != nil {
+			return err
+		}
+		return s.audit.recordComment(tx, AuditDelete, userID, comment, nil)
+	})
+}
+
+func (s *commentService) GetMentions(userID int, unreadOnly bool, limit int, offset int) ([]models.Mention, error) {
+	if limit < 0 || limit > MaxMentionsPage {
+		return nil, apperrors.Validation(apperrors.Field("limit", "max", fmt.Sprintf("must be between 1 and %d", MaxMentionsPage)))
+	}
+	if offset < 0 {
+		return nil, apperrors.Validation(apperrors.Field("offset", "min", "cannot be negative"))
+	}
+	if limit == 0 {
+		limit = 50
+	}
+	return s.repo.GetMentions(userID, unreadOnly, limit, offset)
+}
+
+func (s *commentService) MarkMentionRead(mentionID int, userID int) error {
+	affected, err := s.repo.MarkMentionRead(mentionID, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return apperrors.ErrNotFound.WithMessage("Mention not found")
+	}
+	return nil
+}
+
+// mention stores mentions of the users named in the comment body, skipping
+// users that are already mentioned, and returns the new ones. Users who
+// cannot see the task are not mentioned: the inbox would show them the comment.
+func (s *commentService) mention(repo repository.CommentRepository, comment *models.Comment, task *models.Task, existing []models.Mention) ([]models.Mention, error) {
+	names := markdown.Mentions(comment.Body)
+	if len(names) == 0 {
+		return []models.Mention{}, nil
+	}
+	users, err := s.userRepo.FindByUsernames(names)
+	if err != nil {
+		return nil, err
+	}
+
+	already := make(map[int]bool, len(existing))
+	for _, m := range existing {
+		already[m.UserID] = true
+	}
+	mentions := make([]models.Mention, 0, len(users))
+	for _, user := range users {
+		if already[user.ID] {
+			continue
+		}
+		if _, err := s.taskRepo.GetTaskByID(task.ID, user.ID); errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, models.Mention{
+			CommentID: comment.ID,
+			UserID:    user.ID,
+			Username:  user.Username,
+			TaskID:    task.ID,
+			ListID:    task.ListID,
+		})
+	}
+	if err := repo.CreateMentions(mentions); err != nil {
+		return nil, err
+	}
+	return mentions, nil
+}
+
+func cleanCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(markdown.Sanitize(body))
+	if body == "" {
+		return "", apperrors.Validation(apperrors.Field("body", "required", "comment cannot be empty"))
+	}
+	if utf8.RuneCountInString(body) > MaxCommentLength {
+		return "", apperrors.Validation(apperrors.Field("body", "max", fmt.Sprintf("must be at most %d characters", MaxCommentLength)))
+	}
+	return body, nil
+}
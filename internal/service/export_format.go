@@ -0,0 +1,936 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Files of a zipped export
+const (
+	exportJSONFile     = "export.json"
+	listsCSVFile       = "lists.csv"
+	statusesCSVFile    = "statuses.csv"
+	tasksCSVFile       = "tasks.csv"
+	commentsCSVFile    = "comments.csv"
+	timeEntriesCSVFile = "time_entries.csv"
+	attachmentsCSVFile = "attachments.csv"
+	markdownDir        = "lists/"
+	// csvSeparator joins the IDs of multi-valued CSV columns
+	csvSeparator = "|"
+	// defaultImportList takes CSV tasks and Markdown tasks that name no list
+	defaultImportList = "Imported tasks"
+)
+
+var (
+	listsColumns       = []string{"id", "title", "position", "block_completion", "created_at", "updated_at"}
+	statusesColumns    = []string{"list_id", "position", "name", "terminal", "wip_limit", "next"}
+	tasksColumns       = []string{"id", "list_id", "list_title", "title", "description", "completed", "completed_at", "priority", "status", "assignees", "estimate_minutes", "due_at", "recurrence", "depends_on", "position", "created_at", "updated_at"}
+	commentsColumns    = []string{"id", "task_id", "author_id", "body", "created_at", "edited_at"}
+	timeEntriesColumns = []string{"id", "task_id", "user_id", "started_at", "ended_at", "duration_seconds", "note", "manual"}
+	attachmentsColumns = []string{"id", "task_id", "filename", "content_type", "size", "sha256", "uploaded_by", "created_at"}
+)
+
+// WriteExport writes the document as a zip of files in the format:
+// export.json, one CSV file per kind of row, or one Markdown file per list
+func WriteExport(w io.Writer, format string, doc *ExportDocument) error {
+	zw := zip.NewWriter(w)
+	var err error
+	switch format {
+	case FormatJSON:
+		err = writeJSONExport(zw, doc)
+	case FormatCSV:
+		err = writeCSVExport(zw, doc)
+	case FormatMarkdown:
+		err = writeMarkdownExport(zw, doc)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func createFile(zw *zip.Writer, name string, modified time.Time) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+}
+
+func writeJSONExport(zw *zip.Writer, doc *ExportDocument) error {
+	f, err := createFile(zw, exportJSONFile, doc.ExportedAt)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func writeCSVExport(zw *zip.Writer, doc *ExportDocument) error {
+	lists := [][]string{listsColumns}
+	statuses := [][]string{statusesColumns}
+	tasks := [][]string{tasksColumns}
+	comments := [][]string{commentsColumns}
+	entries := [][]string{timeEntriesColumns}
+	attachments := [][]string{attachmentsColumns}
+
+	for _, l := range doc.Lists {
+		lists = append(lists, []string{
+			strconv.Itoa(l.ID), l.Title, l.Position, strconv.FormatBool(l.BlockCompletion), formatTime(&l.CreatedAt), formatTime(&l.UpdatedAt),
+		})
+		for i, st := range l.Statuses {
+			// Имена колонок могут содержать что угодно, поэтому next хранится как JSON
+			next, err := json.Marshal(orEmpty(st.Next))
+			if err != nil {
+				return err
+			}
+			statuses = append(statuses, []string{
+				strconv.Itoa(l.ID), strconv.Itoa(i), st.Name, strconv.FormatBool(st.Terminal), strconv.Itoa(st.WIPLimit), string(next),
+			})
+		}
+		for _, t := range l.Tasks {
+			tasks = append(tasks, []string{
+				strconv.Itoa(t.ID), strconv.Itoa(l.ID), l.Title, t.Title, t.Description, strconv.FormatBool(t.Completed),
+				formatTime(t.CompletedAt), t.Priority, t.Status, joinInts(t.Assignees, csvSeparator), formatInt(t.EstimateMinutes),
+				formatTime(t.DueAt), t.Recurrence, joinInts(t.DependsOn, csvSeparator), t.Position, formatTime(&t.CreatedAt), formatTime(&t.UpdatedAt),
+			})
+			for _, c := range t.Comments {
+				comments = append(comments, []string{
+					strconv.Itoa(c.ID), strconv.Itoa(t.ID), strconv.Itoa(c.AuthorID), c.Body, formatTime(&c.CreatedAt), formatTime(c.EditedAt),
+				})
+			}
+			for _, e := range t.TimeEntries {
+				entries = append(entries, []string{
+					strconv.Itoa(e.ID), strconv.Itoa(t.ID), strconv.Itoa(e.UserID), formatTime(&e.StartedAt), formatTime(e.EndedAt),
+					strconv.FormatInt(e.Duration, 10), e.Note, strconv.FormatBool(e.Manual),
+				})
+			}
+			for _, a := range t.Attachments {
+				attachments = append(attachments, []string{
+					strconv.Itoa(a.ID), strconv.Itoa(t.ID), a.Filename, a.ContentType, strconv.FormatInt(a.Size, 10), a.Hash,
+					strconv.Itoa(a.UploadedBy), formatTime(&a.CreatedAt),
+				})
+			}
+		}
+	}
+
+	for _, file := range []struct {
+		name string
+		rows [][]string
+	}{
+		{listsCSVFile, lists},
+		{statusesCSVFile, statuses},
+		{tasksCSVFile, tasks},
+		{commentsCSVFile, comments},
+		{timeEntriesCSVFile, entries},
+		{attachmentsCSVFile, attachments},
+	} {
+		f, err := createFile(zw, file.name, doc.ExportedAt)
+		if err != nil {
+			return err
+		}
+		if err := csv.NewWriter(f).WriteAll(file.rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMarkdownExport writes every list as a checklist. Task fields are
+// nested bullets and descriptions are quotes under the task, which is what
+// the Markdown import reads back; comments, time entries and attachments
+// are only in the JSON and CSV exports.
+func writeMarkdownExport(zw *zip.Writer, doc *ExportDocument) error {
+	for i, l := range doc.Lists {
+		var b strings.Builder
+		fmt.Fprintf(&b, "# %s\n\n", oneLine(l.Title))
+		fmt.Fprintf(&b, "<!-- id: %d, block_completion: %t -->\n\n", l.ID, l.BlockCompletion)
+		if len(l.Statuses) > 0 {
+			names := make([]string, len(l.Statuses))
+			for j, st := range l.Statuses {
+				names[j] = st.Name
+				if st.Terminal {
+					names[j] += " (done)"
+				}
+			}
+			fmt.Fprintf(&b, "Workflow: %s\n\n", strings.Join(names, " → "))
+		}
+
+		for _, t := range l.Tasks {
+			mark := " "
+			if t.Completed {
+				mark = "x"
+			}
+			fmt.Fprintf(&b, "- [%s] %s\n", mark, oneLine(t.Title))
+			meta := func(key, value string) {
+				if value != "" {
+					fmt.Fprintf(&b, "  - %s: %s\n", key, value)
+				}
+			}
+			meta("id", strconv.Itoa(t.ID))
+			if t.Priority != "none" {
+				meta("priority", t.Priority)
+			}
+			meta("status", t.Status)
+			meta("due_at", formatTime(t.DueAt))
+			meta("recurrence", t.Recurrence)
+			meta("estimate_minutes", formatInt(t.EstimateMinutes))
+			meta("depends_on", joinInts(t.DependsOn, ", "))
+			meta("assignees", joinInts(t.Assignees, ", "))
+			meta("completed_at", formatTime(t.CompletedAt))
+			if t.Description != "" {
+				for _, line := range strings.Split(t.Description, "\n") {
+					if line == "" {
+						b.WriteString("  >\n")
+					} else {
+						b.WriteString("  > " + line + "\n")
+					}
+				}
+			}
+		}
+
+		f, err := createFile(zw, fmt.Sprintf("%s%03d-%s.md", markdownDir, i+1, slug(l.Title)), doc.ExportedAt)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func parseExportTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+func formatInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func joinInts(ids []int, sep string) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, sep)
+}
+
+// splitInts reads IDs separated by | or commas
+func splitInts(raw string) ([]int, error) {
+	ids := []int{}
+	for _, part := range strings.FieldsFunc(raw, func(r rune) bool { return r == '|' || r == ',' || unicode.IsSpace(r) }) {
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// slug makes a file name out of a list title
+func slug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if b.Len() >= 40 {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	if s := strings.TrimRight(b.String(), "-"); s != "" {
+		return s
+	}
+	return "list"
+}
+
+// importFile is an uploaded file or a file of an uploaded zip
+type importFile struct {
+	name string
+	data []byte
+}
+
+// importBatch is what could be read from the files of an import
+type importBatch struct {
+	lists []ExportList
+	// Rows that could not be read at all
+	issues []ImportIssue
+	// Last ID given to a task of an app export, which are numbered anew
+	lastID int
+}
+
+func (b *importBatch) tasks() int {
+	n := 0
+	for _, list := range b.lists {
+		n += len(list.Tasks)
+	}
+	return n
+}
+
+func (b *importBatch) fail(at ImportSource, field string, message string) {
+	b.issues = append(b.issues, ImportIssue{ImportSource: at, Field: field, Message: message})
+}
+
+// decodeImport reads a single file in opts.Format, or a zip, whose format is
+// detected from its files when opts.Format is empty
+func decodeImport(data []byte, opts *ImportOptions) (*importBatch, error) {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		file := importFile{name: opts.Filename, data: data}
+		switch opts.Format {
+		case FormatJSON:
+			file.name = orDefault(file.name, exportJSONFile)
+			return decodeJSON(file)
+		case FormatCSV:
+			file.name = orDefault(file.name, tasksCSVFile)
+			return decodeCSV(map[string]importFile{tasksCSVFile: file})
+		case FormatMarkdown:
+			file.name = orDefault(file.name, "tasks.md")
+			return decodeMarkdown([]importFile{file})
+		}
+		return nil, apperrors.Validation(apperrors.Field("format", "required", "is required unless a zip is uploaded"))
+	}
+
+	files, err := unzip(data)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]importFile, len(files))
+	var jsonFiles, markdownFiles []importFile
+	for _, f := range files {
+		base := strings.ToLower(path.Base(f.name))
+		byName[base] = f
+		switch path.Ext(base) {
+		case ".json":
+			jsonFiles = append(jsonFiles, f)
+		case ".md", ".markdown":
+			markdownFiles = append(markdownFiles, f)
+		}
+	}
+	if opts.Format == "" {
+		switch {
+		case len(jsonFiles) > 0:
+			opts.Format = FormatJSON
+		case byName[tasksCSVFile].data != nil:
+			opts.Format = FormatCSV
+		case len(markdownFiles) > 0:
+			opts.Format = FormatMarkdown
+		default:
+			return nil, apperrors.ErrInvalidImport.WithMessage("The zip contains no " + exportJSONFile + ", " + tasksCSVFile + " or Markdown files")
+		}
+	}
+
+	switch opts.Format {
+	case FormatJSON:
+		if f, ok := byName[exportJSONFile]; ok {
+			return decodeJSON(f)
+		}
+		if len(jsonFiles) == 1 {
+			return decodeJSON(jsonFiles[0])
+		}
+		return nil, apperrors.ErrInvalidImport.WithMessage("The zip contains no " + exportJSONFile)
+	case FormatCSV:
+		csvFiles := map[string]importFile{}
+		for _, name := range []string{listsCSVFile, statusesCSVFile, tasksCSVFile, commentsCSVFile, timeEntriesCSVFile} {
+			if f, ok := byName[name]; ok {
+				csvFiles[name] = f
+			}
+		}
+		return decodeCSV(csvFiles)
+	default:
+		if len(markdownFiles) == 0 {
+			return nil, apperrors.ErrInvalidImport.WithMessage("The zip contains no Markdown files")
+		}
+		sort.Slice(markdownFiles, func(i, j int) bool { return markdownFiles[i].name < markdownFiles[j].name })
+		return decodeMarkdown(markdownFiles)
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// unzip reads the regular files of a zip. The zip may have at most
+// MaxImportEntries entries, none of which may unpack to more than
+// MaxImportSize bytes, and MaxImportUnpacked bytes in total
+func unzip(data []byte) ([]importFile, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, apperrors.ErrInvalidImport.WithMessage("The zip cannot be read: " + err.Error())
+	}
+	if len(zr.File) > MaxImportEntries {
+		return nil, apperrors.ErrImportTooLarge.WithMessage(fmt.Sprintf("The zip has more than %d entries", MaxImportEntries))
+	}
+	var files []importFile
+	total := 0
+	for _, f := range zr.File {
+		base := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, apperrors.ErrInvalidImport.WithMessage(f.Name + " cannot be read: " + err.Error())
+		}
+		// Размер из заголовка zip не проверяем: ему нельзя верить
+		limit := min(MaxImportSize, MaxImportUnpacked-total)
+		content, err := io.ReadAll(io.LimitReader(rc, int64(limit)+1))
+		rc.Close()
+		if err != nil {
+			return nil, apperrors.ErrInvalidImport.WithMessage(f.Name + " cannot be read: " + err.Error())
+		}
+		if len(content) > MaxImportSize {
+			return nil, apperrors.ErrImportTooLarge.WithMessage(fmt.Sprintf("%s unpacks to more than %d bytes", f.Name, MaxImportSize))
+		}
+		if total += len(content); total > MaxImportUnpacked {
+			return nil, apperrors.ErrImportTooLarge.WithMessage(fmt.Sprintf("The zip unpacks to more than %d bytes", MaxImportUnpacked))
+		}
+		files = append(files, importFile{name: f.Name, data: content})
+	}
+	return files, nil
+}
+
+func decodeJSON(file importFile) (*importBatch, error) {
+	var doc ExportDocument
+	if err := json.Unmarshal(bytes.TrimPrefix(file.data, []byte("\ufeff")), &doc); err != nil {
+		return nil, apperrors.ErrInvalidImport.WithMessage(file.name + ": " + err.Error())
+	}
+	if doc.Version > ExportVersion {
+		return nil, apperrors.ErrInvalidImport.WithMessage(fmt.Sprintf("%s has version %d, only %d and older can be imported", file.name, doc.Version, ExportVersion))
+	}
+	for i := range doc.Lists {
+		l := &doc.Lists[i]
+		l.at = ImportSource{File: file.name, Path: fmt.Sprintf("lists[%d]", i)}
+		for j := range l.Tasks {
+			t := &l.Tasks[j]
+			t.at = ImportSource{File: file.name, Path: fmt.Sprintf("%s.tasks[%d]", l.at.Path, j)}
+			for k := range t.Comments {
+				t.Comments[k].at = ImportSource{File: file.name, Path: fmt.Sprintf("%s.comments[%d]", t.at.Path, k)}
+			}
+			for k := range t.TimeEntries {
+				t.TimeEntries[k].at = ImportSource{File: file.name, Path: fmt.Sprintf("%s.time_entries[%d]", t.at.Path, k)}
+			}
+		}
+	}
+	return &importBatch{lists: doc.Lists, issues: []ImportIssue{}}, nil
+}
+
+// csvTable is a CSV file read by the column names of its header
+type csvTable struct {
+	file    string
+	columns map[string]int
+	records [][]string
+	lines   []int
+}
+
+func readCSV(file importFile) (*csvTable, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(file.data, []byte("\ufeff"))))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, apperrors.ErrInvalidImport.WithMessage(file.name + " is empty")
+	}
+	if err != nil {
+		return nil, apperrors.ErrInvalidImport.WithMessage(file.name + ": " + err.Error())
+	}
+	t := &csvTable{file: file.name, columns: make(map[string]int, len(header))}
+	for i, name := range header {
+		t.columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return t, nil
+		}
+		if err != nil {
+			return nil, apperrors.ErrInvalidImport.WithMessage(file.name + ": " + err.Error())
+		}
+		line, _ := r.FieldPos(0)
+		t.records = append(t.records, record)
+		t.lines = append(t.lines, line)
+	}
+}
+
+func (t *csvTable) row(i int) *csvRow {
+	return &csvRow{table: t, record: t.records[i], at: ImportSource{File: t.file, Row: t.lines[i]}}
+}
+
+// csvRow reads the cells of one record and keeps the first invalid one
+type csvRow struct {
+	table   *csvTable
+	record  []string
+	at      ImportSource
+	field   string
+	message string
+}
+
+func (r *csvRow) str(column string) string {
+	i, ok := r.table.columns[column]
+	if !ok || i >= len(r.record) {
+		return ""
+	}
+	return r.record[i]
+}
+
+func (r *csvRow) invalid(column, message string) {
+	if r.message == "" {
+		r.field, r.message = column, message
+	}
+}
+
+func (r *csvRow) failed() bool {
+	return r.message != ""
+}
+
+func (r *csvRow) int(column string) int {
+	n := r.intPtr(column)
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+func (r *csvRow) intPtr(column string) *int {
+	raw := strings.TrimSpace(r.str(column))
+	if raw == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		r.invalid(column, "must be a whole number")
+		return nil
+	}
+	return &n
+}
+
+func (r *csvRow) bool(column string) bool {
+	raw := strings.TrimSpace(r.str(column))
+	if raw == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		r.invalid(column, "must be true or false")
+	}
+	return b
+}
+
+func (r *csvRow) time(column string) *time.Time {
+	raw := strings.TrimSpace(r.str(column))
+	if raw == "" {
+		return nil
+	}
+	t, err := parseExportTime(raw)
+	if err != nil {
+		r.invalid(column, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+		return nil
+	}
+	return &t
+}
+
+func (r *csvRow) timeValue(column string) time.Time {
+	if t := r.time(column); t != nil {
+		return *t
+	}
+	return time.Time{}
+}
+
+func (r *csvRow) ints(column string) []int {
+	ids, err := splitInts(r.str(column))
+	if err != nil {
+		r.invalid(column, "must be IDs separated by "+csvSeparator)
+		return []int{}
+	}
+	return ids
+}
+
+// decodeCSV reads tasks.csv and, when present, the other files of a CSV
+// export. Tasks find their list by list_id, or by list_title when lists.csv
+// is missing, as in a hand-written file.
+func decodeCSV(files map[string]importFile) (*importBatch, error) {
+	tasksFile, ok := files[tasksCSVFile]
+	if !ok {
+		return nil, apperrors.ErrInvalidImport.WithMessage("The zip contains no " + tasksCSVFile)
+	}
+	tables := map[string]*csvTable{}
+	for name, f := range files {
+		t, err := readCSV(f)
+		if err != nil {
+			return nil, err
+		}
+		tables[name] = t
+	}
+	if _, ok := tables[tasksCSVFile].columns["title"]; !ok {
+		return nil, apperrors.ErrInvalidImport.WithMessage(tasksFile.name + " has no title column")
+	}
+
+	batch := &importBatch{issues: []ImportIssue{}}
+	byID := map[int]int{}
+	byTitle := map[string]int{}
+	addList := func(list ExportList) int {
+		batch.lists = append(batch.lists, list)
+		i := len(batch.lists) - 1
+		if list.ID != 0 {
+			byID[list.ID] = i
+		}
+		if key := strings.ToLower(strings.TrimSpace(list.Title)); byTitle[key] == 0 {
+			byTitle[key] = i + 1
+		}
+		return i
+	}
+
+	if t := tables[listsCSVFile]; t != nil {
+		for i := range t.records {
+			row := t.row(i)
+			list := ExportList{
+				ID:              row.int("id"),
+				Title:           row.str("title"),
+				Position:        row.str("position"),
+				BlockCompletion: row.bool("block_completion"),
+				CreatedAt:       row.timeValue("created_at"),
+				UpdatedAt:       row.timeValue("updated_at"),
+				at:              row.at,
+			}
+			if row.failed() {
+				batch.fail(row.at, row.field, row.message)
+				continue
+			}
+			addList(list)
+		}
+	}
+
+	if t := tables[statusesCSVFile]; t != nil {
+		positions := map[int][]int{}
+		for i := range t.records {
+			row := t.row(i)
+			listID := row.int("list_id")
+			st := ExportStatus{Name: row.str("name"), Terminal: row.bool("terminal"), WIPLimit: row.int("wip_limit"), Next: []string{}}
+			position := row.int("position")
+			if next := strings.TrimSpace(row.str("next")); next != "" {
+				if err := json.Unmarshal([]byte(next), &st.Next); err != nil {
+					row.invalid("next", "must be a JSON array of status names")
+				}
+			}
+			if row.failed() {
+				batch.fail(row.at, row.field, row.message)
+				continue
+			}
+			idx, ok := byID[listID]
+			if !ok {
+				batch.fail(row.at, "list_id", fmt.Sprintf("no list %d in %s", listID, listsCSVFile))
+				continue
+			}
+			batch.lists[idx].Statuses = append(batch.lists[idx].Statuses, st)
+			positions[idx] = append(positions[idx], position)
+		}
+		for idx, pos := range positions {
+			statuses := batch.lists[idx].Statuses
+			order := make([]int, len(statuses))
+			for i := range order {
+				order[i] = i
+			}
+			sort.SliceStable(order, func(a, b int) bool { return pos[order[a]] < pos[order[b]] })
+			sorted := make([]ExportStatus, len(statuses))
+			for i, j := range order {
+				sorted[i] = statuses[j]
+			}
+			batch.lists[idx].Statuses = sorted
+		}
+	}
+
+	taskAt := map[int][2]int{}
+	t := tables[tasksCSVFile]
+	for i := range t.records {
+		row := t.row(i)
+		listID := row.int("list_id")
+		listTitle := strings.TrimSpace(row.str("list_title"))
+		task := ExportTask{
+			ID:              row.int("id"),
+			Title:           row.str("title"),
+			Description:     row.str("description"),
+			Completed:       row.bool("completed"),
+			CompletedAt:     row.time("completed_at"),
+			Priority:        row.str("priority"),
+			Status:          row.str("status"),
+			Assignees:       row.ints("assignees"),
+			EstimateMinutes: row.intPtr("estimate_minutes"),
+			DueAt:           row.time("due_at"),
+			Recurrence:      row.str("recurrence"),
+			DependsOn:       row.ints("depends_on"),
+			Position:        row.str("position"),
+			CreatedAt:       row.timeValue("created_at"),
+			UpdatedAt:       row.timeValue("updated_at"),
+			at:              row.at,
+		}
+		if row.failed() {
+			batch.fail(row.at, row.field, row.message)
+			continue
+		}
+
+		idx, ok := byID[listID]
+		if !ok || listID == 0 {
+			title := orDefault(listTitle, defaultImportList)
+			if listID == 0 && byTitle[strings.ToLower(title)] != 0 {
+				idx = byTitle[strings.ToLower(title)] - 1
+			} else {
+				idx = addList(ExportList{ID: listID, Title: title, at: row.at})
+			}
+		}
+		batch.lists[idx].Tasks = append(batch.lists[idx].Tasks, task)
+		if task.ID != 0 {
+			taskAt[task.ID] = [2]int{idx, len(batch.lists[idx].Tasks) - 1}
+		}
+	}
+
+	taskOf := func(row *csvRow) *ExportTask {
+		id := row.int("task_id")
+		at, ok := taskAt[id]
+		if !ok {
+			if !row.failed() {
+				row.invalid("task_id", fmt.Sprintf("no task %d in %s", id, tasksCSVFile))
+			}
+			return nil
+		}
+		return &batch.lists[at[0]].Tasks[at[1]]
+	}
+	if t := tables[commentsCSVFile]; t != nil {
+		for i := range t.records {
+			row := t.row(i)
+			comment := ExportComment{
+				ID:        row.int("id"),
+				AuthorID:  row.int("author_id"),
+				Body:      row.str("body"),
+				CreatedAt: row.timeValue("created_at"),
+				EditedAt:  row.time("edited_at"),
+				at:        row.at,
+			}
+			target := taskOf(row)
+			if row.failed() {
+				batch.fail(row.at, row.field, row.message)
+				continue
+			}
+			target.Comments = append(target.Comments, comment)
+		}
+	}
+	if t := tables[timeEntriesCSVFile]; t != nil {
+		for i := range t.records {
+			row := t.row(i)
+			entry := ExportTimeEntry{
+				ID:        row.int("id"),
+				UserID:    row.int("user_id"),
+				StartedAt: row.timeValue("started_at"),
+				EndedAt:   row.time("ended_at"),
+				Note:      row.str("note"),
+				Manual:    row.bool("manual"),
+				at:        row.at,
+			}
+			if d := row.intPtr("duration_seconds"); d != nil {
+				entry.Duration = int64(*d)
+			}
+			target := taskOf(row)
+			if row.failed() {
+				batch.fail(row.at, row.field, row.message)
+				continue
+			}
+			target.TimeEntries = append(target.TimeEntries, entry)
+		}
+	}
+	return batch, nil
+}
+
+var (
+	mdHeading  = regexp.MustCompile(`^#\s+(.+?)\s*#*\s*$`)
+	mdListMeta = regexp.MustCompile(`^<!--\s*(.*?)\s*-->$`)
+	mdCheckbox = regexp.MustCompile(`^\s*[-*+]\s+\[([ xX])\]\s+(.+)$`)
+	mdBullet   = regexp.MustCompile(`^[-*+]\s+(.+)$`)
+	mdField    = regexp.MustCompile(`^\s+[-*+]\s+([a-z_]+):\s*(.*)$`)
+)
+
+// decodeMarkdown reads checklists: every "# " heading starts a list and
+// every "- [ ]" or top-level "- " item is a task. Nested "- key: value"
+// items set task fields and quotes under a task are its description.
+func decodeMarkdown(files []importFile) (*importBatch, error) {
+	batch := &importBatch{issues: []ImportIssue{}}
+	for _, file := range files {
+		var list *ExportList
+		var task *ExportTask
+		described := false
+		finish := func() {
+			if list != nil {
+				batch.lists = append(batch.lists, *list)
+			}
+		}
+		addTask := func(title string, completed bool, line int) {
+			if list == nil {
+				list = &ExportList{Title: titleFromFile(file.name), at: ImportSource{File: file.name, Row: line}}
+			}
+			list.Tasks = append(list.Tasks, ExportTask{Title: title, Completed: completed, at: ImportSource{File: file.name, Row: line}})
+			task = &list.Tasks[len(list.Tasks)-1]
+			described = false
+		}
+
+		text := strings.ReplaceAll(string(bytes.TrimPrefix(file.data, []byte("\ufeff"))), "\r\n", "\n")
+		for i, line := range strings.Split(text, "\n") {
+			n := i + 1
+			trimmed := strings.TrimSpace(line)
+			if m := mdHeading.FindStringSubmatch(line); m != nil {
+				finish()
+				list = &ExportList{Title: m[1], at: ImportSource{File: file.name, Row: n}}
+				task = nil
+				continue
+			}
+			if m := mdCheckbox.FindStringSubmatch(line); m != nil {
+				addTask(m[2], m[1] != " ", n)
+				continue
+			}
+			if m := mdBullet.FindStringSubmatch(line); m != nil {
+				addTask(m[1], false, n)
+				continue
+			}
+			switch {
+			case list != nil && task == nil && mdListMeta.MatchString(trimmed):
+				readListMeta(batch, list, mdListMeta.FindStringSubmatch(trimmed)[1], ImportSource{File: file.name, Row: n})
+			case task != nil && line != trimmed && mdField.MatchString(line):
+				m := mdField.FindStringSubmatch(line)
+				if field, message := setMarkdownField(task, m[1], strings.TrimSpace(m[2])); message != "" {
+					batch.fail(ImportSource{File: file.name, Row: n}, field, message)
+				}
+			case task != nil && line != trimmed && strings.HasPrefix(trimmed, ">"):
+				quoted := strings.TrimPrefix(strings.TrimPrefix(trimmed, ">"), " ")
+				if described {
+					task.Description += "\n"
+				}
+				task.Description += quoted
+				described = true
+			}
+		}
+		finish()
+	}
+	return batch, nil
+}
+
+// readListMeta reads the "id: 3, block_completion: true" comment under a list heading
+func readListMeta(batch *importBatch, list *ExportList, meta string, at ImportSource) {
+	for _, pair := range strings.Split(meta, ",") {
+		key, value, ok := strings.Cut(pair, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "id":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				batch.fail(at, "id", "must be a whole number")
+				continue
+			}
+			list.ID = id
+		case "block_completion":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				batch.fail(at, "block_completion", "must be true or false")
+				continue
+			}
+			list.BlockCompletion = b
+		}
+	}
+}
+
+// setMarkdownField sets a task field from a nested "- key: value" item and
+// returns the field and a message when the value is invalid. Unknown keys
+// are ordinary notes and are ignored.
+func setMarkdownField(task *ExportTask, key, value string) (string, string) {
+	switch key {
+	case "id":
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return key, "must be a whole number"
+		}
+		task.ID = id
+	case "priority":
+		task.Priority = value
+	case "status":
+		task.Status = value
+	case "recurrence":
+		task.Recurrence = value
+	case "estimate_minutes":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return key, "must be a whole number"
+		}
+		task.EstimateMinutes = &n
+	case "due_at", "completed_at":
+		t, err := parseExportTime(value)
+		if err != nil {
+			return key, "must be an RFC 3339 timestamp or a YYYY-MM-DD date"
+		}
+		if key == "due_at" {
+			task.DueAt = &t
+		} else {
+			task.CompletedAt = &t
+		}
+	case "depends_on", "assignees":
+		ids, err := splitInts(value)
+		if err != nil {
+			return key, "must be IDs separated by commas"
+		}
+		if key == "depends_on" {
+			task.DependsOn = ids
+		} else {
+			task.Assignees = ids
+		}
+	}
+	return "", ""
+}
+
+// titleFromFile names the list of a Markdown file without a heading:
+// "003-shopping-list.md" becomes "shopping list"
+func titleFromFile(name string) string {
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	base = strings.TrimLeft(base, "0123456789")
+	base = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' {
+			return ' '
+		}
+		return r
+	}, base))
+	return orDefault(base, defaultImportList)
+}
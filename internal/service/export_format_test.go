@@ -0,0 +1,63 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// zeroZip builds a zip of files that unpack to the given numbers of zero bytes
+func zeroZip(t *testing.T, sizes ...int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, size := range sizes {
+		w, err := zw.Create(fmt.Sprintf("file%d.csv", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnzipLimits(t *testing.T) {
+	many := make([]int, MaxImportEntries+1)
+	tests := []struct {
+		name     string
+		sizes    []int
+		tooLarge bool
+	}{
+		{"within limits", []int{10, MaxImportSize}, false},
+		{"file too large", []int{MaxImportSize + 1}, true},
+		{"total too large", []int{MaxImportSize, MaxImportSize, MaxImportSize, MaxImportSize, MaxImportSize, 1}, true},
+		{"total at the limit", []int{MaxImportSize, MaxImportSize, MaxImportSize, MaxImportSize, MaxImportSize}, false},
+		{"too many entries", many, true},
+		{"entries at the limit", many[1:], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := unzip(zeroZip(t, tt.sizes...))
+			if tt.tooLarge {
+				if !errors.Is(err, apperrors.ErrImportTooLarge) {
+					t.Fatalf("error = %v, want %v", err, apperrors.ErrImportTooLarge)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != len(tt.sizes) {
+				t.Errorf("got %d files, want %d", len(files), len(tt.sizes))
+			}
+		})
+	}
+}
@@ -0,0 +1,860 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// ExportVersion is written to every JSON export; imports refuse newer ones
+	ExportVersion = 1
+	// MaxImportSize limits uploaded files and every file inside an uploaded zip, in bytes
+	MaxImportSize = 10 << 20
+	// MaxImportUnpacked limits all files of an uploaded zip together, in bytes
+	MaxImportUnpacked = 5 * MaxImportSize
+	// MaxImportEntries limits the number of entries in an uploaded zip
+	MaxImportEntries = 1000
+	MaxImportLists   = 1000
+	MaxImportTasks   = 10000
+)
+
+const (
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatMarkdown = "markdown"
+)
+
+// ExportFormats are the formats of account exports and imports
+var ExportFormats = []string{FormatJSON, FormatCSV, FormatMarkdown}
+
+// What an import does with lists and tasks that already exist
+const (
+	// DuplicatesSkip merges lists into existing lists with the same title and
+	// skips tasks with the same title and due date as a task already there
+	DuplicatesSkip = "skip"
+	// DuplicatesCreate always creates new lists and tasks
+	DuplicatesCreate = "create"
+)
+
+// errDryRun rolls back the transaction of a dry-run import
+var errDryRun = errors.New("dry run")
+
+// ExportDocument is a full account export. JSON exports are this document as
+// is; imports of every format are read into it.
+// swagger:model
+type ExportDocument struct {
+	// example: 1
+	Version    int          `json:"version"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Lists      []ExportList `json:"lists"`
+}
+
+type ExportList struct {
+	ID              int            `json:"id"`
+	Title           string         `json:"title"`
+	Position        string         `json:"position"`
+	BlockCompletion bool           `json:"block_completion"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	Statuses        []ExportStatus `json:"statuses"`
+	Tasks           []ExportTask   `json:"tasks"`
+
+	at ImportSource
+}
+
+// ExportStatus is a workflow column; columns are listed in board order
+type ExportStatus struct {
+	Name     string   `json:"name"`
+	Terminal bool     `json:"terminal"`
+	WIPLimit int      `json:"wip_limit"`
+	Next     []string `json:"next"`
+}
+
+type ExportTask struct {
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Completed   bool       `json:"completed"`
+	CompletedAt *time.Time `json:"completed_at"`
+	// example: high
+	Priority string `json:"priority"`
+	// Name of the workflow column, empty when the list has no workflow
+	Status          string     `json:"status"`
+	Assignees       []int      `json:"assignees"`
+	EstimateMinutes *int       `json:"estimate_minutes"`
+	DueAt           *time.Time `json:"due_at"`
+	Recurrence      string     `json:"recurrence"`
+	// IDs of the exported tasks this one waits for
+	DependsOn   []int              `json:"depends_on"`
+	Position    string             `json:"position"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+	Comments    []ExportComment    `json:"comments"`
+	TimeEntries []ExportTimeEntry  `json:"time_entries"`
+	Attachments []ExportAttachment `json:"attachments"`
+
+	at ImportSource
+}
+
+type ExportComment struct {
+	ID        int        `json:"id"`
+	AuthorID  int        `json:"author_id"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+
+	at ImportSource
+}
+
+type ExportTimeEntry struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+	Duration  int64      `json:"duration_seconds"`
+	Note      string     `json:"note"`
+	Manual    bool       `json:"manual"`
+
+	at ImportSource
+}
+
+// ExportAttachment describes an attached file; contents are not exported
+// and attachments are not imported
+type ExportAttachment struct {
+	ID          int       `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Hash        string    `json:"sha256"`
+	UploadedBy  int       `json:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ImportSource points at the place in the imported files a report entry is about
+type ImportSource struct {
+	// example: tasks.csv
+	File string `json:"file"`
+	// Line of a CSV record or a Markdown file
+	// example: 12
+	Row int `json:"row,omitempty"`
+	// Location inside a JSON export
+	// example: lists[0].tasks[3]
+	Path string `json:"path,omitempty"`
+}
+
+// ImportIssue is a row, or a field of a row, that could not be imported
+type ImportIssue struct {
+	ImportSource
+	// example: priority
+	Field string `json:"field,omitempty"`
+	// example: must be one of: none low medium high urgent
+	Message string `json:"message"`
+}
+
+// ImportDuplicate is a list or task that already exists and was not created again
+type ImportDuplicate struct {
+	ImportSource
+	// list or task
+	// example: task
+	Kind  string `json:"kind"`
+	Title string `json:"title"`
+	// example: 42
+	ExistingID int `json:"existing_id"`
+}
+
+// ImportCounts tells what became of the rows of one kind
+type ImportCounts struct {
+	Created int `json:"created"`
+	// Matched something that already exists
+	Duplicates int `json:"duplicates"`
+	Failed     int `json:"failed"`
+}
+
+// ImportIDMap maps the IDs of the imported files to the IDs of the created
+// lists and tasks, or of the existing duplicates they were matched with
+type ImportIDMap struct {
+	Lists map[int]int `json:"lists"`
+	Tasks map[int]int `json:"tasks"`
+}
+
+// ImportResult is the report of an import
+// swagger:model
+type ImportResult struct {
+	// Nothing was saved
+	DryRun bool `json:"dry_run"`
+	// example: json
+	Format       string       `json:"format"`
+	Lists        ImportCounts `json:"lists"`
+	Tasks        ImportCounts `json:"tasks"`
+	Dependencies ImportCounts `json:"dependencies"`
+	Comments     ImportCounts `json:"comments"`
+	TimeEntries  ImportCounts `json:"time_entries"`
+	// Left out of dry runs, where nothing gets an ID
+	IDMap      *ImportIDMap      `json:"id_map,omitempty"`
+	Duplicates []ImportDuplicate `json:"duplicates"`
+	Errors     []ImportIssue     `json:"errors"`
+}
+
+// ImportOptions of POST /import
+type ImportOptions struct {
+	// One of ExportFormats; empty detects the format of a zip from its files
+	Format string
+	// Name of an uploaded single file, used in the report
+	Filename string
+	DryRun   bool
+	// DuplicatesSkip (default) or DuplicatesCreate
+	Duplicates string
+}
+
+type ExportService interface {
+	// Export collects the live lists of the user with their tasks and
+	// everything attached to them
+	Export(userID int) (*ExportDocument, error)
+	// Import reads an export, or a zip of one, and creates its lists and
+	// tasks. Rows that cannot be imported are reported instead of failing
+	// the whole import.
+	Import(userID int, r io.Reader, opts ImportOptions) (*ImportResult, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) ExportService
+	// importBatch imports rows already read from files; progress, when not
+	// nil, is called with the number of tasks processed so far
+	importBatch(userID int, batch *importBatch, opts ImportOptions, progress func(done int)) (*ImportResult, error)
+}
+
+type exportService struct {
+	repo        repository.ExportRepository
+	listRepo    repository.TodoListRepository
+	taskRepo    repository.TaskRepository
+	statusRepo  repository.StatusRepository
+	depRepo     repository.DependencyRepository
+	commentRepo repository.CommentRepository
+	timeRepo    repository.TimeEntryRepository
+	tx          repository.Transactor
+	audit       Auditor
+}
+
+func NewExportService(repo repository.ExportRepository, listRepo repository.TodoListRepository, taskRepo repository.TaskRepository, statusRepo repository.StatusRepository, depRepo repository.DependencyRepository, commentRepo repository.CommentRepository, timeRepo repository.TimeEntryRepository, auditor Auditor, tx repository.Transactor) ExportService {
+	return &exportService{
+		repo:        repo,
+		listRepo:    listRepo,
+		taskRepo:    taskRepo,
+		statusRepo:  statusRepo,
+		depRepo:     depRepo,
+		commentRepo: commentRepo,
+		timeRepo:    timeRepo,
+		tx:          tx,
+		audit:       auditor,
+	}
+}
+
+func (s *exportService) WithActor(actor Actor) ExportService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *exportService) Export(userID int) (*ExportDocument, error) {
+	lists, err := s.listRepo.GetAllLists(userID)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := s.repo.GetStatuses(userID)
+	if err != nil {
+		return nil, err
+	}
+	deps, err := s.repo.GetDependencies(userID)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := s.repo.GetComments(userID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.repo.GetTimeEntries(userID)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := s.repo.GetAttachments(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	statusNames := make(map[int]string, len(statuses))
+	listStatuses := make(map[int][]ExportStatus)
+	for _, st := range statuses {
+		statusNames[st.ID] = st.Name
+		next := st.Next
+		if next == nil {
+			next = []string{}
+		}
+		listStatuses[st.ListID] = append(listStatuses[st.ListID], ExportStatus{Name: st.Name, Terminal: st.Terminal, WIPLimit: st.WIPLimit, Next: next})
+	}
+	dependsOn := make(map[int][]int)
+	for _, dep := range deps {
+		dependsOn[dep.TaskID] = append(dependsOn[dep.TaskID], dep.BlockerID)
+	}
+	taskComments := make(map[int][]ExportComment)
+	for _, c := range comments {
+		taskComments[c.TaskID] = append(taskComments[c.TaskID], ExportComment{
+			ID: c.ID, AuthorID: c.AuthorID, Body: c.Body, CreatedAt: c.CreatedAt, EditedAt: c.EditedAt,
+		})
+	}
+	taskEntries := make(map[int][]ExportTimeEntry)
+	for _, e := range entries {
+		taskEntries[e.TaskID] = append(taskEntries[e.TaskID], ExportTimeEntry{
+			ID: e.ID, UserID: e.UserID, StartedAt: e.StartedAt, EndedAt: e.EndedAt, Duration: e.Duration, Note: e.Note, Manual: e.Manual,
+		})
+	}
+	taskAttachments := make(map[int][]ExportAttachment)
+	for _, a := range attachments {
+		taskAttachments[a.TaskID] = append(taskAttachments[a.TaskID], ExportAttachment{
+			ID: a.ID, Filename: a.Filename, ContentType: a.ContentType, Size: a.Size, Hash: a.Hash, UploadedBy: a.UploadedBy, CreatedAt: a.CreatedAt,
+		})
+	}
+
+	doc := &ExportDocument{Version: ExportVersion, ExportedAt: time.Now().UTC(), Lists: make([]ExportList, 0, len(lists))}
+	for _, list := range lists {
+		exported := ExportList{
+			ID:              list.ID,
+			Title:           list.Title,
+			Position:        list.Position,
+			BlockCompletion: list.BlockCompletion,
+			CreatedAt:       list.CreatedAt,
+			UpdatedAt:       list.UpdatedAt,
+			Statuses:        orEmpty(listStatuses[list.ID]),
+			Tasks:           make([]ExportTask, 0, len(list.Tasks)),
+		}
+		for _, task := range list.Tasks {
+			status := ""
+			if task.StatusID != nil {
+				status = statusNames[*task.StatusID]
+			}
+			exported.Tasks = append(exported.Tasks, ExportTask{
+				ID:              task.ID,
+				Title:           task.Title,
+				Description:     task.Description,
+				Completed:       task.Completed,
+				CompletedAt:     task.CompletedAt,
+				Priority:        task.Priority.String(),
+				Status:          status,
+				Assignees:       orEmpty([]int(task.Assignees)),
+				EstimateMinutes: task.Estimate,
+				DueAt:           task.DueAt,
+				Recurrence:      task.Recurrence,
+				DependsOn:       orEmpty(dependsOn[task.ID]),
+				Position:        task.Position,
+				CreatedAt:       task.CreatedAt,
+				UpdatedAt:       task.UpdatedAt,
+				Comments:        orEmpty(taskComments[task.ID]),
+				TimeEntries:     orEmpty(taskEntries[task.ID]),
+				Attachments:     orEmpty(taskAttachments[task.ID]),
+			})
+		}
+		doc.Lists = append(doc.Lists, exported)
+	}
+	return doc, nil
+}
+
+// orEmpty keeps nil slices from being written as null
+func orEmpty[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+func (s *exportService) Import(userID int, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	var fields []apperrors.FieldError
+	if opts.Format != "" && !validFormat(opts.Format) {
+		fields = append(fields, apperrors.Field("format", "oneof", "must be one of: "+strings.Join(ExportFormats, " ")))
+	}
+	fields = append(fields, checkDuplicates(&opts)...)
+	if len(fields) > 0 {
+		return nil, apperrors.Validation(fields...)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxImportSize+1))
+	if err != nil {
+		return nil, apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if len(data) > MaxImportSize {
+		return nil, apperrors.ErrImportTooLarge.WithMessage(fmt.Sprintf("Imports are limited to %d bytes", MaxImportSize))
+	}
+	batch, err := decodeImport(data, &opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.importBatch(userID, batch, opts, nil)
+}
+
+// checkDuplicates defaults the duplicates option to skip
+func checkDuplicates(opts *ImportOptions) []apperrors.FieldError {
+	switch opts.Duplicates {
+	case "":
+		opts.Duplicates = DuplicatesSkip
+	case DuplicatesSkip, DuplicatesCreate:
+	default:
+		return []apperrors.FieldError{apperrors.Field("duplicates", "oneof", "must be one of: skip create")}
+	}
+	return nil
+}
+
+func (s *exportService) importBatch(userID int, batch *importBatch, opts ImportOptions, progress func(done int)) (*ImportResult, error) {
+	if len(batch.lists) > MaxImportLists || batch.tasks() > MaxImportTasks {
+		return nil, apperrors.ErrInvalidImport.WithMessage(fmt.Sprintf("At most %d lists and %d tasks can be imported at once", MaxImportLists, MaxImportTasks))
+	}
+
+	result := &ImportResult{
+		DryRun:     opts.DryRun,
+		Format:     opts.Format,
+		Duplicates: []ImportDuplicate{},
+		Errors:     orEmpty(batch.issues),
+	}
+	ids := &ImportIDMap{Lists: map[int]int{}, Tasks: map[int]int{}}
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		im := &importer{s: s, tx: tx, userID: userID, opts: opts, result: result, ids: ids, now: time.Now(), progress: progress}
+		if err := im.run(batch.lists); err != nil {
+			return err
+		}
+		if opts.DryRun {
+			// Тот же путь, что и у настоящего импорта, но без сохранения
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	if !opts.DryRun {
+		result.IDMap = ids
+	}
+	return result, nil
+}
+
+func validFormat(format string) bool {
+	for _, f := range ExportFormats {
+		if format == f {
+			return true
+		}
+	}
+	return false
+}
+
+// importer creates the rows of one import inside its transaction
+type importer struct {
+	s      *exportService
+	tx     *gorm.DB
+	userID int
+	opts   ImportOptions
+	result *ImportResult
+	ids    *ImportIDMap
+	now    time.Time
+	// Existing lists by lower-cased title, for duplicate detection
+	existing map[string]*models.TodoList
+	lastList string
+	// Dependencies wait until every task has its new ID
+	deps []importDependency
+	// Access of assignees to the target lists
+	access   map[[2]int]bool
+	progress func(done int)
+	done     int
+}
+
+type importDependency struct {
+	at        ImportSource
+	taskID    int
+	blockerID int
+}
+
+func (im *importer) run(lists []ExportList) error {
+	existing, err := im.s.listRepo.WithTx(im.tx).GetAllLists(im.userID)
+	if err != nil {
+		return err
+	}
+	im.existing = make(map[string]*models.TodoList, len(existing))
+	for i := range existing {
+		key := strings.ToLower(existing[i].Title)
+		if im.existing[key] == nil {
+			im.existing[key] = &existing[i]
+		}
+	}
+	if im.lastList, err = im.s.listRepo.WithTx(im.tx).LastListPosition(im.userID); err != nil {
+		return err
+	}
+	im.access = map[[2]int]bool{}
+
+	for i := range lists {
+		if err := im.importList(&lists[i]); err != nil {
+			return err
+		}
+	}
+	return im.importDependencies()
+}
+
+func (im *importer) advance(tasks int) {
+	im.done += tasks
+	if im.progress != nil && tasks > 0 {
+		im.progress(im.done)
+	}
+}
+
+func (im *importer) fail(at ImportSource, field string, message string) {
+	im.result.Errors = append(im.result.Errors, ImportIssue{ImportSource: at, Field: field, Message: message})
+}
+
+// failWith reports a validation error of a row; other errors abort the import
+func (im *importer) failWith(at ImportSource, prefix string, err error) error {
+	var appErr *apperrors.Error
+	if !errors.As(err, &appErr) {
+		return err
+	}
+	if len(appErr.Fields) == 0 {
+		im.fail(at, "", appErr.Message)
+	}
+	for _, f := range appErr.Fields {
+		field := f.Field
+		if prefix != "" && !strings.HasPrefix(field, prefix) {
+			field = prefix + "." + field
+		}
+		im.fail(at, field, f.Message)
+	}
+	return nil
+}
+
+func (im *importer) importList(item *ExportList) error {
+	title := strings.TrimSpace(item.Title)
+	if title == "" {
+		im.fail(item.at, "title", "list title cannot be empty")
+		im.result.Lists.Failed++
+		im.result.Tasks.Failed += len(item.Tasks)
+		im.advance(len(item.Tasks))
+		return nil
+	}
+
+	var list *models.TodoList
+	var existingTasks map[string]int
+	if im.opts.Duplicates == DuplicatesSkip {
+		list = im.existing[strings.ToLower(title)]
+	}
+	if list != nil {
+		im.result.Lists.Duplicates++
+		im.result.Duplicates = append(im.result.Duplicates, ImportDuplicate{ImportSource: item.at, Kind: "list", Title: title, ExistingID: list.ID})
+		existingTasks = make(map[string]int, len(list.Tasks))
+		for _, task := range list.Tasks {
+			existingTasks[taskKey(task.Title, task.DueAt)] = task.ID
+		}
+	} else {
+		var err error
+		if list, err = im.createList(item, title); err != nil {
+			return err
+		}
+		im.result.Lists.Created++
+	}
+	if item.ID != 0 {
+		im.ids.Lists[item.ID] = list.ID
+	}
+
+	wf, err := loadWorkflow(im.s.statusRepo.WithTx(im.tx), list.ID)
+	if err != nil {
+		return err
+	}
+	last, err := im.s.taskRepo.WithTx(im.tx).LastTaskPosition(list.ID)
+	if err != nil {
+		return err
+	}
+	for i := range item.Tasks {
+		if err := im.importTask(list, wf, existingTasks, &item.Tasks[i], &last); err != nil {
+			return err
+		}
+		im.advance(1)
+	}
+	return nil
+}
+
+// createList creates the list with its workflow. A workflow that does not
+// validate is reported and the list is created without it.
+func (im *importer) createList(item *ExportList, title string) (*models.TodoList, error) {
+	im.lastList = nextPosition(im.lastList)
+	list := &models.TodoList{
+		Title:           title,
+		Position:        im.lastList,
+		UserID:          im.userID,
+		BlockCompletion: item.BlockCompletion,
+		CreatedBy:       im.userID,
+		UpdatedBy:       im.userID,
+	}
+	if err := im.s.listRepo.WithTx(im.tx).CreateList(list); err != nil {
+		return nil, err
+	}
+	if err := im.s.audit.recordList(im.tx, AuditCreate, nil, list); err != nil {
+		return nil, err
+	}
+	if len(item.Statuses) == 0 {
+		return list, nil
+	}
+
+	statuses := make([]models.Status, len(item.Statuses))
+	for i, st := range item.Statuses {
+		statuses[i] = models.Status{Name: st.Name, Terminal: st.Terminal, WIPLimit: st.WIPLimit, Next: append([]string(nil), st.Next...)}
+	}
+	if err := validateStatuses(statuses); err != nil {
+		return list, im.failWith(item.at, "", err)
+	}
+	repo := im.s.statusRepo.WithTx(im.tx)
+	for i := range statuses {
+		statuses[i].ListID = list.ID
+		statuses[i].Position = i
+		if err := repo.CreateStatus(&statuses[i]); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func taskKey(title string, dueAt *time.Time) string {
+	key := strings.ToLower(strings.TrimSpace(title)) + "\x00"
+	if dueAt != nil {
+		key += dueAt.UTC().Format(time.RFC3339)
+	}
+	return key
+}
+
+func (im *importer) importTask(list *models.TodoList, wf *workflow, existingTasks map[string]int, item *ExportTask, last *string) error {
+	title := strings.TrimSpace(item.Title)
+	if err := validateTask(title); err != nil {
+		im.result.Tasks.Failed++
+		return im.failWith(item.at, "", err)
+	}
+	if id, ok := existingTasks[taskKey(title, item.DueAt)]; ok {
+		im.result.Tasks.Duplicates++
+		im.result.Duplicates = append(im.result.Duplicates, ImportDuplicate{ImportSource: item.at, Kind: "task", Title: title, ExistingID: id})
+		if item.ID != 0 {
+			im.ids.Tasks[item.ID] = id
+		}
+		// Зависимости сохраняются и для уже существующих задач
+		im.queueDependencies(item, id)
+		return nil
+	}
+
+	priority := models.PriorityNone
+	if item.Priority != "" {
+		p, err := models.ParsePriority(strings.ToLower(strings.TrimSpace(item.Priority)))
+		if err != nil {
+			im.result.Tasks.Failed++
+			im.fail(item.at, "priority", "must be one of: none low medium high urgent")
+			return nil
+		}
+		priority = p
+	}
+	estimate := 0
+	if item.EstimateMinutes != nil {
+		estimate = *item.EstimateMinutes
+	}
+	due := time.Time{}
+	if item.DueAt != nil {
+		due = *item.DueAt
+	}
+	fields := TaskFields{
+		Title:       &title,
+		Description: &item.Description,
+		Completed:   &item.Completed,
+		Priority:    &priority,
+		Estimate:    &estimate,
+		DueAt:       &due,
+		Recurrence:  &item.Recurrence,
+	}
+	task := &models.Task{ListID: list.ID, CreatedBy: im.userID, UpdatedBy: im.userID}
+	// Колонку подбираем ниже по имени, без проверки переходов и WIP-лимитов
+	if err := fields.apply(task, &workflow{}, im.now); err != nil {
+		im.result.Tasks.Failed++
+		return im.failWith(item.at, "", err)
+	}
+	if wf.enabled() && item.Status != "" {
+		if st := statusByName(wf, item.Status); st != nil {
+			id := st.ID
+			task.StatusID = &id
+		} else {
+			im.fail(item.at, "status", fmt.Sprintf("no status %q in the list, placed by completion", item.Status))
+		}
+	}
+	wf.fit(task, im.now)
+	if task.Completed && item.CompletedAt != nil {
+		task.CompletedAt = item.CompletedAt
+	}
+
+	assignees, err := im.assignees(list.ID, item)
+	if err != nil {
+		return err
+	}
+	task.Assignees = assignees
+	*last = nextPosition(*last)
+	task.Position = *last
+
+	if err := im.s.taskRepo.WithTx(im.tx).CreateTask(task); err != nil {
+		return err
+	}
+	if err := im.s.audit.recordTask(im.tx, AuditCreate, im.userID, nil, task); err != nil {
+		return err
+	}
+	im.result.Tasks.Created++
+	if item.ID != 0 {
+		im.ids.Tasks[item.ID] = task.ID
+	}
+	im.queueDependencies(item, task.ID)
+
+	for i := range item.Comments {
+		if err := im.importComment(task.ID, &item.Comments[i]); err != nil {
+			return err
+		}
+	}
+	for i := range item.TimeEntries {
+		if err := im.importTimeEntry(task.ID, &item.TimeEntries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func statusByName(wf *workflow, name string) *models.Status {
+	for i := range wf.statuses {
+		if strings.EqualFold(wf.statuses[i].Name, strings.TrimSpace(name)) {
+			return &wf.statuses[i]
+		}
+	}
+	return nil
+}
+
+// assignees keeps the assignees who can access the list; the others are reported
+func (im *importer) assignees(listID int, item *ExportTask) (models.UserIDs, error) {
+	kept := models.UserIDs{}
+	var dropped []string
+	for _, id := range item.Assignees {
+		key := [2]int{listID, id}
+		ok, known := im.access[key]
+		if !known {
+			var err error
+			if ok, err = im.s.listRepo.WithTx(im.tx).HasAccess(listID, id); err != nil {
+				return nil, err
+			}
+			im.access[key] = ok
+		}
+		if ok {
+			kept = append(kept, id)
+		} else {
+			dropped = append(dropped, fmt.Sprint(id))
+		}
+	}
+	if len(dropped) > 0 {
+		im.fail(item.at, "assignees", "users "+strings.Join(dropped, ", ")+" cannot access the list and were not assigned")
+	}
+	return kept, nil
+}
+
+func (im *importer) queueDependencies(item *ExportTask, taskID int) {
+	for _, blockerID := range item.DependsOn {
+		im.deps = append(im.deps, importDependency{at: item.at, taskID: taskID, blockerID: blockerID})
+	}
+}
+
+func (im *importer) importComment(taskID int, item *ExportComment) error {
+	body := strings.TrimSpace(item.Body)
+	if body == "" {
+		im.result.Comments.Failed++
+		im.fail(item.at, "body", "comment cannot be empty")
+		return nil
+	}
+	// Автором становится тот, кто импортирует: других пользователей в чужом экспорте нет
+	comment := &models.Comment{TaskID: taskID, AuthorID: im.userID, Body: body, CreatedAt: item.CreatedAt, EditedAt: item.EditedAt}
+	if err := im.s.commentRepo.WithTx(im.tx).CreateComment(comment); err != nil {
+		return err
+	}
+	im.result.Comments.Created++
+	return im.s.audit.recordComment(im.tx, AuditCreate, im.userID, nil, comment)
+}
+
+func (im *importer) importTimeEntry(taskID int, item *ExportTimeEntry) error {
+	switch {
+	case item.EndedAt == nil:
+		im.result.TimeEntries.Failed++
+		im.fail(item.at, "ended_at", "running timers are not imported")
+		return nil
+	case item.StartedAt.IsZero() || item.EndedAt.Before(item.StartedAt):
+		im.result.TimeEntries.Failed++
+		im.fail(item.at, "started_at", "must be before ended_at")
+		return nil
+	}
+	duration := item.Duration
+	if duration <= 0 {
+		duration = int64(item.EndedAt.Sub(item.StartedAt) / time.Second)
+	}
+	entry := &models.TimeEntry{
+		TaskID:    taskID,
+		UserID:    im.userID,
+		StartedAt: item.StartedAt,
+		EndedAt:   item.EndedAt,
+		Duration:  duration,
+		Note:      item.Note,
+		Manual:    item.Manual,
+	}
+	if err := im.s.timeRepo.WithTx(im.tx).CreateEntry(entry); err != nil {
+		return err
+	}
+	im.result.TimeEntries.Created++
+	return im.s.audit.recordTimeEntry(im.tx, AuditCreate, im.userID, nil, entry)
+}
+
+// importDependencies links the imported tasks once all of them have their
+// new IDs. Dependencies on tasks outside the import cannot be resolved.
+func (im *importer) importDependencies() error {
+	if len(im.deps) == 0 {
+		return nil
+	}
+	repo := im.s.depRepo.WithTx(im.tx)
+	if err := repo.LockGraph(im.userID); err != nil {
+		return err
+	}
+	for _, d := range im.deps {
+		blockerID, ok := im.ids.Tasks[d.blockerID]
+		if !ok {
+			im.result.Dependencies.Failed++
+			im.fail(d.at, "depends_on", fmt.Sprintf("task %d is not part of the import", d.blockerID))
+			continue
+		}
+		if blockerID == d.taskID {
+			im.result.Dependencies.Failed++
+			im.fail(d.at, "depends_on", "a task cannot block itself")
+			continue
+		}
+		if _, err := repo.GetDependency(d.taskID, blockerID); err == nil {
+			im.result.Dependencies.Duplicates++
+			continue
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		path, err := blockerPath(repo, blockerID, d.taskID)
+		if err != nil {
+			return err
+		}
+		if path != nil {
+			im.result.Dependencies.Failed++
+			im.fail(d.at, "depends_on", fmt.Sprintf("waiting for task %d would create a cycle", d.blockerID))
+			continue
+		}
+		dep := &models.TaskDependency{TaskID: d.taskID, BlockerID: blockerID, CreatedBy: im.userID}
+		if err := repo.CreateDependency(dep); err != nil {
+			return err
+		}
+		if err := im.s.audit.recordDependency(im.tx, AuditCreate, im.userID, dep); err != nil {
+			return err
+		}
+		im.result.Dependencies.Created++
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"encoding/json"
+	"gorm.io/gorm"
+	"time"
+)
+
+type HistoryService interface {
+	GetTaskHistory(listID int, taskID int, userID int) ([]models.TaskVersion, error)
+	// RevertTask restores title, description, priority, completion and the
+	// workflow column of the task as they were after the given version. A
+	// column that no longer exists is replaced by one matching completion.
+	// The revert is a new version itself.
+	RevertTask(listID int, taskID int, userID int, version int) (*models.Task, error)
+	// Undo reverts the caller's last change in the current session. All task
+	// changes made by the same request (e.g. a batch) are undone together.
+	Undo(userID int) ([]models.TaskVersion, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) HistoryService
+}
+
+type historyService struct {
+	repo       repository.HistoryRepository
+	taskRepo   repository.TaskRepository
+	listRepo   repository.TodoListRepository
+	trashRepo  repository.TrashRepository
+	statusRepo repository.StatusRepository
+	depRepo    repository.DependencyRepository
+	tx         repository.Transactor
+	audit      Auditor
+}
+
+func NewHistoryService(repo repository.HistoryRepository, taskRepo repository.TaskRepository, listRepo repository.TodoListRepository, trashRepo repository.TrashRepository, statusRepo repository.StatusRepository, depRepo repository.DependencyRepository, auditor Auditor, tx repository.Transactor) HistoryService {
+	return &historyService{repo: repo, taskRepo: taskRepo, listRepo: listRepo, trashRepo: trashRepo, statusRepo: statusRepo, depRepo: depRepo, tx: tx, audit: auditor}
+}
+
+func (s *historyService) WithActor(actor Actor) HistoryService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *historyService) GetTaskHistory(listID int, taskID int, userID int) ([]models.TaskVersion, error) {
+	if _, err := taskInList(s.taskRepo, listID, taskID, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetVersions(taskID)
+}
+
+func (s *historyService) RevertTask(listID int, taskID int, userID int, version int) (*models.Task, error) {
+	if version <= 0 {
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid version")
+	}
+	var reverted *models.Task
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		task, err := taskInList(s.taskRepo.WithTx(tx), listID, taskID, userID)
+		if err != nil {
+			return err
+		}
+		v, err := s.repo.WithTx(tx).GetVersion(taskID, version)
+		if err != nil {
+			return notFound(err, apperrors.ErrVersionNotFound)
+		}
+		var snapshot models.Task
+		if err := json.Unmarshal(v.Snapshot, &snapshot); err != nil {
+			return err
+		}
+
+		wf, err := loadWorkflow(s.statusRepo.WithTx(tx), task.ListID)
+		if err != nil {
+			return err
+		}
+
+		before := *task
+		task.Title = snapshot.Title
+		task.Description = snapshot.Description
+		task.Priority = snapshot.Priority
+		task.Estimate = snapshot.Estimate
+		task.DueAt = snapshot.DueAt
+		task.Recurrence = snapshot.Recurrence
+		task.Completed = snapshot.Completed
+		task.CompletedAt = snapshot.CompletedAt
+		task.StatusID = snapshot.StatusID
+		if wf.status(task.StatusID) == nil {
+			task.StatusID = nil
+		}
+		wf.fit(task, time.Now())
+		task.UpdatedBy = userID
+		if err := s.taskRepo.WithTx(tx).UpdateTask(task); err != nil {
+			return err
+		}
+		reverted = task
+		return s.audit.recordTask(tx, AuditRevert, userID, &before, task)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := markBlocked(s.depRepo, reverted); err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+func (s *historyService) Undo(userID int) ([]models.TaskVersion, error) {
+	sessionID := s.audit.actor.SessionID
+	if sessionID == "" {
+		return nil, apperrors.ErrNothingToUndo
+	}
+
+	var undone []models.TaskVersion
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		last, err := repo.LastUndoable(userID, sessionID)
+		if err != nil {
+			return notFound(err, apperrors.ErrNothingToUndo)
+		}
+		versions := []models.TaskVersion{*last}
+		if last.RequestID != "" {
+			if versions, err = repo.UndoableByRequest(userID, sessionID, last.RequestID); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		// От новых изменений к старым, как они и применялись
+		for i := range versions {
+			if err := s.undoVersion(tx, &versions[i], userID); err != nil {
+				return err
+			}
+			if err := repo.MarkUndone(versions[i].ID, now); err != nil {
+				return err
+			}
+			versions[i].UndoneAt = &now
+		}
+		undone = versions
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return undone, nil
+}
+
+// undoVersion brings the task back to its state before v
+func (s *historyService) undoVersion(tx *gorm.DB, v *models.TaskVersion, userID int) error {
+	later, err := s.repo.WithTx(tx).VersionsAfter(v.TaskID, v.Version)
+	if err != nil {
+		return err
+	}
+	for _, l := range later {
+		if l.UndoneAt == nil && l.UndoOf == nil {
+			return apperrors.ErrUndoConflict
+		}
+	}
+
+	taskRepo := s.taskRepo.WithTx(tx)
+	switch v.Action {
+	case AuditCreate, AuditRestore:
+		task, err := taskRepo.GetTaskByID(v.TaskID, userID)
+		if err != nil {
+			return notFound(err, apperrors.ErrUndoConflict)
+		}
+		if err := taskRepo.DeleteTask(task); err != nil {
+			return err
+		}
+		return s.audit.recordTaskChange(tx, AuditDelete, userID, task, nil, v.ID)
+	case AuditDelete:
+		trashRepo := s.trashRepo.WithTx(tx)
+		task, err := trashRepo.GetDeletedTask(v.TaskID, userID)
+		if err != nil {
+			return notFound(err, apperrors.ErrUndoConflict)
+		}
+		deleted, err := trashRepo.IsListDeleted(task.ListID)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			return apperrors.ErrListInTrash
+		}
+		if err := trashRepo.RestoreTask(task); err != nil {
+			return err
+		}
+		return s.audit.recordTaskChange(tx, AuditRestore, userID, nil, task, v.ID)
+	default:
+		task, err := taskRepo.GetTaskByID(v.TaskID, userID)
+		if err != nil {
+			return notFound(err, apperrors.ErrUndoConflict)
+		}
+		restored, err := withOldValues(task, v.Changes)
+		if err != nil {
+			return err
+		}
+		if restored.ListID != task.ListID {
+			if _, err := s.listRepo.WithTx(tx).GetListByID(restored.ListID, userID); err != nil {
+				return notFound(err, apperrors.ErrUndoConflict)
+			}
+		}
+		wf, err := loadWorkflow(s.statusRepo.WithTx(tx), restored.ListID)
+		if err != nil {
+			return err
+		}
+		// Колонку могли удалить после изменения
+		if restored.StatusID != nil && wf.status(restored.StatusID) == nil {
+			return apperrors.ErrUndoConflict
+		}
+		wf.fit(restored, time.Now())
+		restored.UpdatedBy = userID
+		if err := taskRepo.UpdateTask(restored); err != nil {
+			return err
+		}
+		return s.audit.recordTaskChange(tx, AuditUpdate, userID, task, restored, v.ID)
+	}
+}
+
+// withOldValues returns a copy of task with the "old" side of changes applied
+func withOldValues(task *models.Task, changes json.RawMessage) (*models.Task, error) {
+	var diff map[string]auditChange
+	if err := json.Unmarshal(changes, &diff); err != nil {
+		return nil, err
+	}
+	fields, err := jsonFields(task)
+	if err != nil {
+		return nil, err
+	}
+	for key, change := range diff {
+		fields[key] = change.Old
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var restored models.Task
+	if err := json.Unmarshal(data, &restored); err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxUnfinishedImports is how many import jobs a user may have waiting or running
+	MaxUnfinishedImports = 5
+	// MaxImportAttempts is how many times a job is started before it is
+	// failed, e.g. when it keeps crashing the worker
+	MaxImportAttempts = 3
+	// importJobsShown is how many of the latest jobs GetJobs returns
+	importJobsShown = 50
+	// A running job that reported no progress for this long is taken over by
+	// another worker
+	importJobStale = 10 * time.Minute
+	// Progress is saved at most this often
+	importProgressInterval = time.Second
+)
+
+// ImportJobOptions describes an uploaded export of another app
+type ImportJobOptions struct {
+	Source     string
+	Filename   string
+	DryRun     bool
+	Duplicates string
+}
+
+type ImportJobService interface {
+	// QueueImport stores the file and leaves the import to a worker
+	QueueImport(userID int, r io.Reader, opts ImportJobOptions) (*models.ImportJob, error)
+	GetJob(jobID int, userID int) (*models.ImportJob, error)
+	GetJobs(userID int) ([]models.ImportJob, error)
+	// DeleteJob deletes a job that is not running: a pending job is cancelled
+	DeleteJob(jobID int, userID int) error
+	// RunNext runs the oldest waiting job; false when there was none
+	RunNext() (bool, error)
+	// FailStale fails the jobs whose worker died too many times
+	FailStale() (int64, error)
+	PurgeFinished(retention time.Duration) (int64, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) ImportJobService
+}
+
+type importJobService struct {
+	repo    repository.ImportJobRepository
+	exports ExportService
+	actor   Actor
+}
+
+func NewImportJobService(repo repository.ImportJobRepository, exports ExportService) ImportJobService {
+	return &importJobService{repo: repo, exports: exports}
+}
+
+func (s *importJobService) WithActor(actor Actor) ImportJobService {
+	cp := *s
+	cp.actor = actor
+	return &cp
+}
+
+func (s *importJobService) QueueImport(userID int, r io.Reader, opts ImportJobOptions) (*models.ImportJob, error) {
+	var fields []apperrors.FieldError
+	if !validSource(opts.Source) {
+		fields = append(fields, apperrors.Field("source", "oneof", "must be one of: "+strings.Join(ImportSources, " ")))
+	}
+	importOpts := ImportOptions{Duplicates: opts.Duplicates}
+	fields = append(fields, checkDuplicates(&importOpts)...)
+	if len(fields) > 0 {
+		return nil, apperrors.Validation(fields...)
+	}
+
+	unfinished, err := s.repo.CountUnfinished(userID)
+	if err != nil {
+		return nil, err
+	}
+	if unfinished >= MaxUnfinishedImports {
+		return nil, apperrors.ErrTooManyImports
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxImportSize+1))
+	if err != nil {
+		return nil, apperrors.ErrInvalidBody.Wrap(err)
+	}
+	if len(data) > MaxImportSize {
+		return nil, apperrors.ErrImportTooLarge.WithMessage(fmt.Sprintf("Imports are limited to %d bytes", MaxImportSize))
+	}
+	if len(data) == 0 {
+		return nil, apperrors.ErrInvalidImport.WithMessage("The file is empty")
+	}
+
+	job := &models.ImportJob{
+		UserID:     userID,
+		Source:     opts.Source,
+		Filename:   opts.Filename,
+		DryRun:     opts.DryRun,
+		Duplicates: importOpts.Duplicates,
+		Status:     models.ImportJobPending,
+		Data:       data,
+		IP:         s.actor.IP,
+		RequestID:  s.actor.RequestID,
+	}
+	if err := s.repo.CreateJob(job); err != nil {
+		return nil, err
+	}
+	job.Data = nil
+	return job, nil
+}
+
+func validSource(source string) bool {
+	for _, s := range ImportSources {
+		if source == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *importJobService) GetJob(jobID int, userID int) (*models.ImportJob, error) {
+	job, err := s.repo.GetJob(jobID, userID)
+	if err != nil {
+		return nil, notFound(err, apperrors.ErrImportJobNotFound)
+	}
+	setPercent(job)
+	return job, nil
+}
+
+func (s *importJobService) GetJobs(userID int) ([]models.ImportJob, error) {
+	jobs, err := s.repo.GetJobs(userID, importJobsShown)
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs {
+		setPercent(&jobs[i])
+	}
+	return jobs, nil
+}
+
+func setPercent(job *models.ImportJob) {
+	switch {
+	case job.Status == models.ImportJobSucceeded:
+		job.Percent = 100
+	case job.Total > 0:
+		job.Percent = job.Processed * 100 / job.Total
+	}
+}
+
+func (s *importJobService) DeleteJob(jobID int, userID int) error {
+	job, err := s.repo.GetJob(jobID, userID)
+	if err != nil {
+		return notFound(err, apperrors.ErrImportJobNotFound)
+	}
+	if job.Status == models.ImportJobRunning {
+		return apperrors.ErrImportJobRunning
+	}
+	return s.repo.DeleteJob(job)
+}
+
+func (s *importJobService) RunNext() (bool, error) {
+	job, err := s.repo.ClaimJob(time.Now().Add(-importJobStale), MaxImportAttempts)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	result, runErr := s.run(job)
+	now := time.Now()
+	job.Status = models.ImportJobSucceeded
+	job.FinishedAt = &now
+	var appErr *apperrors.Error
+	switch {
+	case runErr == nil:
+		job.Processed = job.Total
+		if job.Result, err = json.Marshal(result); err != nil {
+			return true, err
+		}
+	case errors.As(runErr, &appErr):
+		job.Status, job.Error = models.ImportJobFailed, appErr.Message
+	default:
+		// Подробности внутренней ошибки пользователю не показываем
+		job.Status, job.Error = models.ImportJobFailed, "The import failed unexpectedly"
+	}
+	if err := s.repo.FinishJob(job); err != nil {
+		return true, err
+	}
+	if job.Status == models.ImportJobFailed && appErr == nil {
+		return true, fmt.Errorf("import job %d: %w", job.ID, runErr)
+	}
+	return true, nil
+}
+
+// run reads the file of the job and imports it on behalf of the user who
+// queued it, saving the progress as it goes
+func (s *importJobService) run(job *models.ImportJob) (*ImportResult, error) {
+	batch, err := decodeSource(job.Source, job.Data, job.Filename)
+	if err != nil {
+		return nil, err
+	}
+	job.Total = batch.tasks()
+	if err := s.repo.UpdateProgress(job.ID, 0, job.Total); err != nil {
+		return nil, err
+	}
+
+	saved := time.Now()
+	progress := func(done int) {
+		if time.Since(saved) < importProgressInterval {
+			return
+		}
+		saved = time.Now()
+		// Прогресс только для показа, сбой записи не прерывает импорт
+		_ = s.repo.UpdateProgress(job.ID, done, job.Total)
+	}
+	opts := ImportOptions{Format: job.Source, Filename: job.Filename, DryRun: job.DryRun, Duplicates: job.Duplicates}
+	actor := Actor{UserID: job.UserID, IP: job.IP, RequestID: job.RequestID}
+	return s.exports.WithActor(actor).importBatch(job.UserID, batch, opts, progress)
+}
+
+func (s *importJobService) FailStale() (int64, error) {
+	return s.repo.FailStale(time.Now().Add(-importJobStale), MaxImportAttempts, "The import was interrupted too many times")
+}
+
+func (s *importJobService) PurgeFinished(retention time.Duration) (int64, error) {
+	return s.repo.DeleteFinished(time.Now().Add(-retention))
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Microsoft Graph writes dateTime without a zone, with up to seven digits
+// of fractional seconds
+var graphDateLayouts = []string{"2006-01-02T15:04:05.9999999", time.RFC3339Nano}
+
+// Outlook writes CSV dates in the format of the exporting machine
+var outlookDateLayouts = []string{"1/2/2006", "2006-01-02", "2.1.2006", "02.01.2006"}
+
+// decodeMicrosoftToDo reads lists with their tasks as returned by the To Do
+// API of Microsoft Graph, or the tasks CSV exported from Outlook
+func decodeMicrosoftToDo(batch *importBatch, file importFile) error {
+	if isJSON(file.data) {
+		return decodeGraphToDo(batch, file)
+	}
+	return decodeOutlookCSV(batch, file)
+}
+
+type graphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+// time reads the value in its zone. To Do keeps due dates as midnight, which
+// stands for the whole day.
+func (d *graphDateTime) time(dueDate bool) (*time.Time, bool) {
+	if d == nil || d.DateTime == "" {
+		return nil, true
+	}
+	t, ok := parseDateIn(d.DateTime, location(d.TimeZone), graphDateLayouts...)
+	if !ok {
+		return nil, false
+	}
+	if dueDate && t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+		t = endOfDay(t)
+	}
+	return &t, true
+}
+
+type graphTask struct {
+	Title string `json:"title"`
+	Body  *struct {
+		Content     string `json:"content"`
+		ContentType string `json:"contentType"`
+	} `json:"body"`
+	Importance        string         `json:"importance"`
+	Status            string         `json:"status"`
+	DueDateTime       *graphDateTime `json:"dueDateTime"`
+	CompletedDateTime *graphDateTime `json:"completedDateTime"`
+	Recurrence        *struct {
+		Pattern struct {
+			Type       string   `json:"type"`
+			Interval   int      `json:"interval"`
+			DaysOfWeek []string `json:"daysOfWeek"`
+			DayOfMonth int      `json:"dayOfMonth"`
+			Month      int      `json:"month"`
+			Index      string   `json:"index"`
+		} `json:"pattern"`
+	} `json:"recurrence"`
+	ChecklistItems []struct {
+		DisplayName string `json:"displayName"`
+		IsChecked   bool   `json:"isChecked"`
+	} `json:"checklistItems"`
+	Categories []string `json:"categories"`
+}
+
+type graphList struct {
+	DisplayName string `json:"displayName"`
+	// Tasks is an array, or a collection with the array in value
+	Tasks json.RawMessage `json:"tasks"`
+}
+
+// graphCollection is the shape Graph returns collections in
+type graphCollection[T any] struct {
+	Value []T `json:"value"`
+	Lists []T `json:"lists"`
+}
+
+// graphLists accepts an array of lists, a collection of them or a single list
+func graphLists(data []byte) ([]graphList, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var lists []graphList
+		err := json.Unmarshal(data, &lists)
+		return lists, err
+	}
+	var c graphCollection[graphList]
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c.Value != nil || c.Lists != nil {
+		return append(c.Value, c.Lists...), nil
+	}
+	var list graphList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	if list.DisplayName == "" && list.Tasks == nil {
+		return nil, nil
+	}
+	return []graphList{list}, nil
+}
+
+func (l graphList) tasks() ([]graphTask, error) {
+	data := bytes.TrimSpace(l.Tasks)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil, nil
+	}
+	if data[0] == '[' {
+		var tasks []graphTask
+		err := json.Unmarshal(data, &tasks)
+		return tasks, err
+	}
+	var c graphCollection[graphTask]
+	err := json.Unmarshal(data, &c)
+	return c.Value, err
+}
+
+var graphWeekdays = map[string]string{
+	"sunday": "SU", "monday": "MO", "tuesday": "TU", "wednesday": "WE",
+	"thursday": "TH", "friday": "FR", "saturday": "SA",
+}
+
+var graphIndexes = map[string]string{"first": "1", "second": "2", "third": "3", "fourth": "4", "last": "-1"}
+
+// graphRecurrence turns a Graph recurrence pattern into an RRULE
+func graphRecurrence(t *graphTask) (string, bool) {
+	p := t.Recurrence.Pattern
+	relative := strings.HasPrefix(p.Type, "relative")
+	var days []string
+	for _, day := range p.DaysOfWeek {
+		d, ok := graphWeekdays[strings.ToLower(day)]
+		if !ok {
+			return "", false
+		}
+		// Graph заполняет index и для остальных типов, учитываем его только здесь
+		if relative {
+			d = graphIndexes[strings.ToLower(p.Index)] + d
+		}
+		days = append(days, d)
+	}
+	if relative && len(days) == 0 || strings.HasPrefix(p.Type, "absolute") && p.DayOfMonth == 0 {
+		return "", false
+	}
+
+	var parts []string
+	switch p.Type {
+	case "daily":
+		parts = []string{"FREQ=DAILY"}
+	case "weekly":
+		parts = []string{"FREQ=WEEKLY"}
+		if len(days) > 0 {
+			parts = append(parts, "BYDAY="+strings.Join(days, ","))
+		}
+	case "absoluteMonthly":
+		parts = []string{"FREQ=MONTHLY", fmt.Sprintf("BYMONTHDAY=%d", p.DayOfMonth)}
+	case "relativeMonthly":
+		parts = []string{"FREQ=MONTHLY", "BYDAY=" + strings.Join(days, ",")}
+	case "absoluteYearly":
+		parts = []string{"FREQ=YEARLY", fmt.Sprintf("BYMONTH=%d", p.Month), fmt.Sprintf("BYMONTHDAY=%d", p.DayOfMonth)}
+	case "relativeYearly":
+		parts = []string{"FREQ=YEARLY", fmt.Sprintf("BYMONTH=%d", p.Month), "BYDAY=" + strings.Join(days, ",")}
+	default:
+		return "", false
+	}
+	if p.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", p.Interval))
+	}
+	return strings.Join(parts, ";"), true
+}
+
+func graphPriority(importance string) string {
+	switch strings.ToLower(importance) {
+	case "high":
+		return "high"
+	case "low":
+		return "low"
+	}
+	return "none"
+}
+
+func decodeGraphToDo(batch *importBatch, file importFile) error {
+	lists, err := graphLists(bytes.TrimPrefix(file.data, []byte("\ufeff")))
+	if err != nil {
+		return apperrors.ErrInvalidImport.WithMessage(file.name + ": " + err.Error())
+	}
+	if len(lists) == 0 {
+		return apperrors.ErrInvalidImport.WithMessage(file.name + " is not a Microsoft To Do export: it has no lists")
+	}
+
+	for i, l := range lists {
+		at := ImportSource{File: file.name, Path: fmt.Sprintf("lists[%d]", i)}
+		tasks, err := l.tasks()
+		if err != nil {
+			return apperrors.ErrInvalidImport.WithMessage(fmt.Sprintf("%s: %s.tasks: %s", file.name, at.Path, err))
+		}
+		list := ExportList{Title: orDefault(strings.TrimSpace(l.DisplayName), listTitleFromFile(file.name)), at: at}
+		for j := range tasks {
+			t := &tasks[j]
+			task := ExportTask{
+				ID:        batch.newID(),
+				Title:     t.Title,
+				Priority:  graphPriority(t.Importance),
+				Completed: t.Status == "completed",
+				at:        ImportSource{File: file.name, Path: fmt.Sprintf("%s.tasks[%d]", at.Path, j)},
+			}
+			var checklist []checkItem
+			for _, item := range t.ChecklistItems {
+				checklist = append(checklist, checkItem{title: item.DisplayName, done: item.IsChecked})
+			}
+			description := ""
+			// HTML-тело не разбираем, в описание идёт только текст
+			if t.Body != nil && strings.EqualFold(t.Body.ContentType, "text") {
+				description = t.Body.Content
+			}
+			task.Description = withExtras(description, t.Categories, checklist)
+
+			due, ok := t.DueDateTime.time(true)
+			if !ok {
+				batch.fail(task.at, "dueDateTime", fmt.Sprintf("%q is not a date that can be imported, the task has no due date", t.DueDateTime.DateTime))
+			}
+			task.DueAt = due
+			if completed, _ := t.CompletedDateTime.time(false); completed != nil && task.Completed {
+				task.CompletedAt = completed
+			}
+			if t.Recurrence != nil {
+				rule, ok := graphRecurrence(t)
+				switch {
+				case !ok:
+					batch.fail(task.at, "recurrence", fmt.Sprintf("%q recurrences cannot be imported, the task does not repeat", t.Recurrence.Pattern.Type))
+				case task.DueAt == nil:
+					batch.fail(task.at, "recurrence", "the task has no due date and does not repeat")
+				default:
+					task.Recurrence = rule
+				}
+			}
+			list.Tasks = append(list.Tasks, task)
+		}
+		batch.lists = append(batch.lists, list)
+	}
+	return nil
+}
+
+// decodeOutlookCSV reads the Subject, Due Date, Date Completed, Categories,
+// Notes, Priority and Status columns of tasks exported from Outlook. The
+// file has no lists, so they go to a list named after the file.
+func decodeOutlookCSV(batch *importBatch, file importFile) error {
+	t, err := readCSV(file)
+	if err != nil {
+		return err
+	}
+	if _, ok := t.columns["subject"]; !ok {
+		return apperrors.ErrInvalidImport.WithMessage(file.name + " is not an Outlook tasks CSV export: the Subject column is missing")
+	}
+
+	list := ExportList{Title: listTitleFromFile(file.name), at: ImportSource{File: file.name, Row: 1}}
+	for i := range t.records {
+		row := t.row(i)
+		task := ExportTask{
+			ID:       batch.newID(),
+			Title:    row.str("subject"),
+			Priority: graphPriority(strings.TrimSpace(row.str("priority"))),
+			at:       row.at,
+		}
+		status := strings.ToLower(strings.TrimSpace(row.str("status")))
+		task.Completed = status == "completed" || status == "complete" || strings.TrimSpace(row.str("% complete")) == "100%"
+
+		var labels []string
+		for _, c := range strings.Split(row.str("categories"), ";") {
+			if c = strings.TrimSpace(c); c != "" {
+				labels = append(labels, c)
+			}
+		}
+		task.Description = withExtras(row.str("notes"), labels, nil)
+
+		if raw := strings.TrimSpace(row.str("due date")); raw != "" {
+			if due, ok := parseDateIn(raw, time.UTC, outlookDateLayouts...); ok {
+				task.DueAt = &due
+			} else {
+				batch.fail(row.at, "due date", fmt.Sprintf("%q is not a date that can be imported, the task has no due date", raw))
+			}
+		}
+		if raw := strings.TrimSpace(row.str("date completed")); raw != "" && task.Completed {
+			if done, ok := parseDateIn(raw, time.UTC, outlookDateLayouts...); ok {
+				task.CompletedAt = &done
+			}
+		}
+		list.Tasks = append(list.Tasks, task)
+	}
+	batch.lists = append(batch.lists, list)
+	return nil
+}
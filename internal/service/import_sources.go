@@ -0,0 +1,162 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Apps whose exports can be imported by an import job
+const (
+	SourceTodoist       = "todoist"
+	SourceTrello        = "trello"
+	SourceMicrosoftToDo = "microsoft_todo"
+)
+
+var ImportSources = []string{SourceTodoist, SourceTrello, SourceMicrosoftToDo}
+
+// sourceDecoder adds the lists of one export file of an app to the batch
+type sourceDecoder func(batch *importBatch, file importFile) error
+
+var sourceDecoders = map[string]sourceDecoder{
+	SourceTodoist:       decodeTodoist,
+	SourceTrello:        decodeTrello,
+	SourceMicrosoftToDo: decodeMicrosoftToDo,
+}
+
+// decodeSource reads the export of an app: a single JSON or CSV file, or a
+// zip of them such as a Todoist backup with a CSV file per project
+func decodeSource(source string, data []byte, filename string) (*importBatch, error) {
+	decode, ok := sourceDecoders[source]
+	if !ok {
+		return nil, fmt.Errorf("unknown import source %q", source)
+	}
+	files := []importFile{{name: orDefault(filename, source), data: data}}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		var err error
+		if files, err = unzip(data); err != nil {
+			return nil, err
+		}
+	}
+
+	batch := &importBatch{issues: []ImportIssue{}}
+	read := 0
+	for _, file := range files {
+		if len(files) > 1 {
+			// Из архива берём только экспортированные данные, остальное пропускаем
+			if ext := strings.ToLower(path.Ext(file.name)); ext != ".json" && ext != ".csv" {
+				continue
+			}
+		}
+		if err := decode(batch, file); err != nil {
+			return nil, err
+		}
+		read++
+	}
+	if read == 0 {
+		return nil, apperrors.ErrInvalidImport.WithMessage("The zip contains no JSON or CSV files")
+	}
+	return batch, nil
+}
+
+// isJSON tells JSON files from CSV ones by their first character
+func isJSON(data []byte) bool {
+	data = bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\ufeff")), " \t\r\n")
+	return len(data) > 0 && (data[0] == '{' || data[0] == '[')
+}
+
+func (b *importBatch) newID() int {
+	b.lastID++
+	return b.lastID
+}
+
+// checkItem is an item of a checklist, which tasks do not have; checklists
+// are kept in the task description
+type checkItem struct {
+	title string
+	done  bool
+}
+
+// withExtras appends what tasks have no field for to the description:
+// labels and a Markdown checklist
+func withExtras(description string, labels []string, checklist []checkItem) string {
+	parts := []string{}
+	if d := strings.TrimSpace(description); d != "" {
+		parts = append(parts, d)
+	}
+	if len(labels) > 0 {
+		parts = append(parts, "Labels: "+strings.Join(labels, ", "))
+	}
+	if len(checklist) > 0 {
+		var b strings.Builder
+		for i, item := range checklist {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			mark := " "
+			if item.done {
+				mark = "x"
+			}
+			b.WriteString("- [" + mark + "] " + oneLine(item.title))
+		}
+		parts = append(parts, b.String())
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// endOfDay is the due time of a task due on a date: the last second of it
+func endOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 23, 59, 59, 0, t.Location())
+}
+
+// parseDateIn tries the layouts in order. Layouts without a time give the
+// end of the day, and times without a zone are read in loc.
+func parseDateIn(raw string, loc *time.Location, layouts ...string) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, raw, loc)
+		if err != nil {
+			continue
+		}
+		if !strings.Contains(layout, ":") {
+			t = endOfDay(t)
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// location loads an IANA zone, falling back to UTC for unknown ones such as
+// Windows zone names
+func location(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	if loc, err := time.LoadLocation(name); err == nil && name != "Local" {
+		return loc
+	}
+	return time.UTC
+}
+
+var fileIDSuffix = regexp.MustCompile(`\s*\[\d+\]$`)
+
+// listTitleFromFile names a list after an export file: "Work [2203306141].csv"
+// becomes "Work"
+func listTitleFromFile(name string) string {
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	return orDefault(strings.TrimSpace(fileIDSuffix.ReplaceAllString(base, "")), defaultImportList)
+}
+
+// truncate cuts s to at most n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+const todoistJSON = `{
+	"projects": [{"id": "1", "name": "Home"}],
+	"sections": [{"id": "10", "project_id": "1", "name": "Kitchen"}],
+	"items": [
+		{"id": "100", "project_id": "1", "content": "Paint the fence", "priority": 4, "labels": ["weekend"],
+		 "due": {"date": "2026-03-14", "string": "every saturday", "is_recurring": true}, "child_order": 1},
+		{"id": "101", "project_id": "1", "section_id": "10", "content": "Buy milk", "checked": true, "child_order": 2},
+		{"id": "102", "project_id": "1", "content": "Buy paint", "parent_id": "100", "child_order": 3}
+	],
+	"notes": [{"item_id": 100, "content": "White, not grey"}]
+}`
+
+const todoistCSV = "TYPE,CONTENT,DESCRIPTION,PRIORITY,INDENT,AUTHOR,RESPONSIBLE,DATE,DATE_LANG,TIMEZONE\r\n" +
+	"task,Call mom @family,,1,1,,,every sunday,en,Europe/Berlin\r\n"
+
+const trelloJSON = `{
+	"name": "Sprint",
+	"lists": [{"id": "l1", "name": "To Do", "pos": 1}, {"id": "l2", "name": "Done", "pos": 2}, {"id": "l3", "name": "Old", "closed": true, "pos": 3}],
+	"cards": [
+		{"id": "c1", "name": "Write docs", "desc": "API docs", "idList": "l1", "pos": 1, "due": "2026-03-14T12:00:00.000Z",
+		 "labels": [{"name": "", "color": "green"}]},
+		{"id": "c2", "name": "Ship", "idList": "l2", "pos": 2},
+		{"id": "c3", "name": "Forgotten", "idList": "l3", "pos": 3}
+	],
+	"checklists": [{"idCard": "c1", "name": "Steps", "checkItems": [{"name": "Draft", "state": "complete", "pos": 1}]}],
+	"actions": [{"type": "commentCard", "date": "2026-03-01T10:00:00Z", "data": {"text": "Looks good", "card": {"id": "c1"}},
+		"memberCreator": {"fullName": "Jane"}}]
+}`
+
+const graphJSON = `{"value": [{
+	"displayName": "Errands",
+	"tasks": {"value": [
+		{"title": "Renew passport", "importance": "high", "status": "notStarted",
+		 "dueDateTime": {"dateTime": "2026-03-14T00:00:00.0000000", "timeZone": "UTC"},
+		 "body": {"content": "Photos first", "contentType": "text"},
+		 "checklistItems": [{"displayName": "Photos", "isChecked": false}]},
+		{"title": "Water plants", "status": "completed",
+		 "dueDateTime": {"dateTime": "2026-03-10T00:00:00.0000000", "timeZone": "UTC"},
+		 "recurrence": {"pattern": {"type": "weekly", "interval": 1, "daysOfWeek": ["monday"]}}}
+	]}
+}]}`
+
+func TestDecodeSources(t *testing.T) {
+	tests := []struct {
+		name, source, filename, data string
+		// Titles of the lists and of their tasks
+		lists  map[string][]string
+		issues int
+	}{
+		{
+			name: "todoist json", source: SourceTodoist, filename: "todoist.json", data: todoistJSON,
+			lists: map[string][]string{"Home": {"Paint the fence", "Buy paint"}, "Home / Kitchen": {"Buy milk"}},
+		},
+		{
+			name: "todoist csv", source: SourceTodoist, filename: "Family.csv", data: todoistCSV,
+			lists: map[string][]string{"Family": {"Call mom"}},
+		},
+		{
+			name: "trello json", source: SourceTrello, filename: "board.json", data: trelloJSON,
+			lists: map[string][]string{"Sprint": {"Write docs", "Ship"}},
+			// Карточка из архивного списка
+			issues: 1,
+		},
+		{
+			name: "microsoft to do json", source: SourceMicrosoftToDo, filename: "todo.json", data: graphJSON,
+			lists: map[string][]string{"Errands": {"Renew passport", "Water plants"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, err := decodeSource(tt.source, []byte(tt.data), tt.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string][]string{}
+			for _, list := range batch.lists {
+				titles := []string{}
+				for _, task := range list.Tasks {
+					titles = append(titles, task.Title)
+				}
+				got[list.Title] = titles
+			}
+			if len(got) != len(tt.lists) {
+				t.Fatalf("lists = %v, want %v", got, tt.lists)
+			}
+			for title, tasks := range tt.lists {
+				if len(got[title]) != len(tasks) {
+					t.Errorf("list %q has tasks %v, want %v", title, got[title], tasks)
+					continue
+				}
+				for i := range tasks {
+					if got[title][i] != tasks[i] {
+						t.Errorf("list %q has tasks %v, want %v", title, got[title], tasks)
+						break
+					}
+				}
+			}
+			if len(batch.issues) != tt.issues {
+				t.Errorf("issues = %+v, want %d", batch.issues, tt.issues)
+			}
+		})
+	}
+}
+
+// What the importers map besides titles
+func TestDecodeSourceFields(t *testing.T) {
+	batch, err := decodeSource(SourceTodoist, []byte(todoistJSON), "todoist.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fence := batch.lists[0].Tasks[0]
+	if fence.Priority != "urgent" || fence.DueAt == nil || fence.Recurrence == "" ||
+		len(fence.Comments) != 1 || len(fence.DependsOn) != 1 || fence.Description != "Labels: weekend" {
+		t.Errorf("unexpected Todoist task %+v", fence)
+	}
+	if milk := batch.lists[1].Tasks[0]; !milk.Completed {
+		t.Errorf("checked item is not completed: %+v", milk)
+	}
+
+	batch, err = decodeSource(SourceTrello, []byte(trelloJSON), "board.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, ship := batch.lists[0].Tasks[0], batch.lists[0].Tasks[1]
+	if docs.Status != "To Do" || docs.DueAt == nil || len(docs.Comments) != 1 || docs.Comments[0].Body != "Jane: Looks good" ||
+		docs.Description != "API docs\n\nLabels: green\n\n- [x] Draft" {
+		t.Errorf("unexpected Trello card %+v", docs)
+	}
+	if !ship.Completed || ship.Status != "Done" {
+		t.Errorf("card in Done is not completed: %+v", ship)
+	}
+
+	batch, err = decodeSource(SourceMicrosoftToDo, []byte(graphJSON), "todo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passport, plants := batch.lists[0].Tasks[0], batch.lists[0].Tasks[1]
+	if passport.Priority != "high" || passport.DueAt == nil || passport.DueAt.Hour() != 23 ||
+		passport.Description != "Photos first\n\n- [ ] Photos" {
+		t.Errorf("unexpected To Do task %+v", passport)
+	}
+	if !plants.Completed || plants.Recurrence == "" {
+		t.Errorf("unexpected To Do task %+v", plants)
+	}
+}
+
+func TestDecodeSourceMalformed(t *testing.T) {
+	tests := []struct {
+		name, source, data string
+	}{
+		{"todoist broken json", SourceTodoist, `{"projects": [`},
+		{"todoist other json", SourceTodoist, `{"boards": []}`},
+		{"trello broken json", SourceTrello, `{"lists": [{"id": 1}]}`},
+		{"trello other json", SourceTrello, `{"projects": []}`},
+		{"microsoft broken json", SourceMicrosoftToDo, `[{"displayName": "A", "tasks": 5}]`},
+		{"microsoft other json", SourceMicrosoftToDo, `{"items": []}`},
+		{"zip without exports", SourceTrello, string(zipOf(t, "readme.txt"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeSource(tt.source, []byte(tt.data), "export.json")
+			wantErr(t, err, apperrors.ErrInvalidImport)
+		})
+	}
+}
+
+// zipOf builds a zip with empty files of the names
+func zipOf(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		if _, err := zw.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type fakeImportJobRepo struct {
+	repository.ImportJobRepository
+	jobs []models.ImportJob
+}
+
+func (r *fakeImportJobRepo) CountUnfinished(int) (int64, error) { return 0, nil }
+
+func (r *fakeImportJobRepo) CreateJob(job *models.ImportJob) error {
+	job.ID = len(r.jobs) + 1
+	r.jobs = append(r.jobs, *job)
+	return nil
+}
+
+func TestQueueImportSizeCap(t *testing.T) {
+	repo := &fakeImportJobRepo{}
+	s := NewImportJobService(repo, nil)
+	opts := ImportJobOptions{Source: SourceTrello, Filename: "board.json"}
+
+	_, err := s.QueueImport(1, bytes.NewReader(make([]byte, MaxImportSize+1)), opts)
+	wantErr(t, err, apperrors.ErrImportTooLarge)
+	_, err = s.QueueImport(1, bytes.NewReader(nil), opts)
+	wantErr(t, err, apperrors.ErrInvalidImport)
+	if len(repo.jobs) != 0 {
+		t.Fatalf("%d jobs were queued", len(repo.jobs))
+	}
+	if _, err := s.QueueImport(1, bytes.NewReader([]byte(trelloJSON)), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Архив проверяется при распаковке: каждый файл не больше MaxImportSize
+	_, err = decodeSource(SourceTrello, zeroZip(t, MaxImportSize+1), "board.zip")
+	wantErr(t, err, apperrors.ErrImportTooLarge)
+}
@@ -0,0 +1,443 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Todoist writes dates like these; natural language such as "tomorrow"
+// cannot be imported
+var todoistDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"Jan 2 2006",
+	"2 Jan 2006",
+	"January 2 2006",
+	"2 January 2006",
+}
+
+// decodeTodoist reads a project exported as CSV (one file per project in a
+// backup) or the JSON of the Todoist sync API with projects, sections,
+// items and notes
+func decodeTodoist(batch *importBatch, file importFile) error {
+	if isJSON(file.data) {
+		return decodeTodoistJSON(batch, file)
+	}
+	return decodeTodoistCSV(batch, file)
+}
+
+// todoistPriority maps p1 (the most urgent) to p4 (no priority) onto priorities
+func todoistPriority(p int) string {
+	switch p {
+	case 1:
+		return "urgent"
+	case 2:
+		return "high"
+	case 3:
+		return "medium"
+	}
+	return "none"
+}
+
+// todoistLabels takes the @labels out of a CSV task title
+func todoistLabels(content string) (string, []string) {
+	var words, labels []string
+	for _, word := range strings.Fields(content) {
+		if len(word) > 1 && strings.HasPrefix(word, "@") {
+			labels = append(labels, word[1:])
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " "), labels
+}
+
+// todoistDate reads a Todoist date, which is either a date or a recurrence
+// such as "every monday". Recurring tasks get their next occurrence after
+// now as the due date.
+func todoistDate(raw string, loc *time.Location, now time.Time) (due *time.Time, rule string, ok bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, "", true
+	}
+	if t, ok := parseDateIn(raw, loc, todoistDateLayouts...); ok {
+		return &t, "", true
+	}
+	rule, byDay, ok := todoistRecurrence(raw)
+	if !ok {
+		return nil, "", false
+	}
+	next := now.In(loc).AddDate(0, 0, 1)
+	if byDay != nil {
+		for !byDay[next.Weekday()] {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	next = endOfDay(next)
+	return &next, rule, true
+}
+
+var (
+	todoistUnits = map[string]string{
+		"day": "DAILY", "days": "DAILY", "week": "WEEKLY", "weeks": "WEEKLY",
+		"month": "MONTHLY", "months": "MONTHLY", "year": "YEARLY", "years": "YEARLY",
+	}
+	todoistWeekdays = map[string]time.Weekday{
+		"mon": time.Monday, "monday": time.Monday, "tue": time.Tuesday, "tuesday": time.Tuesday,
+		"wed": time.Wednesday, "wednesday": time.Wednesday, "thu": time.Thursday, "thursday": time.Thursday,
+		"fri": time.Friday, "friday": time.Friday, "sat": time.Saturday, "saturday": time.Saturday,
+		"sun": time.Sunday, "sunday": time.Sunday,
+	}
+	icalWeekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+)
+
+// todoistRecurrence understands the common recurring dates: "every day",
+// "every 2 weeks", "every mon, thu", "every weekday", "daily" and so on.
+// The time of day ("every day at 9am") is ignored.
+func todoistRecurrence(raw string) (rule string, byDay map[time.Weekday]bool, ok bool) {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	if i := strings.Index(s, " at "); i >= 0 {
+		s = s[:i]
+	}
+	switch s {
+	case "daily":
+		return "FREQ=DAILY", nil, true
+	case "weekly":
+		return "FREQ=WEEKLY", nil, true
+	case "monthly":
+		return "FREQ=MONTHLY", nil, true
+	case "yearly", "annually":
+		return "FREQ=YEARLY", nil, true
+	}
+	var rest string
+	for _, prefix := range []string{"every! ", "every ", "ev! ", "ev "} {
+		if strings.HasPrefix(s, prefix) {
+			rest = strings.TrimSpace(s[len(prefix):])
+			break
+		}
+	}
+	if rest == "" {
+		return "", nil, false
+	}
+	if rest == "weekday" || rest == "workday" {
+		days := map[time.Weekday]bool{time.Monday: true, time.Tuesday: true, time.Wednesday: true, time.Thursday: true, time.Friday: true}
+		return "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR", days, true
+	}
+	if freq, ok := todoistUnits[rest]; ok {
+		return "FREQ=" + freq, nil, true
+	}
+	if n, unit, found := strings.Cut(rest, " "); found {
+		if interval, err := strconv.Atoi(n); err == nil && interval > 0 {
+			if freq, ok := todoistUnits[unit]; ok {
+				return fmt.Sprintf("FREQ=%s;INTERVAL=%d", freq, interval), nil, true
+			}
+		}
+	}
+
+	days := map[time.Weekday]bool{}
+	for _, word := range strings.FieldsFunc(rest, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if word == "and" {
+			continue
+		}
+		day, ok := todoistWeekdays[strings.TrimSuffix(word, "s")]
+		if !ok {
+			if day, ok = todoistWeekdays[word]; !ok {
+				return "", nil, false
+			}
+		}
+		days[day] = true
+	}
+	if len(days) == 0 {
+		return "", nil, false
+	}
+	var names []string
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if days[d] {
+			names = append(names, icalWeekdays[d])
+		}
+	}
+	return "FREQ=WEEKLY;BYDAY=" + strings.Join(names, ","), days, true
+}
+
+// decodeTodoistCSV reads the TYPE, CONTENT, DESCRIPTION, PRIORITY, INDENT,
+// DATE and TIMEZONE columns of a project export. Sections start lists of
+// their own, notes become comments of the task above them and subtasks
+// become tasks their parent depends on.
+func decodeTodoistCSV(batch *importBatch, file importFile) error {
+	t, err := readCSV(file)
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"type", "content"} {
+		if _, ok := t.columns[column]; !ok {
+			return apperrors.ErrInvalidImport.WithMessage(file.name + " is not a Todoist CSV export: the " + strings.ToUpper(column) + " column is missing")
+		}
+	}
+
+	project := listTitleFromFile(file.name)
+	list := &ExportList{Title: project, at: ImportSource{File: file.name, Row: 1}}
+	// parents[i] is the index of the last task with indent i+1
+	var parents []int
+	first := len(batch.lists)
+	flush := func() {
+		if len(list.Tasks) > 0 {
+			batch.lists = append(batch.lists, *list)
+		}
+	}
+	now := time.Now()
+
+	for i := range t.records {
+		row := t.row(i)
+		switch strings.ToLower(strings.TrimSpace(row.str("type"))) {
+		case "section":
+			flush()
+			list = &ExportList{Title: project + " / " + strings.TrimSpace(row.str("content")), at: row.at}
+			parents = nil
+		case "note":
+			if len(list.Tasks) == 0 {
+				batch.fail(row.at, "content", "notes of a project are not imported, only notes of tasks")
+				continue
+			}
+			task := &list.Tasks[len(list.Tasks)-1]
+			task.Comments = append(task.Comments, ExportComment{Body: row.str("content"), at: row.at})
+		case "task":
+			title, labels := todoistLabels(row.str("content"))
+			priority := row.int("priority")
+			indent := row.int("indent")
+			if row.failed() {
+				batch.fail(row.at, row.field, row.message)
+				continue
+			}
+			task := ExportTask{
+				ID:          batch.newID(),
+				Title:       title,
+				Description: withExtras(row.str("description"), labels, nil),
+				Priority:    todoistPriority(priority),
+				at:          row.at,
+			}
+			if date := row.str("date"); date != "" {
+				due, rule, ok := todoistDate(date, location(row.str("timezone")), now)
+				if !ok {
+					batch.fail(row.at, "date", fmt.Sprintf("%q is not a date that can be imported, the task has no due date", date))
+				}
+				task.DueAt, task.Recurrence = due, rule
+			}
+
+			if indent < 1 {
+				indent = 1
+			}
+			if indent > len(parents)+1 {
+				indent = len(parents) + 1
+			}
+			parents = append(parents[:indent-1], len(list.Tasks))
+			if indent > 1 {
+				parent := &list.Tasks[parents[indent-2]]
+				parent.DependsOn = append(parent.DependsOn, task.ID)
+			}
+			list.Tasks = append(list.Tasks, task)
+		}
+	}
+	flush()
+	// Пустой проект всё равно создаём как список
+	if len(batch.lists) == first {
+		batch.lists = append(batch.lists, ExportList{Title: project, at: ImportSource{File: file.name, Row: 1}})
+	}
+	return nil
+}
+
+// todoistID is an ID of the sync API, a number in old exports and a string in new ones
+type todoistID string
+
+func (id *todoistID) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*id = ""
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = todoistID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*id = todoistID(n.String())
+	return nil
+}
+
+type todoistExport struct {
+	Projects []struct {
+		ID   todoistID `json:"id"`
+		Name string    `json:"name"`
+	} `json:"projects"`
+	Sections []struct {
+		ID        todoistID `json:"id"`
+		ProjectID todoistID `json:"project_id"`
+		Name      string    `json:"name"`
+	} `json:"sections"`
+	Items []struct {
+		ID          todoistID `json:"id"`
+		ProjectID   todoistID `json:"project_id"`
+		SectionID   todoistID `json:"section_id"`
+		ParentID    todoistID `json:"parent_id"`
+		Content     string    `json:"content"`
+		Description string    `json:"description"`
+		// 4 is the most urgent, unlike in the app and in CSV exports
+		Priority int `json:"priority"`
+		Due      *struct {
+			Date        string `json:"date"`
+			Timezone    string `json:"timezone"`
+			String      string `json:"string"`
+			IsRecurring bool   `json:"is_recurring"`
+		} `json:"due"`
+		Labels      []string `json:"labels"`
+		Checked     bool     `json:"checked"`
+		CompletedAt string   `json:"completed_at"`
+		ChildOrder  int      `json:"child_order"`
+		IsDeleted   bool     `json:"is_deleted"`
+	} `json:"items"`
+	Notes []struct {
+		ItemID   todoistID `json:"item_id"`
+		Content  string    `json:"content"`
+		PostedAt string    `json:"posted_at"`
+	} `json:"notes"`
+}
+
+func decodeTodoistJSON(batch *importBatch, file importFile) error {
+	var export todoistExport
+	if err := json.Unmarshal(bytes.TrimPrefix(file.data, []byte("\ufeff")), &export); err != nil {
+		return apperrors.ErrInvalidImport.WithMessage(file.name + ": " + err.Error())
+	}
+	if len(export.Projects) == 0 && len(export.Items) == 0 {
+		return apperrors.ErrInvalidImport.WithMessage(file.name + " is not a Todoist export: it has no projects or items")
+	}
+
+	// Списки: сначала проекты, затем их разделы
+	type key struct{ project, section todoistID }
+	lists := map[key]int{}
+	first := len(batch.lists)
+	projectNames := map[todoistID]string{}
+	for i, p := range export.Projects {
+		projectNames[p.ID] = p.Name
+		lists[key{p.ID, ""}] = len(batch.lists)
+		batch.lists = append(batch.lists, ExportList{Title: p.Name, at: ImportSource{File: file.name, Path: fmt.Sprintf("projects[%d]", i)}})
+	}
+	for i, s := range export.Sections {
+		lists[key{s.ProjectID, s.ID}] = len(batch.lists)
+		title := orDefault(projectNames[s.ProjectID], defaultImportList) + " / " + s.Name
+		batch.lists = append(batch.lists, ExportList{Title: title, at: ImportSource{File: file.name, Path: fmt.Sprintf("sections[%d]", i)}})
+	}
+
+	order := make([]int, len(export.Items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return export.Items[order[a]].ChildOrder < export.Items[order[b]].ChildOrder
+	})
+
+	taskAt := map[todoistID][2]int{}
+	parents := map[todoistID]todoistID{}
+	for _, i := range order {
+		item := export.Items[i]
+		if item.IsDeleted {
+			continue
+		}
+		at := ImportSource{File: file.name, Path: fmt.Sprintf("items[%d]", i)}
+		idx, ok := lists[key{item.ProjectID, item.SectionID}]
+		if !ok {
+			if idx, ok = lists[key{item.ProjectID, ""}]; !ok {
+				idx = len(batch.lists)
+				lists[key{item.ProjectID, ""}] = idx
+				batch.lists = append(batch.lists, ExportList{Title: defaultImportList, at: at})
+			}
+		}
+
+		priority := 5 - item.Priority
+		if item.Priority == 0 {
+			priority = 4
+		}
+		task := ExportTask{
+			ID:          batch.newID(),
+			Title:       item.Content,
+			Description: withExtras(item.Description, item.Labels, nil),
+			Priority:    todoistPriority(priority),
+			Completed:   item.Checked,
+			at:          at,
+		}
+		if t, err := time.Parse(time.RFC3339, item.CompletedAt); err == nil {
+			task.CompletedAt = &t
+		}
+		if item.Due != nil {
+			loc := location(item.Due.Timezone)
+			if due, ok := parseDateIn(item.Due.Date, loc, todoistDateLayouts...); ok {
+				task.DueAt = &due
+			} else {
+				batch.fail(at, "due", fmt.Sprintf("%q is not a date that can be imported, the task has no due date", item.Due.Date))
+			}
+			if item.Due.IsRecurring && task.DueAt != nil {
+				if rule, _, ok := todoistRecurrence(item.Due.String); ok {
+					task.Recurrence = rule
+				} else {
+					batch.fail(at, "due", fmt.Sprintf("%q is not a recurrence that can be imported, the task does not repeat", item.Due.String))
+				}
+			}
+		}
+
+		batch.lists[idx].Tasks = append(batch.lists[idx].Tasks, task)
+		taskAt[item.ID] = [2]int{idx, len(batch.lists[idx].Tasks) - 1}
+		if item.ParentID != "" {
+			parents[item.ID] = item.ParentID
+		}
+	}
+
+	// Родительская задача ждёт свои подзадачи
+	for _, i := range order {
+		child := export.Items[i].ID
+		parent, ok := parents[child]
+		if !ok {
+			continue
+		}
+		p, ok1 := taskAt[parent]
+		c, ok2 := taskAt[child]
+		if !ok1 || !ok2 {
+			continue
+		}
+		parentTask := &batch.lists[p[0]].Tasks[p[1]]
+		parentTask.DependsOn = append(parentTask.DependsOn, batch.lists[c[0]].Tasks[c[1]].ID)
+	}
+
+	for i, note := range export.Notes {
+		at := ImportSource{File: file.name, Path: fmt.Sprintf("notes[%d]", i)}
+		pos, ok := taskAt[note.ItemID]
+		if !ok {
+			batch.fail(at, "item_id", "notes of projects and of deleted items are not imported")
+			continue
+		}
+		comment := ExportComment{Body: note.Content, at: at}
+		if t, err := time.Parse(time.RFC3339, note.PostedAt); err == nil {
+			comment.CreatedAt = t
+		}
+		task := &batch.lists[pos[0]].Tasks[pos[1]]
+		task.Comments = append(task.Comments, comment)
+	}
+
+	// Пустые разделы не создаём, пустые проекты оставляем как списки
+	kept := batch.lists[:first]
+	for _, list := range batch.lists[first:] {
+		if len(list.Tasks) > 0 || !strings.HasPrefix(list.at.Path, "sections") {
+			kept = append(kept, list)
+		}
+	}
+	batch.lists = kept
+	return nil
+}
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Trello lists with these names hold finished cards
+var trelloDoneLists = map[string]bool{"done": true, "complete": true, "completed": true, "finished": true}
+
+// trelloDoneStatus is added to the workflow of a board without a done list
+const trelloDoneStatus = "Done"
+
+var trelloDateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05.000Z", "2006-01-02 15:04:05", "2006-01-02"}
+
+// decodeTrello reads the JSON export of a board or the CSV export of its
+// cards. A board becomes one list whose workflow has a status per Trello
+// list, so cards keep their column.
+func decodeTrello(batch *importBatch, file importFile) error {
+	if isJSON(file.data) {
+		return decodeTrelloJSON(batch, file)
+	}
+	return decodeTrelloCSV(batch, file)
+}
+
+type trelloBoard struct {
+	Name  string `json:"name"`
+	Lists []struct {
+		ID     string  `json:"id"`
+		Name   string  `json:"name"`
+		Closed bool    `json:"closed"`
+		Pos    float64 `json:"pos"`
+	} `json:"lists"`
+	Cards []struct {
+		ID          string  `json:"id"`
+		Name        string  `json:"name"`
+		Desc        string  `json:"desc"`
+		Closed      bool    `json:"closed"`
+		IDList      string  `json:"idList"`
+		Due         *string `json:"due"`
+		DueComplete bool    `json:"dueComplete"`
+		Pos         float64 `json:"pos"`
+		Labels      []struct {
+			Name  string `json:"name"`
+			Color string `json:"color"`
+		} `json:"labels"`
+	} `json:"cards"`
+	Checklists []struct {
+		IDCard     string  `json:"idCard"`
+		Name       string  `json:"name"`
+		Pos        float64 `json:"pos"`
+		CheckItems []struct {
+			Name  string  `json:"name"`
+			State string  `json:"state"`
+			Pos   float64 `json:"pos"`
+		} `json:"checkItems"`
+	} `json:"checklists"`
+	Actions []struct {
+		Type string `json:"type"`
+		Date string `json:"date"`
+		Data struct {
+			Text string `json:"text"`
+			Card struct {
+				ID string `json:"id"`
+			} `json:"card"`
+		} `json:"data"`
+		MemberCreator struct {
+			FullName string `json:"fullName"`
+		} `json:"memberCreator"`
+	} `json:"actions"`
+}
+
+// trelloWorkflow makes a status of each open list, in board order. Lists
+// named like "Done" are terminal; without one a terminal Done status is
+// added. Boards with more lists than a workflow allows get no workflow.
+func trelloWorkflow(batch *importBatch, at ImportSource, names []string) []ExportStatus {
+	var statuses []ExportStatus
+	seen := map[string]bool{}
+	terminal := false
+	for _, name := range names {
+		name = truncate(strings.TrimSpace(name), maxStatusNameLength)
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		done := trelloDoneLists[key]
+		terminal = terminal || done
+		statuses = append(statuses, ExportStatus{Name: name, Terminal: done, Next: []string{}})
+	}
+	if !terminal && !seen[strings.ToLower(trelloDoneStatus)] {
+		statuses = append(statuses, ExportStatus{Name: trelloDoneStatus, Terminal: true, Next: []string{}})
+	}
+	if len(statuses) > MaxStatuses {
+		batch.fail(at, "lists", fmt.Sprintf("the board has more than %d lists, the list is created without a workflow", MaxStatuses))
+		return nil
+	}
+	return statuses
+}
+
+func decodeTrelloJSON(batch *importBatch, file importFile) error {
+	var board trelloBoard
+	if err := json.Unmarshal(bytes.TrimPrefix(file.data, []byte("\ufeff")), &board); err != nil {
+		return apperrors.ErrInvalidImport.WithMessage(file.name + ": " + err.Error())
+	}
+	if board.Lists == nil && board.Cards == nil {
+		return apperrors.ErrInvalidImport.WithMessage(file.name + " is not a Trello board export: it has no lists or cards")
+	}
+
+	at := ImportSource{File: file.name}
+	sort.SliceStable(board.Lists, func(i, j int) bool { return board.Lists[i].Pos < board.Lists[j].Pos })
+	listNames := map[string]string{}
+	var names []string
+	for _, l := range board.Lists {
+		if l.Closed {
+			continue
+		}
+		listNames[l.ID] = l.Name
+		names = append(names, l.Name)
+	}
+	list := ExportList{
+		Title:    orDefault(strings.TrimSpace(board.Name), listTitleFromFile(file.name)),
+		Statuses: trelloWorkflow(batch, at, names),
+		at:       at,
+	}
+
+	checklists := map[string][]checkItem{}
+	sort.SliceStable(board.Checklists, func(i, j int) bool { return board.Checklists[i].Pos < board.Checklists[j].Pos })
+	for _, cl := range board.Checklists {
+		items := cl.CheckItems
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Pos < items[j].Pos })
+		for _, item := range items {
+			checklists[cl.IDCard] = append(checklists[cl.IDCard], checkItem{title: item.Name, done: item.State == "complete"})
+		}
+	}
+	comments := map[string][]ExportComment{}
+	// Trello отдаёт действия от новых к старым
+	for i := len(board.Actions) - 1; i >= 0; i-- {
+		action := board.Actions[i]
+		if action.Type != "commentCard" {
+			continue
+		}
+		comment := ExportComment{Body: action.Data.Text, at: ImportSource{File: file.name, Path: fmt.Sprintf("actions[%d]", i)}}
+		if name := action.MemberCreator.FullName; name != "" {
+			comment.Body = name + ": " + comment.Body
+		}
+		if t, err := time.Parse(time.RFC3339, action.Date); err == nil {
+			comment.CreatedAt = t
+		}
+		comments[action.Data.Card.ID] = append(comments[action.Data.Card.ID], comment)
+	}
+
+	order := make([]int, len(board.Cards))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return board.Cards[order[a]].Pos < board.Cards[order[b]].Pos })
+	archived := 0
+	for _, i := range order {
+		card := board.Cards[i]
+		column, open := listNames[card.IDList]
+		if card.Closed || !open {
+			archived++
+			continue
+		}
+		var labels []string
+		for _, l := range card.Labels {
+			labels = append(labels, orDefault(l.Name, l.Color))
+		}
+		task := ExportTask{
+			ID:          batch.newID(),
+			Title:       card.Name,
+			Description: withExtras(card.Desc, labels, checklists[card.ID]),
+			Completed:   card.DueComplete || trelloDoneLists[strings.ToLower(strings.TrimSpace(column))],
+			Comments:    comments[card.ID],
+			at:          ImportSource{File: file.name, Path: fmt.Sprintf("cards[%d]", i)},
+		}
+		if list.Statuses != nil {
+			task.Status = truncate(strings.TrimSpace(column), maxStatusNameLength)
+		}
+		if card.Due != nil {
+			if due, ok := parseDateIn(*card.Due, time.UTC, trelloDateLayouts...); ok {
+				task.DueAt = &due
+			}
+		}
+		list.Tasks = append(list.Tasks, task)
+	}
+	if archived > 0 {
+		batch.fail(at, "cards", fmt.Sprintf("%d archived cards and cards of archived lists were not imported", archived))
+	}
+	batch.lists = append(batch.lists, list)
+	return nil
+}
+
+// trelloLabel is a label of the CSV export: "Urgent (red)", or "(green)"
+// for a label without a name
+var trelloLabel = regexp.MustCompile(`^(.*?)\s*\((\w+)\)$`)
+
+// trelloTrue reads the booleans of the CSV export, written as true and false
+func trelloTrue(raw string) bool {
+	return strings.EqualFold(strings.TrimSpace(raw), "true")
+}
+
+// decodeTrelloCSV reads the CSV export of a board, with the Card Name,
+// Card Description, Labels, Due Date, Due Complete, List Name, Board Name
+// and Archived columns. It has no checklists and comments.
+func decodeTrelloCSV(batch *importBatch, file importFile) error {
+	t, err := readCSV(file)
+	if err != nil {
+		return err
+	}
+	for _, column := range []string{"card name", "list name"} {
+		if _, ok := t.columns[column]; !ok {
+			return apperrors.ErrInvalidImport.WithMessage(file.name + " is not a Trello CSV export: the " + column + " column is missing")
+		}
+	}
+
+	// Столбцы доски восстанавливаем в порядке первого появления
+	boards := map[string]int{}
+	lists := []*ExportList{}
+	columns := map[string][]string{}
+	archived := map[string]int{}
+	for i := range t.records {
+		row := t.row(i)
+		board := orDefault(strings.TrimSpace(row.str("board name")), listTitleFromFile(file.name))
+		idx, ok := boards[board]
+		if !ok {
+			idx = len(lists)
+			boards[board] = idx
+			lists = append(lists, &ExportList{Title: board, at: row.at})
+		}
+		list := lists[idx]
+		if trelloTrue(row.str("archived")) {
+			archived[board]++
+			continue
+		}
+
+		column := strings.TrimSpace(row.str("list name"))
+		known := false
+		for _, name := range columns[board] {
+			known = known || strings.EqualFold(name, column)
+		}
+		if !known {
+			columns[board] = append(columns[board], column)
+		}
+
+		var labels []string
+		for _, raw := range strings.Split(row.str("labels"), ",") {
+			raw = strings.TrimSpace(raw)
+			if m := trelloLabel.FindStringSubmatch(raw); m != nil {
+				raw = orDefault(m[1], m[2])
+			}
+			if raw != "" {
+				labels = append(labels, raw)
+			}
+		}
+		task := ExportTask{
+			ID:          batch.newID(),
+			Title:       row.str("card name"),
+			Description: withExtras(row.str("card description"), labels, nil),
+			Completed:   trelloTrue(row.str("due complete")) || trelloDoneLists[strings.ToLower(column)],
+			Status:      truncate(column, maxStatusNameLength),
+			at:          row.at,
+		}
+		if raw := row.str("due date"); strings.TrimSpace(raw) != "" {
+			if due, ok := parseDateIn(raw, time.UTC, trelloDateLayouts...); ok {
+				task.DueAt = &due
+			} else {
+				batch.fail(row.at, "due date", fmt.Sprintf("%q is not a date that can be imported, the task has no due date", raw))
+			}
+		}
+		list.Tasks = append(list.Tasks, task)
+	}
+
+	for _, list := range lists {
+		list.Statuses = trelloWorkflow(batch, list.at, columns[list.Title])
+		if list.Statuses == nil {
+			for i := range list.Tasks {
+				list.Tasks[i].Status = ""
+			}
+		}
+		if n := archived[list.Title]; n > 0 {
+			batch.fail(list.at, "archived", fmt.Sprintf("%d archived cards were not imported", n))
+		}
+		batch.lists = append(batch.lists, *list)
+	}
+	return nil
+}
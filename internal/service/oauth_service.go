@@ -0,0 +1,913 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/oidc"
+	"crypto/subtle"
+	"gorm.io/gorm"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Scopes third-party apps can request. A write scope includes reading.
+const (
+	ScopeListsRead  = "lists:read"
+	ScopeListsWrite = "lists:write"
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+	ScopeProfile    = "profile"
+)
+
+const (
+	// Redirect URIs a client can register
+	maxRedirectURIs = 10
+	// RFC 7636 limits the length of a PKCE code verifier
+	minVerifierLength = 43
+	maxVerifierLength = 128
+)
+
+// OAuthScope model
+// swagger:model
+type OAuthScope struct {
+	// example: tasks:read
+	Name string `json:"name"`
+	// Shown to the user on the consent screen
+	// example: See your tasks, their comments and attachments
+	Description string `json:"description"`
+}
+
+var oauthScopes = []OAuthScope{
+	{Name: ScopeListsRead, Description: "See your lists and their workflows"},
+	{Name: ScopeListsWrite, Description: "See, create, change and delete your lists"},
+	{Name: ScopeTasksRead, Description: "See your tasks, their comments and attachments"},
+	{Name: ScopeTasksWrite, Description: "See, create, change and delete your tasks, comments and attachments"},
+	{Name: ScopeProfile, Description: "See your username, email and preferences"},
+}
+
+// OAuthSettings configure the authorization server for third-party apps
+type OAuthSettings struct {
+	// Page of the web app that asks the user to authorize an app; it
+	// receives the parameters of the authorization request
+	ConsentURL string
+	// How long an authorization code can be exchanged
+	CodeTTL time.Duration
+	// Lifetime of an access token and of a refresh token
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// OAuthClientInput holds the app details given at registration
+type OAuthClientInput struct {
+	Name         string
+	Website      string
+	RedirectURIs []string
+	Confidential bool
+}
+
+// OAuthClientCredentials model is a client with its secret, which is only
+// shown when it is created
+// swagger:model
+type OAuthClientCredentials struct {
+	models.OAuthClient
+	// Present for confidential clients; store it, it cannot be shown again
+	// example: 9b2d6c0e4f1a8e3d7c5b2a0f9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b3a2f1e0d
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// OAuthClientInfo model is what users see about an app
+// swagger:model
+type OAuthClientInfo struct {
+	// example: 3f1c2a9d8e7b6a5f4e3d2c1b0a998877
+	ClientID string `json:"client_id"`
+	// example: Calendar Sync
+	Name string `json:"name"`
+	// example: https://sync.example.com
+	Website string `json:"website"`
+}
+
+// AuthorizationRequest holds the parameters of an authorization request
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// OAuthConsentPrompt model is what the consent screen shows
+// swagger:model
+type OAuthConsentPrompt struct {
+	Client OAuthClientInfo `json:"client"`
+	// Requested scopes
+	Scopes []OAuthScope `json:"scopes"`
+	// The user already granted all requested scopes to the app; the screen
+	// may approve at once
+	Granted bool `json:"granted"`
+}
+
+// OAuthAuthorization model is an app the user authorized
+// swagger:model
+type OAuthAuthorization struct {
+	Client OAuthClientInfo `json:"client"`
+	// example: ["lists:read","tasks:write"]
+	Scopes    []string  `json:"scopes"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// TokenRequest holds the form of a token request and the client credentials
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+	ClientID     string
+	ClientSecret string
+}
+
+// OAuthTokenResponse model (RFC 6749)
+// swagger:model
+type OAuthTokenResponse struct {
+	// example: 5e1f0c3b9a8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f
+	AccessToken string `json:"access_token"`
+	// example: Bearer
+	TokenType string `json:"token_type"`
+	// Seconds until the access token expires
+	// example: 3600
+	ExpiresIn int `json:"expires_in"`
+	// Renews the tokens once; the response carries a new one
+	// example: 0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b
+	RefreshToken string `json:"refresh_token"`
+	// example: lists:read tasks:write
+	Scope string `json:"scope"`
+}
+
+// OAuthIntrospection model (RFC 7662)
+// swagger:model
+type OAuthIntrospection struct {
+	Active bool `json:"active"`
+	// example: lists:read tasks:write
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	// example: john
+	Username string `json:"username,omitempty"`
+	// ID of the user
+	// example: 42
+	Subject string `json:"sub,omitempty"`
+	// access_token or refresh_token
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// OAuthAccess is what a valid access token grants
+type OAuthAccess struct {
+	UserID   int
+	ClientID string
+	Scopes   []string
+}
+
+type OAuthService interface {
+	Scopes() []OAuthScope
+
+	RegisterClient(ownerID int, input OAuthClientInput) (*OAuthClientCredentials, error)
+	GetClients(ownerID int) ([]models.OAuthClient, error)
+	GetClient(ownerID, id int) (*models.OAuthClient, error)
+	// RotateSecret replaces the secret of a confidential client
+	RotateSecret(ownerID, id int) (*OAuthClientCredentials, error)
+	// DeleteClient deletes the client and revokes every token issued to it
+	DeleteClient(ownerID, id int) error
+
+	// AuthorizeRedirect checks an authorization request and returns where to
+	// send the browser: the consent page, or back to the client with an
+	// error. Only an unknown client or redirect URI is returned as an error.
+	AuthorizeRedirect(req AuthorizationRequest) (string, error)
+	// Prompt checks an authorization request for the consent screen
+	Prompt(userID int, req AuthorizationRequest) (*OAuthConsentPrompt, error)
+	// Decide records the user's answer to an authorization request and
+	// returns the client URL to redirect to, with the code or an error
+	Decide(userID int, req AuthorizationRequest, approve bool) (string, error)
+
+	// Token issues tokens for the authorization_code and refresh_token grants
+	Token(req TokenRequest) (*OAuthTokenResponse, error)
+	// Introspect tells a confidential client about a token issued to it
+	Introspect(clientID, clientSecret, token string) (*OAuthIntrospection, error)
+	// Revoke revokes an access or refresh token of the client together with
+	// its pair; unknown tokens are ignored
+	Revoke(clientID, clientSecret, token string) error
+	// Authenticate returns what an access token grants; nil when it is
+	// unknown or expired
+	Authenticate(accessToken string) (*OAuthAccess, error)
+
+	GetAuthorizations(userID int) ([]OAuthAuthorization, error)
+	// RevokeAuthorization forgets the consent of the user to the app and
+	// revokes its tokens
+	RevokeAuthorization(userID int, clientID string) error
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) OAuthService
+}
+
+type oauthService struct {
+	repo     repository.OAuthRepository
+	userRepo repository.UserRepository
+	settings OAuthSettings
+	tx       repository.Transactor
+	audit    Auditor
+}
+
+func NewOAuthService(repo repository.OAuthRepository, userRepo repository.UserRepository, settings OAuthSettings,
+	auditor Auditor, tx repository.Transactor) OAuthService {
+	return &oauthService{repo: repo, userRepo: userRepo, settings: settings, tx: tx, audit: auditor}
+}
+
+func (s *oauthService) WithActor(actor Actor) OAuthService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *oauthService) Scopes() []OAuthScope {
+	return oauthScopes
+}
+
+func (s *oauthService) RegisterClient(ownerID int, input OAuthClientInput) (*OAuthClientCredentials, error) {
+	client := &models.OAuthClient{
+		OwnerID:      ownerID,
+		Name:         strings.TrimSpace(input.Name),
+		Website:      strings.TrimSpace(input.Website),
+		Confidential: input.Confidential,
+	}
+	var fields []apperrors.FieldError
+	if client.Name == "" {
+		fields = append(fields, apperrors.Field("name", "required", "is required"))
+	} else if utf8.RuneCountInString(client.Name) > 100 {
+		fields = append(fields, apperrors.Field("name", "max", "must be at most 100 characters"))
+	}
+	if client.Website != "" {
+		if u, err := url.Parse(client.Website); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fields = append(fields, apperrors.Field("website", "url", "must be an http or https URL"))
+		}
+	}
+	switch {
+	case len(input.RedirectURIs) == 0:
+		fields = append(fields, apperrors.Field("redirect_uris", "required", "at least one is required"))
+	case len(input.RedirectURIs) > maxRedirectURIs:
+		fields = append(fields, apperrors.Field("redirect_uris", "max", "at most "+strconv.Itoa(maxRedirectURIs)+" are allowed"))
+	}
+	for i, uri := range input.RedirectURIs {
+		if msg := checkRedirectURI(uri); msg != "" {
+			fields = append(fields, apperrors.Field("redirect_uris["+strconv.Itoa(i)+"]", "redirect_uri", msg))
+		} else if !slices.Contains(client.RedirectURIs, uri) {
+			client.RedirectURIs = append(client.RedirectURIs, uri)
+		}
+	}
+	if len(fields) > 0 {
+		return nil, apperrors.Validation(fields...)
+	}
+
+	clientID, _, err := newHashedToken()
+	if err != nil {
+		return nil, apperrors.ErrInternal.Wrap(err)
+	}
+	client.ClientID = clientID[:32]
+	result := &OAuthClientCredentials{}
+	if client.Confidential {
+		if result.ClientSecret, client.SecretHash, err = newHashedToken(); err != nil {
+			return nil, apperrors.ErrInternal.Wrap(err)
+		}
+	}
+
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		owner, err := s.userRepo.WithTx(tx).FindByID(ownerID)
+		if err != nil {
+			return err
+		}
+		if owner == nil {
+			return apperrors.ErrUnauthorized
+		}
+		if err := s.repo.WithTx(tx).CreateClient(client); err != nil {
+			return err
+		}
+		return s.audit.recordAccount(tx, AuditCreate, owner, nil, clientAudit(client))
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.OAuthClient = *client
+	return result, nil
+}
+
+// checkRedirectURI returns why the URI cannot be a redirect URI, if it cannot.
+// Loopback addresses may use http; native apps may use a private scheme
+// such as com.example.app (RFC 8252).
+func checkRedirectURI(uri string) string {
+	u, err := url.Parse(uri)
+	switch {
+	case err != nil || !u.IsAbs():
+		return "must be an absolute URI"
+	case u.Fragment != "" || strings.Contains(uri, "#"):
+		return "must not contain a fragment"
+	case u.Scheme == "https":
+		if u.Host == "" {
+			return "must have a host"
+		}
+	case u.Scheme == "http":
+		host := u.Hostname()
+		if host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			return "http is only allowed for localhost; use https"
+		}
+	case !strings.Contains(u.Scheme, "."):
+		return "a private scheme must be a reversed domain name, such as com.example.app"
+	}
+	return ""
+}
+
+func (s *oauthService) GetClients(ownerID int) ([]models.OAuthClient, error) {
+	return s.repo.GetClients(ownerID)
+}
+
+func (s *oauthService) GetClient(ownerID, id int) (*models.OAuthClient, error) {
+	client, err := s.repo.GetClient(ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, apperrors.ErrOAuthClientNotFound
+	}
+	return client, nil
+}
+
+func (s *oauthService) RotateSecret(ownerID, id int) (*OAuthClientCredentials, error) {
+	client, err := s.GetClient(ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Confidential {
+		return nil, apperrors.ErrOAuthClientPublic
+	}
+	secret, secretHash, err := newHashedToken()
+	if err != nil {
+		return nil, apperrors.ErrInternal.Wrap(err)
+	}
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		owner, err := s.userRepo.WithTx(tx).FindByID(ownerID)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.WithTx(tx).UpdateClientSecret(client.ID, secretHash); err != nil {
+			return err
+		}
+		after := clientAudit(client)
+		after["secret"] = "rotated"
+		return s.audit.recordAccount(tx, AuditUpdate, owner, nil, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+	client.SecretHash = secretHash
+	return &OAuthClientCredentials{OAuthClient: *client, ClientSecret: secret}, nil
+}
+
+func (s *oauthService) DeleteClient(ownerID, id int) error {
+	client, err := s.GetClient(ownerID, id)
+	if err != nil {
+		return err
+	}
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		owner, err := s.userRepo.WithTx(tx).FindByID(ownerID)
+		if err != nil {
+			return err
+		}
+		if err := s.repo.WithTx(tx).DeleteClient(client.ID); err != nil {
+			return err
+		}
+		return s.audit.recordAccount(tx, AuditDelete, owner, clientAudit(client), nil)
+	})
+}
+
+// authorization is a checked authorization request
+type authorization struct {
+	req         AuthorizationRequest
+	client      *models.OAuthClient
+	redirectURI string
+	scopes      []string
+}
+
+// checkAuthorization checks an authorization request. Without the client and
+// redirect URI the returned authorization is nil and the error cannot be
+// sent to the client; other errors can.
+func (s *oauthService) checkAuthorization(req AuthorizationRequest) (*authorization, error) {
+	if req.ClientID == "" {
+		return nil, apperrors.ErrOAuthInvalidRedirect
+	}
+	client, err := s.repo.GetClientByClientID(req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, apperrors.ErrOAuthInvalidRedirect
+	}
+	redirectURI := req.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, apperrors.ErrOAuthInvalidRedirect
+	}
+
+	auth := &authorization{req: req, client: client, redirectURI: redirectURI}
+	if req.ResponseType != "code" {
+		return auth, apperrors.ErrOAuthUnsupportedResponseType
+	}
+	// PKCE обязателен и для конфиденциальных клиентов
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return auth, apperrors.ErrOAuthInvalidRequest.WithMessage("PKCE is required: send code_challenge with code_challenge_method S256")
+	}
+	if auth.scopes, err = parseScopes(req.Scope); err != nil {
+		return auth, err
+	}
+	return auth, nil
+}
+
+// parseScopes splits a space-separated scope parameter into known scopes in
+// the order of oauthScopes
+func parseScopes(scope string) ([]string, error) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return nil, apperrors.ErrOAuthInvalidScope
+	}
+	var scopes []string
+	for _, known := range oauthScopes {
+		if slices.Contains(requested, known.Name) {
+			scopes = append(scopes, known.Name)
+		}
+	}
+	for _, name := range requested {
+		if !slices.Contains(scopes, name) {
+			return nil, apperrors.ErrOAuthInvalidScope.WithMessage("Unknown scope " + name)
+		}
+	}
+	return scopes, nil
+}
+
+// redirectURL adds the parameters to the query of the redirect URI
+func redirectURL(redirectURI string, params url.Values) string {
+	u, _ := url.Parse(redirectURI)
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// errorRedirect sends the error of an authorization request back to the client
+func errorRedirect(auth *authorization, err error) string {
+	appErr := apperrors.From(err)
+	code := appErr.Code
+	if appErr.Status >= 500 {
+		code = "server_error"
+	}
+	params := url.Values{"error": {code}, "error_description": {appErr.Message}}
+	if auth.req.State != "" {
+		params.Set("state", auth.req.State)
+	}
+	return redirectURL(auth.redirectURI, params)
+}
+
+func (s *oauthService) AuthorizeRedirect(req AuthorizationRequest) (string, error) {
+	auth, err := s.checkAuthorization(req)
+	if auth == nil {
+		return "", err
+	}
+	if err != nil {
+		return errorRedirect(auth, err), nil
+	}
+	params := url.Values{
+		"response_type":         {req.ResponseType},
+		"client_id":             {req.ClientID},
+		"scope":                 {strings.Join(auth.scopes, " ")},
+		"code_challenge":        {req.CodeChallenge},
+		"code_challenge_method": {req.CodeChallengeMethod},
+	}
+	// Опущенный redirect_uri так и передаётся дальше: от него зависит,
+	// должен ли клиент повторить его при обмене кода
+	if req.RedirectURI != "" {
+		params.Set("redirect_uri", req.RedirectURI)
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	return redirectURL(s.settings.ConsentURL, params), nil
+}
+
+func (s *oauthService) Prompt(userID int, req AuthorizationRequest) (*OAuthConsentPrompt, error) {
+	auth, err := s.checkAuthorization(req)
+	if err != nil {
+		return nil, err
+	}
+	consent, err := s.repo.GetConsent(userID, auth.client.ID)
+	if err != nil {
+		return nil, err
+	}
+	prompt := &OAuthConsentPrompt{Client: clientInfo(auth.client), Granted: consent != nil}
+	for _, scope := range oauthScopes {
+		if slices.Contains(auth.scopes, scope.Name) {
+			prompt.Scopes = append(prompt.Scopes, scope)
+			if consent != nil && !slices.Contains(strings.Fields(consent.Scope), scope.Name) {
+				prompt.Granted = false
+			}
+		}
+	}
+	return prompt, nil
+}
+
+func (s *oauthService) Decide(userID int, req AuthorizationRequest, approve bool) (string, error) {
+	auth, err := s.checkAuthorization(req)
+	if auth == nil {
+		return "", err
+	}
+	if err != nil {
+		return errorRedirect(auth, err), nil
+	}
+	if !approve {
+		return errorRedirect(auth, apperrors.ErrOAuthAccessDenied), nil
+	}
+
+	now := time.Now()
+	if _, err := s.repo.DeleteExpiredCodes(now); err != nil {
+		return "", err
+	}
+	code, codeHash, err := newHashedToken()
+	if err != nil {
+		return "", apperrors.ErrInternal.Wrap(err)
+	}
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		user, err := s.userRepo.WithTx(tx).FindByID(userID)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return apperrors.ErrUnauthorized
+		}
+		consent, err := repo.GetConsent(userID, auth.client.ID)
+		if err != nil {
+			return err
+		}
+		// Согласие копит области: новое разрешение не отнимает выданные раньше
+		var granted []string
+		if consent != nil {
+			granted = strings.Fields(consent.Scope)
+		}
+		added := false
+		for _, scope := range auth.scopes {
+			if !slices.Contains(granted, scope) {
+				granted = append(granted, scope)
+				added = true
+			}
+		}
+		if added {
+			sort.Strings(granted)
+			if err := repo.SaveConsent(&models.OAuthConsent{UserID: userID, ClientID: auth.client.ID, Scope: strings.Join(granted, " ")}); err != nil {
+				return err
+			}
+			after := clientAudit(auth.client)
+			after["scope"] = strings.Join(granted, " ")
+			if err := s.audit.recordAccount(tx, AuditUpdate, user, nil, after); err != nil {
+				return err
+			}
+		}
+		return repo.CreateCode(&models.OAuthCode{
+			CodeHash:         codeHash,
+			ClientID:         auth.client.ID,
+			UserID:           userID,
+			RedirectURI:      auth.redirectURI,
+			RedirectURIGiven: req.RedirectURI != "",
+			Scope:            strings.Join(auth.scopes, " "),
+			CodeChallenge:    req.CodeChallenge,
+			ExpiresAt:        now.Add(s.settings.CodeTTL),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	return redirectURL(auth.redirectURI, params), nil
+}
+
+// authenticateClient finds the client and checks its secret. Public clients
+// only identify themselves.
+func (s *oauthService) authenticateClient(clientID, clientSecret string) (*models.OAuthClient, error) {
+	if clientID == "" {
+		return nil, apperrors.ErrOAuthInvalidClient
+	}
+	client, err := s.repo.GetClientByClientID(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, apperrors.ErrOAuthInvalidClient
+	}
+	if client.Confidential && subtle.ConstantTimeCompare([]byte(hashToken(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, apperrors.ErrOAuthInvalidClient
+	}
+	return client, nil
+}
+
+func (s *oauthService) Token(req TokenRequest) (*OAuthTokenResponse, error) {
+	client, err := s.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	switch req.GrantType {
+	case "authorization_code":
+		return s.exchangeCode(client, req)
+	case "refresh_token":
+		return s.refresh(client, req)
+	case "":
+		return nil, apperrors.ErrOAuthInvalidRequest.WithMessage("grant_type is required")
+	default:
+		return nil, apperrors.ErrOAuthUnsupportedGrantType
+	}
+}
+
+func (s *oauthService) exchangeCode(client *models.OAuthClient, req TokenRequest) (*OAuthTokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, apperrors.ErrOAuthInvalidRequest.WithMessage("code and code_verifier are required")
+	}
+	if len(req.CodeVerifier) < minVerifierLength || len(req.CodeVerifier) > maxVerifierLength {
+		return nil, apperrors.ErrOAuthInvalidRequest.WithMessage("code_verifier must be 43 to 128 characters long")
+	}
+	now := time.Now()
+	code, err := s.repo.TakeCode(hashToken(req.Code), now)
+	if err != nil {
+		return nil, err
+	}
+	if code == nil || code.ClientID != client.ID {
+		return nil, apperrors.ErrOAuthInvalidGrant
+	}
+	// RFC 6749 §4.1.3: redirect_uri is required only when the authorization
+	// request had it, and then must be identical
+	if (code.RedirectURIGiven || req.RedirectURI != "") && code.RedirectURI != req.RedirectURI {
+		return nil, apperrors.ErrOAuthInvalidGrant.WithMessage("redirect_uri does not match the authorization request")
+	}
+	if subtle.ConstantTimeCompare([]byte(oidc.S256Challenge(req.CodeVerifier)), []byte(code.CodeChallenge)) != 1 {
+		return nil, apperrors.ErrOAuthInvalidGrant.WithMessage("code_verifier does not match the code_challenge")
+	}
+	if err := s.checkUser(code.UserID); err != nil {
+		return nil, err
+	}
+
+	token := &models.OAuthToken{ClientID: client.ID, UserID: code.UserID, Scope: code.Scope}
+	response, err := s.renew(token, now)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.repo.DeleteExpiredTokens(now); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateToken(token); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (s *oauthService) refresh(client *models.OAuthClient, req TokenRequest) (*OAuthTokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, apperrors.ErrOAuthInvalidRequest.WithMessage("refresh_token is required")
+	}
+	now := time.Now()
+	token, err := s.repo.GetTokenByRefresh(hashToken(req.RefreshToken), now)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || token.ClientID != client.ID {
+		return nil, apperrors.ErrOAuthInvalidGrant
+	}
+	// Области можно только сузить
+	if req.Scope != "" {
+		scopes, err := parseScopes(req.Scope)
+		if err != nil {
+			return nil, err
+		}
+		granted := strings.Fields(token.Scope)
+		for _, scope := range scopes {
+			if !slices.Contains(granted, scope) {
+				return nil, apperrors.ErrOAuthInvalidScope.WithMessage("The scope " + scope + " was not granted")
+			}
+		}
+		token.Scope = strings.Join(scopes, " ")
+	}
+	if err := s.checkUser(token.UserID); err != nil {
+		return nil, err
+	}
+
+	oldRefreshHash := token.RefreshHash
+	response, err := s.renew(token, now)
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := s.repo.RotateToken(token, oldRefreshHash)
+	if err != nil {
+		return nil, err
+	}
+	if rotated == 0 {
+		return nil, apperrors.ErrOAuthInvalidGrant
+	}
+	return response, nil
+}
+
+// checkUser refuses tokens for users that were deleted or wait for deletion
+func (s *oauthService) checkUser(userID int) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.PendingDeletion() {
+		return apperrors.ErrOAuthInvalidGrant
+	}
+	return nil
+}
+
+// renew puts new access and refresh tokens into token and returns them
+func (s *oauthService) renew(token *models.OAuthToken, now time.Time) (*OAuthTokenResponse, error) {
+	accessToken, accessHash, err := newHashedToken()
+	if err != nil {
+		return nil, apperrors.ErrInternal.Wrap(err)
+	}
+	refreshToken, refreshHash, err := newHashedToken()
+	if err != nil {
+		return nil, apperrors.ErrInternal.Wrap(err)
+	}
+	token.AccessHash, token.RefreshHash = accessHash, refreshHash
+	token.RefreshedAt = now
+	token.AccessExpiresAt = now.Add(s.settings.AccessTokenTTL)
+	token.RefreshExpiresAt = now.Add(s.settings.RefreshTokenTTL)
+	return &OAuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.settings.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        token.Scope,
+	}, nil
+}
+
+// findToken returns the unexpired token with the access or refresh token
+// and which of the two it is
+func (s *oauthService) findToken(raw string, now time.Time) (*models.OAuthToken, string, error) {
+	hash := hashToken(raw)
+	token, err := s.repo.GetTokenByAccess(hash, now)
+	if err != nil || token != nil {
+		return token, "access_token", err
+	}
+	token, err = s.repo.GetTokenByRefresh(hash, now)
+	return token, "refresh_token", err
+}
+
+func (s *oauthService) Introspect(clientID, clientSecret, raw string) (*OAuthIntrospection, error) {
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Confidential {
+		return nil, apperrors.ErrOAuthInvalidClient.WithMessage("Public clients cannot introspect tokens")
+	}
+	if raw == "" {
+		return nil, apperrors.ErrOAuthInvalidRequest.WithMessage("token is required")
+	}
+	token, tokenType, err := s.findToken(raw, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	// Чужие токены неотличимы от недействительных
+	if token == nil || token.ClientID != client.ID {
+		return &OAuthIntrospection{}, nil
+	}
+	user, err := s.userRepo.FindByID(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.PendingDeletion() {
+		return &OAuthIntrospection{}, nil
+	}
+	expiresAt := token.AccessExpiresAt
+	if tokenType == "refresh_token" {
+		expiresAt = token.RefreshExpiresAt
+	}
+	return &OAuthIntrospection{
+		Active:    true,
+		Scope:     token.Scope,
+		ClientID:  client.ClientID,
+		Username:  user.Username,
+		Subject:   strconv.Itoa(user.ID),
+		TokenType: tokenType,
+		ExpiresAt: expiresAt.Unix(),
+		IssuedAt:  token.RefreshedAt.Unix(),
+	}, nil
+}
+
+func (s *oauthService) Revoke(clientID, clientSecret, raw string) error {
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return err
+	}
+	if raw == "" {
+		return apperrors.ErrOAuthInvalidRequest.WithMessage("token is required")
+	}
+	token, _, err := s.findToken(raw, time.Now())
+	if err != nil || token == nil || token.ClientID != client.ID {
+		return err
+	}
+	return s.repo.DeleteToken(token.ID)
+}
+
+func (s *oauthService) Authenticate(accessToken string) (*OAuthAccess, error) {
+	token, err := s.repo.GetTokenByAccess(hashToken(accessToken), time.Now())
+	if err != nil || token == nil {
+		return nil, err
+	}
+	clients, err := s.repo.GetClientsByIDs([]int{token.ClientID})
+	if err != nil || len(clients) == 0 {
+		return nil, err
+	}
+	return &OAuthAccess{UserID: token.UserID, ClientID: clients[0].ClientID, Scopes: strings.Fields(token.Scope)}, nil
+}
+
+func (s *oauthService) GetAuthorizations(userID int) ([]OAuthAuthorization, error) {
+	consents, err := s.repo.GetConsents(userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(consents))
+	for i, consent := range consents {
+		ids[i] = consent.ClientID
+	}
+	clients, err := s.repo.GetClientsByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*models.OAuthClient, len(clients))
+	for i := range clients {
+		byID[clients[i].ID] = &clients[i]
+	}
+	authorizations := make([]OAuthAuthorization, 0, len(consents))
+	for _, consent := range consents {
+		if client := byID[consent.ClientID]; client != nil {
+			authorizations = append(authorizations, OAuthAuthorization{
+				Client:    clientInfo(client),
+				Scopes:    strings.Fields(consent.Scope),
+				GrantedAt: consent.UpdatedAt,
+			})
+		}
+	}
+	return authorizations, nil
+}
+
+func (s *oauthService) RevokeAuthorization(userID int, clientID string) error {
+	client, err := s.repo.GetClientByClientID(clientID)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return apperrors.ErrOAuthAuthorizationNotFound
+	}
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		user, err := s.userRepo.WithTx(tx).FindByID(userID)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return apperrors.ErrUnauthorized
+		}
+		deleted, err := s.repo.WithTx(tx).DeleteGrant(userID, client.ID)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return apperrors.ErrOAuthAuthorizationNotFound
+		}
+		return s.audit.recordAccount(tx, AuditUpdate, user, clientAudit(client), nil)
+	})
+}
+
+func clientInfo(client *models.OAuthClient) OAuthClientInfo {
+	return OAuthClientInfo{ClientID: client.ClientID, Name: client.Name, Website: client.Website}
+}
+
+func clientAudit(client *models.OAuthClient) map[string]interface{} {
+	return map[string]interface{}{"oauth_client": client.ClientID, "name": client.Name}
+}
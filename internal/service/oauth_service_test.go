@@ -0,0 +1,284 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/oidc"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type fakeOAuthRepo struct {
+	repository.OAuthRepository
+	clients  []models.OAuthClient
+	codes    map[string]models.OAuthCode
+	tokens   []models.OAuthToken
+	consents []models.OAuthConsent
+}
+
+func (r *fakeOAuthRepo) WithTx(*gorm.DB) repository.OAuthRepository { return r }
+
+func (r *fakeOAuthRepo) GetClientByClientID(clientID string) (*models.OAuthClient, error) {
+	for i := range r.clients {
+		if r.clients[i].ClientID == clientID {
+			client := r.clients[i]
+			return &client, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeOAuthRepo) CreateCode(code *models.OAuthCode) error {
+	r.codes[code.CodeHash] = *code
+	return nil
+}
+
+func (r *fakeOAuthRepo) TakeCode(codeHash string, now time.Time) (*models.OAuthCode, error) {
+	code, ok := r.codes[codeHash]
+	delete(r.codes, codeHash)
+	if !ok || !code.ExpiresAt.After(now) {
+		return nil, nil
+	}
+	return &code, nil
+}
+
+func (r *fakeOAuthRepo) DeleteExpiredCodes(time.Time) (int64, error) { return 0, nil }
+
+func (r *fakeOAuthRepo) CreateToken(token *models.OAuthToken) error {
+	token.ID = len(r.tokens) + 1
+	r.tokens = append(r.tokens, *token)
+	return nil
+}
+
+func (r *fakeOAuthRepo) GetTokenByAccess(accessHash string, now time.Time) (*models.OAuthToken, error) {
+	for _, token := range r.tokens {
+		if token.AccessHash == accessHash && token.AccessExpiresAt.After(now) {
+			return &token, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeOAuthRepo) GetClientsByIDs(ids []int) ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	for _, client := range r.clients {
+		for _, id := range ids {
+			if client.ID == id {
+				clients = append(clients, client)
+			}
+		}
+	}
+	return clients, nil
+}
+
+func (r *fakeOAuthRepo) GetTokenByRefresh(refreshHash string, now time.Time) (*models.OAuthToken, error) {
+	for _, token := range r.tokens {
+		if token.RefreshHash == refreshHash && token.RefreshExpiresAt.After(now) {
+			return &token, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeOAuthRepo) RotateToken(token *models.OAuthToken, oldRefreshHash string) (int64, error) {
+	for i := range r.tokens {
+		if r.tokens[i].ID == token.ID && r.tokens[i].RefreshHash == oldRefreshHash {
+			r.tokens[i] = *token
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (r *fakeOAuthRepo) DeleteExpiredTokens(time.Time) (int64, error) { return 0, nil }
+
+func (r *fakeOAuthRepo) GetConsent(userID, clientID int) (*models.OAuthConsent, error) {
+	for _, consent := range r.consents {
+		if consent.UserID == userID && consent.ClientID == clientID {
+			return &consent, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeOAuthRepo) SaveConsent(consent *models.OAuthConsent) error {
+	for i := range r.consents {
+		if r.consents[i].UserID == consent.UserID && r.consents[i].ClientID == consent.ClientID {
+			r.consents[i] = *consent
+			return nil
+		}
+	}
+	r.consents = append(r.consents, *consent)
+	return nil
+}
+
+const (
+	testClientID = "3f1c2a9d8e7b6a5f4e3d2c1b0a998877"
+	testRedirect = "https://sync.example.com/callback"
+	testVerifier = "dBjftJeZ4CVP-mB92K27uhbUZU0hjf4Ki9zB1o3c4Pr1"
+)
+
+func newOAuthTest(redirectURIs ...string) OAuthService {
+	repo := &fakeOAuthRepo{
+		clients: []models.OAuthClient{{ID: 1, ClientID: testClientID, Name: "Calendar Sync", RedirectURIs: redirectURIs}},
+		codes:   map[string]models.OAuthCode{},
+	}
+	users := &fakeUserRepo{users: []*models.User{{ID: 1, Username: "john"}}}
+	settings := OAuthSettings{
+		ConsentURL:      "https://app.example.com/oauth/consent",
+		CodeTTL:         time.Minute,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+	}
+	return NewOAuthService(repo, users, settings, NewAuditor(&fakeAuditRepo{}, nil), fakeTransactor{})
+}
+
+// authorize lets user 1 approve the scopes and returns the code
+func authorize(t *testing.T, s OAuthService, redirectURI, scope string) string {
+	t.Helper()
+	redirect, err := s.Decide(1, AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            testClientID,
+		RedirectURI:         redirectURI,
+		Scope:               scope,
+		CodeChallenge:       oidc.S256Challenge(testVerifier),
+		CodeChallengeMethod: "S256",
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := u.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in %s", redirect)
+	}
+	return code
+}
+
+func exchange(s OAuthService, code, redirectURI, verifier string) (*OAuthTokenResponse, error) {
+	return s.Token(TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  redirectURI,
+		CodeVerifier: verifier,
+		ClientID:     testClientID,
+	})
+}
+
+func TestOAuthPKCEMismatch(t *testing.T) {
+	s := newOAuthTest(testRedirect)
+	code := authorize(t, s, testRedirect, ScopeTasksRead)
+
+	_, err := exchange(s, code, testRedirect, strings.Repeat("x", minVerifierLength))
+	wantErr(t, err, apperrors.ErrOAuthInvalidGrant)
+	// Неудачная попытка тоже расходует код
+	_, err = exchange(s, code, testRedirect, testVerifier)
+	wantErr(t, err, apperrors.ErrOAuthInvalidGrant)
+}
+
+func TestOAuthCodeReuse(t *testing.T) {
+	s := newOAuthTest(testRedirect)
+	code := authorize(t, s, testRedirect, ScopeTasksRead)
+
+	tokens, err := exchange(s, code, testRedirect, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Scope != ScopeTasksRead || tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("unexpected tokens %+v", tokens)
+	}
+	_, err = exchange(s, code, testRedirect, testVerifier)
+	wantErr(t, err, apperrors.ErrOAuthInvalidGrant)
+}
+
+// RFC 6749 §4.1.3: the token request repeats redirect_uri only when the
+// authorization request had it
+func TestOAuthRedirectURI(t *testing.T) {
+	other := "https://sync.example.com/other"
+	tests := []struct {
+		name       string
+		registered []string
+		authorize  string
+		exchange   string
+		ok         bool
+	}{
+		{"given and repeated", []string{testRedirect, other}, testRedirect, testRedirect, true},
+		{"given and left out", []string{testRedirect, other}, testRedirect, "", false},
+		{"given and different", []string{testRedirect, other}, testRedirect, other, false},
+		{"defaulted and left out", []string{testRedirect}, "", "", true},
+		{"defaulted and repeated", []string{testRedirect}, "", testRedirect, true},
+		{"defaulted and different", []string{testRedirect}, "", other, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newOAuthTest(tt.registered...)
+			code := authorize(t, s, tt.authorize, ScopeTasksRead)
+			_, err := exchange(s, code, tt.exchange, testVerifier)
+			if tt.ok && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.ok {
+				wantErr(t, err, apperrors.ErrOAuthInvalidGrant)
+			}
+		})
+	}
+}
+
+func TestOAuthRefreshRotation(t *testing.T) {
+	s := newOAuthTest(testRedirect)
+	tokens, err := exchange(s, authorize(t, s, testRedirect, ScopeTasksRead), testRedirect, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := func(token, scope string) (*OAuthTokenResponse, error) {
+		return s.Token(TokenRequest{GrantType: "refresh_token", RefreshToken: token, Scope: scope, ClientID: testClientID})
+	}
+
+	renewed, err := refresh(tokens.RefreshToken, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.RefreshToken == tokens.RefreshToken || renewed.AccessToken == tokens.AccessToken {
+		t.Error("refreshing did not replace the tokens")
+	}
+	if access, err := s.Authenticate(tokens.AccessToken); err != nil || access != nil {
+		t.Errorf("the old access token still works: %+v, %v", access, err)
+	}
+	_, err = refresh(tokens.RefreshToken, "")
+	wantErr(t, err, apperrors.ErrOAuthInvalidGrant)
+	if _, err := refresh(renewed.RefreshToken, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOAuthScopeNarrowing(t *testing.T) {
+	s := newOAuthTest(testRedirect)
+	tokens, err := exchange(s, authorize(t, s, testRedirect, ScopeTasksRead+" "+ScopeListsRead), testRedirect, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh := func(token, scope string) (*OAuthTokenResponse, error) {
+		return s.Token(TokenRequest{GrantType: "refresh_token", RefreshToken: token, Scope: scope, ClientID: testClientID})
+	}
+
+	_, err = refresh(tokens.RefreshToken, ScopeTasksWrite)
+	wantErr(t, err, apperrors.ErrOAuthInvalidScope)
+	narrowed, err := refresh(tokens.RefreshToken, ScopeTasksRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if narrowed.Scope != ScopeTasksRead {
+		t.Errorf("scope = %q, want %q", narrowed.Scope, ScopeTasksRead)
+	}
+	// Суженные области не возвращаются при следующем обновлении
+	_, err = refresh(narrowed.RefreshToken, ScopeListsRead)
+	wantErr(t, err, apperrors.ErrOAuthInvalidScope)
+}
@@ -0,0 +1,439 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/oidc"
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Attempts to find a free username for a provisioned user before a random
+// suffix is used
+const usernameAttempts = 20
+
+// Runs of characters a username cannot contain
+var usernameInvalid = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// OIDCProvider is an identity provider users can sign in with
+type OIDCProvider struct {
+	// Name in URLs and of linked identities
+	Name        string
+	DisplayName string
+	Client      *oidc.Provider
+	// Create a user at the first sign-in of an unknown identity
+	AutoProvision bool
+	// Link an unknown identity to the user with the same verified email
+	LinkByEmail bool
+	// Email domains allowed to sign in; empty allows any
+	AllowedDomains []string
+}
+
+// OIDCSettings configure sign-in with identity providers
+type OIDCSettings struct {
+	// How long a started sign-in waits for the provider to redirect back
+	StateTTL  time.Duration
+	Providers []OIDCProvider
+}
+
+// OIDCProviderInfo model
+// swagger:model
+type OIDCProviderInfo struct {
+	// example: company
+	Name string `json:"name"`
+	// example: Company SSO
+	DisplayName string `json:"display_name"`
+	// Open it in the browser to sign in
+	// example: /auth/oidc/company/login
+	LoginURL string `json:"login_url"`
+}
+
+// OIDCCallback holds the query parameters the provider redirects back with
+type OIDCCallback struct {
+	State            string
+	Code             string
+	Error            string
+	ErrorDescription string
+	// Binding is the secret Start gave to the browser, read from its cookie
+	Binding string
+}
+
+// OIDCResult is the outcome of a provider redirecting back
+type OIDCResult struct {
+	User     *models.User
+	Identity *models.UserIdentity
+	// The identity was linked to a signed in user instead of signing in
+	Linked bool
+	// The user was created at this sign-in
+	Created bool
+	// The sign-in was started to restore the user's account
+	Restore bool
+}
+
+type OIDCService interface {
+	Providers() []OIDCProviderInfo
+	// Start returns the provider URL to send the browser to and a secret to
+	// keep in a cookie of that browser: only the browser that has it can
+	// finish the sign-in. With a linkUserID the identity is linked to that
+	// user instead of signing in; restore marks a sign-in that restores an
+	// account scheduled for deletion.
+	Start(ctx context.Context, provider string, linkUserID int, restore bool) (authURL string, binding string, err error)
+	// Finish checks the redirect back from the provider and finds, links or
+	// creates the user of the identity
+	Finish(ctx context.Context, provider string, callback OIDCCallback) (*OIDCResult, error)
+	GetIdentities(userID int) ([]models.UserIdentity, error)
+	// Unlink removes an identity, unless it is the only way the user can sign in
+	Unlink(userID, identityID int) error
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) OIDCService
+}
+
+type oidcService struct {
+	repo     repository.IdentityRepository
+	userRepo repository.UserRepository
+	settings OIDCSettings
+	tx       repository.Transactor
+	audit    Auditor
+}
+
+func NewOIDCService(repo repository.IdentityRepository, userRepo repository.UserRepository, settings OIDCSettings,
+	auditor Auditor, tx repository.Transactor) OIDCService {
+	return &oidcService{repo: repo, userRepo: userRepo, settings: settings, tx: tx, audit: auditor}
+}
+
+func (s *oidcService) WithActor(actor Actor) OIDCService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *oidcService) provider(name string) (*OIDCProvider, error) {
+	for i := range s.settings.Providers {
+		if s.settings.Providers[i].Name == name {
+			return &s.settings.Providers[i], nil
+		}
+	}
+	return nil, apperrors.ErrOIDCProviderNotFound
+}
+
+func (s *oidcService) Providers() []OIDCProviderInfo {
+	infos := make([]OIDCProviderInfo, len(s.settings.Providers))
+	for i, p := range s.settings.Providers {
+		infos[i] = OIDCProviderInfo{Name: p.Name, DisplayName: p.DisplayName, LoginURL: "/auth/oidc/" + p.Name + "/login"}
+	}
+	return infos
+}
+
+func (s *oidcService) Start(ctx context.Context, name string, linkUserID int, restore bool) (string, string, error) {
+	provider, err := s.provider(name)
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	if _, err := s.repo.DeleteExpiredLogins(now); err != nil {
+		return "", "", err
+	}
+	state, stateHash, err := newHashedToken()
+	if err != nil {
+		return "", "", apperrors.ErrInternal.Wrap(err)
+	}
+	binding, bindingHash, err := newHashedToken()
+	if err != nil {
+		return "", "", apperrors.ErrInternal.Wrap(err)
+	}
+	nonce, _, err := newHashedToken()
+	if err != nil {
+		return "", "", apperrors.ErrInternal.Wrap(err)
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return "", "", apperrors.ErrInternal.Wrap(err)
+	}
+
+	authURL, err := provider.Client.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		log.Printf("OIDC provider %s: %v", name, err)
+		return "", "", apperrors.ErrOIDCUnavailable.Wrap(err)
+	}
+	login := &models.OIDCLogin{
+		StateHash:    stateHash,
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		BrowserHash:  bindingHash,
+		Restore:      restore && linkUserID == 0,
+		ExpiresAt:    now.Add(s.settings.StateTTL),
+	}
+	if linkUserID != 0 {
+		login.LinkUserID = &linkUserID
+	}
+	if err := s.repo.CreateLogin(login); err != nil {
+		return "", "", err
+	}
+	return authURL, binding, nil
+}
+
+func (s *oidcService) Finish(ctx context.Context, name string, callback OIDCCallback) (*OIDCResult, error) {
+	provider, err := s.provider(name)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	login, err := s.repo.TakeLogin(hashToken(callback.State), now)
+	if err != nil {
+		return nil, err
+	}
+	if login == nil || login.Provider != name {
+		return nil, apperrors.ErrInvalidOIDCState
+	}
+	// Иначе можно начать вход у себя и подсунуть ссылку на callback жертве
+	if callback.Binding == "" || hashToken(callback.Binding) != login.BrowserHash {
+		return nil, apperrors.ErrInvalidOIDCState
+	}
+	if callback.Error != "" {
+		msg := "The identity provider refused the sign-in: " + callback.Error
+		if callback.ErrorDescription != "" {
+			msg += " (" + callback.ErrorDescription + ")"
+		}
+		return nil, apperrors.ErrOIDCFailed.WithMessage(msg)
+	}
+	if callback.Code == "" {
+		return nil, apperrors.ErrOIDCFailed
+	}
+
+	token, err := provider.Client.Exchange(ctx, callback.Code, login.CodeVerifier)
+	if err == nil {
+		var idToken *oidc.IDToken
+		if idToken, err = provider.Client.VerifyIDToken(ctx, token.IDToken, login.Nonce); err == nil {
+			return s.finish(provider, login, idToken, now)
+		}
+	}
+	log.Printf("OIDC provider %s: %v", name, err)
+	var oauthErr *oidc.Error
+	if errors.As(err, &oauthErr) || errors.Is(err, oidc.ErrInvalidIDToken) {
+		return nil, apperrors.ErrOIDCFailed.Wrap(err)
+	}
+	return nil, apperrors.ErrOIDCUnavailable.Wrap(err)
+}
+
+// finish links, finds or creates the user of a checked ID token
+func (s *oidcService) finish(provider *OIDCProvider, login *models.OIDCLogin, idToken *oidc.IDToken, now time.Time) (*OIDCResult, error) {
+	var email string
+	if idToken.Email != "" {
+		normalized, err := normalizeEmail(idToken.Email)
+		if err != nil {
+			return nil, apperrors.ErrOIDCFailed.WithMessage("The identity provider reported an invalid email")
+		}
+		email = normalized
+	}
+	if len(provider.AllowedDomains) > 0 && !(idToken.EmailVerified && allowedDomain(email, provider.AllowedDomains)) {
+		return nil, apperrors.ErrOIDCDomain
+	}
+
+	identity, err := s.repo.Get(provider.Name, idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if login.LinkUserID != nil {
+		return s.link(provider, *login.LinkUserID, identity, idToken, email, now)
+	}
+
+	if identity != nil {
+		user, err := s.userRepo.FindByID(identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, apperrors.ErrOIDCNoAccount
+		}
+		if err := s.repo.Touch(identity.ID, email, now); err != nil {
+			return nil, err
+		}
+		return &OIDCResult{User: user, Identity: identity, Restore: login.Restore}, nil
+	}
+
+	var existing *models.User
+	if email != "" {
+		if existing, err = s.userRepo.FindByEmail(email); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case existing != nil && provider.LinkByEmail && idToken.EmailVerified && existing.EmailVerified():
+		// Обе стороны подтвердили email, значит это один и тот же человек
+		result, err := s.link(provider, existing.ID, nil, idToken, email, now)
+		if err != nil {
+			return nil, err
+		}
+		result.Linked = false
+		return result, nil
+	case existing != nil:
+		return nil, apperrors.ErrOIDCAccountExists
+	case provider.AutoProvision:
+		return s.provision(provider, idToken, email, now)
+	}
+	return nil, apperrors.ErrOIDCNoAccount
+}
+
+// link attaches the identity to the user; linking it again is a no-op
+func (s *oidcService) link(provider *OIDCProvider, userID int, identity *models.UserIdentity, idToken *oidc.IDToken,
+	email string, now time.Time) (*OIDCResult, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, apperrors.ErrUnauthorized
+	}
+	if identity != nil {
+		if identity.UserID != userID {
+			return nil, apperrors.ErrIdentityLinked
+		}
+		return &OIDCResult{User: user, Identity: identity, Linked: true}, nil
+	}
+
+	identity = &models.UserIdentity{UserID: userID, Provider: provider.Name, Subject: idToken.Subject, Email: email, LastLoginAt: &now}
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.repo.WithTx(tx).Create(identity); err != nil {
+			return err
+		}
+		return s.audit.orUser(userID).recordAccount(tx, AuditUpdate, user, nil, identityAudit(identity))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCResult{User: user, Identity: identity, Linked: true}, nil
+}
+
+// provision creates a user without a password for the identity
+func (s *oidcService) provision(provider *OIDCProvider, idToken *oidc.IDToken, email string, now time.Time) (*OIDCResult, error) {
+	username, err := s.freeUsername(idToken.PreferredUsername, email)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{Username: username}
+	if name := strings.TrimSpace(idToken.Name); name != "" && utf8.RuneCountInString(name) <= MaxDisplayNameLength {
+		user.DisplayName = name
+	}
+	if email != "" {
+		user.Email = &email
+		if idToken.EmailVerified {
+			user.EmailVerifiedAt = &now
+		}
+	}
+	identity := &models.UserIdentity{Provider: provider.Name, Subject: idToken.Subject, Email: email, LastLoginAt: &now}
+
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).CreateUser(user); err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		if err := s.repo.WithTx(tx).Create(identity); err != nil {
+			return err
+		}
+		after := profileAudit(user)
+		for k, v := range identityAudit(identity) {
+			after[k] = v
+		}
+		return s.audit.orUser(user.ID).recordAccount(tx, AuditCreate, user, nil, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCResult{User: user, Identity: identity, Created: true}, nil
+}
+
+// freeUsername derives a username from the preferred username or the email
+// and adds a number while it is taken
+func (s *oidcService) freeUsername(preferred, email string) (string, error) {
+	base := preferred
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	// После замены остаются только ASCII-символы, длина в байтах равна длине в символах
+	base = strings.Trim(usernameInvalid.ReplaceAllString(base, "_"), ".-")
+	if len(base) < MinUsernameLength {
+		base = "user"
+	}
+	for i := 0; i < usernameAttempts; i++ {
+		var suffix string
+		if i > 0 {
+			suffix = fmt.Sprint(i + 1)
+		}
+		candidate := strings.TrimRight(base[:min(len(base), MaxUsernameLength-len(suffix))], ".-") + suffix
+		existing, err := s.userRepo.FindByUsername(candidate)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return candidate, nil
+		}
+	}
+	suffix, _, err := newHashedToken()
+	if err != nil {
+		return "", apperrors.ErrInternal.Wrap(err)
+	}
+	return strings.TrimRight(base[:min(len(base), MaxUsernameLength-9)], ".-") + "_" + suffix[:8], nil
+}
+
+func (s *oidcService) GetIdentities(userID int) ([]models.UserIdentity, error) {
+	return s.repo.GetForUser(userID)
+}
+
+func (s *oidcService) Unlink(userID, identityID int) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return apperrors.ErrUnauthorized
+	}
+	identities, err := s.repo.GetForUser(userID)
+	if err != nil {
+		return err
+	}
+	var identity *models.UserIdentity
+	for i := range identities {
+		if identities[i].ID == identityID {
+			identity = &identities[i]
+		}
+	}
+	if identity == nil {
+		return apperrors.ErrIdentityNotFound
+	}
+	if user.Password == "" && len(identities) == 1 {
+		return apperrors.ErrLastSignInMethod
+	}
+
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if _, err := s.repo.WithTx(tx).Delete(userID, identityID); err != nil {
+			return err
+		}
+		return s.audit.recordAccount(tx, AuditUpdate, user, identityAudit(identity), nil)
+	})
+}
+
+func identityAudit(identity *models.UserIdentity) map[string]interface{} {
+	return map[string]interface{}{"identity": identity.Provider + ":" + identity.Subject}
+}
+
+func allowedDomain(email string, domains []string) bool {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+	for _, d := range domains {
+		if strings.EqualFold(domain, strings.TrimPrefix(d, "@")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,535 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/oidc"
+	"RestAPI/pkg/oidc/fakeidp"
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+// In-memory repositories; every method the OIDC service does not use
+// panics through the nil embedded interface
+
+type fakeIdentityRepo struct {
+	repository.IdentityRepository
+	identities []models.UserIdentity
+	logins     map[string]models.OIDCLogin
+}
+
+func (r *fakeIdentityRepo) WithTx(*gorm.DB) repository.IdentityRepository { return r }
+
+func (r *fakeIdentityRepo) Get(provider, subject string) (*models.UserIdentity, error) {
+	for i := range r.identities {
+		if r.identities[i].Provider == provider && r.identities[i].Subject == subject {
+			identity := r.identities[i]
+			return &identity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeIdentityRepo) GetForUser(userID int) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	for _, identity := range r.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (r *fakeIdentityRepo) Create(identity *models.UserIdentity) error {
+	identity.ID = len(r.identities) + 1
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *fakeIdentityRepo) Touch(identityID int, email string, at time.Time) error {
+	for i := range r.identities {
+		if r.identities[i].ID == identityID {
+			r.identities[i].Email, r.identities[i].LastLoginAt = email, &at
+		}
+	}
+	return nil
+}
+
+func (r *fakeIdentityRepo) Delete(userID, identityID int) (int64, error) {
+	for i, identity := range r.identities {
+		if identity.ID == identityID && identity.UserID == userID {
+			r.identities = append(r.identities[:i], r.identities[i+1:]...)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (r *fakeIdentityRepo) CreateLogin(login *models.OIDCLogin) error {
+	r.logins[login.StateHash] = *login
+	return nil
+}
+
+func (r *fakeIdentityRepo) TakeLogin(stateHash string, now time.Time) (*models.OIDCLogin, error) {
+	login, ok := r.logins[stateHash]
+	delete(r.logins, stateHash)
+	if !ok || !login.ExpiresAt.After(now) {
+		return nil, nil
+	}
+	return &login, nil
+}
+
+func (r *fakeIdentityRepo) DeleteExpiredLogins(before time.Time) (int64, error) {
+	var n int64
+	for hash, login := range r.logins {
+		if login.ExpiresAt.Before(before) {
+			delete(r.logins, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+type fakeUserRepo struct {
+	repository.UserRepository
+	users []*models.User
+}
+
+func (r *fakeUserRepo) WithTx(*gorm.DB) repository.UserRepository { return r }
+
+func (r *fakeUserRepo) FindByID(id int) (*models.User, error) {
+	for _, u := range r.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeUserRepo) FindByUsername(username string) (*models.User, error) {
+	for _, u := range r.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeUserRepo) FindByEmail(email string) (*models.User, error) {
+	for _, u := range r.users {
+		if u.Email != nil && *u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeUserRepo) CreateUser(user *models.User) error {
+	user.ID = len(r.users) + 1
+	r.users = append(r.users, user)
+	return nil
+}
+
+type oidcTest struct {
+	idp        *fakeidp.Server
+	provider   *OIDCProvider
+	identities *fakeIdentityRepo
+	users      *fakeUserRepo
+	audit      *fakeAuditRepo
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	idp := fakeidp.New("client-id", "client-secret")
+	srv := httptest.NewServer(idp)
+	t.Cleanup(srv.Close)
+	client, err := oidc.NewProvider(oidc.Config{
+		Issuer:       srv.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://app.example.com/auth/oidc/company/callback",
+		Scopes:       []string{"email", "profile"},
+		Client:       srv.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.SignIn(fakeidp.User{Subject: "sub-1", Email: "john@example.com", EmailVerified: true, Name: "John Doe", PreferredUsername: "john"})
+	return &oidcTest{
+		idp:        idp,
+		provider:   &OIDCProvider{Name: "company", DisplayName: "Company SSO", Client: client, AutoProvision: true, LinkByEmail: true},
+		identities: &fakeIdentityRepo{logins: map[string]models.OIDCLogin{}},
+		users:      &fakeUserRepo{},
+		audit:      &fakeAuditRepo{},
+	}
+}
+
+func (o *oidcTest) service() OIDCService {
+	settings := OIDCSettings{StateTTL: 10 * time.Minute, Providers: []OIDCProvider{*o.provider}}
+	return NewOIDCService(o.identities, o.users, settings, NewAuditor(o.audit, nil), fakeTransactor{})
+}
+
+// signIn starts a sign-in, lets the provider approve it and finishes it
+func (o *oidcTest) signIn(t *testing.T, linkUserID int) (*OIDCResult, error) {
+	t.Helper()
+	s := o.service()
+	ctx := context.Background()
+	authURL, binding, err := s.Start(ctx, "company", linkUserID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := o.idp.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Finish(ctx, "company", callbackOf(t, redirect, binding))
+}
+
+// callbackOf parses the redirect back from the provider as the browser
+// with the binding cookie sends it
+func callbackOf(t *testing.T, callback string, binding string) OIDCCallback {
+	t.Helper()
+	u, err := url.Parse(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	return OIDCCallback{State: q.Get("state"), Code: q.Get("code"), Error: q.Get("error"), ErrorDescription: q.Get("error_description"), Binding: binding}
+}
+
+func (o *oidcTest) addUser(username, email string, verified bool) *models.User {
+	user := &models.User{Username: username, Password: "hash"}
+	if email != "" {
+		user.Email = &email
+		if verified {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+	}
+	o.users.CreateUser(user)
+	return user
+}
+
+func TestOIDCProvision(t *testing.T) {
+	o := newOIDCTest(t)
+	o.addUser("john", "", false)
+
+	result, err := o.signIn(t, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := result.User
+	if !result.Created || result.Linked {
+		t.Errorf("Created = %v, Linked = %v", result.Created, result.Linked)
+	}
+	if user.Username != "john2" || user.DisplayName != "John Doe" || user.Password != "" {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if user.Email == nil || *user.Email != "john@example.com" || !user.EmailVerified() {
+		t.Errorf("email %v is not set and verified", user.Email)
+	}
+	if result.Identity.UserID != user.ID || result.Identity.Subject != "sub-1" || result.Identity.Provider != "company" {
+		t.Errorf("unexpected identity %+v", result.Identity)
+	}
+	if len(o.audit.entries) != 1 || o.audit.entries[0].ActorID != user.ID || o.audit.entries[0].Action != AuditCreate {
+		t.Errorf("unexpected audit entries %+v", o.audit.entries)
+	}
+
+	// Повторный вход находит того же пользователя
+	result, err = o.signIn(t, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Created || result.User.ID != user.ID {
+		t.Errorf("second sign-in: Created = %v, user %d", result.Created, result.User.ID)
+	}
+	if len(o.users.users) != 2 || len(o.identities.identities) != 1 {
+		t.Errorf("%d users and %d identities", len(o.users.users), len(o.identities.identities))
+	}
+	if o.identities.identities[0].LastLoginAt == nil {
+		t.Error("sign-in time was not stored")
+	}
+}
+
+func TestOIDCUnverifiedEmailProvision(t *testing.T) {
+	o := newOIDCTest(t)
+	o.idp.SignIn(fakeidp.User{Subject: "sub-1", Email: "john@example.com"})
+	result, err := o.signIn(t, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User.Username != "john" || result.User.EmailVerified() {
+		t.Errorf("unexpected user %+v", result.User)
+	}
+}
+
+func TestOIDCLinkByEmail(t *testing.T) {
+	tests := []struct {
+		name          string
+		userVerified  bool
+		idpVerified   bool
+		linkByEmail   bool
+		autoProvision bool
+		err           *apperrors.Error
+	}{
+		{"both verified", true, true, true, false, nil},
+		{"user email unverified", false, true, true, true, apperrors.ErrOIDCAccountExists},
+		{"provider email unverified", true, false, true, true, apperrors.ErrOIDCAccountExists},
+		{"linking by email disabled", true, true, false, true, apperrors.ErrOIDCAccountExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOIDCTest(t)
+			o.provider.LinkByEmail, o.provider.AutoProvision = tt.linkByEmail, tt.autoProvision
+			o.idp.SignIn(fakeidp.User{Subject: "sub-1", Email: "john@example.com", EmailVerified: tt.idpVerified})
+			existing := o.addUser("johnny", "john@example.com", tt.userVerified)
+
+			result, err := o.signIn(t, 0)
+			if tt.err != nil {
+				wantErr(t, err, tt.err)
+				if len(o.identities.identities) != 0 || len(o.users.users) != 1 {
+					t.Error("an identity or user was created")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.User.ID != existing.ID || result.Created || result.Linked {
+				t.Errorf("user %d, Created = %v, Linked = %v", result.User.ID, result.Created, result.Linked)
+			}
+			if len(o.identities.identities) != 1 || o.identities.identities[0].UserID != existing.ID {
+				t.Errorf("unexpected identities %+v", o.identities.identities)
+			}
+		})
+	}
+}
+
+func TestOIDCNoAccount(t *testing.T) {
+	o := newOIDCTest(t)
+	o.provider.AutoProvision = false
+	_, err := o.signIn(t, 0)
+	wantErr(t, err, apperrors.ErrOIDCNoAccount)
+}
+
+func TestOIDCAllowedDomains(t *testing.T) {
+	o := newOIDCTest(t)
+	o.provider.AllowedDomains = []string{"@example.org"}
+	_, err := o.signIn(t, 0)
+	wantErr(t, err, apperrors.ErrOIDCDomain)
+
+	o.provider.AllowedDomains = []string{"EXAMPLE.com"}
+	if _, err := o.signIn(t, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Неподтверждённый email не доказывает принадлежность домену
+	o.idp.SignIn(fakeidp.User{Subject: "sub-2", Email: "jane@example.com"})
+	_, err = o.signIn(t, 0)
+	wantErr(t, err, apperrors.ErrOIDCDomain)
+}
+
+func TestOIDCLinkSignedInUser(t *testing.T) {
+	o := newOIDCTest(t)
+	user := o.addUser("johnny", "other@example.com", true)
+	result, err := o.signIn(t, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Linked || result.User.ID != user.ID || result.Identity.UserID != user.ID {
+		t.Errorf("unexpected result %+v", result)
+	}
+	// Повторная привязка ничего не меняет
+	if _, err := o.signIn(t, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.identities.identities) != 1 {
+		t.Errorf("%d identities", len(o.identities.identities))
+	}
+
+	other := o.addUser("jane", "", false)
+	_, err = o.signIn(t, other.ID)
+	wantErr(t, err, apperrors.ErrIdentityLinked)
+}
+
+func TestOIDCState(t *testing.T) {
+	o := newOIDCTest(t)
+	s := o.service()
+	ctx := context.Background()
+	authURL, binding, err := s.Start(ctx, "company", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := o.idp.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := callbackOf(t, redirect, binding)
+
+	forged := callback
+	forged.State = "forged"
+	_, err = s.Finish(ctx, "company", forged)
+	wantErr(t, err, apperrors.ErrInvalidOIDCState)
+	_, err = s.Finish(ctx, "other", callback)
+	wantErr(t, err, apperrors.ErrOIDCProviderNotFound)
+
+	if _, err := s.Finish(ctx, "company", callback); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Finish(ctx, "company", callback)
+	wantErr(t, err, apperrors.ErrInvalidOIDCState)
+
+	_, _, err = s.Start(ctx, "other", 0, false)
+	wantErr(t, err, apperrors.ErrOIDCProviderNotFound)
+}
+
+// A callback from a browser without the cookie of the sign-in, as when an
+// attacker starts linking and lures the victim to the redirect, fails and
+// uses the sign-in up
+func TestOIDCBrowserBinding(t *testing.T) {
+	o := newOIDCTest(t)
+	victim := o.addUser("victim", "", false)
+	attacker := o.addUser("attacker", "", false)
+	s := o.service()
+	ctx := context.Background()
+
+	authURL, binding, err := s.Start(ctx, "company", attacker.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := o.idp.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, victimBinding, err := s.Start(ctx, "company", victim.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []string{"", victimBinding, binding + "x"} {
+		_, err = s.Finish(ctx, "company", callbackOf(t, redirect, b))
+		wantErr(t, err, apperrors.ErrInvalidOIDCState)
+	}
+	_, err = s.Finish(ctx, "company", callbackOf(t, redirect, binding))
+	wantErr(t, err, apperrors.ErrInvalidOIDCState)
+	if identities, _ := o.identities.GetForUser(attacker.ID); len(identities) != 0 {
+		t.Errorf("identity linked: %+v", identities)
+	}
+}
+
+func TestOIDCExpiredState(t *testing.T) {
+	o := newOIDCTest(t)
+	s := o.service()
+	ctx := context.Background()
+	authURL, binding, err := s.Start(ctx, "company", 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := o.idp.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hash, login := range o.identities.logins {
+		login.ExpiresAt = time.Now().Add(-time.Second)
+		o.identities.logins[hash] = login
+	}
+	_, err = s.Finish(ctx, "company", callbackOf(t, redirect, binding))
+	wantErr(t, err, apperrors.ErrInvalidOIDCState)
+}
+
+func TestOIDCProviderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+	}{
+		{"wrong nonce", func(c jwt.MapClaims) { c["nonce"] = "other" }},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOIDCTest(t)
+			o.idp.ModifyClaims = tt.modify
+			_, err := o.signIn(t, 0)
+			wantErr(t, err, apperrors.ErrOIDCFailed)
+			if len(o.users.users) != 0 {
+				t.Error("a user was created")
+			}
+		})
+	}
+
+	t.Run("refused", func(t *testing.T) {
+		o := newOIDCTest(t)
+		o.idp.SignOut()
+		_, err := o.signIn(t, 0)
+		wantErr(t, err, apperrors.ErrOIDCFailed)
+	})
+	t.Run("wrong client secret", func(t *testing.T) {
+		o := newOIDCTest(t)
+		o.idp.ClientSecret = "other"
+		_, err := o.signIn(t, 0)
+		wantErr(t, err, apperrors.ErrOIDCFailed)
+	})
+	t.Run("unreachable", func(t *testing.T) {
+		client, err := oidc.NewProvider(oidc.Config{Issuer: "http://127.0.0.1:1", ClientID: "id", RedirectURL: "https://app.example.com/cb"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := newOIDCTest(t)
+		o.provider.Client = client
+		_, _, err = o.service().Start(context.Background(), "company", 0, false)
+		wantErr(t, err, apperrors.ErrOIDCUnavailable)
+	})
+}
+
+func TestOIDCRestore(t *testing.T) {
+	o := newOIDCTest(t)
+	if _, err := o.signIn(t, 0); err != nil {
+		t.Fatal(err)
+	}
+	s := o.service()
+	ctx := context.Background()
+	for _, restore := range []bool{false, true} {
+		authURL, binding, err := s.Start(ctx, "company", 0, restore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		redirect, err := o.idp.Authorize(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := s.Finish(ctx, "company", callbackOf(t, redirect, binding))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Restore != restore {
+			t.Errorf("Restore = %v, want %v", result.Restore, restore)
+		}
+	}
+}
+
+func TestOIDCUnlink(t *testing.T) {
+	o := newOIDCTest(t)
+	result, err := o.signIn(t, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := o.service()
+	// Без пароля единственная привязка - единственный способ войти
+	wantErr(t, s.Unlink(result.User.ID, result.Identity.ID), apperrors.ErrLastSignInMethod)
+	wantErr(t, s.Unlink(result.User.ID, result.Identity.ID+1), apperrors.ErrIdentityNotFound)
+
+	result.User.Password = "hash"
+	if err := s.Unlink(result.User.ID, result.Identity.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(o.identities.identities) != 0 {
+		t.Error("identity was not deleted")
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/pkg/rank"
+)
+
+// placement is where an item lands inside an ordered sequence
+type placement struct {
+	Index int
+	Key   string
+	// Rebalanced holds fresh keys for every other item of the sequence, in
+	// order, when the neighbours left no room (or had legacy blank keys).
+	Rebalanced []string
+}
+
+// place computes the ordering key for an item put right after afterID or
+// right before beforeID (at the end when both are zero). ids and keys
+// describe the current sequence without the item being placed.
+func place(ids []int, keys []string, afterID int, beforeID int) (*placement, error) {
+	idx := len(ids)
+	switch {
+	case afterID != 0:
+		i := indexOf(ids, afterID)
+		if i < 0 {
+			return nil, apperrors.Validation(apperrors.Field("after_id", "not_found", "item is not in this sequence"))
+		}
+		idx = i + 1
+		if beforeID != 0 && (idx >= len(ids) || ids[idx] != beforeID) {
+			return nil, apperrors.Validation(apperrors.Field("before_id", "not_adjacent", "must directly follow after_id"))
+		}
+	case beforeID != 0:
+		idx = indexOf(ids, beforeID)
+		if idx < 0 {
+			return nil, apperrors.Validation(apperrors.Field("before_id", "not_found", "item is not in this sequence"))
+		}
+	}
+
+	lower, upper := "", ""
+	if idx > 0 {
+		lower = keys[idx-1]
+	}
+	if idx < len(keys) {
+		upper = keys[idx]
+	}
+	if !hasBlank(keys) {
+		key, err := rank.Between(lower, upper)
+		if err == nil && len(key) <= rank.MaxLength {
+			return &placement{Index: idx, Key: key}, nil
+		}
+	}
+
+	// Ключи слишком длинные или некорректные - перенумеровываем всю последовательность
+	sequence := rank.Sequence(len(ids) + 1)
+	rebalanced := append(append([]string{}, sequence[:idx]...), sequence[idx+1:]...)
+	return &placement{Index: idx, Key: sequence[idx], Rebalanced: rebalanced}, nil
+}
+
+// nextPosition returns a key after last, starting a fresh sequence when
+// last is blank or unusable
+func nextPosition(last string) string {
+	key, err := rank.Between(last, "")
+	if err != nil {
+		key, _ = rank.Between("", "")
+	}
+	return key
+}
+
+func indexOf(ids []int, id int) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasBlank(keys []string) bool {
+	for _, key := range keys {
+		if key == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,403 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/blobstore"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxDisplayNameLength = 100
+	MaxEmailLength       = 254
+	MinUsernameLength    = 3
+	MaxUsernameLength    = 32
+)
+
+var (
+	// Имя должно оставаться упоминаемым через @username
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_.-]*[A-Za-z0-9_])?$`)
+	localePattern   = regexp.MustCompile(`^([a-zA-Z]{2,3})(-[a-zA-Z]{4})?(-([a-zA-Z]{2}|[0-9]{3}))?$`)
+)
+
+// Regions whose weeks start on Sunday or Saturday; elsewhere they start on Monday
+var (
+	sundayRegions   = map[string]bool{"US": true, "CA": true, "MX": true, "BR": true, "JP": true, "KR": true, "IL": true, "PH": true, "IN": true, "ZA": true, "AU": true}
+	saturdayRegions = map[string]bool{"EG": true, "SA": true, "AE": true, "IQ": true, "JO": true, "KW": true, "QA": true, "SY": true, "AF": true, "IR": true}
+)
+
+// ProfileFields holds the profile changes; nil fields stay unchanged
+type ProfileFields struct {
+	Username    *string
+	DisplayName *string
+	// "" clears it
+	Email    *string
+	Timezone *string
+	Locale   *string
+	// 0 clears it
+	DefaultListID *int
+	// "" follows the locale
+	WeekStart *string
+}
+
+// DateSettings tell how the user reads dates
+type DateSettings struct {
+	Location  *time.Location
+	WeekStart time.Weekday
+}
+
+type ProfileService interface {
+	GetProfile(userID int) (*models.User, error)
+	UpdateProfile(userID int, fields ProfileFields) (*models.User, error)
+	// SetAvatar resizes the uploaded image and replaces the user's avatar
+	SetAvatar(ctx context.Context, userID int, r io.Reader) (*models.User, error)
+	OpenAvatar(ctx context.Context, userID int) (*models.User, blobstore.Object, error)
+	DeleteAvatar(ctx context.Context, userID int) error
+	// DateSettings returns the time zone and first day of the week of the user
+	DateSettings(userID int) (DateSettings, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) ProfileService
+}
+
+type profileService struct {
+	repo          repository.UserRepository
+	listRepo      repository.TodoListRepository
+	verifications EmailVerificationService
+	store         blobstore.Store
+	tx            repository.Transactor
+	audit         Auditor
+}
+
+func NewProfileService(repo repository.UserRepository, listRepo repository.TodoListRepository, verifications EmailVerificationService,
+	store blobstore.Store, auditor Auditor, tx repository.Transactor) ProfileService {
+	return &profileService{repo: repo, listRepo: listRepo, verifications: verifications, store: store, tx: tx, audit: auditor}
+}
+
+func (s *profileService) WithActor(actor Actor) ProfileService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	cp.verifications = s.verifications.WithActor(actor)
+	return &cp
+}
+
+func (s *profileService) getUser(userID int) (*models.User, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, apperrors.ErrUnauthorized
+	}
+	if user.AvatarKey != "" {
+		user.AvatarURL = "/me/avatar"
+	}
+	return user, nil
+}
+
+func (s *profileService) GetProfile(userID int) (*models.User, error) {
+	return s.getUser(userID)
+}
+
+func (s *profileService) UpdateProfile(userID int, fields ProfileFields) (*models.User, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	before := profileAudit(user)
+
+	var errs []apperrors.FieldError
+	if fields.Username != nil && *fields.Username != user.Username {
+		username := *fields.Username
+		n := utf8.RuneCountInString(username)
+		switch {
+		case n < MinUsernameLength || n > MaxUsernameLength:
+			errs = append(errs, apperrors.Field("username", "length", fmt.Sprintf("must be %d to %d characters", MinUsernameLength, MaxUsernameLength)))
+		case !usernamePattern.MatchString(username):
+			errs = append(errs, apperrors.Field("username", "format", "may contain only letters, digits, _, . and - and must start and end with a letter, digit or _"))
+		default:
+			existing, err := s.repo.FindByUsername(username)
+			if err != nil {
+				return nil, err
+			}
+			if existing != nil {
+				return nil, apperrors.ErrUserAlreadyExists
+			}
+			user.Username = username
+		}
+	}
+	if fields.DisplayName != nil {
+		name := strings.TrimSpace(*fields.DisplayName)
+		if utf8.RuneCountInString(name) > MaxDisplayNameLength {
+			errs = append(errs, apperrors.Field("display_name", "max", fmt.Sprintf("must be at most %d characters", MaxDisplayNameLength)))
+		}
+		user.DisplayName = name
+	}
+	if fields.Email != nil {
+		email, err := s.cleanEmail(*fields.Email, user.ID)
+		var fieldErr *apperrors.Error
+		if errors.As(err, &fieldErr) && len(fieldErr.Fields) > 0 {
+			errs = append(errs, fieldErr.Fields...)
+		} else if err != nil {
+			return nil, err
+		}
+		if !sameEmail(email, user.Email) {
+			// Новый адрес нужно подтвердить заново
+			user.Email, user.EmailVerifiedAt = email, nil
+		}
+	}
+	if fields.Timezone != nil {
+		if _, err := time.LoadLocation(*fields.Timezone); err != nil || *fields.Timezone == "" || *fields.Timezone == "Local" {
+			errs = append(errs, apperrors.Field("timezone", "timezone", "must be an IANA time zone such as Europe/Moscow"))
+		}
+		user.Timezone = *fields.Timezone
+	}
+	if fields.Locale != nil {
+		locale, ok := canonicalLocale(*fields.Locale)
+		if !ok {
+			errs = append(errs, apperrors.Field("locale", "locale", "must be a language tag such as en-US"))
+		}
+		user.Locale = locale
+	}
+	if fields.WeekStart != nil {
+		switch *fields.WeekStart {
+		case "", models.WeekStartMonday, models.WeekStartSunday, models.WeekStartSaturday:
+			user.Preferences.WeekStart = *fields.WeekStart
+		default:
+			errs = append(errs, apperrors.Field("preferences.week_start", "oneof", "must be one of: monday sunday saturday"))
+		}
+	}
+	if fields.DefaultListID != nil {
+		listID := *fields.DefaultListID
+		if listID == 0 {
+			user.Preferences.DefaultListID = nil
+		} else {
+			ok, err := s.listRepo.HasAccess(listID, userID)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				errs = append(errs, apperrors.Field("preferences.default_list_id", "exists", "must be one of your lists"))
+			}
+			user.Preferences.DefaultListID = &listID
+		}
+	}
+	if len(errs) > 0 {
+		return nil, apperrors.Validation(errs...)
+	}
+
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.repo.WithTx(tx).UpdateProfile(user); err != nil {
+			return err
+		}
+		return s.audit.recordAccount(tx, AuditUpdate, user, before, profileAudit(user))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user.Email != nil && !user.EmailVerified() && !sameEmail(user.Email, before["email"].(*string)) {
+		// Профиль уже сохранён; письмо можно запросить повторно
+		if err := s.verifications.Send(context.Background(), user.ID); err != nil {
+			log.Printf("Verification email after an email change failed: %v", err)
+		}
+	}
+	return user, nil
+}
+
+func sameEmail(a, b *string) bool {
+	return a == b || a != nil && b != nil && *a == *b
+}
+
+// cleanEmail normalizes the address and checks that no other account uses
+// it; an empty address clears the email
+func (s *profileService) cleanEmail(raw string, userID int) (*string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	email, err := normalizeEmail(raw)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.ID != userID {
+		return nil, apperrors.ErrEmailAlreadyUsed
+	}
+	return &email, nil
+}
+
+func (s *profileService) SetAvatar(ctx context.Context, userID int, r io.Reader) (*models.User, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	avatar, err := resizeAvatar(r)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newAvatarKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Put(ctx, key, bytes.NewReader(avatar), int64(len(avatar))); err != nil {
+		return nil, err
+	}
+
+	old := user.AvatarKey
+	user.AvatarKey = key
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.repo.WithTx(tx).UpdateProfile(user); err != nil {
+			return err
+		}
+		return s.audit.recordAccount(tx, AuditUpdate, user, map[string]interface{}{"avatar": old != ""}, map[string]interface{}{"avatar": true})
+	})
+	if err != nil {
+		_ = s.store.Delete(ctx, key)
+		return nil, err
+	}
+	if old != "" {
+		// Старый файл больше не нужен; если удалить не вышло, он просто останется в хранилище
+		_ = s.store.Delete(ctx, old)
+	}
+	user.AvatarURL = "/me/avatar"
+	return user, nil
+}
+
+func (s *profileService) OpenAvatar(ctx context.Context, userID int) (*models.User, blobstore.Object, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.AvatarKey == "" {
+		return nil, nil, apperrors.ErrAvatarNotFound
+	}
+	avatar, err := s.store.Open(ctx, user.AvatarKey)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, nil, apperrors.ErrAvatarNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("open avatar %s: %w", user.AvatarKey, err)
+	}
+	return user, avatar, nil
+}
+
+func (s *profileService) DeleteAvatar(ctx context.Context, userID int) error {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.AvatarKey == "" {
+		return apperrors.ErrAvatarNotFound
+	}
+	key := user.AvatarKey
+	user.AvatarKey = ""
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.repo.WithTx(tx).UpdateProfile(user); err != nil {
+			return err
+		}
+		return s.audit.recordAccount(tx, AuditUpdate, user, map[string]interface{}{"avatar": true}, map[string]interface{}{"avatar": false})
+	})
+	if err != nil {
+		return err
+	}
+	_ = s.store.Delete(ctx, key)
+	return nil
+}
+
+func (s *profileService) DateSettings(userID int) (DateSettings, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return DateSettings{}, err
+	}
+	return userDateSettings(user), nil
+}
+
+// userDateSettings falls back to UTC for zones unknown to this server and
+// takes the first day of the week from the locale unless it is set
+func userDateSettings(user *models.User) DateSettings {
+	settings := DateSettings{Location: location(user.Timezone), WeekStart: time.Monday}
+	weekStart := user.Preferences.WeekStart
+	if weekStart == "" {
+		weekStart = localeWeekStart(user.Locale)
+	}
+	switch weekStart {
+	case models.WeekStartSunday:
+		settings.WeekStart = time.Sunday
+	case models.WeekStartSaturday:
+		settings.WeekStart = time.Saturday
+	}
+	return settings
+}
+
+// localeWeekStart returns the first day of the week in the locale's region
+func localeWeekStart(locale string) string {
+	parts := strings.Split(locale, "-")
+	region := parts[len(parts)-1]
+	if len(parts) == 1 {
+		// Без региона смотрим на язык: en - это США, ar - арабские страны
+		switch parts[0] {
+		case "en", "ja", "ko", "he", "pt":
+			region = "US"
+		case "ar", "fa":
+			region = "SA"
+		}
+	}
+	switch {
+	case sundayRegions[region]:
+		return models.WeekStartSunday
+	case saturdayRegions[region]:
+		return models.WeekStartSaturday
+	}
+	return models.WeekStartMonday
+}
+
+// canonicalLocale checks a language[-Script][-REGION] tag and fixes the case
+// of its parts: EN-us becomes en-US
+func canonicalLocale(raw string) (string, bool) {
+	m := localePattern.FindStringSubmatch(raw)
+	if m == nil {
+		return raw, false
+	}
+	locale := strings.ToLower(m[1])
+	if m[2] != "" {
+		locale += "-" + strings.ToUpper(m[2][1:2]) + strings.ToLower(m[2][2:])
+	}
+	if m[4] != "" {
+		locale += "-" + strings.ToUpper(m[4])
+	}
+	return locale, true
+}
+
+// profileAudit is the part of the profile recorded in the audit log
+func profileAudit(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"username":     user.Username,
+		"display_name": user.DisplayName,
+		"email":        user.Email,
+		"timezone":     user.Timezone,
+		"locale":       user.Locale,
+		"preferences":  user.Preferences,
+	}
+}
+
+func newAvatarKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "avatar-" + hex.EncodeToString(b), nil
+}
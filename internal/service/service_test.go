@@ -0,0 +1,37 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// In-memory fakes shared by the service tests. They embed the repository
+// interface, so a method a test does not expect panics.
+
+type fakeAuditRepo struct {
+	repository.AuditRepository
+	entries []models.AuditEntry
+}
+
+func (r *fakeAuditRepo) WithTx(*gorm.DB) repository.AuditRepository { return r }
+
+func (r *fakeAuditRepo) CreateEntry(entry *models.AuditEntry) error {
+	r.entries = append(r.entries, *entry)
+	return nil
+}
+
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithinTransaction(fn func(tx *gorm.DB) error) error { return fn(nil) }
+
+func wantErr(t *testing.T, err error, want *apperrors.Error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("error = %v, want %v", err, want)
+	}
+}
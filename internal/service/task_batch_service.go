@@ -0,0 +1,392 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+const (
+	BatchOpCreate   = "create"
+	BatchOpUpdate   = "update"
+	BatchOpDelete   = "delete"
+	BatchOpComplete = "complete"
+
+	MaxBatchOperations = 500
+)
+
+// BatchOperation is a single create/update/delete/complete step of a batch.
+// TaskID is ignored for create.
+type BatchOperation struct {
+	Op     string
+	TaskID int
+	TaskFields
+}
+
+// BatchResult reports the outcome of one operation
+type BatchResult struct {
+	Index  int
+	Op     string
+	Status int
+	Task   *models.Task
+	Err    *apperrors.Error
+}
+
+var errBatchAborted = errors.New("batch aborted")
+
+// BatchTasks runs all operations against one list inside a single transaction.
+// In atomic mode the first failure rolls everything back; otherwise each
+// operation runs in its own savepoint and failures are only reported.
+func (s *taskService) BatchTasks(listID int, userID int, ops []BatchOperation, atomic bool) ([]BatchResult, error) {
+	if len(ops) == 0 {
+		return nil, apperrors.Validation(apperrors.Field("operations", "required", "at least one operation is required"))
+	}
+	if len(ops) > MaxBatchOperations {
+		return nil, apperrors.Validation(apperrors.Field("operations", "max", fmt.Sprintf("must contain at most %d operations", MaxBatchOperations)))
+	}
+
+	results := make([]BatchResult, len(ops))
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+
+		// Одна выборка вместо проверки владельца для каждой задачи
+		existing, err := repo.GetAllTasksForThisList(listID, userID)
+		if err != nil {
+			return err
+		}
+		tasks := make(map[int]*models.Task, len(existing))
+		for i := range existing {
+			tasks[existing[i].ID] = &existing[i]
+		}
+		last, err := repo.LastTaskPosition(listID)
+		if err != nil {
+			return err
+		}
+		wf, err := loadWorkflow(s.statusRepo.WithTx(tx), listID)
+		if err != nil {
+			return err
+		}
+		run := &batchRun{
+			tx:     tx,
+			repo:   repo,
+			audit:  s.audit,
+			deps:   s.depRepo.WithTx(tx),
+			wf:     wf,
+			tasks:  tasks,
+			last:   last,
+			listID: listID,
+			userID: userID,
+			now:    time.Now(),
+		}
+
+		for i, op := range ops {
+			savepoint := fmt.Sprintf("batch_op_%d", i)
+			var state batchState
+			if !atomic {
+				if err := tx.SavePoint(savepoint).Error; err != nil {
+					return err
+				}
+				state = run.save()
+			}
+
+			results[i] = run.execute(i, op)
+			if results[i].Err == nil {
+				continue
+			}
+			if results[i].Status >= http.StatusInternalServerError || atomic {
+				return errBatchAborted
+			}
+			if err := tx.RollbackTo(savepoint).Error; err != nil {
+				return err
+			}
+			run.restore(state)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchAborted) {
+		return nil, err
+	}
+	if err == nil {
+		var tasks []*models.Task
+		for _, result := range results {
+			if result.Task != nil {
+				tasks = append(tasks, result.Task)
+			}
+		}
+		if err := markBlocked(s.depRepo, tasks...); err != nil {
+			return nil, err
+		}
+	}
+	if errors.Is(err, errBatchAborted) {
+		// Транзакция откатилась, поэтому успешные шаги тоже не применены
+		for i := range results {
+			if results[i].Err == nil && results[i].Status != 0 {
+				results[i].Status = http.StatusFailedDependency
+				results[i].Task = nil
+				results[i].Err = errBatchRolledBack
+			} else if results[i].Status == 0 {
+				results[i] = BatchResult{Index: i, Op: ops[i].Op, Status: http.StatusFailedDependency, Err: errBatchRolledBack}
+			}
+		}
+	}
+	return results, nil
+}
+
+var errBatchRolledBack = apperrors.New(http.StatusFailedDependency, "batch_rolled_back", "Not applied because another operation in the batch failed")
+
+// batchRun is the state shared by the operations of one batch
+type batchRun struct {
+	tx    *gorm.DB
+	repo  repository.TaskRepository
+	audit Auditor
+	deps  repository.DependencyRepository
+	wf    *workflow
+	tasks map[int]*models.Task
+	// Ordering key of the last task, so created tasks are appended in request order
+	last   string
+	listID int
+	userID int
+	now    time.Time
+}
+
+// batchState is the part of a batch run that lives in memory, so a rollback
+// to a savepoint does not undo it. WIP limits are counted in the database
+// and need no copy.
+type batchState struct {
+	tasks map[int]models.Task
+	last  string
+}
+
+func (b *batchRun) save() batchState {
+	state := batchState{tasks: make(map[int]models.Task, len(b.tasks)), last: b.last}
+	for id, task := range b.tasks {
+		state.tasks[id] = *task
+	}
+	return state
+}
+
+// restore brings back the saved state. Tasks keep their pointers, which
+// results of earlier operations share.
+func (b *batchRun) restore(state batchState) {
+	for id, task := range b.tasks {
+		saved, ok := state.tasks[id]
+		if !ok {
+			delete(b.tasks, id)
+			continue
+		}
+		*task = saved
+	}
+	for id, saved := range state.tasks {
+		if _, ok := b.tasks[id]; !ok {
+			task := saved
+			b.tasks[id] = &task
+		}
+	}
+	b.last = state.last
+}
+
+func (b *batchRun) execute(index int, op BatchOperation) BatchResult {
+	result := BatchResult{Index: index, Op: op.Op}
+	fail := func(err error) BatchResult {
+		appErr := apperrors.From(err)
+		result.Status = appErr.Status
+		result.Err = appErr
+		return result
+	}
+
+	if op.Op == BatchOpCreate {
+		title := ""
+		if op.Title != nil {
+			title = *op.Title
+		}
+		if err := validateTask(title); err != nil {
+			return fail(err)
+		}
+		task := &models.Task{
+			ListID:    b.listID,
+			Position:  nextPosition(b.last),
+			CreatedBy: b.userID,
+			UpdatedBy: b.userID,
+		}
+		if err := op.apply(task, b.wf, b.now); err != nil {
+			return fail(err)
+		}
+		if err := b.repo.CreateTask(task); err != nil {
+			return fail(err)
+		}
+		if err := b.audit.recordTask(b.tx, AuditCreate, b.userID, nil, task); err != nil {
+			return fail(err)
+		}
+		b.tasks[task.ID] = task
+		b.last = task.Position
+		result.Status = http.StatusCreated
+		result.Task = task
+		return result
+	}
+
+	task, ok := b.tasks[op.TaskID]
+	if !ok {
+		return fail(apperrors.ErrTaskNotFound)
+	}
+
+	switch op.Op {
+	case BatchOpUpdate:
+		if op.Title != nil && *op.Title == "" {
+			return fail(apperrors.Validation(apperrors.Field("title", "required", "task title cannot be empty")))
+		}
+		updated := *task
+		if err := op.apply(&updated, b.wf, b.now); err != nil {
+			return fail(err)
+		}
+		if err := checkBlocked(b.deps, task, &updated); err != nil {
+			return fail(err)
+		}
+		updated.UpdatedBy = b.userID
+		if err := b.repo.UpdateTask(&updated); err != nil {
+			return fail(err)
+		}
+		if err := b.audit.recordTask(b.tx, AuditUpdate, b.userID, task, &updated); err != nil {
+			return fail(err)
+		}
+		*task = updated
+	case BatchOpComplete:
+		updated := *task
+		completed := true
+		if err := b.wf.place(&updated, nil, &completed, b.now); err != nil {
+			return fail(err)
+		}
+		if err := checkBlocked(b.deps, task, &updated); err != nil {
+			return fail(err)
+		}
+		updated.UpdatedBy = b.userID
+		if err := b.repo.UpdateTask(&updated); err != nil {
+			return fail(err)
+		}
+		if err := b.audit.recordTask(b.tx, AuditUpdate, b.userID, task, &updated); err != nil {
+			return fail(err)
+		}
+		*task = updated
+	case BatchOpDelete:
+		if err := b.repo.DeleteTask(task); err != nil {
+			return fail(err)
+		}
+		if err := b.audit.recordTask(b.tx, AuditDelete, b.userID, task, nil); err != nil {
+			return fail(err)
+		}
+		delete(b.tasks, op.TaskID)
+		result.Status = http.StatusNoContent
+		return result
+	default:
+		return fail(apperrors.Validation(apperrors.Field("op", "oneof", "must be one of: create update delete complete")))
+	}
+
+	result.Status = http.StatusOK
+	result.Task = task
+	return result
+}
+
+// CompleteAllTasks marks every open task of the list as completed. In a
+// list with a workflow the tasks move to the first terminal column
+// regardless of transitions and WIP limits. A list that blocks completion
+// refuses when some task waits for an open task of another list.
+func (s *taskService) CompleteAllTasks(listID int, userID int) (int64, error) {
+	now := time.Now()
+	var done *int
+	return s.bulkUpdate(listID, userID, false, AuditUpdate, func(task *models.Task) {
+		task.SetCompleted(true, now)
+		if done != nil {
+			task.StatusID = done
+		}
+		task.UpdatedBy = userID
+	}, func(tx *gorm.DB) (int64, error) {
+		if err := s.checkListBlocked(tx, listID, userID); err != nil {
+			return 0, err
+		}
+		wf, err := loadWorkflow(s.statusRepo.WithTx(tx), listID)
+		if err != nil {
+			return 0, err
+		}
+		if wf.enabled() {
+			done = &wf.statuses[wf.columnFor(true)].ID
+		}
+		return s.repo.WithTx(tx).CompleteAllTasksForThisList(listID, userID, now, done)
+	})
+}
+
+// checkListBlocked fails when completing all open tasks of the list at once
+// would complete a task whose blocker stays open
+func (s *taskService) checkListBlocked(tx *gorm.DB, listID int, userID int) error {
+	deps := s.depRepo.WithTx(tx)
+	strict, err := deps.BlocksCompletion(listID)
+	if err != nil || !strict {
+		return err
+	}
+	completed := false
+	tasks, err := s.repo.WithTx(tx).FindTasks(listID, userID, TaskFilter{Completed: &completed})
+	if err != nil {
+		return err
+	}
+	ids := make([]int, len(tasks))
+	closing := make(map[int]bool, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+		closing[task.ID] = true
+	}
+	open, err := deps.OpenBlockerIDs(ids)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		for _, blocker := range open[task.ID] {
+			if !closing[blocker] {
+				return apperrors.ErrTaskBlocked.WithMessage(fmt.Sprintf("Task %d waits for task %d of another list", task.ID, blocker))
+			}
+		}
+	}
+	return nil
+}
+
+// ClearCompletedTasks deletes every completed task of the list
+func (s *taskService) ClearCompletedTasks(listID int, userID int) (int64, error) {
+	return s.bulkUpdate(listID, userID, true, AuditDelete, nil, func(tx *gorm.DB) (int64, error) {
+		return s.repo.WithTx(tx).DeleteCompletedTasksForThisList(listID, userID)
+	})
+}
+
+// bulkUpdate runs a single-statement update over the tasks whose completion
+// flag equals completed and writes an audit entry for each of them. change
+// mirrors the statement in memory; nil means the tasks are deleted.
+func (s *taskService) bulkUpdate(listID int, userID int, completed bool, action string, change func(task *models.Task), update func(tx *gorm.DB) (int64, error)) (int64, error) {
+	if listID <= 0 {
+		return 0, apperrors.ErrInvalidID.WithMessage("invalid list ID")
+	}
+	var affected int64
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		tasks, err := repo.FindTasks(listID, userID, TaskFilter{Completed: &completed})
+		if err != nil {
+			return err
+		}
+		if affected, err = update(tx); err != nil {
+			return err
+		}
+		for i := range tasks {
+			before := tasks[i]
+			var after *models.Task
+			if change != nil {
+				after = &tasks[i]
+				change(after)
+			}
+			if err := s.audit.recordTask(tx, action, userID, &before, after); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return affected, err
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TaskDependencies are the tasks a task waits for and the tasks waiting for it
+type TaskDependencies struct {
+	BlockedBy []models.Task `json:"blocked_by"`
+	Blocks    []models.Task `json:"blocks"`
+}
+
+func (s *taskService) GetDependencies(listID int, taskID int, userID int) (*TaskDependencies, error) {
+	if _, err := taskInList(s.repo, listID, taskID, userID); err != nil {
+		return nil, err
+	}
+	blockers, err := s.depRepo.GetBlockers(taskID, userID)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := s.depRepo.GetBlocked(taskID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := markBlockedAll(s.depRepo, blockers); err != nil {
+		return nil, err
+	}
+	if err := markBlockedAll(s.depRepo, blocked); err != nil {
+		return nil, err
+	}
+	return &TaskDependencies{BlockedBy: blockers, Blocks: blocked}, nil
+}
+
+func (s *taskService) AddDependency(listID int, taskID int, userID int, blockerID int) (*models.TaskDependency, error) {
+	if blockerID <= 0 {
+		return nil, apperrors.Validation(apperrors.Field("blocker_id", "required", "blocker_id is required"))
+	}
+	if blockerID == taskID {
+		return nil, apperrors.Validation(apperrors.Field("blocker_id", "invalid", "a task cannot block itself"))
+	}
+	var dep *models.TaskDependency
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		if _, err := taskInList(repo, listID, taskID, userID); err != nil {
+			return err
+		}
+		// Блокирующая задача может быть в любом списке пользователя
+		if _, err := repo.GetTaskByID(blockerID, userID); err != nil {
+			return notFound(err, apperrors.Validation(apperrors.Field("blocker_id", "exists", "no such task")))
+		}
+
+		deps := s.depRepo.WithTx(tx)
+		if err := deps.LockGraph(userID); err != nil {
+			return err
+		}
+		if _, err := deps.GetDependency(taskID, blockerID); err == nil {
+			return apperrors.ErrConflict.WithMessage("The dependency already exists")
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		path, err := blockerPath(deps, blockerID, taskID)
+		if err != nil {
+			return err
+		}
+		if path != nil {
+			return apperrors.ErrDependencyCycle.WithMessage("Tasks would wait for each other: " + formatCycle(taskID, path))
+		}
+
+		dep = &models.TaskDependency{TaskID: taskID, BlockerID: blockerID, CreatedBy: userID}
+		if err := deps.CreateDependency(dep); err != nil {
+			return err
+		}
+		return s.audit.recordDependency(tx, AuditCreate, userID, dep)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dep, nil
+}
+
+func (s *taskService) RemoveDependency(listID int, taskID int, userID int, blockerID int) error {
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if _, err := taskInList(s.repo.WithTx(tx), listID, taskID, userID); err != nil {
+			return err
+		}
+		deps := s.depRepo.WithTx(tx)
+		dep, err := deps.GetDependency(taskID, blockerID)
+		if err != nil {
+			return notFound(err, apperrors.ErrDependencyNotFound)
+		}
+		if err := deps.DeleteDependency(dep); err != nil {
+			return err
+		}
+		return s.audit.recordDependency(tx, AuditDelete, userID, dep)
+	})
+}
+
+// TopologicalOrder returns the live tasks of the list so that every task
+// comes after the tasks of the same list blocking it. Independent tasks
+// keep their list order.
+func (s *taskService) TopologicalOrder(listID int, userID int) ([]models.Task, error) {
+	if listID <= 0 {
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid list ID")
+	}
+	list, err := s.listRepo.GetListByID(listID, userID)
+	if err != nil {
+		return nil, notFound(err, apperrors.ErrListNotFound)
+	}
+	deps, err := s.depRepo.GetListDependencies(listID)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := list.Tasks
+	index := make(map[int]int, len(tasks))
+	for i, task := range tasks {
+		index[task.ID] = i
+	}
+	waiting := make([]int, len(tasks))
+	unblocks := make([][]int, len(tasks))
+	for _, dep := range deps {
+		// Задачи из корзины в порядке не участвуют
+		from, ok1 := index[dep.BlockerID]
+		to, ok2 := index[dep.TaskID]
+		if !ok1 || !ok2 {
+			continue
+		}
+		unblocks[from] = append(unblocks[from], to)
+		waiting[to]++
+	}
+
+	// Алгоритм Кана; среди готовых задач берётся первая по позиции
+	var ready []int
+	for i := range tasks {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	ordered := make([]models.Task, 0, len(tasks))
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, tasks[i])
+		for _, next := range unblocks[i] {
+			if waiting[next]--; waiting[next] == 0 {
+				at := sort.SearchInts(ready, next)
+				ready = append(ready[:at], append([]int{next}, ready[at:]...)...)
+			}
+		}
+	}
+	if len(ordered) != len(tasks) {
+		return nil, fmt.Errorf("dependency cycle in list %d", listID)
+	}
+	if err := markBlockedAll(s.depRepo, ordered); err != nil {
+		return nil, err
+	}
+	return ordered, nil
+}
+
+// blockerPath searches the blockers of from, transitively, for to and
+// returns the chain from..to, or nil when to does not block from
+func blockerPath(repo repository.DependencyRepository, from int, to int) ([]int, error) {
+	parent := map[int]int{from: 0}
+	frontier := []int{from}
+	for len(frontier) > 0 {
+		blockers, err := repo.BlockerIDs(frontier)
+		if err != nil {
+			return nil, err
+		}
+		var next []int
+		for _, id := range frontier {
+			for _, blocker := range blockers[id] {
+				if _, seen := parent[blocker]; seen {
+					continue
+				}
+				parent[blocker] = id
+				if blocker == to {
+					path := []int{to}
+					for at := id; at != 0; at = parent[at] {
+						path = append([]int{at}, path...)
+					}
+					return path, nil
+				}
+				next = append(next, blocker)
+			}
+		}
+		frontier = next
+	}
+	return nil, nil
+}
+
+// formatCycle renders the cycle the new dependency would close, e.g. "3 → 5 → 3"
+func formatCycle(taskID int, path []int) string {
+	ids := []string{strconv.Itoa(taskID)}
+	for _, id := range path {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return strings.Join(ids, " → ")
+}
+
+// checkBlocked refuses to complete a task with open blockers when its list
+// asks for it
+func checkBlocked(repo repository.DependencyRepository, before *models.Task, after *models.Task) error {
+	if before.Completed || !after.Completed {
+		return nil
+	}
+	strict, err := repo.BlocksCompletion(after.ListID)
+	if err != nil || !strict {
+		return err
+	}
+	open, err := repo.OpenBlockerIDs([]int{after.ID})
+	if err != nil {
+		return err
+	}
+	if ids := open[after.ID]; len(ids) > 0 {
+		return apperrors.ErrTaskBlocked.WithMessage(fmt.Sprintf("Complete tasks %s first", joinIDs(ids)))
+	}
+	return nil
+}
+
+func joinIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// markBlocked fills the computed Blocked and BlockedBy fields of tasks
+func markBlocked(repo repository.DependencyRepository, tasks ...*models.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	ids := make([]int, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	open, err := repo.OpenBlockerIDs(ids)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		task.BlockedBy = open[task.ID]
+		if task.BlockedBy == nil {
+			task.BlockedBy = []int{}
+		}
+		task.Blocked = len(task.BlockedBy) > 0
+	}
+	return nil
+}
+
+func markBlockedAll(repo repository.DependencyRepository, tasks []models.Task) error {
+	ptrs := make([]*models.Task, len(tasks))
+	for i := range tasks {
+		ptrs[i] = &tasks[i]
+	}
+	return markBlocked(repo, ptrs...)
+}
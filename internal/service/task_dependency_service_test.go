@@ -0,0 +1,113 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type fakeTaskRepo struct {
+	repository.TaskRepository
+	tasks []models.Task
+}
+
+func (r *fakeTaskRepo) WithTx(*gorm.DB) repository.TaskRepository { return r }
+
+func (r *fakeTaskRepo) GetTaskByID(taskID int, userID int) (*models.Task, error) {
+	for _, task := range r.tasks {
+		if task.ID == taskID {
+			return &task, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+type fakeDependencyRepo struct {
+	repository.DependencyRepository
+	deps []models.TaskDependency
+}
+
+func (r *fakeDependencyRepo) WithTx(*gorm.DB) repository.DependencyRepository { return r }
+
+func (r *fakeDependencyRepo) LockGraph(int) error { return nil }
+
+func (r *fakeDependencyRepo) GetDependency(taskID int, blockerID int) (*models.TaskDependency, error) {
+	for _, dep := range r.deps {
+		if dep.TaskID == taskID && dep.BlockerID == blockerID {
+			return &dep, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeDependencyRepo) CreateDependency(dep *models.TaskDependency) error {
+	dep.ID = len(r.deps) + 1
+	r.deps = append(r.deps, *dep)
+	return nil
+}
+
+func (r *fakeDependencyRepo) BlockerIDs(taskIDs []int) (map[int][]int, error) {
+	blockers := map[int][]int{}
+	for _, dep := range r.deps {
+		for _, id := range taskIDs {
+			if dep.TaskID == id {
+				blockers[id] = append(blockers[id], dep.BlockerID)
+			}
+		}
+	}
+	return blockers, nil
+}
+
+func TestAddDependencyCycles(t *testing.T) {
+	// Пара {задача, блокирующая задача}: первая ждёт вторую
+	tests := []struct {
+		name     string
+		existing [][2]int
+		add      [2]int
+		err      *apperrors.Error
+		// Rendered cycle in the error
+		cycle string
+	}{
+		{name: "self-loop", add: [2]int{1, 1}, err: apperrors.ErrValidation},
+		{name: "2-cycle", existing: [][2]int{{2, 1}}, add: [2]int{1, 2}, err: apperrors.ErrDependencyCycle, cycle: "1 → 2 → 1"},
+		{name: "longer cycle", existing: [][2]int{{2, 1}, {3, 2}, {4, 3}}, add: [2]int{1, 4}, err: apperrors.ErrDependencyCycle, cycle: "1 → 4 → 3 → 2 → 1"},
+		{name: "chain", existing: [][2]int{{2, 1}, {3, 2}}, add: [2]int{4, 3}},
+		{name: "diamond", existing: [][2]int{{2, 1}, {3, 1}, {4, 2}, {4, 3}}, add: [2]int{5, 4}},
+		{name: "shortcut through a diamond", existing: [][2]int{{2, 1}, {3, 1}, {4, 2}, {4, 3}}, add: [2]int{4, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks := &fakeTaskRepo{}
+			for id := 1; id <= 5; id++ {
+				tasks.tasks = append(tasks.tasks, models.Task{ID: id, ListID: 1})
+			}
+			deps := &fakeDependencyRepo{}
+			for _, dep := range tt.existing {
+				deps.deps = append(deps.deps, models.TaskDependency{TaskID: dep[0], BlockerID: dep[1]})
+			}
+			s := NewTaskService(tasks, nil, nil, deps, NewAuditor(&fakeAuditRepo{}, nil), fakeTransactor{})
+
+			_, err := s.AddDependency(1, tt.add[0], 1, tt.add[1])
+			if tt.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(deps.deps) != len(tt.existing)+1 {
+					t.Errorf("dependency was not created")
+				}
+				return
+			}
+			wantErr(t, err, tt.err)
+			if !strings.HasSuffix(err.Error(), tt.cycle) {
+				t.Errorf("error %q does not name the cycle %s", err, tt.cycle)
+			}
+			if len(deps.deps) != len(tt.existing) {
+				t.Errorf("dependency was created")
+			}
+		})
+	}
+}
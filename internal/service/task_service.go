@@ -1,88 +1,556 @@
 package service
 
 import (
+	"RestAPI/internal/apperrors"
 	"RestAPI/internal/models"
 	"RestAPI/internal/repository"
+	"RestAPI/pkg/ical"
 	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"time"
 )
 
+// TaskFilter narrows and sorts task queries
+type TaskFilter = repository.TaskFilter
+
+// TaskFields carries the editable fields of a task; nil fields are not set.
+// Setting StatusID moves the task to another workflow column and derives
+// Completed from it.
+type TaskFields struct {
+	Title       *string
+	Description *string
+	Completed   *bool
+	Priority    *models.Priority
+	StatusID    *int
+	// Estimate in minutes; 0 clears it
+	Estimate *int
+	// Deadline; the zero time clears it
+	DueAt *time.Time
+	// RRULE of a recurring task; "" clears it
+	Recurrence *string
+	// updated_at of the task the fields were computed from; when set, the
+	// update fails with ErrTaskChanged if the task was changed since
+	UpdatedAt *time.Time
+}
+
+// apply sets the non-nil fields on task and puts it into the right column
+// of the list's workflow
+func (f TaskFields) apply(task *models.Task, wf *workflow, now time.Time) error {
+	if f.Title != nil {
+		task.Title = *f.Title
+	}
+	if f.Description != nil {
+		task.Description = *f.Description
+	}
+	if f.Priority != nil {
+		task.Priority = *f.Priority
+	}
+	if f.Estimate != nil {
+		if *f.Estimate < 0 {
+			return apperrors.Validation(apperrors.Field("estimate_minutes", "min", "must not be negative"))
+		}
+		task.Estimate = nil
+		if *f.Estimate > 0 {
+			estimate := *f.Estimate
+			task.Estimate = &estimate
+		}
+	}
+	if f.DueAt != nil {
+		task.DueAt = nil
+		if !f.DueAt.IsZero() {
+			due := *f.DueAt
+			task.DueAt = &due
+		}
+	}
+	if f.Recurrence != nil {
+		task.Recurrence = ""
+		if *f.Recurrence != "" {
+			rule, err := ical.NormalizeRecurrence(*f.Recurrence)
+			if err != nil {
+				return apperrors.Validation(apperrors.Field("recurrence", "rrule", "must be an iCalendar RRULE: "+err.Error()))
+			}
+			task.Recurrence = rule
+		}
+	}
+	if task.Recurrence != "" && task.DueAt == nil {
+		return apperrors.Validation(apperrors.Field("due_at", "required", "recurring tasks need a due date"))
+	}
+	return wf.place(task, f.StatusID, f.Completed, now)
+}
+
 type TaskService interface {
-	GetAllTasksForList(listID int, userID int) ([]models.Task, error)
+	GetAllTasksForList(listID int, userID int, filter TaskFilter) ([]models.Task, error)
 	GetTaskByID(taskID int, userID int) (*models.Task, error)
-	CreateTask(title string, description string, listID int) error
-	UpdateTask(taskID int, userID int, title string, description string, isCompleted *bool) error
-	DeleteTask(taskID int, userID int) error
+	CreateTask(listID int, userID int, fields TaskFields) (*models.Task, error)
+	// UpdateTask changes only the fields that are not nil
+	UpdateTask(listID int, taskID int, userID int, fields TaskFields) (*models.Task, error)
+	// ReplaceTask overwrites every editable field (PUT semantics); nil fields
+	// get their defaults, except the status, which follows completion
+	ReplaceTask(listID int, taskID int, userID int, fields TaskFields) (*models.Task, error)
+	DeleteTask(listID int, taskID int, userID int) error
+	BatchTasks(listID int, userID int, ops []BatchOperation, atomic bool) ([]BatchResult, error)
+	CompleteAllTasks(listID int, userID int) (int64, error)
+	ClearCompletedTasks(listID int, userID int) (int64, error)
+	MoveTask(taskID int, userID int, targetListID int, afterID int, beforeID int) (*models.Task, error)
+	CopyTask(taskID int, userID int, targetListID int) (*models.Task, error)
+	ReorderTask(listID int, taskID int, userID int, afterID int, beforeID int) (*models.Task, error)
+	GetDependencies(listID int, taskID int, userID int) (*TaskDependencies, error)
+	// AddDependency makes taskID wait for blockerID, which may be in any list
+	// of the user. Dependencies that would form a cycle are refused.
+	AddDependency(listID int, taskID int, userID int, blockerID int) (*models.TaskDependency, error)
+	RemoveDependency(listID int, taskID int, userID int, blockerID int) error
+	// TopologicalOrder returns the tasks of the list with every task after its blockers
+	TopologicalOrder(listID int, userID int) ([]models.Task, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) TaskService
 }
 
-func NewTaskService(repo repository.TaskRepository) TaskService {
-	return &taskService{repo: repo}
+func NewTaskService(repo repository.TaskRepository, listRepo repository.TodoListRepository, statusRepo repository.StatusRepository, depRepo repository.DependencyRepository, auditor Auditor, tx repository.Transactor) TaskService {
+	return &taskService{repo: repo, listRepo: listRepo, statusRepo: statusRepo, depRepo: depRepo, tx: tx, audit: auditor}
 }
 
 type taskService struct {
-	repo repository.TaskRepository
+	repo       repository.TaskRepository
+	listRepo   repository.TodoListRepository
+	statusRepo repository.StatusRepository
+	depRepo    repository.DependencyRepository
+	tx         repository.Transactor
+	audit      Auditor
 }
 
-func (s *taskService) GetAllTasksForList(listID int, userID int) ([]models.Task, error) {
+func (s *taskService) WithActor(actor Actor) TaskService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *taskService) GetAllTasksForList(listID int, userID int, filter TaskFilter) ([]models.Task, error) {
 	if listID <= 0 {
-		return nil, errors.New("invalid list ID")
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid list ID")
+	}
+	if !validSort(filter.Sort) {
+		return nil, apperrors.Validation(apperrors.Field("sort", "oneof",
+			"must be one of: "+strings.Join(repository.TaskSortFields, " ")+", optionally prefixed with -"))
+	}
+	tasks, err := s.repo.FindTasks(listID, userID, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := markBlockedAll(s.depRepo, tasks); err != nil {
+		return nil, err
 	}
-	return s.repo.GetAllTasksForThisList(listID, userID)
+	return tasks, nil
+}
+
+func validSort(sort string) bool {
+	if sort == "" {
+		return true
+	}
+	column := strings.TrimPrefix(sort, "-")
+	for _, field := range repository.TaskSortFields {
+		if column == field {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *taskService) GetTaskByID(taskID int, userID int) (*models.Task, error) {
 	if taskID <= 0 {
-		return nil, errors.New("invalid task ID")
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid task ID")
+	}
+	task, err := s.repo.GetTaskByID(taskID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, apperrors.ErrTaskNotFound
+		}
+		return nil, err
 	}
-	return s.repo.GetTaskByID(taskID, userID)
+	return s.withBlocked(task)
 }
 
-func (s *taskService) CreateTask(title string, description string, listID int) error {
+func (s *taskService) CreateTask(listID int, userID int, fields TaskFields) (*models.Task, error) {
 	if listID <= 0 {
-		return errors.New("invalid list ID")
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid list ID")
 	}
-	if title == "" {
-		return errors.New("task title cannot be empty")
+	title := ""
+	if fields.Title != nil {
+		title = *fields.Title
 	}
-	if description == "" {
-		return errors.New("task description cannot be empty")
+	if err := validateTask(title); err != nil {
+		return nil, err
 	}
 
 	task := &models.Task{
-		Title:       title,
-		Description: description,
-		ListID:      listID,
-		Completed:   false,
+		ListID:    listID,
+		Completed: false,
+		CreatedBy: userID,
+		UpdatedBy: userID,
 	}
 
-	err := s.repo.CreateTask(task)
-	return err
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		wf, err := loadWorkflow(s.statusRepo.WithTx(tx), listID)
+		if err != nil {
+			return err
+		}
+		if err := fields.apply(task, wf, time.Now()); err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		last, err := repo.LastTaskPosition(listID)
+		if err != nil {
+			return err
+		}
+		task.Position = nextPosition(last)
+
+		if err := repo.CreateTask(task); err != nil {
+			return err
+		}
+		return s.audit.recordTask(tx, AuditCreate, userID, nil, task)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.withBlocked(task)
 }
 
-func (s *taskService) UpdateTask(taskID int, userID int, title string, description string, isCompleted *bool) error {
-	task, err := s.GetTaskByID(taskID, userID)
+func (s *taskService) UpdateTask(listID int, taskID int, userID int, fields TaskFields) (*models.Task, error) {
+	if fields.Title != nil && *fields.Title == "" {
+		return nil, apperrors.Validation(apperrors.Field("title", "required", "task title cannot be empty"))
+	}
+	return s.updateTask(listID, taskID, userID, fields)
+}
+
+func (s *taskService) ReplaceTask(listID int, taskID int, userID int, fields TaskFields) (*models.Task, error) {
+	title, description, priority := "", "", models.PriorityNone
+	if fields.Title != nil {
+		title = *fields.Title
+	}
+	if err := validateTask(title); err != nil {
+		return nil, err
+	}
+	if fields.Description != nil {
+		description = *fields.Description
+	}
+	if fields.Priority != nil {
+		priority = *fields.Priority
+	}
+	fields.Title, fields.Description, fields.Priority = &title, &description, &priority
+	if fields.Estimate == nil {
+		none := 0
+		fields.Estimate = &none
+	}
+	if fields.DueAt == nil {
+		fields.DueAt = &time.Time{}
+	}
+	if fields.Recurrence == nil {
+		none := ""
+		fields.Recurrence = &none
+	}
+	if fields.Completed == nil && fields.StatusID == nil {
+		completed := false
+		fields.Completed = &completed
+	}
+	return s.updateTask(listID, taskID, userID, fields)
+}
+
+// updateTask loads the task, applies fields and saves it together with
+// the audit entry
+func (s *taskService) updateTask(listID int, taskID int, userID int, fields TaskFields) (*models.Task, error) {
+	var updated *models.Task
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		task, err := taskInList(repo, listID, taskID, userID)
+		if err != nil {
+			return err
+		}
+		wf, err := loadWorkflow(s.statusRepo.WithTx(tx), task.ListID)
+		if err != nil {
+			return err
+		}
+		before := *task
+		if err := fields.apply(task, wf, time.Now()); err != nil {
+			return err
+		}
+		if err := checkBlocked(s.depRepo.WithTx(tx), &before, task); err != nil {
+			return err
+		}
+		task.UpdatedBy = userID
+		if fields.UpdatedAt == nil {
+			if err := repo.UpdateTask(task); err != nil {
+				return err
+			}
+		} else {
+			// Условие в самом UPDATE: параллельный запрос мог изменить
+			// задачу уже после того, как мы её прочитали
+			saved, err := repo.UpdateTaskIfUnchanged(task, *fields.UpdatedAt)
+			if err != nil {
+				return err
+			}
+			if saved == 0 {
+				return apperrors.ErrTaskChanged
+			}
+		}
+		updated = task
+		return s.audit.recordTask(tx, AuditUpdate, userID, &before, task)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return s.withBlocked(updated)
+}
+
+func (s *taskService) DeleteTask(listID int, taskID int, userID int) error {
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		task, err := taskInList(repo, listID, taskID, userID)
+		if err != nil {
+			return err
+		}
+		if err := repo.DeleteTask(task); err != nil {
+			return err
+		}
+		return s.audit.recordTask(tx, AuditDelete, userID, task, nil)
+	})
+}
 
-	if title != "" {
-		task.Title = title
+// MoveTask transfers a task to another list owned by the same user, placing
+// it after afterID or before beforeID (at the end when both are zero)
+func (s *taskService) MoveTask(taskID int, userID int, targetListID int, afterID int, beforeID int) (*models.Task, error) {
+	var moved *models.Task
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		task, err := s.taskAndTargetList(tx, taskID, userID, targetListID)
+		if err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		before := *task
+		if err := positionTask(repo, task, targetListID, userID, afterID, beforeID); err != nil {
+			return err
+		}
+		if err := s.enterList(tx, task, targetListID); err != nil {
+			return err
+		}
+		task.UpdatedBy = userID
+		if err := repo.UpdateTask(task); err != nil {
+			return err
+		}
+		moved = task
+		return s.audit.recordTask(tx, AuditUpdate, userID, &before, task)
+	})
+	if err != nil {
+		return nil, err
 	}
-	if description != "" {
-		task.Description = description
+	return s.withBlocked(moved)
+}
+
+// CopyTask creates a copy of the task in the target list (or the same list
+// when targetListID is zero)
+func (s *taskService) CopyTask(taskID int, userID int, targetListID int) (*models.Task, error) {
+	var copied *models.Task
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if targetListID == 0 {
+			task, err := s.repo.WithTx(tx).GetTaskByID(taskID, userID)
+			if err != nil {
+				return notFound(err, apperrors.ErrTaskNotFound)
+			}
+			targetListID = task.ListID
+		}
+		task, err := s.taskAndTargetList(tx, taskID, userID, targetListID)
+		if err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		last, err := repo.LastTaskPosition(targetListID)
+		if err != nil {
+			return err
+		}
+		copied = &models.Task{
+			Title:       task.Title,
+			Description: task.Description,
+			Completed:   task.Completed,
+			CompletedAt: task.CompletedAt,
+			Priority:    task.Priority,
+			Estimate:    task.Estimate,
+			DueAt:       task.DueAt,
+			Recurrence:  task.Recurrence,
+			StatusID:    task.StatusID,
+			Assignees:   task.Assignees,
+			Position:    nextPosition(last),
+			ListID:      task.ListID,
+			CreatedBy:   userID,
+			UpdatedBy:   userID,
+		}
+		if targetListID != task.ListID {
+			if err := s.enterList(tx, copied, targetListID); err != nil {
+				return err
+			}
+		} else if copied.StatusID != nil {
+			// Копия занимает место в той же колонке
+			wf, err := loadWorkflow(s.statusRepo.WithTx(tx), targetListID)
+			if err != nil {
+				return err
+			}
+			if err := wf.checkLimit(wf.status(copied.StatusID), 0); err != nil {
+				return err
+			}
+		}
+		if err := repo.CreateTask(copied); err != nil {
+			return err
+		}
+		return s.audit.recordTask(tx, AuditCreate, userID, nil, copied)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return s.withBlocked(copied)
+}
 
-	if isCompleted != nil {
-		task.Completed = *isCompleted
+// ReorderTask moves a task within its list right after afterID or right
+// before beforeID. Normally only the moved row is written.
+func (s *taskService) ReorderTask(listID int, taskID int, userID int, afterID int, beforeID int) (*models.Task, error) {
+	if afterID == 0 && beforeID == 0 {
+		return nil, apperrors.Validation(apperrors.Field("after_id", "required", "after_id or before_id is required"))
+	}
+	if afterID == taskID || beforeID == taskID {
+		return nil, apperrors.Validation(apperrors.Field("after_id", "invalid", "a task cannot be placed next to itself"))
 	}
 
-	return s.repo.UpdateTask(task)
+	var reordered *models.Task
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		task, err := repo.GetTaskByID(taskID, userID)
+		if err != nil {
+			return notFound(err, apperrors.ErrTaskNotFound)
+		}
+		if task.ListID != listID {
+			return apperrors.ErrTaskNotFound
+		}
+		before := *task
+		if err := positionTask(repo, task, listID, userID, afterID, beforeID); err != nil {
+			return err
+		}
+		reordered = task
+		if err := repo.UpdateTaskPosition(task.ID, task.Position); err != nil {
+			return err
+		}
+		return s.audit.recordTask(tx, AuditUpdate, userID, &before, task)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.withBlocked(reordered)
 }
 
-func (s *taskService) DeleteTask(taskID int, userID int) error {
-	task, err := s.GetTaskByID(taskID, userID)
+// positionTask computes task.Position for its new place in listID and
+// rewrites the other tasks' keys if the list had to be rebalanced
+func positionTask(repo repository.TaskRepository, task *models.Task, listID int, userID int, afterID int, beforeID int) error {
+	tasks, err := repo.GetAllTasksForThisList(listID, userID)
 	if err != nil {
 		return err
 	}
+	ids := make([]int, 0, len(tasks))
+	keys := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		if t.ID == task.ID {
+			continue
+		}
+		ids = append(ids, t.ID)
+		keys = append(keys, t.Position)
+	}
 
-	return s.repo.DeleteTask(task)
+	p, err := place(ids, keys, afterID, beforeID)
+	if err != nil {
+		return err
+	}
+	for i, key := range p.Rebalanced {
+		if err := repo.UpdateTaskPosition(ids[i], key); err != nil {
+			return err
+		}
+	}
+	task.Position = p.Key
+	return nil
+}
+
+// withBlocked fills the computed dependency fields of a returned task
+func (s *taskService) withBlocked(task *models.Task) (*models.Task, error) {
+	if err := markBlocked(s.depRepo, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// enterList moves the task into listID, keeps the assignees who can access
+// that list and places the task into the column of the list's workflow that
+// matches its completion
+func (s *taskService) enterList(tx *gorm.DB, task *models.Task, listID int) error {
+	wf, err := loadWorkflow(s.statusRepo.WithTx(tx), listID)
+	if err != nil {
+		return err
+	}
+	// Исполнители без доступа к новому списку снимаются с задачи
+	assignees := make(models.UserIDs, 0, len(task.Assignees))
+	for _, id := range task.Assignees {
+		ok, err := s.listRepo.WithTx(tx).HasAccess(listID, id)
+		if err != nil {
+			return err
+		}
+		if ok {
+			assignees = append(assignees, id)
+		}
+	}
+	task.Assignees = assignees
+	task.ListID = listID
+	task.StatusID = nil
+	return wf.place(task, nil, nil, time.Now())
+}
+
+// taskAndTargetList loads the task and checks that both its list and the
+// target list belong to the user
+func (s *taskService) taskAndTargetList(tx *gorm.DB, taskID int, userID int, targetListID int) (*models.Task, error) {
+	if taskID <= 0 {
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid task ID")
+	}
+	if targetListID <= 0 {
+		return nil, apperrors.Validation(apperrors.Field("list_id", "required", "target list is required"))
+	}
+	task, err := s.repo.WithTx(tx).GetTaskByID(taskID, userID)
+	if err != nil {
+		return nil, notFound(err, apperrors.ErrTaskNotFound)
+	}
+	if _, err := s.listRepo.WithTx(tx).GetListByID(targetListID, userID); err != nil {
+		return nil, notFound(err, apperrors.ErrListNotFound)
+	}
+	return task, nil
+}
+
+// taskInList loads a live task and checks it belongs to the list from the URL
+func taskInList(repo repository.TaskRepository, listID int, taskID int, userID int) (*models.Task, error) {
+	if taskID <= 0 {
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid task ID")
+	}
+	task, err := repo.GetTaskByID(taskID, userID)
+	if err != nil {
+		return nil, notFound(err, apperrors.ErrTaskNotFound)
+	}
+	if task.ListID != listID {
+		return nil, apperrors.ErrTaskNotFound
+	}
+	return task, nil
+}
+
+// notFound maps gorm's not-found error onto the given domain error
+func notFound(err error, domainErr *apperrors.Error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return domainErr
+	}
+	return err
+}
+
+func validateTask(title string) error {
+	if title == "" {
+		return apperrors.Validation(apperrors.Field("title", "required", "task title cannot be empty"))
+	}
+	return nil
 }
@@ -0,0 +1,363 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxTimeEntryNote     = 1000
+	MaxTimeEntryDuration = 24 * time.Hour
+	MaxTimeReportDays    = 366
+	// DefaultTimeReportDays is the report period when from is not given
+	DefaultTimeReportDays = 30
+)
+
+// TaskTime is the time logged on one task
+type TaskTime = repository.TaskTime
+
+// TimeReportRow is one group of a time report
+type TimeReportRow = repository.TimeReportRow
+
+// TaskTimeEntries are the entries of a task with their total
+type TaskTimeEntries struct {
+	TaskID          int  `json:"task_id"`
+	EstimateMinutes *int `json:"estimate_minutes"`
+	// Sum of the finished entries; running timers are not counted
+	// example: 5400
+	TrackedSeconds int64              `json:"tracked_seconds"`
+	Entries        []models.TimeEntry `json:"entries"`
+}
+
+// ListTime is the time logged on the live tasks of a list
+type ListTime struct {
+	ListID int `json:"list_id"`
+	// Sum of the estimates of the tasks
+	// example: 480
+	EstimateMinutes int `json:"estimate_minutes"`
+	// example: 27000
+	TrackedSeconds int64      `json:"tracked_seconds"`
+	Tasks          []TaskTime `json:"tasks"`
+}
+
+// TimeReportFilter selects the period and grouping of a time report.
+// Zero From and To default to the last DefaultTimeReportDays days.
+type TimeReportFilter struct {
+	From     *time.Time
+	To       *time.Time
+	GroupBy  string
+	Location *time.Location
+	// First day of the weeks when grouping by week
+	WeekStart time.Weekday
+}
+
+// TimeReport sums the time logged on the user's lists
+type TimeReport struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// list, day or week
+	// example: list
+	GroupBy string `json:"group_by"`
+	// Time zone of the days, IANA name
+	// example: Europe/Moscow
+	TimeZone string `json:"time_zone"`
+	// First day of the weeks, set when grouping by week
+	// example: monday
+	WeekStart      string          `json:"week_start,omitempty"`
+	TrackedSeconds int64           `json:"tracked_seconds"`
+	Rows           []TimeReportRow `json:"rows"`
+}
+
+type TimeService interface {
+	// StartTimer starts a timer on the task; a user has at most one running timer
+	StartTimer(listID int, taskID int, userID int) (*models.TimeEntry, error)
+	// StopTaskTimer stops the user's timer if it runs on the task
+	StopTaskTimer(listID int, taskID int, userID int, note string) (*models.TimeEntry, error)
+	// StopTimer stops the user's timer whatever task it runs on
+	StopTimer(userID int, note string) (*models.TimeEntry, error)
+	GetRunningTimer(userID int) (*models.TimeEntry, error)
+	GetTaskEntries(listID int, taskID int, userID int) (*TaskTimeEntries, error)
+	// LogTime adds a manual entry of the given length
+	LogTime(listID int, taskID int, userID int, startedAt time.Time, duration time.Duration, note string) (*models.TimeEntry, error)
+	DeleteEntry(listID int, taskID int, entryID int, userID int) error
+	GetListTime(listID int, userID int) (*ListTime, error)
+	GetReport(userID int, filter TimeReportFilter) (*TimeReport, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) TimeService
+}
+
+type timeService struct {
+	repo     repository.TimeEntryRepository
+	taskRepo repository.TaskRepository
+	listRepo repository.TodoListRepository
+	tx       repository.Transactor
+	audit    Auditor
+}
+
+func NewTimeService(repo repository.TimeEntryRepository, taskRepo repository.TaskRepository, listRepo repository.TodoListRepository, auditor Auditor, tx repository.Transactor) TimeService {
+	return &timeService{repo: repo, taskRepo: taskRepo, listRepo: listRepo, tx: tx, audit: auditor}
+}
+
+func (s *timeService) WithActor(actor Actor) TimeService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *timeService) StartTimer(listID int, taskID int, userID int) (*models.TimeEntry, error) {
+	var entry *models.TimeEntry
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		task, err := taskInList(s.taskRepo.WithTx(tx), listID, taskID, userID)
+		if err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		if err := repo.LockTimer(userID); err != nil {
+			return err
+		}
+		running, err := repo.GetRunning(userID)
+		if err == nil {
+			return apperrors.ErrTimerRunning.WithMessage(fmt.Sprintf("A timer is already running on task %d, stop it first", running.TaskID))
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		entry = &models.TimeEntry{TaskID: task.ID, UserID: userID, StartedAt: time.Now()}
+		if err := repo.CreateEntry(entry); err != nil {
+			return err
+		}
+		return s.audit.recordTimeEntry(tx, AuditCreate, userID, nil, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *timeService) StopTaskTimer(listID int, taskID int, userID int, note string) (*models.TimeEntry, error) {
+	if _, err := taskInList(s.taskRepo, listID, taskID, userID); err != nil {
+		return nil, err
+	}
+	return s.stop(userID, taskID, note)
+}
+
+func (s *timeService) StopTimer(userID int, note string) (*models.TimeEntry, error) {
+	return s.stop(userID, 0, note)
+}
+
+// stop ends the running timer of the user; a non-zero taskID requires the
+// timer to run on that task
+func (s *timeService) stop(userID int, taskID int, note string) (*models.TimeEntry, error) {
+	note, err := cleanTimeNote(note)
+	if err != nil {
+		return nil, err
+	}
+	var entry *models.TimeEntry
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.LockTimer(userID); err != nil {
+			return err
+		}
+		running, err := repo.GetRunning(userID)
+		if err != nil {
+			return notFound(err, apperrors.ErrNoRunningTimer)
+		}
+		if taskID != 0 && running.TaskID != taskID {
+			return apperrors.ErrNoRunningTimer.WithMessage(fmt.Sprintf("No timer is running on this task, the running timer is on task %d", running.TaskID))
+		}
+
+		before := *running
+		now := time.Now()
+		running.EndedAt = &now
+		running.Duration = int64(now.Sub(running.StartedAt) / time.Second)
+		if note != "" {
+			running.Note = note
+		}
+		if err := repo.UpdateEntry(running); err != nil {
+			return err
+		}
+		entry = running
+		return s.audit.recordTimeEntry(tx, AuditUpdate, userID, &before, running)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *timeService) GetRunningTimer(userID int) (*models.TimeEntry, error) {
+	entry, err := s.repo.GetRunning(userID)
+	if err != nil {
+		return nil, notFound(err, apperrors.ErrNoRunningTimer)
+	}
+	return entry, nil
+}
+
+func (s *timeService) GetTaskEntries(listID int, taskID int, userID int) (*TaskTimeEntries, error) {
+	task, err := taskInList(s.taskRepo, listID, taskID, userID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.repo.GetEntries(task.ID)
+	if err != nil {
+		return nil, err
+	}
+	result := &TaskTimeEntries{TaskID: task.ID, EstimateMinutes: task.Estimate, Entries: entries}
+	for _, e := range entries {
+		if !e.Running() {
+			result.TrackedSeconds += e.Duration
+		}
+	}
+	if result.Entries == nil {
+		result.Entries = []models.TimeEntry{}
+	}
+	return result, nil
+}
+
+func (s *timeService) LogTime(listID int, taskID int, userID int, startedAt time.Time, duration time.Duration, note string) (*models.TimeEntry, error) {
+	note, err := cleanTimeNote(note)
+	if err != nil {
+		return nil, err
+	}
+	var fields []apperrors.FieldError
+	if startedAt.IsZero() {
+		fields = append(fields, apperrors.Field("started_at", "required", "started_at is required"))
+	}
+	if duration < time.Second || duration > MaxTimeEntryDuration {
+		fields = append(fields, apperrors.Field("duration_seconds", "range", fmt.Sprintf("must be between 1 and %d", int64(MaxTimeEntryDuration/time.Second))))
+	} else if startedAt.Add(duration).After(time.Now()) {
+		fields = append(fields, apperrors.Field("started_at", "past", "the entry cannot end in the future"))
+	}
+	if len(fields) > 0 {
+		return nil, apperrors.Validation(fields...)
+	}
+
+	endedAt := startedAt.Add(duration)
+	entry := &models.TimeEntry{
+		UserID:    userID,
+		StartedAt: startedAt,
+		EndedAt:   &endedAt,
+		Duration:  int64(duration / time.Second),
+		Note:      note,
+		Manual:    true,
+	}
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		task, err := taskInList(s.taskRepo.WithTx(tx), listID, taskID, userID)
+		if err != nil {
+			return err
+		}
+		entry.TaskID = task.ID
+		if err := s.repo.WithTx(tx).CreateEntry(entry); err != nil {
+			return err
+		}
+		return s.audit.recordTimeEntry(tx, AuditCreate, userID, nil, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *timeService) DeleteEntry(listID int, taskID int, entryID int, userID int) error {
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if _, err := taskInList(s.taskRepo.WithTx(tx), listID, taskID, userID); err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		entry, err := repo.GetEntry(taskID, entryID)
+		if err != nil {
+			return notFound(err, apperrors.ErrTimeEntryNotFound)
+		}
+		if err := repo.DeleteEntry(entry); err != nil {
+			return err
+		}
+		return s.audit.recordTimeEntry(tx, AuditDelete, userID, entry, nil)
+	})
+}
+
+func (s *timeService) GetListTime(listID int, userID int) (*ListTime, error) {
+	if listID <= 0 {
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid list ID")
+	}
+	ok, err := s.listRepo.HasAccess(listID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, apperrors.ErrListNotFound
+	}
+	tasks, err := s.repo.GetListTimes(listID)
+	if err != nil {
+		return nil, err
+	}
+	result := &ListTime{ListID: listID, Tasks: tasks}
+	for _, t := range tasks {
+		result.TrackedSeconds += t.TrackedSeconds
+		if t.EstimateMinutes != nil {
+			result.EstimateMinutes += *t.EstimateMinutes
+		}
+	}
+	if result.Tasks == nil {
+		result.Tasks = []TaskTime{}
+	}
+	return result, nil
+}
+
+func (s *timeService) GetReport(userID int, filter TimeReportFilter) (*TimeReport, error) {
+	loc := filter.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	to := time.Now()
+	if filter.To != nil {
+		to = *filter.To
+	}
+	from := to.AddDate(0, 0, -DefaultTimeReportDays)
+	if filter.From != nil {
+		from = *filter.From
+	}
+
+	var fields []apperrors.FieldError
+	if filter.GroupBy != "list" && filter.GroupBy != "day" && filter.GroupBy != "week" {
+		fields = append(fields, apperrors.Field("group_by", "oneof", "must be one of: list day week"))
+	}
+	if !from.Before(to) {
+		fields = append(fields, apperrors.Field("from", "before", "must be before to"))
+	} else if to.Sub(from) > MaxTimeReportDays*24*time.Hour {
+		fields = append(fields, apperrors.Field("to", "range", fmt.Sprintf("the period cannot be longer than %d days", MaxTimeReportDays)))
+	}
+	if len(fields) > 0 {
+		return nil, apperrors.Validation(fields...)
+	}
+
+	rows, err := s.repo.Report(userID, from, to, filter.GroupBy, loc, filter.WeekStart)
+	if err != nil {
+		return nil, err
+	}
+	report := &TimeReport{From: from, To: to, GroupBy: filter.GroupBy, TimeZone: loc.String(), Rows: rows}
+	if filter.GroupBy == "week" {
+		report.WeekStart = strings.ToLower(filter.WeekStart.String())
+	}
+	for _, row := range rows {
+		report.TrackedSeconds += row.TrackedSeconds
+	}
+	if report.Rows == nil {
+		report.Rows = []TimeReportRow{}
+	}
+	return report, nil
+}
+
+func cleanTimeNote(note string) (string, error) {
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > MaxTimeEntryNote {
+		return "", apperrors.Validation(apperrors.Field("note", "max", fmt.Sprintf("must be at most %d characters", MaxTimeEntryNote)))
+	}
+	return note, nil
+}
@@ -1,66 +1,384 @@
 package service
 
 import (
+	"RestAPI/internal/apperrors"
 	"RestAPI/internal/models"
 	"RestAPI/internal/repository"
 	"errors"
+	"gorm.io/gorm"
+	"time"
 )
 
+// ListFields carries the editable fields of a list; nil fields are not set
+type ListFields struct {
+	Title           *string
+	BlockCompletion *bool
+}
+
 type TodoListService interface {
 	GetAllLists(userID int) ([]models.TodoList, error)
 	GetListByID(listID int, userID int) (*models.TodoList, error)
-	CreateList(title string, userID int) error
-	UpdateList(listID int, userID int, title string) error
+	CreateList(title string, userID int) (*models.TodoList, error)
+	UpdateList(listID int, userID int, fields ListFields) (*models.TodoList, error)
 	DeleteList(listID int, userID int) error
+	DuplicateList(listID int, userID int, title string, resetCompleted bool) (*models.TodoList, error)
+	ReorderList(listID int, userID int, afterID int, beforeID int) (*models.TodoList, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) TodoListService
 }
 
 type todoListService struct {
-	repo repository.TodoListRepository
+	repo       repository.TodoListRepository
+	statusRepo repository.StatusRepository
+	depRepo    repository.DependencyRepository
+	tx         repository.Transactor
+	audit      Auditor
+}
+
+func NewTodoListService(repo repository.TodoListRepository, statusRepo repository.StatusRepository, depRepo repository.DependencyRepository, auditor Auditor, tx repository.Transactor) TodoListService {
+	return &todoListService{repo: repo, statusRepo: statusRepo, depRepo: depRepo, tx: tx, audit: auditor}
 }
 
-func NewTodoListService(repo repository.TodoListRepository) TodoListService {
-	return &todoListService{repo: repo}
+func (s *todoListService) WithActor(actor Actor) TodoListService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
 }
 
 func (s *todoListService) GetAllLists(userID int) ([]models.TodoList, error) {
-	return s.repo.GetAllLists(userID)
+	lists, err := s.repo.GetAllLists(userID)
+	if err != nil {
+		return nil, err
+	}
+	ptrs := make([]*models.TodoList, len(lists))
+	for i := range lists {
+		ptrs[i] = &lists[i]
+	}
+	if err := s.markBlocked(ptrs...); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// markBlocked fills the computed dependency fields of the lists' tasks
+func (s *todoListService) markBlocked(lists ...*models.TodoList) error {
+	var tasks []*models.Task
+	for _, list := range lists {
+		for i := range list.Tasks {
+			tasks = append(tasks, &list.Tasks[i])
+		}
+	}
+	return markBlocked(s.depRepo, tasks...)
+}
+
+// withBlocked fills the computed dependency fields of a returned list
+func (s *todoListService) withBlocked(list *models.TodoList) (*models.TodoList, error) {
+	if err := s.markBlocked(list); err != nil {
+		return nil, err
+	}
+	return list, nil
 }
 
 func (s *todoListService) GetListByID(listID int, userID int) (*models.TodoList, error) {
 	if listID <= 0 {
-		return nil, errors.New("invalid list ID")
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid list ID")
+	}
+	list, err := s.repo.GetListByID(listID, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, apperrors.ErrListNotFound
+		}
+		return nil, err
 	}
-	return s.repo.GetListByID(listID, userID)
+	return s.withBlocked(list)
 }
 
-func (s *todoListService) CreateList(title string, userID int) error {
+func (s *todoListService) CreateList(title string, userID int) (*models.TodoList, error) {
 	if userID <= 0 {
-		return errors.New("invalid user ID")
+		return nil, apperrors.ErrUnauthorized
+	}
+	if title == "" {
+		return nil, apperrors.Validation(apperrors.Field("title", "required", "is required"))
 	}
 	list := &models.TodoList{
-		Title:  title,
-		UserID: userID,
+		Title:     title,
+		UserID:    userID,
+		CreatedBy: userID,
+		UpdatedBy: userID,
+		Tasks:     []models.Task{},
+	}
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		last, err := repo.LastListPosition(userID)
+		if err != nil {
+			return err
+		}
+		list.Position = nextPosition(last)
+		if err := repo.CreateList(list); err != nil {
+			return err
+		}
+		return s.audit.recordList(tx, AuditCreate, nil, list)
+	})
+	if err != nil {
+		return nil, err
 	}
-	err := s.repo.CreateList(list)
-	return err
+	return list, nil
 }
 
-func (s *todoListService) UpdateList(listID int, userID int, title string) error {
-	list, err := s.GetListByID(listID, userID)
+func (s *todoListService) UpdateList(listID int, userID int, fields ListFields) (*models.TodoList, error) {
+	if fields.Title != nil && *fields.Title == "" {
+		return nil, apperrors.Validation(apperrors.Field("title", "required", "is required"))
+	}
+	if listID <= 0 {
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid list ID")
+	}
+	var updated *models.TodoList
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		list, err := repo.GetListByID(listID, userID)
+		if err != nil {
+			return notFound(err, apperrors.ErrListNotFound)
+		}
+		before := *list
+		if fields.Title != nil {
+			list.Title = *fields.Title
+		}
+		if fields.BlockCompletion != nil {
+			list.BlockCompletion = *fields.BlockCompletion
+		}
+		list.UpdatedBy = userID
+		if err := repo.UpdateList(list); err != nil {
+			return err
+		}
+		updated = list
+		return s.audit.recordList(tx, AuditUpdate, &before, list)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	list.Title = title
-	return s.repo.UpdateList(list)
+	return s.withBlocked(updated)
 }
 
+// DeleteList moves the list and its tasks to the trash
 func (s *todoListService) DeleteList(listID int, userID int) error {
-	list, err := s.GetListByID(listID, userID)
+	if listID <= 0 {
+		return apperrors.ErrInvalidID.WithMessage("invalid list ID")
+	}
+	deletedAt := time.Now()
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		list, err := repo.GetListByID(listID, userID)
+		if err != nil {
+			return notFound(err, apperrors.ErrListNotFound)
+		}
+		if err := repo.DeleteAllTasksForThisList(list, deletedAt); err != nil {
+			return err
+		}
+		if err := repo.DeleteList(list, deletedAt); err != nil {
+			return err
+		}
+		// Задачи удаляются вместе со списком, отдельные записи для них не пишем
+		return s.audit.recordList(tx, AuditDelete, list, nil)
+	})
+}
+
+// DuplicateList deep-copies a list with all its tasks. An empty title
+// reuses the original one with a "(copy)" suffix.
+func (s *todoListService) DuplicateList(listID int, userID int, title string, resetCompleted bool) (*models.TodoList, error) {
+	var duplicate *models.TodoList
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		list, err := repo.GetListByID(listID, userID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return apperrors.ErrListNotFound
+			}
+			return err
+		}
+
+		if title == "" {
+			title = list.Title + " (copy)"
+		}
+		last, err := repo.LastListPosition(userID)
+		if err != nil {
+			return err
+		}
+		duplicate = &models.TodoList{
+			Title:           title,
+			Position:        nextPosition(last),
+			UserID:          userID,
+			BlockCompletion: list.BlockCompletion,
+			CreatedBy:       userID,
+			UpdatedBy:       userID,
+			Tasks:           make([]models.Task, 0, len(list.Tasks)),
+		}
+		for _, task := range list.Tasks {
+			copied := models.Task{
+				Title:       task.Title,
+				Description: task.Description,
+				Completed:   task.Completed,
+				CompletedAt: task.CompletedAt,
+				Priority:    task.Priority,
+				Estimate:    task.Estimate,
+				DueAt:       task.DueAt,
+				Recurrence:  task.Recurrence,
+				Assignees:   task.Assignees,
+				Position:    task.Position,
+				CreatedBy:   userID,
+				UpdatedBy:   userID,
+			}
+			if resetCompleted {
+				copied.SetCompleted(false, time.Now())
+			}
+			duplicate.Tasks = append(duplicate.Tasks, copied)
+		}
+		// Задачи создаются вместе со списком через ассоциацию Tasks
+		if err := repo.CreateList(duplicate); err != nil {
+			return err
+		}
+		if err := s.copyWorkflow(tx, list, duplicate); err != nil {
+			return err
+		}
+		if err := s.audit.recordList(tx, AuditCreate, nil, duplicate); err != nil {
+			return err
+		}
+		for i := range duplicate.Tasks {
+			if err := s.audit.recordTask(tx, AuditCreate, userID, nil, &duplicate.Tasks[i]); err != nil {
+				return err
+			}
+		}
+		return s.copyDependencies(tx, list, duplicate, userID)
+	})
 	if err != nil {
+		return nil, err
+	}
+	return s.withBlocked(duplicate)
+}
+
+// copyWorkflow gives the duplicate the statuses of the original list and
+// puts the copied tasks into the matching columns
+func (s *todoListService) copyWorkflow(tx *gorm.DB, list *models.TodoList, duplicate *models.TodoList) error {
+	repo := s.statusRepo.WithTx(tx)
+	statuses, err := repo.GetStatuses(list.ID)
+	if err != nil || len(statuses) == 0 {
 		return err
 	}
-	if len(list.Tasks) > 0 {
-		s.repo.DeleteAllTasksForThisList(list)
+	ids := make(map[int]int, len(statuses))
+	copied := make([]models.Status, len(statuses))
+	for i, st := range statuses {
+		copied[i] = models.Status{
+			ListID:   duplicate.ID,
+			Name:     st.Name,
+			Position: st.Position,
+			Terminal: st.Terminal,
+			WIPLimit: st.WIPLimit,
+			Next:     st.Next,
+		}
+		if err := repo.CreateStatus(&copied[i]); err != nil {
+			return err
+		}
+		ids[st.ID] = copied[i].ID
+	}
+
+	wf := &workflow{statuses: copied}
+	now := time.Now()
+	for i, task := range list.Tasks {
+		dup := &duplicate.Tasks[i]
+		if task.StatusID != nil {
+			if id, ok := ids[*task.StatusID]; ok {
+				dup.StatusID = &id
+			}
+		}
+		// Сброс выполнения возвращает задачу в первую открытую колонку
+		if st := wf.status(dup.StatusID); st != nil && st.Terminal != dup.Completed {
+			dup.StatusID = nil
+		}
+		wf.fit(dup, now)
+		if err := repo.SetTaskStatus(dup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDependencies repeats the dependencies between tasks of the original
+// list among their copies; dependencies on other lists are not copied
+func (s *todoListService) copyDependencies(tx *gorm.DB, list *models.TodoList, duplicate *models.TodoList, userID int) error {
+	repo := s.depRepo.WithTx(tx)
+	deps, err := repo.GetListDependencies(list.ID)
+	if err != nil || len(deps) == 0 {
+		return err
+	}
+	ids := make(map[int]int, len(list.Tasks))
+	for i, task := range list.Tasks {
+		ids[task.ID] = duplicate.Tasks[i].ID
+	}
+	for _, dep := range deps {
+		taskID, ok1 := ids[dep.TaskID]
+		blockerID, ok2 := ids[dep.BlockerID]
+		if !ok1 || !ok2 {
+			continue
+		}
+		copied := &models.TaskDependency{TaskID: taskID, BlockerID: blockerID, CreatedBy: userID}
+		if err := repo.CreateDependency(copied); err != nil {
+			return err
+		}
+		if err := s.audit.recordDependency(tx, AuditCreate, userID, copied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReorderList moves a list among the user's lists right after afterID or
+// right before beforeID
+func (s *todoListService) ReorderList(listID int, userID int, afterID int, beforeID int) (*models.TodoList, error) {
+	if afterID == 0 && beforeID == 0 {
+		return nil, apperrors.Validation(apperrors.Field("after_id", "required", "after_id or before_id is required"))
+	}
+	if afterID == listID || beforeID == listID {
+		return nil, apperrors.Validation(apperrors.Field("after_id", "invalid", "a list cannot be placed next to itself"))
+	}
+
+	var reordered *models.TodoList
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		lists, err := repo.GetAllLists(userID)
+		if err != nil {
+			return err
+		}
+		ids := make([]int, 0, len(lists))
+		keys := make([]string, 0, len(lists))
+		for i := range lists {
+			if lists[i].ID == listID {
+				reordered = &lists[i]
+				continue
+			}
+			ids = append(ids, lists[i].ID)
+			keys = append(keys, lists[i].Position)
+		}
+		if reordered == nil {
+			return apperrors.ErrListNotFound
+		}
+
+		p, err := place(ids, keys, afterID, beforeID)
+		if err != nil {
+			return err
+		}
+		for i, key := range p.Rebalanced {
+			if err := repo.UpdateListPosition(ids[i], key); err != nil {
+				return err
+			}
+		}
+		before := *reordered
+		reordered.Position = p.Key
+		if err := repo.UpdateListPosition(listID, p.Key); err != nil {
+			return err
+		}
+		return s.audit.recordList(tx, AuditUpdate, &before, reordered)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return s.repo.DeleteList(list)
+	return s.withBlocked(reordered)
 }
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	TrashTypeList = "list"
+	TrashTypeTask = "task"
+)
+
+// Trash is the content of a user's trash
+type Trash struct {
+	Lists []models.TodoList
+	Tasks []models.Task
+}
+
+type TrashService interface {
+	GetTrash(userID int) (*Trash, error)
+	Restore(itemType string, id int, userID int) error
+	Purge(itemType string, id int, userID int) error
+	EmptyTrash(userID int) error
+	PurgeExpired(retention time.Duration) (int64, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) TrashService
+}
+
+type trashService struct {
+	repo  repository.TrashRepository
+	tx    repository.Transactor
+	audit Auditor
+}
+
+func NewTrashService(repo repository.TrashRepository, auditor Auditor, tx repository.Transactor) TrashService {
+	return &trashService{repo: repo, tx: tx, audit: auditor}
+}
+
+func (s *trashService) WithActor(actor Actor) TrashService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *trashService) GetTrash(userID int) (*Trash, error) {
+	lists, err := s.repo.GetDeletedLists(userID)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := s.repo.GetDeletedTasks(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Trash{Lists: lists, Tasks: tasks}, nil
+}
+
+// Restore takes a list (with the tasks deleted together with it) or a single
+// task out of the trash
+func (s *trashService) Restore(itemType string, id int, userID int) error {
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		switch itemType {
+		case TrashTypeList:
+			list, err := repo.GetDeletedList(id, userID)
+			if err != nil {
+				return notFound(err, apperrors.ErrListNotFound)
+			}
+			if err := repo.RestoreList(list); err != nil {
+				return err
+			}
+			return s.audit.recordList(tx, AuditRestore, nil, list)
+		case TrashTypeTask:
+			task, err := repo.GetDeletedTask(id, userID)
+			if err != nil {
+				return notFound(err, apperrors.ErrTaskNotFound)
+			}
+			deleted, err := repo.IsListDeleted(task.ListID)
+			if err != nil {
+				return err
+			}
+			if deleted {
+				return apperrors.ErrListInTrash
+			}
+			if err := repo.RestoreTask(task); err != nil {
+				return err
+			}
+			return s.audit.recordTask(tx, AuditRestore, userID, nil, task)
+		}
+		return errUnknownTrashType
+	})
+}
+
+// Purge permanently deletes an item that is already in the trash
+func (s *trashService) Purge(itemType string, id int, userID int) error {
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		switch itemType {
+		case TrashTypeList:
+			list, err := repo.GetDeletedList(id, userID)
+			if err != nil {
+				return notFound(err, apperrors.ErrListNotFound)
+			}
+			if err := repo.PurgeList(list); err != nil {
+				return err
+			}
+			return s.audit.recordList(tx, AuditPurge, list, nil)
+		case TrashTypeTask:
+			task, err := repo.GetDeletedTask(id, userID)
+			if err != nil {
+				return notFound(err, apperrors.ErrTaskNotFound)
+			}
+			if err := repo.PurgeTask(task); err != nil {
+				return err
+			}
+			return s.audit.recordTask(tx, AuditPurge, userID, task, nil)
+		}
+		return errUnknownTrashType
+	})
+}
+
+func (s *trashService) EmptyTrash(userID int) error {
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		lists, err := repo.GetDeletedLists(userID)
+		if err != nil {
+			return err
+		}
+		tasks, err := repo.GetDeletedTasks(userID)
+		if err != nil {
+			return err
+		}
+		if err := repo.PurgeAll(userID); err != nil {
+			return err
+		}
+		for i := range lists {
+			if err := s.audit.recordList(tx, AuditPurge, &lists[i], nil); err != nil {
+				return err
+			}
+		}
+		for i := range tasks {
+			if err := s.audit.recordTask(tx, AuditPurge, userID, &tasks[i], nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PurgeExpired permanently deletes everything that has been in the trash
+// longer than retention; zero keeps it forever
+func (s *trashService) PurgeExpired(retention time.Duration) (int64, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+	var purged int64
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		var err error
+		purged, err = s.repo.WithTx(tx).PurgeDeletedBefore(time.Now().Add(-retention))
+		return err
+	})
+	return purged, err
+}
+
+var errUnknownTrashType = apperrors.ErrNotFound.WithMessage("Unknown trash item type, expected list or task")
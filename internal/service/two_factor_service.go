@@ -0,0 +1,462 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/qrcode"
+	"RestAPI/pkg/totp"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	// Recovery codes issued at once; a new set replaces the old one
+	RecoveryCodeCount = 10
+	// Invalid codes in a row after which the second factor is locked
+	maxMFAFailures = 5
+	mfaLockout     = 15 * time.Minute
+	// Codes of the neighbouring 30 second steps are accepted for clock drift
+	totpSkew = 1
+	// Pixels per module of the enrollment QR code
+	qrCodeScale = 6
+	// User agents are cut to this length for device names
+	maxDeviceNameLength = 200
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorSettings configure two-factor authentication
+type TwoFactorSettings struct {
+	// Shown by authenticator apps next to the account
+	Issuer string
+	// How long the challenge returned by the first login step is valid
+	ChallengeTTL time.Duration
+	// How long a remembered device skips the second factor, 0 turns remembering off
+	RememberDevice time.Duration
+}
+
+// TwoFactorStatus model
+// swagger:model
+type TwoFactorStatus struct {
+	Enabled   bool       `json:"enabled"`
+	EnabledAt *time.Time `json:"enabled_at"`
+	// An authenticator was enrolled but not confirmed with a code yet
+	Pending bool `json:"pending"`
+	// Unused recovery codes
+	// example: 10
+	RecoveryCodesLeft int64 `json:"recovery_codes_left"`
+	// example: 1
+	TrustedDevices int `json:"trusted_devices"`
+}
+
+// TOTPEnrollment is the secret to add to an authenticator app
+// swagger:model
+type TOTPEnrollment struct {
+	// Base32 secret for entering by hand
+	// example: JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP
+	Secret string `json:"secret"`
+	// otpauth:// link; the QR code encodes it
+	// example: otpauth://totp/Todo:john_doe?algorithm=SHA1&digits=6&issuer=Todo&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP
+	URI string `json:"uri"`
+	// example: /me/2fa/totp/qr.png
+	QRCodeURL string `json:"qr_code_url"`
+}
+
+// SecondFactor is a code from the authenticator app or a recovery code;
+// one of them is expected
+type SecondFactor struct {
+	Code         string
+	RecoveryCode string
+}
+
+type TwoFactorService interface {
+	Status(userID int) (*TwoFactorStatus, error)
+	// Enroll generates a new secret; it takes effect once Confirm gets a
+	// code from it
+	Enroll(userID int) (*TOTPEnrollment, error)
+	// QRCode renders the otpauth link of the pending secret as PNG
+	QRCode(userID int) ([]byte, error)
+	// Confirm turns two-factor authentication on and returns the recovery codes
+	Confirm(userID int, code string) ([]string, error)
+	// Disable turns two-factor authentication off and forgets the recovery
+	// codes and trusted devices
+	Disable(userID int, password string, factor SecondFactor) error
+	// RegenerateRecoveryCodes replaces the recovery codes with a new set
+	RegenerateRecoveryCodes(userID int, factor SecondFactor) ([]string, error)
+	GetDevices(userID int) ([]models.TrustedDevice, error)
+	DeleteDevice(userID, deviceID int) error
+	// IsTrustedDevice reports whether the token remembers a device of the user
+	IsTrustedDevice(userID int, deviceToken string) (bool, error)
+	// Verify checks the second factor at login; a recovery code is used up
+	Verify(user *models.User, factor SecondFactor) error
+	// RememberDevice trusts a device of the user and returns its token;
+	// empty when remembering devices is turned off
+	RememberDevice(userID int, name string) (string, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) TwoFactorService
+}
+
+type twoFactorService struct {
+	repo     repository.TwoFactorRepository
+	userRepo repository.UserRepository
+	settings TwoFactorSettings
+	tx       repository.Transactor
+	audit    Auditor
+}
+
+func NewTwoFactorService(repo repository.TwoFactorRepository, userRepo repository.UserRepository, settings TwoFactorSettings,
+	auditor Auditor, tx repository.Transactor) TwoFactorService {
+	return &twoFactorService{repo: repo, userRepo: userRepo, settings: settings, tx: tx, audit: auditor}
+}
+
+func (s *twoFactorService) WithActor(actor Actor) TwoFactorService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *twoFactorService) getUser(userID int) (*models.User, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, apperrors.ErrUnauthorized
+	}
+	return user, nil
+}
+
+func (s *twoFactorService) Status(userID int) (*TwoFactorStatus, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	status := &TwoFactorStatus{
+		Enabled:   user.TwoFactorEnabled(),
+		EnabledAt: user.TwoFactorEnabledAt,
+		Pending:   user.TOTPPendingSecret != "",
+	}
+	if !status.Enabled {
+		return status, nil
+	}
+	if status.RecoveryCodesLeft, err = s.repo.CountRecoveryCodes(userID); err != nil {
+		return nil, err
+	}
+	devices, err := s.repo.GetDevices(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	status.TrustedDevices = len(devices)
+	return status, nil
+}
+
+func (s *twoFactorService) Enroll(userID int) (*TOTPEnrollment, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled() {
+		return nil, apperrors.ErrTwoFactorEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, apperrors.ErrInternal.Wrap(err)
+	}
+	user.TOTPPendingSecret = secret
+	if err := s.repo.SaveTOTP(user); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{Secret: secret, URI: s.uri(user), QRCodeURL: "/me/2fa/totp/qr.png"}, nil
+}
+
+// uri names the account by its email, as authenticator apps show it
+// next to the issuer
+func (s *twoFactorService) uri(user *models.User) string {
+	account := user.Username
+	if user.Email != nil {
+		account = *user.Email
+	}
+	return totp.URI(s.settings.Issuer, account, user.TOTPPendingSecret)
+}
+
+func (s *twoFactorService) QRCode(userID int) ([]byte, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, apperrors.ErrTOTPNotEnrolled
+	}
+	code, err := qrcode.Encode([]byte(s.uri(user)))
+	if err != nil {
+		return nil, apperrors.ErrInternal.Wrap(err)
+	}
+	return code.PNG(qrCodeScale)
+}
+
+func (s *twoFactorService) Confirm(userID int, code string) ([]string, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled() {
+		return nil, apperrors.ErrTwoFactorEnabled
+	}
+	if user.TOTPPendingSecret == "" {
+		return nil, apperrors.ErrTOTPNotEnrolled
+	}
+	now := time.Now()
+	counter, ok, err := totp.Validate(user.TOTPPendingSecret, code, now, totpSkew)
+	if err != nil {
+		return nil, apperrors.ErrInternal.Wrap(err)
+	}
+	if !ok {
+		return nil, invalidCodeField("code")
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, apperrors.ErrInternal.Wrap(err)
+	}
+	user.TOTPSecret = user.TOTPPendingSecret
+	user.TOTPPendingSecret = ""
+	user.TOTPLastCounter = counter
+	user.TwoFactorEnabledAt = &now
+	user.MFAFailures = 0
+	user.MFALockedUntil = nil
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.SaveTOTP(user); err != nil {
+			return err
+		}
+		if err := repo.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+			return err
+		}
+		return s.audit.recordAccount(tx, AuditUpdate, user, map[string]interface{}{"two_factor": false}, map[string]interface{}{"two_factor": true})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *twoFactorService) Disable(userID int, password string, factor SecondFactor) error {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TwoFactorEnabled() {
+		return apperrors.ErrTwoFactorDisabled
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return apperrors.Validation(apperrors.Field("password", "password", "is not the password of the account"))
+	}
+	if err := s.checkOwnFactor(user, factor); err != nil {
+		return err
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPPendingSecret = ""
+	user.TOTPLastCounter = 0
+	user.TwoFactorEnabledAt = nil
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.SaveTOTP(user); err != nil {
+			return err
+		}
+		if err := repo.DeleteForUser(user.ID); err != nil {
+			return err
+		}
+		return s.audit.recordAccount(tx, AuditUpdate, user, map[string]interface{}{"two_factor": true}, map[string]interface{}{"two_factor": false})
+	})
+}
+
+func (s *twoFactorService) RegenerateRecoveryCodes(userID int, factor SecondFactor) ([]string, error) {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TwoFactorEnabled() {
+		return nil, apperrors.ErrTwoFactorDisabled
+	}
+	if err := s.checkOwnFactor(user, factor); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, apperrors.ErrInternal.Wrap(err)
+	}
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		if err := s.repo.WithTx(tx).ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+			return err
+		}
+		return s.audit.recordAccount(tx, AuditUpdate, user, nil, map[string]interface{}{"recovery_codes_regenerated": true})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *twoFactorService) GetDevices(userID int) ([]models.TrustedDevice, error) {
+	return s.repo.GetDevices(userID, time.Now())
+}
+
+func (s *twoFactorService) DeleteDevice(userID, deviceID int) error {
+	user, err := s.getUser(userID)
+	if err != nil {
+		return err
+	}
+	return s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		deleted, err := s.repo.WithTx(tx).DeleteDevice(userID, deviceID)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return apperrors.ErrTrustedDeviceNotFound
+		}
+		return s.audit.recordAccount(tx, AuditUpdate, user, map[string]interface{}{"trusted_device": deviceID}, nil)
+	})
+}
+
+func (s *twoFactorService) IsTrustedDevice(userID int, deviceToken string) (bool, error) {
+	if deviceToken == "" {
+		return false, nil
+	}
+	now := time.Now()
+	device, err := s.repo.GetDevice(userID, hashToken(deviceToken), now)
+	if err != nil || device == nil {
+		return false, err
+	}
+	return true, s.repo.TouchDevice(device.ID, now)
+}
+
+func (s *twoFactorService) Verify(user *models.User, factor SecondFactor) error {
+	now := time.Now()
+	if user.MFALockedUntil != nil && user.MFALockedUntil.After(now) {
+		wait := int(user.MFALockedUntil.Sub(now).Minutes()) + 1
+		return apperrors.ErrMFALocked.WithMessage(fmt.Sprintf("Too many invalid codes, try again in %d minutes", wait))
+	}
+
+	ok, err := s.checkFactor(user, factor, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := s.repo.RecordFailure(user.ID, maxMFAFailures, now.Add(mfaLockout)); err != nil {
+			return err
+		}
+		return apperrors.ErrInvalidMFACode
+	}
+	return s.repo.ResetFailures(user.ID)
+}
+
+// checkFactor validates the code, moving the user's last used time step, or
+// uses up the recovery code
+func (s *twoFactorService) checkFactor(user *models.User, factor SecondFactor, now time.Time) (bool, error) {
+	if factor.RecoveryCode != "" {
+		codeHash := hashToken(normalizeRecoveryCode(factor.RecoveryCode))
+		var used bool
+		err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+			repo := s.repo.WithTx(tx)
+			updated, err := repo.UseRecoveryCode(user.ID, codeHash, now)
+			if err != nil || updated == 0 {
+				return err
+			}
+			used = true
+			left, err := repo.CountRecoveryCodes(user.ID)
+			if err != nil {
+				return err
+			}
+			return s.audit.orUser(user.ID).recordAccount(tx, AuditUpdate, user,
+				map[string]interface{}{"recovery_codes_left": left + 1}, map[string]interface{}{"recovery_codes_left": left})
+		})
+		return used, err
+	}
+
+	counter, ok, err := totp.Validate(user.TOTPSecret, factor.Code, now, totpSkew)
+	if err != nil {
+		return false, apperrors.ErrInternal.Wrap(err)
+	}
+	if !ok || counter <= user.TOTPLastCounter {
+		return false, nil
+	}
+	// Параллельный запрос мог принять тот же код раньше
+	updated, err := s.repo.AcceptCounter(user.ID, counter)
+	return updated > 0, err
+}
+
+// checkOwnFactor is Verify for a signed in user: an invalid code is a
+// field error rather than a failed sign-in
+func (s *twoFactorService) checkOwnFactor(user *models.User, factor SecondFactor) error {
+	err := s.Verify(user, factor)
+	if errors.Is(err, apperrors.ErrInvalidMFACode) {
+		field := "code"
+		if factor.RecoveryCode != "" {
+			field = "recovery_code"
+		}
+		return invalidCodeField(field)
+	}
+	return err
+}
+
+func (s *twoFactorService) RememberDevice(userID int, name string) (string, error) {
+	if s.settings.RememberDevice <= 0 {
+		return "", nil
+	}
+	now := time.Now()
+	if _, err := s.repo.DeleteExpiredDevices(now); err != nil {
+		return "", err
+	}
+	token, tokenHash, err := newHashedToken()
+	if err != nil {
+		return "", apperrors.ErrInternal.Wrap(err)
+	}
+	if len(name) > maxDeviceNameLength {
+		name = strings.ToValidUTF8(name[:maxDeviceNameLength], "")
+	}
+	device := &models.TrustedDevice{
+		UserID:     userID,
+		TokenHash:  tokenHash,
+		Name:       name,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.settings.RememberDevice),
+	}
+	if err := s.repo.CreateDevice(device); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func invalidCodeField(field string) error {
+	return apperrors.Validation(apperrors.Field(field, "invalid_code", "is invalid or was already used"))
+}
+
+// newRecoveryCodes returns codes such as "k3n7q-x2m4p" and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(raw)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed in any case, with or without
+// the dash
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
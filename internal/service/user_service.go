@@ -1,75 +1,262 @@
 package service
 
 import (
+	"RestAPI/internal/apperrors"
 	"RestAPI/internal/models"
 	"RestAPI/internal/repository"
-	"errors"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
+	"log"
+	"strings"
 	"time"
 )
 
 type UserService interface {
-	RegisterUser(username, password string) error
-	LoginUser(username, password string) (string, error)
+	// RegisterUser creates the account and emails a verification link when
+	// an email is given; the email is required by the block policy
+	RegisterUser(ctx context.Context, username, password, email string) error
+	// LoginUser checks the credentials. With two-factor authentication on it
+	// returns a challenge for CompleteLogin instead of the token, unless
+	// deviceToken remembers a trusted device.
+	LoginUser(username, password, deviceToken string) (*LoginResult, error)
+	// CompleteLogin checks the second factor of the challenge and signs in;
+	// with rememberDevice the result carries a token of the trusted device
+	CompleteLogin(challenge string, factor SecondFactor, rememberDevice bool, deviceName string) (*LoginResult, error)
+	// SignIn logs in a user authenticated elsewhere, such as by an identity
+	// provider; the same policies apply as after a password
+	SignIn(userID int) (*LoginResult, error)
+	IsAdmin(userID int) (bool, error)
+	// IsActive reports whether the user exists and is not waiting for deletion
+	IsActive(userID int) (bool, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) UserService
+}
+
+// LoginResult holds the access token or, when a second factor is needed,
+// the challenge to complete the login with
+type LoginResult struct {
+	Token     string
+	Challenge string
+	// Token of the device remembered at this login
+	DeviceToken string
 }
 
 type userService struct {
-	repo        repository.UserRepository
-	secretKey   string
-	tokenExpiry time.Duration
+	repo          repository.UserRepository
+	verifications EmailVerificationService
+	twoFactor     TwoFactorService
+	// Политика для пользователей без подтверждённого email
+	policy       string
+	secretKey    string
+	tokenExpiry  time.Duration
+	challengeTTL time.Duration
+}
+
+func NewUserService(repo repository.UserRepository, verifications EmailVerificationService, twoFactor TwoFactorService, policy string,
+	secretKey string, tokenExpiry, challengeTTL time.Duration) UserService {
+	return &userService{repo: repo, verifications: verifications, twoFactor: twoFactor, policy: policy,
+		secretKey: secretKey, tokenExpiry: tokenExpiry, challengeTTL: challengeTTL}
 }
 
-func NewUserService(repo repository.UserRepository, secretKey string, tokenExpiry time.Duration) UserService {
-	return &userService{repo: repo, secretKey: secretKey, tokenExpiry: tokenExpiry}
+func (s *userService) WithActor(actor Actor) UserService {
+	cp := *s
+	cp.twoFactor = s.twoFactor.WithActor(actor)
+	return &cp
 }
 
-func (s *userService) RegisterUser(username, password string) error {
+func (s *userService) RegisterUser(ctx context.Context, username, password, email string) error {
+	var emailPtr *string
+	if strings.TrimSpace(email) != "" {
+		normalized, err := normalizeEmail(email)
+		if err != nil {
+			return err
+		}
+		emailPtr = &normalized
+	} else if s.policy == VerificationPolicyBlock {
+		return apperrors.Validation(apperrors.Field("email", "required", "is required to sign up"))
+	}
+
 	existingUser, err := s.repo.FindByUsername(username)
-	if err == nil && existingUser != nil {
-		return ErrUserAlreadyExists
+	if err != nil {
+		return err
+	}
+	if existingUser != nil {
+		return apperrors.ErrUserAlreadyExists
+	}
+	if emailPtr != nil {
+		existing, err := s.repo.FindByEmail(*emailPtr)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return apperrors.ErrEmailAlreadyUsed
+		}
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return ErrHashingPassword
+		return apperrors.ErrInternal.Wrap(err)
 	}
 
 	newUser := &models.User{
 		Username: username,
 		Password: string(hashedPassword),
+		Email:    emailPtr,
+	}
+	if err := s.repo.CreateUser(newUser); err != nil {
+		return err
 	}
-	return s.repo.CreateUser(newUser)
+	if emailPtr != nil {
+		// Аккаунт уже создан; письмо можно запросить повторно
+		if err := s.verifications.Send(ctx, newUser.ID); err != nil {
+			log.Printf("Verification email after sign up failed: %v", err)
+		}
+	}
+	return nil
 }
 
-func (s *userService) LoginUser(username, password string) (string, error) {
+func (s *userService) LoginUser(username, password, deviceToken string) (*LoginResult, error) {
 	// Находим пользователя в базе
 	user, err := s.repo.FindByUsername(username)
-	if err != nil || user == nil {
-		return "", ErrUserNotFound
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, apperrors.ErrUserNotFound
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
-		return "", ErrInvalidCredentials
+		return nil, apperrors.ErrInvalidCredentials
+	}
+	return s.login(user, deviceToken)
+}
+
+func (s *userService) SignIn(userID int) (*LoginResult, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, apperrors.ErrUnauthorized
+	}
+	return s.login(user, "")
+}
+
+// login checks what may keep an authenticated user from signing in and
+// issues the token or the second factor challenge
+func (s *userService) login(user *models.User, deviceToken string) (*LoginResult, error) {
+	if user.PendingDeletion() {
+		return nil, apperrors.ErrAccountPendingDeletion
+	}
+	if s.policy == VerificationPolicyBlock && !user.EmailVerified() {
+		return nil, apperrors.ErrEmailNotVerified
+	}
+
+	if user.TwoFactorEnabled() {
+		trusted, err := s.twoFactor.IsTrustedDevice(user.ID, deviceToken)
+		if err != nil {
+			return nil, err
+		}
+		if !trusted {
+			// В токене проверки нет user_id, поэтому JWTMiddleware его не примет
+			challenge := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"mfa_user_id": user.ID,
+				"exp":         time.Now().Add(s.challengeTTL).Unix(),
+			})
+			challengeString, err := challenge.SignedString([]byte(s.secretKey))
+			if err != nil {
+				return nil, err
+			}
+			return &LoginResult{Challenge: challengeString}, nil
+		}
+	}
+
+	tokenString, err := s.issueToken(user)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Token: tokenString}, nil
+}
+
+func (s *userService) CompleteLogin(challenge string, factor SecondFactor, rememberDevice bool, deviceName string) (*LoginResult, error) {
+	token, err := jwt.Parse(challenge, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrInvalidKey
+		}
+		return []byte(s.secretKey), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, apperrors.ErrInvalidMFAChallenge
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	userID, ok := claims["mfa_user_id"].(float64)
+	if !ok {
+		return nil, apperrors.ErrInvalidMFAChallenge
+	}
+
+	user, err := s.repo.FindByID(int(userID))
+	if err != nil {
+		return nil, err
+	}
+	// За время проверки аккаунт могли удалить или отключить второй фактор
+	if user == nil || user.PendingDeletion() || !user.TwoFactorEnabled() {
+		return nil, apperrors.ErrInvalidMFAChallenge
+	}
+	if err := s.twoFactor.Verify(user, factor); err != nil {
+		return nil, err
+	}
+
+	result := &LoginResult{}
+	if result.Token, err = s.issueToken(user); err != nil {
+		return nil, err
 	}
+	if rememberDevice {
+		if result.DeviceToken, err = s.twoFactor.RememberDevice(user.ID, deviceName); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
 
+// issueToken signs the access token of a new login session
+func (s *userService) issueToken(user *models.User) (string, error) {
+	sessionID, err := newSessionID()
+	if err != nil {
+		return "", apperrors.ErrInternal.Wrap(err)
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(s.tokenExpiry).Unix(), // Используем константу
+		"user_id":   user.ID,
+		"sid":       sessionID,                            // Сессия входа, в её пределах работает отмена изменений
+		"auth_time": time.Now().Unix(),                    // Когда пользователь вошёл, для подтверждения входом
+		"exp":       time.Now().Add(s.tokenExpiry).Unix(), // Используем константу
 	})
+	return token.SignedString([]byte(s.secretKey)) // Используем константу
+}
 
-	tokenString, err := token.SignedString([]byte(s.secretKey)) // Используем константу
+func (s *userService) IsAdmin(userID int) (bool, error) {
+	user, err := s.repo.FindByID(userID)
 	if err != nil {
-		return "", err
+		return false, err
 	}
+	return user != nil && user.IsAdmin, nil
+}
 
-	return tokenString, nil
+func (s *userService) IsActive(userID int) (bool, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return false, err
+	}
+	return user != nil && !user.PendingDeletion(), nil
 }
 
-var (
-	ErrUserNotFound       = errors.New("user not found")
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUserAlreadyExists  = errors.New("user already exists")
-	ErrHashingPassword    = errors.New("failed to hash password")
-)
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
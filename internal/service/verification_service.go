@@ -0,0 +1,240 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"RestAPI/pkg/mailer"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// What users without a verified email may do
+const (
+	// Everything, verification is optional
+	VerificationPolicyNone = "none"
+	// Read, manage their profile and verify the email
+	VerificationPolicyRestrict = "restrict"
+	// Nothing, they cannot sign in
+	VerificationPolicyBlock = "block"
+)
+
+// Verification emails older than this are deleted; the daily limit is
+// counted over the same period
+const verificationWindow = 24 * time.Hour
+
+// VerificationSettings configure email verification
+type VerificationSettings struct {
+	// none, restrict or block
+	Policy string
+	// Link sent by email, {token} is replaced with the token
+	URL            string
+	TokenTTL       time.Duration
+	ResendInterval time.Duration
+	// Verification emails a user can get per day
+	DailyLimit int
+}
+
+type EmailVerificationService interface {
+	// Send emails a verification link to the user's current email
+	Send(ctx context.Context, userID int) error
+	// SendTo emails a verification link to the account with the unverified
+	// email. It reports nothing about the account so as not to reveal
+	// which emails are registered.
+	SendTo(ctx context.Context, email string) error
+	// Verify confirms the email the token was sent to
+	Verify(token string) (*models.User, error)
+	// IsVerified reports whether the user confirmed their email
+	IsVerified(userID int) (bool, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) EmailVerificationService
+}
+
+type emailVerificationService struct {
+	repo     repository.EmailVerificationRepository
+	userRepo repository.UserRepository
+	mailer   mailer.Mailer
+	settings VerificationSettings
+	tx       repository.Transactor
+	audit    Auditor
+}
+
+func NewEmailVerificationService(repo repository.EmailVerificationRepository, userRepo repository.UserRepository,
+	mailer mailer.Mailer, settings VerificationSettings, auditor Auditor, tx repository.Transactor) EmailVerificationService {
+	return &emailVerificationService{repo: repo, userRepo: userRepo, mailer: mailer, settings: settings, tx: tx, audit: auditor}
+}
+
+func (s *emailVerificationService) WithActor(actor Actor) EmailVerificationService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *emailVerificationService) Send(ctx context.Context, userID int) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return apperrors.ErrUnauthorized
+	}
+	if user.Email == nil {
+		return apperrors.Validation(apperrors.Field("email", "required", "add an email to the profile first"))
+	}
+	if user.EmailVerified() {
+		return apperrors.ErrEmailVerified
+	}
+	return s.send(ctx, user)
+}
+
+func (s *emailVerificationService) SendTo(ctx context.Context, email string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	user, err := s.userRepo.FindByEmail(email)
+	if err != nil || user == nil || user.EmailVerified() || user.PendingDeletion() {
+		return err
+	}
+	err = s.send(ctx, user)
+	if errors.Is(err, apperrors.ErrTooManyEmails) || errors.Is(err, apperrors.ErrMailUnavailable) {
+		// Отказ выдал бы, что такой email зарегистрирован; сбой отправки уже в логе
+		return nil
+	}
+	return err
+}
+
+// send stores a new token and emails the link, unless the user got too many
+// emails lately
+func (s *emailVerificationService) send(ctx context.Context, user *models.User) error {
+	now := time.Now()
+	if _, err := s.repo.DeleteOlder(now.Add(-max(verificationWindow, s.settings.TokenTTL))); err != nil {
+		return err
+	}
+	sent, err := s.repo.GetSentSince(user.ID, now.Add(-verificationWindow))
+	if err != nil {
+		return err
+	}
+	if len(sent) > 0 {
+		if wait := sent[0].CreatedAt.Add(s.settings.ResendInterval).Sub(now); wait > 0 {
+			return apperrors.ErrTooManyEmails.WithMessage(fmt.Sprintf("Wait %d seconds before requesting another verification email", int(wait.Seconds())+1))
+		}
+	}
+	if s.settings.DailyLimit > 0 && len(sent) >= s.settings.DailyLimit {
+		return apperrors.ErrTooManyEmails.WithMessage(fmt.Sprintf("At most %d verification emails can be sent per day", s.settings.DailyLimit))
+	}
+
+	token, tokenHash, err := newHashedToken()
+	if err != nil {
+		return err
+	}
+	verification := &models.EmailVerification{
+		UserID:    user.ID,
+		Email:     *user.Email,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(s.settings.TokenTTL),
+	}
+	if err := s.repo.Create(verification); err != nil {
+		return err
+	}
+
+	name := user.DisplayName
+	if name == "" {
+		name = user.Username
+	}
+	link := strings.ReplaceAll(s.settings.URL, "{token}", url.QueryEscape(token))
+	msg := mailer.Message{
+		To:      *user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nplease confirm your email address by opening this link:\n\n%s\n\n"+
+			"The link expires in %s. If you did not sign up, ignore this email.\n", name, link, s.settings.TokenTTL),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		log.Printf("Verification email to user %d failed: %v", user.ID, err)
+		return apperrors.ErrMailUnavailable.Wrap(err)
+	}
+	return nil
+}
+
+func (s *emailVerificationService) Verify(token string) (*models.User, error) {
+	now := time.Now()
+	verification, err := s.repo.GetByToken(hashToken(token), now)
+	if err != nil {
+		return nil, err
+	}
+	if verification == nil {
+		return nil, apperrors.ErrInvalidEmailToken
+	}
+	user, err := s.userRepo.FindByID(verification.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// Ссылка на старый email после его смены не подходит
+	if user == nil || user.Email == nil || *user.Email != verification.Email {
+		return nil, apperrors.ErrInvalidEmailToken
+	}
+
+	err = s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		repo := s.repo.WithTx(tx)
+		updated, err := repo.MarkVerified(user.ID, verification.Email, now)
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return apperrors.ErrInvalidEmailToken
+		}
+		if err := repo.DeleteForUser(user.ID); err != nil {
+			return err
+		}
+		user.EmailVerifiedAt = &now
+		return s.audit.recordAccount(tx, AuditUpdate, user, map[string]interface{}{"email_verified": false}, map[string]interface{}{"email_verified": true})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *emailVerificationService) IsVerified(userID int) (bool, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return false, err
+	}
+	return user != nil && user.EmailVerified(), nil
+}
+
+// normalizeEmail checks the address and lowercases it
+func normalizeEmail(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	addr, err := mail.ParseAddress(raw)
+	if err != nil || addr.Address != raw || len(raw) > MaxEmailLength {
+		return "", apperrors.Validation(apperrors.Field("email", "email", "must be an email address such as john@example.com"))
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// newHashedToken returns a random token and the hash that is stored instead
+// of it
+func newHashedToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,422 @@
+package service
+
+import (
+	"RestAPI/internal/apperrors"
+	"RestAPI/internal/models"
+	"RestAPI/internal/repository"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	MaxStatuses         = 20
+	maxStatusNameLength = 50
+	implicitOpenColumn  = "Open"
+	implicitDoneColumn  = "Done"
+)
+
+// BoardColumn is one column of a list's board with its tasks
+type BoardColumn struct {
+	// Nil for the two implicit columns of a list without a workflow
+	StatusID *int          `json:"status_id"`
+	Name     string        `json:"name"`
+	Terminal bool          `json:"terminal"`
+	WIPLimit int           `json:"wip_limit"`
+	Count    int           `json:"count"`
+	Tasks    []models.Task `json:"tasks"`
+}
+
+// Board is a list's tasks grouped by workflow column
+type Board struct {
+	ListID  int           `json:"list_id"`
+	Columns []BoardColumn `json:"columns"`
+}
+
+type WorkflowService interface {
+	GetStatuses(listID int, userID int) ([]models.Status, error)
+	// ReplaceStatuses sets the list's workflow. Statuses with an ID keep it,
+	// statuses missing from the new set are removed and their tasks move to
+	// the first open or terminal column. An empty set removes the workflow.
+	ReplaceStatuses(listID int, userID int, statuses []models.Status) ([]models.Status, error)
+	GetBoard(listID int, userID int) (*Board, error)
+	// WithActor returns a copy of the service that records actor in the audit log
+	WithActor(actor Actor) WorkflowService
+}
+
+type workflowService struct {
+	repo     repository.StatusRepository
+	listRepo repository.TodoListRepository
+	depRepo  repository.DependencyRepository
+	tx       repository.Transactor
+	audit    Auditor
+}
+
+func NewWorkflowService(repo repository.StatusRepository, listRepo repository.TodoListRepository, depRepo repository.DependencyRepository, auditor Auditor, tx repository.Transactor) WorkflowService {
+	return &workflowService{repo: repo, listRepo: listRepo, depRepo: depRepo, tx: tx, audit: auditor}
+}
+
+func (s *workflowService) WithActor(actor Actor) WorkflowService {
+	cp := *s
+	cp.audit = s.audit.As(actor)
+	return &cp
+}
+
+func (s *workflowService) GetStatuses(listID int, userID int) ([]models.Status, error) {
+	if _, err := s.list(s.listRepo, listID, userID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetStatuses(listID)
+}
+
+func (s *workflowService) ReplaceStatuses(listID int, userID int, statuses []models.Status) ([]models.Status, error) {
+	if err := validateStatuses(statuses); err != nil {
+		return nil, err
+	}
+
+	var saved []models.Status
+	err := s.tx.WithinTransaction(func(tx *gorm.DB) error {
+		list, err := s.list(s.listRepo.WithTx(tx), listID, userID)
+		if err != nil {
+			return err
+		}
+		repo := s.repo.WithTx(tx)
+		old, err := repo.GetStatuses(listID)
+		if err != nil {
+			return err
+		}
+		existing := make(map[int]bool, len(old))
+		for _, st := range old {
+			existing[st.ID] = true
+		}
+
+		saved = make([]models.Status, len(statuses))
+		kept := map[int]bool{}
+		for i, st := range statuses {
+			st.ListID = listID
+			st.Position = i
+			if st.ID != 0 {
+				if !existing[st.ID] {
+					return apperrors.Validation(apperrors.Field(fmt.Sprintf("statuses[%d].id", i), "exists", "no such status in this list"))
+				}
+				kept[st.ID] = true
+				err = repo.UpdateStatus(&st)
+			} else {
+				err = repo.CreateStatus(&st)
+			}
+			if err != nil {
+				return err
+			}
+			saved[i] = st
+		}
+
+		// Сначала переносим задачи, иначе внешний ключ обнулит им колонку молча
+		if err := s.reassignTasks(tx, listID, userID, &workflow{statuses: saved, repo: repo}); err != nil {
+			return err
+		}
+		for i := range old {
+			if kept[old[i].ID] {
+				continue
+			}
+			if err := repo.DeleteStatus(&old[i]); err != nil {
+				return err
+			}
+		}
+		return s.audit.recordWorkflow(tx, list, old, saved)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// reassignTasks puts every task of the list, including the ones in the
+// trash, into a column of the new workflow and keeps completion in sync
+func (s *workflowService) reassignTasks(tx *gorm.DB, listID int, userID int, wf *workflow) error {
+	repo := s.repo.WithTx(tx)
+	tasks, err := repo.GetListTasks(listID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range tasks {
+		before := tasks[i]
+		task := &tasks[i]
+		wf.fit(task, now)
+		if sameStatus(before.StatusID, task.StatusID) && before.Completed == task.Completed {
+			continue
+		}
+		task.UpdatedBy = userID
+		if err := repo.SetTaskStatus(task); err != nil {
+			return err
+		}
+		if err := s.audit.recordTask(tx, AuditUpdate, userID, &before, task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *workflowService) GetBoard(listID int, userID int) (*Board, error) {
+	list, err := s.list(s.listRepo, listID, userID)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := s.repo.GetStatuses(listID)
+	if err != nil {
+		return nil, err
+	}
+	if err := markBlockedAll(s.depRepo, list.Tasks); err != nil {
+		return nil, err
+	}
+	wf := &workflow{statuses: statuses}
+
+	var columns []BoardColumn
+	if wf.enabled() {
+		for _, st := range statuses {
+			id := st.ID
+			columns = append(columns, BoardColumn{StatusID: &id, Name: st.Name, Terminal: st.Terminal, WIPLimit: st.WIPLimit})
+		}
+	} else {
+		columns = []BoardColumn{{Name: implicitOpenColumn}, {Name: implicitDoneColumn, Terminal: true}}
+	}
+	index := make(map[int]int, len(statuses))
+	for i, st := range statuses {
+		index[st.ID] = i
+	}
+
+	// Задачи уже отсортированы по позиции в списке
+	for _, task := range list.Tasks {
+		col := -1
+		if task.StatusID != nil {
+			if i, ok := index[*task.StatusID]; ok {
+				col = i
+			}
+		}
+		switch {
+		case col >= 0:
+		case wf.enabled():
+			col = wf.columnFor(task.Completed)
+		case task.Completed:
+			col = 1
+		default:
+			col = 0
+		}
+		columns[col].Tasks = append(columns[col].Tasks, task)
+	}
+	for i := range columns {
+		if columns[i].Tasks == nil {
+			columns[i].Tasks = []models.Task{}
+		}
+		columns[i].Count = len(columns[i].Tasks)
+	}
+	return &Board{ListID: listID, Columns: columns}, nil
+}
+
+func (s *workflowService) list(repo repository.TodoListRepository, listID int, userID int) (*models.TodoList, error) {
+	if listID <= 0 {
+		return nil, apperrors.ErrInvalidID.WithMessage("invalid list ID")
+	}
+	list, err := repo.GetListByID(listID, userID)
+	if err != nil {
+		return nil, notFound(err, apperrors.ErrListNotFound)
+	}
+	return list, nil
+}
+
+// validateStatuses checks a new workflow and normalizes the names in Next
+// to the spelling of the columns they refer to
+func validateStatuses(statuses []models.Status) error {
+	if len(statuses) == 0 {
+		return nil
+	}
+	if len(statuses) > MaxStatuses {
+		return apperrors.Validation(apperrors.Field("statuses", "max", fmt.Sprintf("must contain at most %d statuses", MaxStatuses)))
+	}
+
+	var fields []apperrors.FieldError
+	names := make(map[string]string, len(statuses))
+	ids := map[int]bool{}
+	open, terminal := false, false
+	for i := range statuses {
+		st := &statuses[i]
+		st.Name = strings.TrimSpace(st.Name)
+		field := fmt.Sprintf("statuses[%d]", i)
+		switch {
+		case st.Name == "":
+			fields = append(fields, apperrors.Field(field+".name", "required", "name cannot be empty"))
+		case len([]rune(st.Name)) > maxStatusNameLength:
+			fields = append(fields, apperrors.Field(field+".name", "max", fmt.Sprintf("must be at most %d characters", maxStatusNameLength)))
+		case names[strings.ToLower(st.Name)] != "":
+			fields = append(fields, apperrors.Field(field+".name", "unique", "status names must be unique"))
+		default:
+			names[strings.ToLower(st.Name)] = st.Name
+		}
+		if st.ID != 0 {
+			if ids[st.ID] {
+				fields = append(fields, apperrors.Field(field+".id", "unique", "status is listed twice"))
+			}
+			ids[st.ID] = true
+		}
+		if st.WIPLimit < 0 {
+			fields = append(fields, apperrors.Field(field+".wip_limit", "min", "cannot be negative"))
+		}
+		if st.Terminal {
+			terminal = true
+		} else {
+			open = true
+		}
+	}
+	if !open || !terminal {
+		fields = append(fields, apperrors.Field("statuses", "invalid", "a workflow needs at least one open and one terminal status"))
+	}
+
+	for i := range statuses {
+		st := &statuses[i]
+		if st.Next == nil {
+			st.Next = []string{}
+		}
+		for j, next := range st.Next {
+			name, ok := names[strings.ToLower(strings.TrimSpace(next))]
+			if !ok {
+				fields = append(fields, apperrors.Field(fmt.Sprintf("statuses[%d].next[%d]", i, j), "exists", "no status with this name"))
+				continue
+			}
+			st.Next[j] = name
+		}
+	}
+	if len(fields) > 0 {
+		return apperrors.Validation(fields...)
+	}
+	return nil
+}
+
+// workflow is the set of columns of one list. Without columns tasks only
+// have the completed flag.
+type workflow struct {
+	statuses []models.Status
+	// Нужен только для проверки WIP-лимитов
+	repo repository.StatusRepository
+}
+
+func loadWorkflow(repo repository.StatusRepository, listID int) (*workflow, error) {
+	statuses, err := repo.GetStatuses(listID)
+	if err != nil {
+		return nil, err
+	}
+	return &workflow{statuses: statuses, repo: repo}, nil
+}
+
+func (w *workflow) enabled() bool {
+	return len(w.statuses) > 0
+}
+
+func (w *workflow) status(id *int) *models.Status {
+	if id == nil {
+		return nil
+	}
+	for i := range w.statuses {
+		if w.statuses[i].ID == *id {
+			return &w.statuses[i]
+		}
+	}
+	return nil
+}
+
+// columnFor returns the index of the first terminal column for completed
+// tasks and of the first open column otherwise
+func (w *workflow) columnFor(completed bool) int {
+	for i := range w.statuses {
+		if w.statuses[i].Terminal == completed {
+			return i
+		}
+	}
+	return 0
+}
+
+// place moves the task to the requested column, or to the first column
+// matching the requested completion, enforcing transitions and WIP limits.
+// An explicit completion change (the API before workflows) skips the
+// transition rules.
+func (w *workflow) place(task *models.Task, statusID *int, completed *bool, now time.Time) error {
+	if !w.enabled() {
+		if statusID != nil {
+			return apperrors.Validation(apperrors.Field("status_id", "invalid", "the list has no workflow"))
+		}
+		if completed != nil {
+			task.SetCompleted(*completed, now)
+		}
+		return nil
+	}
+
+	current := w.status(task.StatusID)
+	var target *models.Status
+	switch {
+	case statusID != nil:
+		if target = w.status(statusID); target == nil {
+			return apperrors.Validation(apperrors.Field("status_id", "exists", "no such status in this list"))
+		}
+		if completed != nil && *completed != target.Terminal {
+			return apperrors.Validation(apperrors.Field("completed", "conflict", "contradicts the terminal flag of the status"))
+		}
+		if current != nil && current.ID != target.ID && !current.CanMoveTo(target) {
+			return apperrors.ErrStatusTransition.WithMessage(fmt.Sprintf("Tasks cannot move from %q to %q", current.Name, target.Name))
+		}
+	case completed != nil && (current == nil || current.Terminal != *completed):
+		target = &w.statuses[w.columnFor(*completed)]
+	case current == nil:
+		target = &w.statuses[w.columnFor(task.Completed)]
+	default:
+		return nil
+	}
+
+	if current != nil && current.ID == target.ID {
+		return nil
+	}
+	if err := w.checkLimit(target, task.ID); err != nil {
+		return err
+	}
+	id := target.ID
+	task.StatusID = &id
+	task.SetCompleted(target.Terminal, now)
+	return nil
+}
+
+// checkLimit fails when the column cannot take one more task besides taskID
+func (w *workflow) checkLimit(status *models.Status, taskID int) error {
+	if status == nil || status.WIPLimit <= 0 {
+		return nil
+	}
+	count, err := w.repo.CountTasks(status.ID, taskID)
+	if err != nil {
+		return err
+	}
+	if count >= int64(status.WIPLimit) {
+		return apperrors.ErrWIPLimit.WithMessage(fmt.Sprintf("%q already holds %d of %d tasks", status.Name, count, status.WIPLimit))
+	}
+	return nil
+}
+
+// fit puts a task whose column no longer exists into a valid one and syncs
+// its completion, without checking rules; used when the workflow changes
+func (w *workflow) fit(task *models.Task, now time.Time) {
+	if !w.enabled() {
+		task.StatusID = nil
+		return
+	}
+	current := w.status(task.StatusID)
+	if current == nil {
+		current = &w.statuses[w.columnFor(task.Completed)]
+		id := current.ID
+		task.StatusID = &id
+	}
+	task.SetCompleted(current.Terminal, now)
+}
+
+func sameStatus(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
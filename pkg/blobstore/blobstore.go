@@ -0,0 +1,31 @@
+// Package blobstore stores opaque binary objects under string keys.
+// Two backends are provided: a local directory and any S3-compatible
+// object storage.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned by Open when no object is stored under the key
+var ErrNotFound = errors.New("blobstore: object not found")
+
+// Store is the storage backend for blobs
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any previous object
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open returns the object for reading. The object can seek, so callers
+	// may serve byte ranges without reading the whole blob.
+	Open(ctx context.Context, key string) (Object, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete removes the object; deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is an opened blob
+type Object interface {
+	io.ReadSeekCloser
+	Size() int64
+}
@@ -0,0 +1,140 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testStore runs the behaviour every Store must share against store
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	const key = "0123456789abcdef"
+	data := []byte("the quick brown fox jumps over the lazy dog")
+
+	t.Run("missing object", func(t *testing.T) {
+		if _, err := store.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Open error = %v, want %v", err, ErrNotFound)
+		}
+		ok, err := store.Exists(ctx, key)
+		if err != nil || ok {
+			t.Fatalf("Exists = %v, %v", ok, err)
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete of a missing object: %v", err)
+		}
+	})
+
+	t.Run("put and open", func(t *testing.T) {
+		if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+			t.Fatal(err)
+		}
+		ok, err := store.Exists(ctx, key)
+		if err != nil || !ok {
+			t.Fatalf("Exists = %v, %v", ok, err)
+		}
+		obj, err := store.Open(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer obj.Close()
+		if obj.Size() != int64(len(data)) {
+			t.Errorf("Size = %d, want %d", obj.Size(), len(data))
+		}
+		got, err := io.ReadAll(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("read %q, want %q", got, data)
+		}
+	})
+
+	t.Run("ranged reads", func(t *testing.T) {
+		obj, err := store.Open(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer obj.Close()
+		tests := []struct {
+			offset int64
+			whence int
+			n      int
+			want   string
+		}{
+			{4, io.SeekStart, 5, "quick"},
+			{1, io.SeekCurrent, 5, "brown"},
+			{-3, io.SeekEnd, 3, "dog"},
+			{0, io.SeekStart, 3, "the"},
+			{16, io.SeekStart, 3, "fox"},
+		}
+		for _, tt := range tests {
+			if _, err := obj.Seek(tt.offset, tt.whence); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, tt.n)
+			if _, err := io.ReadFull(obj, buf); err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != tt.want {
+				t.Errorf("read %q at %d/%d, want %q", buf, tt.offset, tt.whence, tt.want)
+			}
+		}
+		if _, err := obj.Seek(0, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := obj.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("read at the end = %d, %v", n, err)
+		}
+		if _, err := obj.Seek(-1, io.SeekStart); err == nil {
+			t.Error("seek to a negative position succeeded")
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		replaced := "replaced"
+		if err := store.Put(ctx, key, strings.NewReader(replaced), int64(len(replaced))); err != nil {
+			t.Fatal(err)
+		}
+		obj, err := store.Open(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(obj)
+		obj.Close()
+		if string(got) != replaced {
+			t.Errorf("read %q, want %q", got, replaced)
+		}
+	})
+
+	t.Run("empty object", func(t *testing.T) {
+		if err := store.Put(ctx, "empty", bytes.NewReader(nil), 0); err != nil {
+			t.Fatal(err)
+		}
+		obj, err := store.Open(ctx, "empty")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer obj.Close()
+		got, err := io.ReadAll(obj)
+		if err != nil || len(got) != 0 || obj.Size() != 0 {
+			t.Errorf("read %q, %v, size %d", got, err, obj.Size())
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := store.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Open after Delete error = %v, want %v", err, ErrNotFound)
+		}
+		ok, err := store.Exists(ctx, key)
+		if err != nil || ok {
+			t.Fatalf("Exists after Delete = %v, %v", ok, err)
+		}
+	})
+}
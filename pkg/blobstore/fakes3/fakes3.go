@@ -0,0 +1,96 @@
+// Package fakes3 is an in-memory stand-in for an S3-compatible service.
+// It understands the path-style object requests blobstore sends (PUT, GET
+// with Range, HEAD and DELETE), so the S3 backend can be exercised without
+// network access:
+//
+//	srv := httptest.NewServer(fakes3.New("bucket", "access-key"))
+//	store, _ := blobstore.NewS3(blobstore.S3Config{Endpoint: srv.URL, Bucket: "bucket", PathStyle: true, ...})
+//
+// Signatures are not verified, only the presence of a SigV4 Authorization
+// header with the expected access key.
+package fakes3
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server serves a single bucket from memory
+type Server struct {
+	bucket      string
+	accessKeyID string
+
+	mu      sync.Mutex
+	objects map[string]object
+}
+
+type object struct {
+	data     []byte
+	modified time.Time
+}
+
+func New(bucket, accessKeyID string) *Server {
+	return &Server{bucket: bucket, accessKeyID: accessKeyID, objects: map[string]object{}}
+}
+
+// Len returns the number of stored objects
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+s.accessKeyID+"/") {
+		s.fail(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		s.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if key == "" {
+		s.fail(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.fail(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.mu.Lock()
+		s.objects[key] = object{data: data, modified: time.Now()}
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		s.mu.Lock()
+		obj, ok := s.objects[key]
+		s.mu.Unlock()
+		if !ok {
+			s.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		http.ServeContent(w, r, "", obj.modified, bytes.NewReader(obj.data))
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *Server) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, "<Error><Code>"+code+"</Code></Error>")
+}
@@ -0,0 +1,120 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type localStore struct {
+	root string
+}
+
+// NewLocal returns a store that keeps every object in a file under root.
+// Keys are spread over two levels of subdirectories by their first
+// characters, so content hashes make good keys.
+func NewLocal(root string) (Store, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("blobstore: create %s: %w", root, err)
+	}
+	return &localStore{root: root}, nil
+}
+
+func (s *localStore) path(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("blobstore: invalid key %q", key)
+	}
+	dir := s.root
+	if len(key) >= 4 {
+		dir = filepath.Join(s.root, key[:2], key[2:4])
+	}
+	return filepath.Join(dir, key), nil
+}
+
+func (s *localStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	// Пишем во временный файл и переименовываем, чтобы читатели не увидели недописанный объект
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("blobstore: wrote %d bytes of %d", written, size)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *localStore) Open(ctx context.Context, key string) (Object, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &localObject{File: f, size: info.Size()}, nil
+}
+
+func (s *localStore) Exists(ctx context.Context, key string) (bool, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+type localObject struct {
+	*os.File
+	size int64
+}
+
+func (o *localObject) Size() int64 {
+	return o.size
+}
@@ -0,0 +1,65 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
+
+func TestLocalLayout(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := store.Put(ctx, "abcdef", bytes.NewReader([]byte("x")), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "ab", "cd", "abcdef")); err != nil {
+		t.Errorf("object is not spread over subdirectories: %v", err)
+	}
+	// Недописанный объект не должен остаться на диске
+	if err := store.Put(ctx, "short", bytes.NewReader([]byte("x")), 2); err == nil {
+		t.Error("Put with a wrong size succeeded")
+	}
+	if ok, _ := store.Exists(ctx, "short"); ok {
+		t.Error("object with a wrong size was stored")
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "sh", "or"))
+	if len(entries) != 0 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
+
+func TestLocalInvalidKeys(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"", "../etc/passwd", "a/b", `a\b`, ".hidden"} {
+		if err := store.Put(ctx, key, bytes.NewReader(nil), 0); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if _, err := store.Open(ctx, key); err == nil {
+			t.Errorf("Open(%q) succeeded", key)
+		}
+		if _, err := store.Exists(ctx, key); err == nil {
+			t.Errorf("Exists(%q) succeeded", key)
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) succeeded", key)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Service        = "s3"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDateFormat    = "20060102T150405Z"
+)
+
+// S3Config describes a bucket of an S3-compatible object storage
+type S3Config struct {
+	// Base URL of the service, e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses objects as endpoint/bucket/key instead of
+	// bucket.endpoint/key; MinIO and most local stand-ins need it
+	PathStyle bool
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type s3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3 returns a store backed by an S3-compatible bucket. Requests are
+// signed with AWS Signature Version 4.
+func NewS3(cfg S3Config) (Store, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("blobstore: invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" || cfg.Region == "" {
+		return nil, errors.New("blobstore: S3 bucket and region are required")
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &s3Store{cfg: cfg, endpoint: endpoint, client: client, now: time.Now}, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	body := io.NopCloser(r)
+	if size == 0 {
+		body = http.NoBody
+	}
+	req, err := s.request(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := s.do(req, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) Open(ctx context.Context, key string) (Object, error) {
+	size, err := s.head(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{ctx: ctx, store: s, key: key, size: size}, nil
+}
+
+func (s *s3Store) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.head(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) head(ctx context.Context, key string) (int64, error) {
+	req, err := s.request(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// get fetches the object starting at offset
+func (s *s3Store) get(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) request(ctx context.Context, method, key string, body io.ReadCloser) (*http.Request, error) {
+	if key == "" {
+		return nil, errors.New("blobstore: empty key")
+	}
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		path += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = path + "/" + key
+	u.RawPath = s3Escape(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = body
+	}
+	return req, nil
+}
+
+// do signs and sends the request; a non-2xx status becomes an error and
+// 404 becomes ErrNotFound
+func (s *s3Store) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("blobstore: S3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(detail)))
+}
+
+// sign adds the SigV4 Authorization header. Only host and the x-amz-*
+// headers are signed, which is enough for S3 and keeps proxies that touch
+// other headers from breaking the signature.
+func (s *s3Store) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format(amzDateFormat)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.cfg.Region + "/" + s3Service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// s3Escape encodes every path segment the way SigV4 expects: everything
+// except unreserved characters is percent-encoded
+func s3Escape(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Object reads the object lazily: every seek drops the current response
+// and the next read starts a ranged GET at the new offset
+type s3Object struct {
+	ctx    context.Context
+	store  *s3Store
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *s3Object) Size() int64 {
+	return o.size
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := o.store.get(o.ctx, o.key, o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("blobstore: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("blobstore: negative position")
+	}
+	if offset != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
@@ -0,0 +1,79 @@
+package blobstore
+
+import (
+	"RestAPI/pkg/blobstore/fakes3"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newS3(t *testing.T, accessKeyID string) (Store, *fakes3.Server) {
+	t.Helper()
+	fake := fakes3.New("bucket", "access-key")
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	store, err := NewS3(S3Config{
+		Endpoint:        srv.URL,
+		Region:          "us-east-1",
+		Bucket:          "bucket",
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+		Client:          srv.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, fake
+}
+
+func TestS3(t *testing.T) {
+	store, _ := newS3(t, "access-key")
+	testStore(t, store)
+}
+
+func TestS3Keys(t *testing.T) {
+	store, fake := newS3(t, "access-key")
+	ctx := context.Background()
+	key := "attachments/12/report (final)+v2.pdf"
+	if err := store.Put(ctx, key, strings.NewReader("pdf"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if fake.Len() != 1 {
+		t.Fatalf("fake holds %d objects", fake.Len())
+	}
+	obj, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	if got, _ := io.ReadAll(obj); string(got) != "pdf" {
+		t.Errorf("read %q", got)
+	}
+	if err := store.Put(ctx, "", bytes.NewReader(nil), 0); err == nil {
+		t.Error("Put with an empty key succeeded")
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	store, _ := newS3(t, "wrong-key")
+	ctx := context.Background()
+	err := store.Put(ctx, "key", strings.NewReader("x"), 1)
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Put error = %v, want AccessDenied", err)
+	}
+	if _, err := store.Exists(ctx, "key"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Exists error = %v", err)
+	}
+
+	if _, err := NewS3(S3Config{Endpoint: "localhost", Region: "r", Bucket: "b"}); err == nil {
+		t.Error("endpoint without a scheme accepted")
+	}
+	if _, err := NewS3(S3Config{Endpoint: "http://localhost"}); err == nil {
+		t.Error("config without a bucket accepted")
+	}
+}
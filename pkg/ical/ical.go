@@ -0,0 +1,323 @@
+// Package ical reads and writes iCalendar (RFC 5545) data. It handles the
+// syntax — content lines, folding, escaping, nested components — and the few
+// value types the API needs; what the components mean is left to callers.
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineOctets is the folding limit for content lines, CRLF excluded
+const maxLineOctets = 75
+
+var ErrSyntax = errors.New("ical: syntax error")
+
+// Property is one content line. Value is kept as written: TEXT values are
+// escaped, see Escape and Component.Text.
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Param returns the value of a parameter, or "" when it is not set
+func (p *Property) Param(name string) string {
+	return p.Params[strings.ToUpper(name)]
+}
+
+// Component is a BEGIN:NAME ... END:NAME block
+type Component struct {
+	Name       string
+	Props      []Property
+	Components []*Component
+}
+
+// NewCalendar returns an empty VCALENDAR
+func NewCalendar(prodID string) *Component {
+	cal := &Component{Name: "VCALENDAR"}
+	cal.Add("VERSION", "2.0")
+	cal.Add("PRODID", prodID)
+	cal.Add("CALSCALE", "GREGORIAN")
+	return cal
+}
+
+// Add appends a property with a raw value. params are name, value pairs.
+func (c *Component) Add(name string, value string, params ...string) {
+	prop := Property{Name: strings.ToUpper(name), Value: value}
+	if len(params) > 0 {
+		prop.Params = make(map[string]string, len(params)/2)
+		for i := 0; i+1 < len(params); i += 2 {
+			prop.Params[strings.ToUpper(params[i])] = params[i+1]
+		}
+	}
+	c.Props = append(c.Props, prop)
+}
+
+// AddText appends a TEXT property, escaping the value
+func (c *Component) AddText(name string, text string) {
+	c.Add(name, Escape(text))
+}
+
+// AddTime appends a DATE-TIME property in UTC
+func (c *Component) AddTime(name string, t time.Time) {
+	c.Add(name, FormatTime(t))
+}
+
+// Prop returns the first property with the name, or nil
+func (c *Component) Prop(name string) *Property {
+	name = strings.ToUpper(name)
+	for i := range c.Props {
+		if c.Props[i].Name == name {
+			return &c.Props[i]
+		}
+	}
+	return nil
+}
+
+// Text returns the unescaped value of the first property with the name
+func (c *Component) Text(name string) string {
+	if p := c.Prop(name); p != nil {
+		return Unescape(p.Value)
+	}
+	return ""
+}
+
+// Children returns the nested components with the name
+func (c *Component) Children(name string) []*Component {
+	name = strings.ToUpper(name)
+	var found []*Component
+	for _, child := range c.Components {
+		if child.Name == name {
+			found = append(found, child)
+		}
+	}
+	return found
+}
+
+// Encode writes the component as folded CRLF-terminated content lines
+func (c *Component) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	c.encode(bw)
+	return bw.Flush()
+}
+
+func (c *Component) encode(w *bufio.Writer) {
+	writeLine(w, "BEGIN:"+c.Name)
+	for _, p := range c.Props {
+		writeLine(w, p.line())
+	}
+	for _, child := range c.Components {
+		child.encode(w)
+	}
+	writeLine(w, "END:"+c.Name)
+}
+
+func (p *Property) line() string {
+	var b strings.Builder
+	b.WriteString(p.Name)
+	names := make([]string, 0, len(p.Params))
+	for name := range p.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := p.Params[name]
+		if strings.ContainsAny(value, ":;,") {
+			value = `"` + strings.ReplaceAll(value, `"`, "") + `"`
+		}
+		b.WriteString(";" + name + "=" + value)
+	}
+	b.WriteString(":" + p.Value)
+	return b.String()
+}
+
+// writeLine folds the line after at most 75 octets without splitting a
+// UTF-8 sequence; continuation lines start with a space
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// Пробел в начале строки продолжения тоже считается
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+// Decode parses an iCalendar stream and returns its top-level component
+func Decode(r io.Reader) (*Component, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.TrimPrefix(text, "\ufeff")
+
+	// Развёртываем строки: продолжение начинается с пробела или табуляции
+	var lines []string
+	var numbers []int
+	for i, raw := range strings.Split(text, "\n") {
+		raw = strings.TrimSuffix(raw, "\r")
+		if (strings.HasPrefix(raw, " ") || strings.HasPrefix(raw, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += raw[1:]
+			continue
+		}
+		lines = append(lines, raw)
+		numbers = append(numbers, i+1)
+	}
+
+	var root *Component
+	var stack []*Component
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		prop, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrSyntax, numbers[i], err)
+		}
+		switch prop.Name {
+		case "BEGIN":
+			comp := &Component{Name: strings.ToUpper(prop.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, comp)
+			} else if root != nil {
+				return nil, fmt.Errorf("%w: line %d: more than one top-level component", ErrSyntax, numbers[i])
+			} else {
+				root = comp
+			}
+			stack = append(stack, comp)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(prop.Value) {
+				return nil, fmt.Errorf("%w: line %d: unexpected END:%s", ErrSyntax, numbers[i], prop.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: line %d: property outside a component", ErrSyntax, numbers[i])
+			}
+			current := stack[len(stack)-1]
+			current.Props = append(current.Props, prop)
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("%w: no component", ErrSyntax)
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("%w: %s is not closed", ErrSyntax, stack[len(stack)-1].Name)
+	}
+	return root, nil
+}
+
+// parseLine splits name;param=value;...:value, honouring quoted parameter values
+func parseLine(line string) (Property, error) {
+	var prop Property
+	end := strings.IndexAny(line, ";:")
+	if end <= 0 {
+		return prop, errors.New("missing ':'")
+	}
+	prop.Name = strings.ToUpper(line[:end])
+	rest := line[end:]
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return prop, errors.New("invalid parameter")
+		}
+		name := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return prop, errors.New("unterminated quoted parameter")
+			}
+			value = rest[1 : closing+1]
+			rest = rest[closing+2:]
+		} else {
+			stop := strings.IndexAny(rest, ";:")
+			if stop < 0 {
+				return prop, errors.New("missing ':'")
+			}
+			value, rest = rest[:stop], rest[stop:]
+		}
+		if prop.Params == nil {
+			prop.Params = make(map[string]string)
+		}
+		prop.Params[name] = value
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return prop, errors.New("missing ':'")
+	}
+	prop.Value = rest[1:]
+	return prop, nil
+}
+
+// Escape escapes a TEXT value
+func Escape(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(text)
+}
+
+// Unescape reverses Escape
+func Unescape(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// FormatTime formats a DATE-TIME value in UTC
+func FormatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Time parses a DATE-TIME or DATE value. UTC values end with Z; other
+// values are in the zone of the TZID parameter, or in loc when the zone is
+// not given or unknown. allDay is true for DATE values.
+func (p *Property) Time(loc *time.Location) (t time.Time, allDay bool, err error) {
+	value := p.Value
+	if p.Param("VALUE") == "DATE" || len(value) == len("20060102") {
+		t, err = time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	if tzid := p.Param("TZID"); tzid != "" {
+		if zone, zerr := time.LoadLocation(strings.TrimPrefix(tzid, "/")); zerr == nil {
+			loc = zone
+		}
+	}
+	t, err = time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
@@ -0,0 +1,137 @@
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestRoundTrip(t *testing.T) {
+	summary := strings.Repeat("Купить молоко, хлеб; ", 8)
+	description := "Line one\nback\\slash, comma; semicolon\nСтрока три"
+	start := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	cal := NewCalendar("-//Todo//EN")
+	event := &Component{Name: "VEVENT"}
+	event.AddText("SUMMARY", summary)
+	event.AddText("DESCRIPTION", description)
+	event.AddTime("DTSTART", start)
+	event.Add("ATTENDEE", "mailto:john@example.com", "CN", "Doe, John: PM")
+	cal.Components = append(cal.Components, event)
+
+	var buf bytes.Buffer
+	if err := cal.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.String()
+	if !strings.HasSuffix(encoded, "END:VCALENDAR\r\n") {
+		t.Errorf("output does not end with a CRLF-terminated END:VCALENDAR")
+	}
+	lines := strings.Split(strings.TrimSuffix(encoded, "\r\n"), "\r\n")
+	folded := 0
+	for i, line := range lines {
+		if len(line) > maxLineOctets {
+			t.Errorf("line %d has %d octets: %q", i+1, len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line %d splits a UTF-8 sequence: %q", i+1, line)
+		}
+		if strings.HasPrefix(line, " ") {
+			folded++
+		}
+	}
+	if folded == 0 {
+		t.Fatal("the long SUMMARY was not folded")
+	}
+
+	decoded, err := Decode(strings.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := decoded.Children("VEVENT")
+	if decoded.Name != "VCALENDAR" || len(events) != 1 {
+		t.Fatalf("decoded %s with %d events", decoded.Name, len(events))
+	}
+	got := events[0]
+	if got.Text("SUMMARY") != summary {
+		t.Errorf("SUMMARY = %q, want %q", got.Text("SUMMARY"), summary)
+	}
+	if got.Text("DESCRIPTION") != description {
+		t.Errorf("DESCRIPTION = %q, want %q", got.Text("DESCRIPTION"), description)
+	}
+	if at, allDay, err := got.Prop("DTSTART").Time(time.UTC); err != nil || allDay || !at.Equal(start) {
+		t.Errorf("DTSTART = %v, %v, %v; want %v", at, allDay, err, start)
+	}
+	attendee := got.Prop("ATTENDEE")
+	if attendee.Value != "mailto:john@example.com" || attendee.Param("cn") != "Doe, John: PM" {
+		t.Errorf("ATTENDEE = %+v", attendee)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		text, escaped string
+	}{
+		{"plain", "plain"},
+		{"a,b;c", `a\,b\;c`},
+		{`back\slash`, `back\\slash`},
+		{"two\nlines", `two\nlines`},
+		{"crlf\r\nline", `crlf\nline`},
+	}
+	for _, tt := range tests {
+		if got := Escape(tt.text); got != tt.escaped {
+			t.Errorf("Escape(%q) = %q, want %q", tt.text, got, tt.escaped)
+		}
+		if got := Unescape(tt.escaped); got != strings.ReplaceAll(tt.text, "\r\n", "\n") {
+			t.Errorf("Unescape(%q) = %q", tt.escaped, got)
+		}
+	}
+	// Другие программы пишут \N и лишние обратные косые черты
+	if got := Unescape(`A\NB\:C\`); got != "A\nB:C\\" {
+		t.Errorf("Unescape = %q", got)
+	}
+}
+
+// Calendars from other programs may start with a BOM and use bare LF,
+// tabs for folding and lowercase names
+func TestDecodeLenient(t *testing.T) {
+	input := "\ufeffbegin:vcalendar\nBEGIN:VTODO\nSUMMARY:Long\n\t summary\nDUE;VALUE=DATE:20260314\nEND:VTODO\nEND:VCALENDAR\n"
+	cal, err := Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	todos := cal.Children("VTODO")
+	if len(todos) != 1 || todos[0].Text("SUMMARY") != "Long summary" {
+		t.Fatalf("unexpected calendar %+v", cal)
+	}
+	if _, allDay, err := todos[0].Prop("DUE").Time(time.UTC); err != nil || !allDay {
+		t.Errorf("DUE is not an all-day date: %v", err)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name, input string
+	}{
+		{"empty", ""},
+		{"no colon", "BEGIN:VCALENDAR\r\nSUMMARY\r\nEND:VCALENDAR\r\n"},
+		{"not closed", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"end without begin", "END:VCALENDAR\r\n"},
+		{"missing end", "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"},
+		{"property outside", "VERSION:2.0\r\nBEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"},
+		{"two calendars", "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\nBEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"},
+		{"invalid parameter", "BEGIN:VCALENDAR\r\nDTSTART;TZID:20260314T093000\r\nEND:VCALENDAR\r\n"},
+		{"unterminated quote", "BEGIN:VCALENDAR\r\nATTENDEE;CN=\"Doe:mailto:a@b.c\r\nEND:VCALENDAR\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cal, err := Decode(strings.NewReader(tt.input))
+			if !errors.Is(err, ErrSyntax) {
+				t.Errorf("Decode = %+v, %v; want ErrSyntax", cal, err)
+			}
+		})
+	}
+}
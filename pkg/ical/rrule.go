@@ -0,0 +1,114 @@
+package ical
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	frequencies = map[string]bool{"DAILY": true, "WEEKLY": true, "MONTHLY": true, "YEARLY": true}
+	weekdays    = map[string]bool{"MO": true, "TU": true, "WE": true, "TH": true, "FR": true, "SA": true, "SU": true}
+)
+
+// NormalizeRecurrence checks an RRULE value such as "FREQ=WEEKLY;BYDAY=MO,TH"
+// and returns it upper-cased with FREQ first. Only daily and coarser
+// frequencies are accepted; an optional "RRULE:" prefix is dropped.
+func NormalizeRecurrence(rule string) (string, error) {
+	rule = strings.ToUpper(strings.TrimSpace(rule))
+	rule = strings.TrimPrefix(rule, "RRULE:")
+	if rule == "" {
+		return "", fmt.Errorf("empty rule")
+	}
+
+	seen := make(map[string]bool)
+	var freq string
+	var rest []string
+	for _, part := range strings.Split(rule, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return "", fmt.Errorf("%q is not KEY=VALUE", part)
+		}
+		if seen[key] {
+			return "", fmt.Errorf("%s is given twice", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "FREQ":
+			if !frequencies[value] {
+				return "", fmt.Errorf("FREQ must be one of DAILY, WEEKLY, MONTHLY, YEARLY")
+			}
+			freq = part
+			continue
+		case "INTERVAL", "COUNT":
+			err = checkInts(value, 1, 1000, false)
+		case "UNTIL":
+			if _, perr := time.Parse("20060102", value); perr != nil {
+				if _, perr = time.Parse("20060102T150405Z", value); perr != nil {
+					err = fmt.Errorf("must be a date or a UTC date-time")
+				}
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				n := strings.TrimRight(day, "MOTUWEHFRSAU")
+				if !weekdays[day[len(n):]] {
+					err = fmt.Errorf("%q is not a weekday", day)
+					break
+				}
+				if n != "" {
+					if err = checkInts(n, 1, 53, true); err != nil {
+						break
+					}
+				}
+			}
+		case "BYMONTHDAY":
+			err = checkInts(value, 1, 31, true)
+		case "BYMONTH":
+			err = checkInts(value, 1, 12, false)
+		case "BYYEARDAY":
+			err = checkInts(value, 1, 366, true)
+		case "BYWEEKNO":
+			err = checkInts(value, 1, 53, true)
+		case "BYSETPOS":
+			err = checkInts(value, 1, 366, true)
+		case "WKST":
+			if !weekdays[value] {
+				err = fmt.Errorf("must be a weekday")
+			}
+		default:
+			return "", fmt.Errorf("%s is not supported", key)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", key, err)
+		}
+		rest = append(rest, part)
+	}
+	if freq == "" {
+		return "", fmt.Errorf("FREQ is required")
+	}
+	if seen["COUNT"] && seen["UNTIL"] {
+		return "", fmt.Errorf("COUNT and UNTIL cannot be combined")
+	}
+	return strings.Join(append([]string{freq}, rest...), ";"), nil
+}
+
+// checkInts checks a comma-separated list of integers in [min, max], or in
+// [-max, -min] as well when signed
+func checkInts(value string, min int, max int, signed bool) error {
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", item)
+		}
+		if signed && n < 0 {
+			n = -n
+		}
+		if n < min || n > max {
+			return fmt.Errorf("%s is out of range", item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7386) and
+// JSON Patch (RFC 6902) documents to JSON values.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch document")
+	ErrPathNotFound = errors.New("path not found")
+	ErrTestFailed   = errors.New("test operation failed")
+)
+
+// Operation is a single RFC 6902 operation
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MergePatch applies an RFC 7386 merge patch to doc
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = mergeValue(t[key], value)
+	}
+	return t
+}
+
+// DecodePatch parses an RFC 6902 patch document
+func DecodePatch(patch []byte) ([]Operation, error) {
+	var ops []Operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return ops, nil
+}
+
+// Apply applies RFC 6902 operations to doc. Operations are applied in
+// order and the whole patch fails if any of them fails.
+func Apply(doc []byte, ops []Operation) ([]byte, error) {
+	var root interface{}
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		var err error
+		root, err = applyOperation(root, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func applyOperation(root interface{}, op Operation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, fmt.Errorf("%w: missing value", ErrInvalidPatch)
+		}
+		var value interface{}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		switch op.Op {
+		case "add":
+			return add(root, path, value)
+		case "replace":
+			if _, err := get(root, path); err != nil {
+				return nil, err
+			}
+			if len(path) == 0 {
+				return value, nil
+			}
+			root, err = remove(root, path)
+			if err != nil {
+				return nil, err
+			}
+			return add(root, path, value)
+		default:
+			current, err := get(root, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, ErrTestFailed
+			}
+			return root, nil
+		}
+	case "remove":
+		return remove(root, path)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(root, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: cannot move a value into itself", ErrInvalidPatch)
+			}
+			if root, err = remove(root, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+		return add(root, path, value)
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: pointer %q must start with /", ErrInvalidPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func get(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			value, ok := n[token]
+			if !ok {
+				return nil, ErrPathNotFound
+			}
+			node = value
+		case []interface{}:
+			idx, err := arrayIndex(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[idx]
+		default:
+			return nil, ErrPathNotFound
+		}
+	}
+	return node, nil
+}
+
+func add(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+		child, ok := n[token]
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		child, err := add(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = child
+		return n, nil
+	case []interface{}:
+		if len(rest) == 0 {
+			if token == "-" {
+				return append(n, value), nil
+			}
+			idx, err := arrayIndex(token, len(n))
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[idx+1:], n[idx:])
+			n[idx] = value
+			return n, nil
+		}
+		idx, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		child, err := add(n[idx], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = child
+		return n, nil
+	}
+	return nil, ErrPathNotFound
+}
+
+func remove(node interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalidPatch)
+	}
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, nil
+		}
+		child, err := remove(child, rest)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = child
+		return n, nil
+	case []interface{}:
+		idx, err := arrayIndex(token, len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			return append(n[:idx], n[idx+1:]...), nil
+		}
+		child, err := remove(n[idx], rest)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = child
+		return n, nil
+	}
+	return nil, ErrPathNotFound
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+	if idx > max {
+		return 0, ErrPathNotFound
+	}
+	return idx, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		cp := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			cp[key] = deepCopy(item)
+		}
+		return cp
+	case []interface{}:
+		cp := make([]interface{}, len(v))
+		for i, item := range v {
+			cp[i] = deepCopy(item)
+		}
+		return cp
+	}
+	return value
+}
@@ -0,0 +1,159 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func equalJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("result is not JSON: %v (%s)", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("bad expected JSON: %v", err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// Examples of RFC 6902, appendix A
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   error
+	}{
+		{"A.1 add object member", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, nil},
+		{"A.2 add array element", `{"foo":["bar","baz"]}`,
+			`[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, nil},
+		{"A.3 remove object member", `{"baz":"qux","foo":"bar"}`,
+			`[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, nil},
+		{"A.4 remove array element", `{"foo":["bar","qux","baz"]}`,
+			`[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, nil},
+		{"A.5 replace value", `{"baz":"qux","foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, nil},
+		{"A.6 move value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, nil},
+		{"A.7 move array element", `{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, nil},
+		{"A.8 test success", `{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`, nil},
+		{"A.9 test error", `{"baz":"qux"}`,
+			`[{"op":"test","path":"/baz","value":"bar"}]`, "", ErrTestFailed},
+		{"A.10 add nested member", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`, nil},
+		{"A.11 ignore unrecognized elements", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`, nil},
+		{"A.12 add to nonexistent target", `{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", ErrPathNotFound},
+		{"A.14 escape ordering", `{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`, nil},
+		{"A.15 comparing strings and numbers", `{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":"10"}]`, "", ErrTestFailed},
+		{"A.16 add array value", `{"foo":["bar"]}`,
+			`[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, nil},
+		{"copy value", `{"foo":{"bar":1}}`,
+			`[{"op":"copy","from":"/foo","path":"/baz"}]`, `{"foo":{"bar":1},"baz":{"bar":1}}`, nil},
+		{"replace whole document", `{"foo":1}`,
+			`[{"op":"replace","path":"","value":[1]}]`, `[1]`, nil},
+		{"remove missing member", `{"foo":1}`,
+			`[{"op":"remove","path":"/bar"}]`, "", ErrPathNotFound},
+		{"array index out of range", `{"foo":[1]}`,
+			`[{"op":"add","path":"/foo/2","value":2}]`, "", ErrPathNotFound},
+		{"leading zero index", `{"foo":[1,2]}`,
+			`[{"op":"remove","path":"/foo/01"}]`, "", ErrInvalidPatch},
+		{"unknown operation", `{"a":1}`,
+			`[{"op":"jump","path":"/a"}]`, "", ErrInvalidPatch},
+		{"path without leading slash", `{"a":1}`,
+			`[{"op":"add","path":"a","value":1}]`, "", ErrInvalidPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := DecodePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatalf("DecodePatch: %v", err)
+			}
+			got, err := Apply([]byte(tt.doc), ops)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			equalJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestApplyIsAtomic(t *testing.T) {
+	doc := []byte(`{"foo":"bar"}`)
+	ops, err := DecodePatch([]byte(`[{"op":"add","path":"/baz","value":1},{"op":"test","path":"/foo","value":"x"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Apply(doc, ops); !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("error = %v, want %v", err, ErrTestFailed)
+	}
+	equalJSON(t, doc, `{"foo":"bar"}`)
+}
+
+func TestDecodePatchInvalid(t *testing.T) {
+	for _, patch := range []string{
+		`{"op":"add"}`,
+		`not json`,
+	} {
+		if _, err := DecodePatch([]byte(patch)); !errors.Is(err, ErrInvalidPatch) {
+			t.Errorf("DecodePatch(%s) error = %v, want %v", patch, err, ErrInvalidPatch)
+		}
+	}
+}
+
+// Examples of RFC 7396, appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("MergePatch(%s, %s): %v", tt.doc, tt.patch, err)
+			continue
+		}
+		equalJSON(t, got, tt.want)
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	if _, err := MergePatch([]byte(`{}`), []byte(`{`)); !errors.Is(err, ErrInvalidPatch) {
+		t.Fatalf("error = %v, want %v", err, ErrInvalidPatch)
+	}
+}
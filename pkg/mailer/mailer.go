@@ -0,0 +1,108 @@
+// Package mailer sends plain text emails over SMTP, or writes them to files
+// or the log during development.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Bytes formats the message as an RFC 5322 email from the address
+func (m Message) Bytes(from string) ([]byte, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+
+	var buf bytes.Buffer
+	headers := []struct{ name, value string }{
+		{"From", from},
+		{"To", m.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(id) + "@" + domain + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	for _, h := range headers {
+		// Перевод строки в заголовке позволил бы дописать свои заголовки
+		if strings.ContainsAny(h.value, "\r\n") {
+			return nil, fmt.Errorf("header %s contains a line break", h.name)
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", h.name, h.value)
+	}
+	buf.WriteString("\r\n")
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(strings.ReplaceAll(m.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type logMailer struct{}
+
+// NewLog returns a mailer that prints the messages to the log instead of
+// sending them
+func NewLog() Mailer {
+	return logMailer{}
+}
+
+func (logMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFile returns a mailer that writes every message to an .eml file in dir
+func NewFile(dir, from string) (Mailer, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &fileMailer{dir: dir, from: from}, nil
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := msg.Bytes(m.from)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := time.Now().UTC().Format("20060102-150405.000000") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o640)
+}
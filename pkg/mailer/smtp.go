@@ -0,0 +1,142 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// Connection security of an SMTP server
+const (
+	// STARTTLS on the plain port, usually 587
+	SecurityStartTLS = "starttls"
+	// TLS from the start, usually port 465
+	SecurityTLS = "tls"
+	// No encryption, only for local relays
+	SecurityNone = "none"
+)
+
+// SMTPConfig describes an SMTP server
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// starttls, tls or none
+	Security string
+	// Sender address, e.g. "Todo <noreply@example.com>"
+	From string
+	// How long a message may take to send
+	Timeout time.Duration
+}
+
+type smtpMailer struct {
+	cfg SMTPConfig
+}
+
+func NewSMTP(cfg SMTPConfig) (Mailer, error) {
+	if cfg.Host == "" || cfg.Port == 0 {
+		return nil, fmt.Errorf("smtp host and port are required")
+	}
+	switch cfg.Security {
+	case SecurityStartTLS, SecurityTLS, SecurityNone:
+	default:
+		return nil, fmt.Errorf("unknown smtp security %q, expected starttls, tls or none", cfg.Security)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &smtpMailer{cfg: cfg}, nil
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	data, err := msg.Bytes(m.cfg.From)
+	if err != nil {
+		return err
+	}
+	from, err := addressOnly(m.cfg.From)
+	if err != nil {
+		return err
+	}
+	to, err := addressOnly(msg.To)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+	client, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if m.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp rcpt to: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	return client.Quit()
+}
+
+// dial connects and says hello, switching to TLS as configured
+func (m *smtpMailer) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.cfg.Host, fmt.Sprint(m.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: m.cfg.Host}
+	var conn net.Conn
+	var err error
+	if m.cfg.Security == SecurityTLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("smtp connect: %w", err)
+	}
+	// net/smtp не принимает контекст, поэтому ограничиваем соединение сроком
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("smtp hello: %w", err)
+	}
+	if m.cfg.Security == SecurityStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// addressOnly strips the display name: "Todo <noreply@example.com>"
+// becomes noreply@example.com
+func addressOnly(raw string) (string, error) {
+	addr, err := mail.ParseAddress(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", raw, err)
+	}
+	return addr.Address, nil
+}
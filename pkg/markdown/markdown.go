@@ -0,0 +1,168 @@
+// Package markdown sanitizes user-written markdown and extracts @mentions.
+// It does not render markdown; it only makes sure that whatever renderer the
+// client uses gets no raw HTML and no script links.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+var (
+	// Destinations of [text](dest) and ![alt](dest). Link text may nest
+	// brackets and images, so every "](" is taken for the start of one;
+	// checking a "](" that is not a link costs nothing. A destination in <>
+	// may contain spaces, a bare one ends at a space or a parenthesis.
+	linkPattern = regexp.MustCompile(`(\]\()[ \t]*(?:<([^<>\n]*)>|([^\s)]*))`)
+	// [ref]: dest, where the label may escape brackets and the destination
+	// may start on the next line
+	refPattern     = regexp.MustCompile(`(?m)^( {0,3}\[(?:\\.|[^\\\[\]])+\]:[ \t]*(?:\n[ \t]*)?)(?:<([^<>\n]*)>|(\S*))`)
+	mentionPattern = regexp.MustCompile(`(^|[^\w@.])@([A-Za-z0-9_][A-Za-z0-9_.-]*)`)
+
+	// Links with any other scheme are rewritten to #; relative links are kept
+	safeSchemes = []string{"http", "https", "mailto"}
+)
+
+// Sanitize escapes raw HTML and neutralizes links whose scheme is not
+// http, https or mailto. Code spans and fenced code blocks are left untouched because
+// renderers never interpret them.
+func Sanitize(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, strings.ReplaceAll(text, "\r\n", "\n"))
+
+	var b strings.Builder
+	forEachProse(text, func(chunk string, prose bool) {
+		if !prose {
+			b.WriteString(chunk)
+			return
+		}
+		for _, pattern := range []*regexp.Regexp{linkPattern, refPattern} {
+			chunk = pattern.ReplaceAllStringFunc(chunk, func(m string) string {
+				parts := pattern.FindStringSubmatch(m)
+				if !isSafeURL(parts[2] + parts[3]) {
+					return parts[1] + "#"
+				}
+				return m
+			})
+		}
+		b.WriteString(strings.ReplaceAll(chunk, "<", "&lt;"))
+	})
+	return b.String()
+}
+
+// Mentions returns the distinct usernames mentioned as @username outside
+// of code, in order of first appearance
+func Mentions(text string) []string {
+	var names []string
+	seen := map[string]bool{}
+	forEachProse(text, func(chunk string, prose bool) {
+		if !prose {
+			return
+		}
+		for _, m := range mentionPattern.FindAllStringSubmatch(chunk, -1) {
+			// Точка в конце - это конец предложения, а не часть имени
+			name := strings.TrimRight(m[2], ".-")
+			if name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	})
+	return names
+}
+
+// isSafeURL reports whether the link destination is relative or uses one of
+// safeSchemes. The destination is decoded the way a renderer and a browser
+// would see it: markdown backslash escapes, HTML entities, and whitespace or
+// control characters inside the scheme.
+func isSafeURL(url string) bool {
+	cleaned := url
+	for i := 0; i < 3; i++ {
+		// Обратный слеш вне схемы нам не важен, поэтому убираем все
+		decoded := html.UnescapeString(strings.ReplaceAll(cleaned, "\\", ""))
+		if decoded == cleaned {
+			break
+		}
+		cleaned = decoded
+	}
+	cleaned = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, cleaned)
+
+	end := strings.IndexAny(cleaned, ":/?#")
+	if end < 0 || cleaned[end] != ':' {
+		return true
+	}
+	return slices.Contains(safeSchemes, cleaned[:end])
+}
+
+// forEachProse splits text into code and non-code chunks: fenced blocks
+// (``` or ~~~) and inline code spans are passed with prose set to false
+func forEachProse(text string, fn func(chunk string, prose bool)) {
+	lines := strings.SplitAfter(text, "\n")
+	var prose strings.Builder
+	fence := ""
+	flush := func() {
+		if prose.Len() > 0 {
+			splitCodeSpans(prose.String(), fn)
+			prose.Reset()
+		}
+	}
+
+	for _, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if fence != "" {
+			fn(line, false)
+			if strings.HasPrefix(strings.TrimSpace(trimmed), fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			flush()
+			fence = trimmed[:3]
+			fn(line, false)
+			continue
+		}
+		prose.WriteString(line)
+	}
+	flush()
+}
+
+// splitCodeSpans separates `inline code` from the rest of a prose chunk
+func splitCodeSpans(text string, fn func(chunk string, prose bool)) {
+	for {
+		start := strings.IndexByte(text, '`')
+		if start < 0 {
+			break
+		}
+		n := 1
+		for start+n < len(text) && text[start+n] == '`' {
+			n++
+		}
+		ticks := text[start : start+n]
+		end := strings.Index(text[start+n:], ticks)
+		if end < 0 {
+			break
+		}
+		end += start + 2*n
+		if start > 0 {
+			fn(text[:start], true)
+		}
+		fn(text[start:end], false)
+		text = text[end:]
+	}
+	if text != "" {
+		fn(text, true)
+	}
+}
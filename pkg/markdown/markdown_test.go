@@ -0,0 +1,89 @@
+package markdown
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeLinks(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"[x](https://example.com)", "[x](https://example.com)"},
+		{"[x](http://example.com/a?b=c#d)", "[x](http://example.com/a?b=c#d)"},
+		{"[x](mailto:me@example.com)", "[x](mailto:me@example.com)"},
+		{"[x](/lists/1)", "[x](/lists/1)"},
+		{"[x](../a:b)", "[x](../a:b)"},
+		{"[x](#top)", "[x](#top)"},
+		{"[x](?q=a:b)", "[x](?q=a:b)"},
+		{"![img](HTTPS://example.com/a.png)", "![img](HTTPS://example.com/a.png)"},
+
+		{"[x](javascript:alert(1))", "[x](#))"},
+		{"[x](JavaScript:alert(1))", "[x](#))"},
+		{"[x](java&#115;cript:alert(1))", "[x](#))"},
+		{"[x](javascript&colon;alert(1))", "[x](#))"},
+		{"[x](javascript&#58;alert(1))", "[x](#))"},
+		{"[x](javascript&#x3A;alert(1))", "[x](#))"},
+		{"[x](javascript&#X3a;alert(1))", "[x](#))"},
+		{"[x](javascript&#0000058alert(1))", "[x](#))"},
+		{"[x](javascript\\:alert(1))", "[x](#))"},
+		{"[x](java\\script:alert(1))", "[x](#))"},
+		{"[x](&#106;avascript:alert(1))", "[x](#))"},
+		{"[x](<javascript:alert(1)>)", "[x](#)"},
+		{"[x](<java\tscript:alert(1)>)", "[x](#)"},
+		{"[a [b] c](javascript:alert(1))", "[a [b] c](#))"},
+		{"[![img](x.png)](javascript:alert(1))", "[![img](x.png)](#))"},
+		{"[![img](javascript:alert(1))](https://example.com)", "[![img](#))](https://example.com)"},
+		{"[`code`](javascript:alert(1))", "[`code`](#))"},
+		{"[a\\](javascript:alert(1))", "[a\\](#))"},
+		{"[x](vbscript:msgbox(1))", "[x](#))"},
+		{"[x](data:text/html;base64,PHNjcmlwdD4=)", "[x](#)"},
+		{"[x](file:///etc/passwd)", "[x](#)"},
+		{"[x](:alert(1))", "[x](#))"},
+		{"![img](javascript:alert(1))", "![img](#))"},
+		{"[ref]: javascript:alert(1)", "[ref]: #"},
+		{"[ref]: java&#115;cript:alert(1)", "[ref]: #"},
+		{"[ref]:\n  javascript:alert(1)", "[ref]:\n  #"},
+		{"[ref]: <javascript:alert(1)>", "[ref]: #"},
+		{"[a\\]b]: javascript:alert(1)", "[a\\]b]: #"},
+		{"[ref]: https://example.com", "[ref]: https://example.com"},
+	}
+	for _, tt := range tests {
+		if got := Sanitize(tt.in); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeHTMLAndCode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"<script>alert(1)</script>", "&lt;script>alert(1)&lt;/script>"},
+		{"a\r\nb\x00c", "a\nbc"},
+		{"`<b>` and [x](javascript:1)", "`<b>` and [x](#)"},
+		{"```\n<b>[x](javascript:1)\n```\n<i>", "```\n<b>[x](javascript:1)\n```\n&lt;i>"},
+	}
+	for _, tt := range tests {
+		if got := Sanitize(tt.in); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"hi @bob and @alice.", []string{"bob", "alice"}},
+		{"@bob @bob", []string{"bob"}},
+		{"mail me@example.com", nil},
+		{"`@bob` @carol", []string{"carol"}},
+	}
+	for _, tt := range tests {
+		if got := Mentions(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Mentions(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,22 +1,48 @@
 package middleware
 
 import (
-	"RestAPI/pkg/utils"
+	"RestAPI/internal/apperrors"
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func JWTMiddleware(secretKey string) echo.MiddlewareFunc {
+// AccessToken is what an OAuth2 access token of a third-party app grants
+type AccessToken struct {
+	UserID   int
+	ClientID string
+	Scopes   []string
+}
+
+// JWTMiddleware authenticates the request with the JWT of a login session.
+// With lookup it also accepts the opaque access tokens of third-party apps;
+// lookup returns nil for unknown ones.
+func JWTMiddleware(secretKey string, lookup func(token string) (*AccessToken, error)) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authHeader := c.Request().Header.Get("Authorization")
 			if authHeader == "" || len(authHeader) < 7 || authHeader[:7] != "Bearer " {
-				return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Missing or invalid token")
+				return apperrors.ErrUnauthorized
 			}
 
 			tokenString := authHeader[7:]
+			// В JWT три части через точку, в токенах приложений точек нет
+			if lookup != nil && strings.Count(tokenString, ".") != 2 {
+				access, err := lookup(tokenString)
+				if err != nil {
+					return err
+				}
+				if access == nil {
+					return apperrors.ErrUnauthorized.WithMessage("Invalid token")
+				}
+				c.Set("user_id", float64(access.UserID))
+				c.Set("oauth_client_id", access.ClientID)
+				c.Set("oauth_scopes", access.Scopes)
+				return next(c)
+			}
 			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 					return nil, jwt.ErrInvalidKey
@@ -24,16 +50,115 @@ func JWTMiddleware(secretKey string) echo.MiddlewareFunc {
 				return []byte(secretKey), nil
 			})
 			if err != nil || !token.Valid {
-				return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid token")
+				return apperrors.ErrUnauthorized.WithMessage("Invalid token")
 			}
 
 			claims, ok := token.Claims.(jwt.MapClaims)
 			if !ok {
-				return utils.JSONResponse(c, http.StatusUnauthorized, "error", "Invalid token claims")
+				return apperrors.ErrUnauthorized.WithMessage("Invalid token claims")
 			}
 
 			// Сохраняем user_id в контексте, для использования в обработчиках
 			c.Set("user_id", claims["user_id"])
+			if sid, ok := claims["sid"].(string); ok {
+				c.Set("session_id", sid)
+			}
+			if authTime, ok := claims["auth_time"].(float64); ok {
+				c.Set("auth_time", int64(authTime))
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireScope limits the requests of third-party apps to the routes their
+// scopes grant. scopes returns the scopes any of which grants the route, none
+// for the routes apps cannot use. Login session tokens are not limited.
+func RequireScope(scopes func(method, path string) []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			granted, ok := c.Get("oauth_scopes").([]string)
+			if !ok {
+				return next(c)
+			}
+			for _, scope := range scopes(c.Request().Method, c.Path()) {
+				if slices.Contains(granted, scope) {
+					return next(c)
+				}
+			}
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="insufficient_scope"`)
+			return apperrors.ErrInsufficientScope
+		}
+	}
+}
+
+// RequireAdmin lets the request through only if isAdmin reports true for
+// the user authenticated by JWTMiddleware
+func RequireAdmin(isAdmin func(userID int) (bool, error)) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := c.Get("user_id").(float64)
+			if !ok {
+				return apperrors.ErrUnauthorized
+			}
+			admin, err := isAdmin(int(userID))
+			if err != nil {
+				return err
+			}
+			if !admin {
+				return apperrors.ErrForbidden
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireActive rejects the tokens of users that were deleted or are
+// waiting for deletion, though the tokens themselves are still valid
+func RequireActive(isActive func(userID int) (bool, error)) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := c.Get("user_id").(float64)
+			if !ok {
+				return apperrors.ErrUnauthorized
+			}
+			active, err := isActive(int(userID))
+			if err != nil {
+				return err
+			}
+			if !active {
+				return apperrors.ErrUnauthorized.WithMessage("The account is deleted or scheduled for deletion")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireVerified refuses the requests of users whose email is not verified,
+// except those to the allowed routes. With readOnly they may still make
+// GET and HEAD requests.
+func RequireVerified(isVerified func(userID int) (bool, error), readOnly bool, allowed ...string) echo.MiddlewareFunc {
+	allowedPaths := make(map[string]bool, len(allowed))
+	for _, path := range allowed {
+		allowedPaths[path] = true
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := c.Request().Method
+			if allowedPaths[c.Path()] || readOnly && (method == http.MethodGet || method == http.MethodHead) {
+				return next(c)
+			}
+			userID, ok := c.Get("user_id").(float64)
+			if !ok {
+				return apperrors.ErrUnauthorized
+			}
+			verified, err := isVerified(int(userID))
+			if err != nil {
+				return err
+			}
+			if !verified {
+				return apperrors.ErrEmailNotVerified
+			}
 			return next(c)
 		}
 	}
@@ -0,0 +1,339 @@
+// Package fakeidp is an in-process OpenID Connect provider, so the relying
+// party can be exercised without a real identity provider or a browser:
+//
+//	idp := fakeidp.New("client-id", "client-secret")
+//	srv := httptest.NewServer(idp)
+//	idp.SignIn(fakeidp.User{Subject: "42", Email: "john@example.com", EmailVerified: true})
+//	callback, _ := idp.Authorize(authURL) // authURL from oidc.Provider.AuthCodeURL
+//
+// /authorize approves at once as the signed in user and redirects back with
+// a code; /token checks the client secret, redirect URI and PKCE verifier
+// and answers with an RS256 ID token. Codes are single use.
+package fakeidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// How long an authorization code can be exchanged
+const codeTTL = time.Minute
+
+// User is the account signed in at the provider
+type User struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+type grant struct {
+	user        User
+	redirectURI string
+	nonce       string
+	challenge   string
+	expiresAt   time.Time
+}
+
+// Server is the provider. Its fields may be changed between requests.
+type Server struct {
+	ClientID     string
+	ClientSecret string
+	// Issuer defaults to the scheme and host the request was sent to
+	Issuer string
+	// ModifyClaims, when set, changes the claims of every ID token before
+	// it is signed, e.g. to expire it or change its audience
+	ModifyClaims func(claims jwt.MapClaims)
+
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu     sync.Mutex
+	user   *User
+	codes  map[string]grant
+	issued int
+}
+
+// New starts a provider with a fresh 2048-bit signing key
+func New(clientID, clientSecret string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("fakeidp: generate key: %v", err))
+	}
+	return &Server{ClientID: clientID, ClientSecret: clientSecret, key: key, keyID: randomString(8), codes: map[string]grant{}}
+}
+
+// SignIn makes the user approve the next authorization requests
+func (s *Server) SignIn(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = &user
+}
+
+// SignOut makes authorization requests fail with login_required
+func (s *Server) SignOut() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = nil
+}
+
+// RotateKey signs the next tokens with a new key under a new key id
+func (s *Server) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("fakeidp: generate key: %v", err))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key, s.keyID = key, randomString(8)
+}
+
+// IssuedTokens returns how many ID tokens were issued
+func (s *Server) IssuedTokens() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// Authorize plays the browser: it sends the authorization URL to the
+// provider and returns the URL the provider redirects back to
+func (s *Server) Authorize(authURL string) (string, error) {
+	req := httptest.NewRequest(http.MethodGet, authURL, nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		return "", fmt.Errorf("fakeidp: authorize answered %d: %s", rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+	return rec.Header().Get("Location"), nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		s.discovery(w, r)
+	case "/authorize":
+		s.authorize(w, r)
+	case "/token":
+		s.token(w, r)
+	case "/jwks":
+		s.jwks(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) issuer(r *http.Request) string {
+	if s.Issuer != "" {
+		return s.Issuer
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := s.issuer(r)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	target, err := url.Parse(redirectURI)
+	// Без проверенного redirect_uri ошибку нельзя вернуть редиректом
+	if q.Get("client_id") != s.ClientID || err != nil || !target.IsAbs() {
+		http.Error(w, "invalid client_id or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	redirect := func(params url.Values) {
+		params.Set("state", q.Get("state"))
+		target.RawQuery = params.Encode()
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	}
+
+	switch {
+	case q.Get("response_type") != "code":
+		redirect(url.Values{"error": {"unsupported_response_type"}})
+		return
+	case !strings.Contains(" "+q.Get("scope")+" ", " openid "):
+		redirect(url.Values{"error": {"invalid_scope"}})
+		return
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		redirect(url.Values{"error": {"invalid_request"}, "error_description": {"PKCE with S256 is required"}})
+		return
+	}
+
+	s.mu.Lock()
+	user := s.user
+	code := randomString(16)
+	if user != nil {
+		s.codes[code] = grant{
+			user:        *user,
+			redirectURI: redirectURI,
+			nonce:       q.Get("nonce"),
+			challenge:   q.Get("code_challenge"),
+			expiresAt:   time.Now().Add(codeTTL),
+		}
+	}
+	s.mu.Unlock()
+	if user == nil {
+		redirect(url.Values{"error": {"login_required"}})
+		return
+	}
+	redirect(url.Values{"code": {code}})
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.ClientID || secret != s.ClientSecret {
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	s.mu.Lock()
+	code := r.PostForm.Get("code")
+	g, found := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+	switch {
+	case !found || time.Now().After(g.expiresAt):
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	case r.PostForm.Get("redirect_uri") != g.redirectURI:
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match")
+		return
+	case s256(r.PostForm.Get("code_verifier")) != g.challenge:
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            s.issuer(r),
+		"sub":            g.user.Subject,
+		"aud":            s.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	if g.user.Name != "" {
+		claims["name"] = g.user.Name
+	}
+	if g.user.PreferredUsername != "" {
+		claims["preferred_username"] = g.user.PreferredUsername
+	}
+	if s.ModifyClaims != nil {
+		s.ModifyClaims(claims)
+	}
+
+	s.mu.Lock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	idToken, err := token.SignedString(s.key)
+	s.issued++
+	s.mu.Unlock()
+	if err != nil {
+		oauthError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(16),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter) {
+	s.mu.Lock()
+	pub, kid := s.key.PublicKey, s.keyID
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	body := map[string]string{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,217 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Unknown key ids refetch the key set at most this often, as providers
+// rotate keys but a forged kid should not hammer them
+const jwksRefresh = time.Minute
+
+// Only asymmetric algorithms: "none" and HMAC with the client secret are refused
+var signingAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// IDToken holds the checked claims of an ID token
+type IDToken struct {
+	Issuer            string
+	Subject           string
+	Audience          []string
+	Expiry            time.Time
+	IssuedAt          time.Time
+	Nonce             string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	// All claims, for those specific to a provider
+	Claims map[string]interface{}
+}
+
+type keySet struct {
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// jwk is a JSON Web Key (RFC 7517) of type RSA or EC
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// VerifyIDToken checks the signature, issuer, audience, times and nonce of
+// an ID token (OpenID Connect Core 1.0, section 3.1.3.7)
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: signingAlgs, SkipClaimsValidation: true}
+	_, err = parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, metadata.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	token := &IDToken{Claims: claims}
+	token.Issuer, _ = claims["iss"].(string)
+	token.Subject, _ = claims["sub"].(string)
+	token.Nonce, _ = claims["nonce"].(string)
+	token.Email, _ = claims["email"].(string)
+	token.Name, _ = claims["name"].(string)
+	token.PreferredUsername, _ = claims["preferred_username"].(string)
+	// Некоторые провайдеры присылают email_verified строкой
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		token.EmailVerified = v
+	case string:
+		token.EmailVerified = v == "true"
+	}
+	switch aud := claims["aud"].(type) {
+	case string:
+		token.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				token.Audience = append(token.Audience, s)
+			}
+		}
+	}
+
+	now := p.now()
+	exp, hasExp := numericDate(claims["exp"])
+	iat, hasIat := numericDate(claims["iat"])
+	token.Expiry, token.IssuedAt = exp, iat
+	switch {
+	case token.Issuer != metadata.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q is not %q", ErrInvalidIDToken, token.Issuer, metadata.Issuer)
+	case token.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	case !contains(token.Audience, p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidIDToken)
+	case len(token.Audience) > 1 && claims["azp"] != p.cfg.ClientID:
+		return nil, fmt.Errorf("%w: authorized party is not this client", ErrInvalidIDToken)
+	case !hasExp || now.After(exp.Add(clockSkew)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case !hasIat || iat.After(now.Add(clockSkew)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case token.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && nbf.After(now.Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidIDToken)
+	}
+	return token, nil
+}
+
+// key returns the verification key with the id, refreshing the key set
+// when the id is unknown
+func (p *Provider) key(ctx context.Context, jwksURI, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys.lookup(kid); ok {
+		return key, nil
+	}
+	if !p.keys.fetchedAt.IsZero() && p.now().Sub(p.keys.fetchedAt) < jwksRefresh {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	p.keys.fetchedAt = p.now()
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("fetch keys: %w", err)
+	}
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Ключи неизвестных типов пропускаем, провайдер может публиковать и другие
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	p.keys.keys = keys
+	if key, ok := p.keys.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// lookup finds the key by id; a token without kid is accepted when the set
+// has a single key
+func (s keySet) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid EC point")
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("EC point is not on the curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func numericDate(v interface{}) (time.Time, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
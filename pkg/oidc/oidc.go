@@ -0,0 +1,278 @@
+// Package oidc is an OpenID Connect relying party: provider discovery, the
+// authorization code flow with PKCE (RFC 7636) and validation of ID tokens
+// against the provider's published keys. A Provider talks to one identity
+// provider and caches what it discovers about it.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Clock difference tolerated when checking token times
+	clockSkew = time.Minute
+	// Responses of the provider larger than this are refused
+	maxResponseSize = 1 << 20
+	// A failed discovery is retried at the earliest after this
+	discoveryRetry = 10 * time.Second
+)
+
+var (
+	ErrInvalidIDToken = errors.New("oidc: invalid id token")
+	ErrDiscovery      = errors.New("oidc: discovery failed")
+)
+
+// Error is an error response of the token endpoint (RFC 6749, section 5.2)
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oidc: %s: %s", e.Code, e.Description)
+	}
+	return "oidc: " + e.Code
+}
+
+// Config describes a client registered at an identity provider
+type Config struct {
+	// Issuer URL, the discovery document is read from
+	// Issuer + "/.well-known/openid-configuration"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Callback the provider redirects to, as registered
+	RedirectURL string
+	// Requested scopes; openid is always added
+	Scopes []string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// Metadata is the part of the discovery document the relying party uses
+type Metadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// Token is a successful answer of the token endpoint
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Provider is the relying party of one identity provider. It is safe for
+// concurrent use.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	mu           sync.Mutex
+	metadata     *Metadata
+	discoveredAt time.Time
+	keys         keySet
+}
+
+// NewProvider checks the configuration; the provider is contacted only
+// when it is first needed
+func NewProvider(cfg Config) (*Provider, error) {
+	issuer, err := url.Parse(cfg.Issuer)
+	if err != nil || issuer.Scheme == "" || issuer.Host == "" {
+		return nil, fmt.Errorf("oidc: invalid issuer %q", cfg.Issuer)
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("oidc: client id is required")
+	}
+	if _, err := url.ParseRequestURI(cfg.RedirectURL); err != nil {
+		return nil, fmt.Errorf("oidc: invalid redirect url %q", cfg.RedirectURL)
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Provider{cfg: cfg, client: client, now: time.Now}, nil
+}
+
+// Metadata returns the discovery document, fetching it on first use
+func (p *Provider) Metadata(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	if !p.discoveredAt.IsZero() && p.now().Sub(p.discoveredAt) < discoveryRetry {
+		return nil, fmt.Errorf("%w: retrying later", ErrDiscovery)
+	}
+	p.discoveredAt = p.now()
+
+	var metadata Metadata
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	// OpenID Connect Discovery 1.0, section 4.3
+	if metadata.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrDiscovery, metadata.Issuer, p.cfg.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: endpoints are missing", ErrDiscovery)
+	}
+	if len(metadata.CodeChallengeMethodsSupported) > 0 && !contains(metadata.CodeChallengeMethodsSupported, "S256") {
+		return nil, fmt.Errorf("%w: the provider does not support PKCE with S256", ErrDiscovery)
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// AuthCodeURL returns the URL to send the browser to. state and nonce
+// must be unguessable; challenge is the S256 challenge of the PKCE verifier.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := []string{"openid"}
+	for _, scope := range p.cfg.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return metadata.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens. The answer must carry
+// an ID token; check it with VerifyIDToken.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	// client_secret_basic по умолчанию, client_secret_post - если провайдер принимает только его
+	methods := metadata.TokenEndpointAuthMethodsSupported
+	usePost := p.cfg.ClientSecret != "" && len(methods) > 0 && !contains(methods, "client_secret_basic") && contains(methods, "client_secret_post")
+	if p.cfg.ClientSecret == "" || usePost {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	if usePost {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" && !usePost {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr Error
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Code != "" {
+			return nil, &oauthErr
+		}
+		return nil, fmt.Errorf("oidc: token endpoint answered %s", resp.Status)
+	}
+	var token Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("oidc: token response: %w", err)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: the token response has no id_token", ErrInvalidIDToken)
+	}
+	return &token, nil
+}
+
+// getJSON fetches a JSON document of the provider
+func (p *Provider) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s answered %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+// NewPKCE returns a random code verifier and its S256 challenge
+func NewPKCE() (verifier, challenge string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	return verifier, S256Challenge(verifier), nil
+}
+
+// S256Challenge derives the PKCE challenge of a verifier
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
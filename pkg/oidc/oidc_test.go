@@ -0,0 +1,338 @@
+package oidc
+
+import (
+	"RestAPI/pkg/oidc/fakeidp"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	clientID     = "client-id"
+	clientSecret = "client-secret"
+	redirectURL  = "https://app.example.com/callback"
+)
+
+// clock is a settable time source for the provider
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newTestProvider(t *testing.T) (*Provider, *fakeidp.Server, *clock) {
+	t.Helper()
+	idp := fakeidp.New(clientID, clientSecret)
+	srv := httptest.NewServer(idp)
+	t.Cleanup(srv.Close)
+	p, err := NewProvider(Config{
+		Issuer:       srv.URL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email", "openid", "profile"},
+		Client:       srv.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &clock{now: time.Now()}
+	p.now = c.Now
+	idp.SignIn(fakeidp.User{Subject: "42", Email: "john@example.com", EmailVerified: true, Name: "John", PreferredUsername: "john"})
+	return p, idp, c
+}
+
+// signIn runs the code flow up to the token response
+func signIn(t *testing.T, p *Provider, idp *fakeidp.Server, nonce string) *Token {
+	t.Helper()
+	ctx := context.Background()
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(ctx, "state", nonce, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := p.Exchange(ctx, authorize(t, idp, authURL), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// authorize returns the code the provider redirects back with
+func authorize(t *testing.T, idp *fakeidp.Server, authURL string) string {
+	t.Helper()
+	callback, err := idp.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("state") != "state" {
+		t.Fatalf("state not returned: %s", callback)
+	}
+	code := u.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in %s", callback)
+	}
+	return code
+}
+
+func TestNewProvider(t *testing.T) {
+	for _, cfg := range []Config{
+		{Issuer: "issuer", ClientID: clientID, RedirectURL: redirectURL},
+		{Issuer: "https://idp.example.com", RedirectURL: redirectURL},
+		{Issuer: "https://idp.example.com", ClientID: clientID, RedirectURL: "callback"},
+	} {
+		if _, err := NewProvider(cfg); err == nil {
+			t.Errorf("NewProvider(%+v) succeeded", cfg)
+		}
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	p, _, _ := newTestProvider(t)
+	ctx := context.Background()
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Issuer != p.cfg.Issuer || metadata.TokenEndpoint != p.cfg.Issuer+"/token" || metadata.JWKSURI != p.cfg.Issuer+"/jwks" {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+
+	// Провайдер, выдающий себя за другого, не принимается
+	p, idp, c := newTestProvider(t)
+	idp.Issuer = "https://other.example.com"
+	if _, err := p.Metadata(ctx); !errors.Is(err, ErrDiscovery) {
+		t.Fatalf("error = %v, want %v", err, ErrDiscovery)
+	}
+	idp.Issuer = ""
+	if _, err := p.Metadata(ctx); !errors.Is(err, ErrDiscovery) {
+		t.Fatalf("discovery was retried at once: %v", err)
+	}
+	c.now = c.now.Add(discoveryRetry)
+	if _, err := p.Metadata(ctx); err != nil {
+		t.Fatalf("discovery was not retried: %v", err)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p, _, _ := newTestProvider(t)
+	authURL, err := p.AuthCodeURL(context.Background(), "the-state", "the-nonce", "the-challenge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             clientID,
+		"redirect_uri":          redirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        "the-challenge",
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+}
+
+func TestCodeFlow(t *testing.T) {
+	p, idp, _ := newTestProvider(t)
+	token := signIn(t, p, idp, "nonce")
+	idToken, err := p.VerifyIDToken(context.Background(), token.IDToken, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idToken.Subject != "42" || idToken.Email != "john@example.com" || !idToken.EmailVerified ||
+		idToken.Name != "John" || idToken.PreferredUsername != "john" || idToken.Nonce != "nonce" {
+		t.Errorf("unexpected claims %+v", idToken)
+	}
+}
+
+func TestExchangeErrors(t *testing.T) {
+	ctx := context.Background()
+	p, idp, _ := newTestProvider(t)
+	verifier, challenge, _ := NewPKCE()
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var oauthErr *Error
+	code := authorize(t, idp, authURL)
+	if _, err := p.Exchange(ctx, code, verifier+"x"); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Errorf("wrong verifier: error = %v, want invalid_grant", err)
+	}
+	// Код одноразовый, даже если первая попытка не удалась
+	if _, err := p.Exchange(ctx, code, verifier); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Errorf("reused code: error = %v, want invalid_grant", err)
+	}
+
+	code = authorize(t, idp, authURL)
+	if _, err := p.Exchange(ctx, code, verifier); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(ctx, code, verifier); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Errorf("exchanged code: error = %v, want invalid_grant", err)
+	}
+
+	idp.ClientSecret = "other"
+	code = authorize(t, idp, authURL)
+	if _, err := p.Exchange(ctx, code, verifier); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_client" {
+		t.Errorf("wrong secret: error = %v, want invalid_client", err)
+	}
+
+	idp.SignOut()
+	callback, err := idp.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(callback, "error=login_required") {
+		t.Errorf("signed out: callback %s", callback)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+		nonce  string
+		ok     bool
+	}{
+		{"valid", nil, "nonce", true},
+		{"wrong nonce", nil, "other", false},
+		{"no nonce", func(c jwt.MapClaims) { delete(c, "nonce") }, "nonce", false},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, "nonce", false},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }, "nonce", false},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }, "nonce", false},
+		{"audience list", func(c jwt.MapClaims) { c["aud"] = []string{"other-client", clientID}; c["azp"] = clientID }, "nonce", true},
+		{"audience list without azp", func(c jwt.MapClaims) { c["aud"] = []string{"other-client", clientID} }, "nonce", false},
+		{"audience list with other azp", func(c jwt.MapClaims) { c["aud"] = []string{"other-client", clientID}; c["azp"] = "other-client" }, "nonce", false},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }, "nonce", false},
+		{"expired within skew", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-30 * time.Second).Unix() }, "nonce", true},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, "nonce", false},
+		{"issued in the future", func(c jwt.MapClaims) { c["iat"] = time.Now().Add(5 * time.Minute).Unix() }, "nonce", false},
+		{"not valid yet", func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(5 * time.Minute).Unix() }, "nonce", false},
+		{"email_verified as string", func(c jwt.MapClaims) { c["email_verified"] = "true" }, "nonce", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, idp, _ := newTestProvider(t)
+			idp.ModifyClaims = tt.modify
+			token := signIn(t, p, idp, "nonce")
+			idToken, err := p.VerifyIDToken(ctx, token.IDToken, tt.nonce)
+			if !tt.ok {
+				if !errors.Is(err, ErrInvalidIDToken) {
+					t.Fatalf("error = %v, want %v", err, ErrInvalidIDToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !idToken.EmailVerified {
+				t.Error("email is not verified")
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenSignature(t *testing.T) {
+	ctx := context.Background()
+	p, idp, _ := newTestProvider(t)
+	raw := signIn(t, p, idp, "nonce").IDToken
+	parts := strings.Split(raw, ".")
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(raw, claims); err != nil {
+		t.Fatal(err)
+	}
+	hs256, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(clientSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Подпись от другого токена с теми же заголовками
+	other := strings.Split(signIn(t, p, idp, "other").IDToken, ".")
+
+	for name, token := range map[string]string{
+		"signature of another token": parts[0] + "." + parts[1] + "." + other[2],
+		"changed claims":             parts[0] + "." + other[1] + "." + parts[2],
+		"no signature":               parts[0] + "." + parts[1] + ".",
+		"HS256 with the secret":      hs256,
+		"alg none":                   none,
+		"not a JWT":                  "not-a-jwt",
+	} {
+		if _, err := p.VerifyIDToken(ctx, token, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: error = %v, want %v", name, err, ErrInvalidIDToken)
+		}
+	}
+	if _, err := p.VerifyIDToken(ctx, raw, "nonce"); err != nil {
+		t.Fatalf("the original token: %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	p, idp, c := newTestProvider(t)
+	before := signIn(t, p, idp, "nonce").IDToken
+	if _, err := p.VerifyIDToken(ctx, before, "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	fetchedAt := p.keys.fetchedAt
+
+	idp.RotateKey()
+	after := signIn(t, p, idp, "nonce").IDToken
+	// Неизвестный kid сразу после загрузки ключей не перечитывает их
+	if _, err := p.VerifyIDToken(ctx, after, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("error = %v, want %v", err, ErrInvalidIDToken)
+	}
+	if !p.keys.fetchedAt.Equal(fetchedAt) {
+		t.Fatal("keys were refetched within jwksRefresh")
+	}
+
+	c.now = c.now.Add(jwksRefresh)
+	if _, err := p.VerifyIDToken(ctx, after, "nonce"); err != nil {
+		t.Fatalf("token of the new key: %v", err)
+	}
+	// Старый ключ больше не опубликован
+	c.now = c.now.Add(jwksRefresh)
+	if _, err := p.VerifyIDToken(ctx, before, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("token of the retired key: error = %v, want %v", err, ErrInvalidIDToken)
+	}
+}
+
+func TestS256Challenge(t *testing.T) {
+	// RFC 7636, appendix B
+	if got := S256Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("S256Challenge = %s", got)
+	}
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifier) < 43 || S256Challenge(verifier) != challenge {
+		t.Errorf("NewPKCE = %s, %s", verifier, challenge)
+	}
+}
@@ -0,0 +1,493 @@
+// Package qrcode encodes short byte strings, such as otpauth URIs, as QR
+// codes (ISO/IEC 18004) with medium error correction and renders them as
+// PNG images. Only byte mode and versions 1 to 10 are supported, which is
+// enough for up to 213 bytes.
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// MaxVersion is the largest symbol the package draws, 57×57 modules
+const MaxVersion = 10
+
+// Quiet zone around the symbol, in modules
+const quietZone = 4
+
+var ErrTooLong = errors.New("qrcode: data too long")
+
+// Error correction of level M: codewords per block and number of blocks by version
+var (
+	eccPerBlock = [MaxVersion + 1]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	eccBlocks   = [MaxVersion + 1]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+)
+
+// Code is an encoded QR symbol
+type Code struct {
+	Version int
+	// Size is the width and height in modules
+	Size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Encode builds the smallest QR code holding data, choosing the mask with
+// the lowest penalty
+func Encode(data []byte) (*Code, error) {
+	version := 0
+	for v := 1; v <= MaxVersion; v++ {
+		if 4+countBits(v)+8*len(data) <= dataCodewords(v)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	var bits bitBuffer
+	bits.append(0b0100, 4) // byte mode
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := dataCodewords(version) * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	c := newCode(version)
+	c.drawFunctionPatterns()
+	c.drawCodewords(addECCAndInterleave(bits.bytes(), version))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if p := c.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // XOR снимает маску обратно
+	}
+	c.applyMask(best)
+	c.drawFormatBits(best)
+	return c, nil
+}
+
+// Dark reports whether the module in column x and row y is dark
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// PNG renders the code with scale pixels per module and a quiet zone
+func (c *Code) PNG(scale int) ([]byte, error) {
+	if scale < 1 {
+		scale = 1
+	}
+	side := (c.Size + 2*quietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				row := img.Pix[((y+quietZone)*scale+dy)*img.Stride:]
+				for dx := 0; dx < scale; dx++ {
+					row[(x+quietZone)*scale+dx] = 1
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func newCode(version int) *Code {
+	size := version*4 + 17
+	c := &Code{Version: version, Size: size, modules: make([][]bool, size), isFunction: make([][]bool, size)}
+	for i := range c.modules {
+		c.modules[i] = make([]bool, size)
+		c.isFunction[i] = make([]bool, size)
+	}
+	return c
+}
+
+// countBits is the length of the character count of byte mode
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// rawModules counts the modules left for data and error correction once
+// the function patterns are drawn
+func rawModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+func dataCodewords(version int) int {
+	return rawModules(version)/8 - eccPerBlock[version]*eccBlocks[version]
+}
+
+// addECCAndInterleave splits the data into blocks, appends Reed-Solomon
+// codewords to each and interleaves them
+func addECCAndInterleave(data []byte, version int) []byte {
+	numBlocks, eccLen := eccBlocks[version], eccPerBlock[version]
+	raw := rawModules(version) / 8
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+
+	divisor := rsDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			// Короткие блоки выравниваем пустым байтом, при чередовании он пропускается
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, raw)
+	for i := 0; i <= shortLen; i++ {
+		for j, block := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// rsDivisor returns the generator polynomial of the given degree, highest
+// coefficient first and the leading 1 left out
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	positions := alignmentPositions(c.Version)
+	last := len(positions) - 1
+	for i, y := range positions {
+		for j, x := range positions {
+			// Углы заняты поисковыми узорами
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+func (c *Code) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || x >= c.Size || y < 0 || y >= c.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunction(x, y, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(cx, cy int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPositions returns the centre coordinates of the alignment patterns
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := (version*4 + n*2 + 1) / (n*2 - 2) * 2
+	result := make([]int, n)
+	result[0] = 6
+	for i, pos := n-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// drawFormatBits draws both copies of the error correction level (M) and
+// the mask
+func (c *Code) drawFormatBits(mask int) {
+	data := mask // уровень M кодируется нулями
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(bits, i))
+	}
+	c.setFunction(8, 7, bit(bits, 6))
+	c.setFunction(8, 8, bit(bits, 7))
+	c.setFunction(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(bits, i))
+	}
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(bits, i))
+	}
+	c.setFunction(8, c.Size-8, true)
+}
+
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	rem := c.Version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := c.Version<<12 | rem
+	for i := 0; i < 18; i++ {
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, bit(bits, i))
+		c.setFunction(b, a, bit(bits, i))
+	}
+}
+
+// drawCodewords fills the data area in the zigzag order of the standard
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunction[y][x] && i < len(data)*8 {
+					c.modules[y][x] = data[i>>3]>>(7-i&7)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by the mask; applying it twice
+// restores them
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.isFunction[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to read: long runs, 2×2 blocks,
+// finder-like patterns and an unbalanced share of dark modules
+func (c *Code) penalty() int {
+	result := 0
+	line := make([]bool, c.Size)
+	for _, vertical := range []bool{false, true} {
+		for i := 0; i < c.Size; i++ {
+			for j := 0; j < c.Size; j++ {
+				if vertical {
+					line[j] = c.modules[j][i]
+				} else {
+					line[j] = c.modules[i][j]
+				}
+			}
+			result += linePenalty(line)
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				m := c.modules[y][x]
+				if m == c.modules[y][x+1] && m == c.modules[y+1][x] && m == c.modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+	total := c.Size * c.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return result + max(k, 0)*10
+}
+
+// finderLike is the 1:1:3:1:1 pattern with four light modules on one side
+var finderLike = [][]bool{
+	{false, false, false, false, true, false, true, true, true, false, true},
+	{true, false, true, true, true, false, true, false, false, false, false},
+}
+
+func linePenalty(line []bool) int {
+	result := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			result += 3 + run - 5
+		}
+		run = 1
+	}
+	// За пределами символа - светлая зона
+	at := func(i int) bool { return i >= 0 && i < len(line) && line[i] }
+	for start := -4; start < len(line); start++ {
+		for _, pattern := range finderLike {
+			match := true
+			for k, dark := range pattern {
+				if at(start+k) != dark {
+					match = false
+					break
+				}
+			}
+			if match {
+				result += 40
+			}
+		}
+	}
+	return result
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 == 1)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, set := range b {
+		if set {
+			result[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+	return result
+}
+
+func bit(x, i int) bool {
+	return x>>i&1 == 1
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,242 @@
+// Package rank generates fractional-index ordering keys. Keys compare
+// bytewise, so a new key can be placed between any two neighbours without
+// touching other rows. A key is an integer part (a head character encoding
+// its length followed by digits) and an optional fraction without trailing
+// zeros; appending at either end only increments the integer part, which
+// keeps keys short.
+package rank
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// MaxLength is the key length above which callers should rebalance
+	MaxLength = 24
+)
+
+var (
+	ErrInvalidKey = errors.New("rank: invalid key")
+	ErrOrder      = errors.New("rank: lower key must sort before upper key")
+	ErrExhausted  = errors.New("rank: key space exhausted")
+
+	smallestInteger = "A" + strings.Repeat("0", 26)
+)
+
+// Between returns a key that sorts strictly between a and b. An empty a
+// means "before everything", an empty b means "after everything".
+func Between(a, b string) (string, error) {
+	if a != "" {
+		if err := validate(a); err != nil {
+			return "", err
+		}
+	}
+	if b != "" {
+		if err := validate(b); err != nil {
+			return "", err
+		}
+	}
+	if a != "" && b != "" && a >= b {
+		return "", ErrOrder
+	}
+
+	switch {
+	case a == "" && b == "":
+		return "a0", nil
+	case a == "":
+		ib := integerPart(b)
+		fb := b[len(ib):]
+		if ib == smallestInteger {
+			return ib + midpoint("", fb, true), nil
+		}
+		if ib < b {
+			return ib, nil
+		}
+		res, ok := decrementInteger(ib)
+		if !ok {
+			return "", ErrExhausted
+		}
+		return res, nil
+	case b == "":
+		ia := integerPart(a)
+		fa := a[len(ia):]
+		if i, ok := incrementInteger(ia); ok {
+			return i, nil
+		}
+		return ia + midpoint(fa, "", false), nil
+	}
+
+	ia := integerPart(a)
+	fa := a[len(ia):]
+	ib := integerPart(b)
+	fb := b[len(ib):]
+	if ia == ib {
+		return ia + midpoint(fa, fb, true), nil
+	}
+	i, ok := incrementInteger(ia)
+	if !ok {
+		return "", ErrExhausted
+	}
+	if i < b {
+		return i, nil
+	}
+	return ia + midpoint(fa, "", false), nil
+}
+
+// Sequence returns n increasing short keys, used to rebalance a whole list
+func Sequence(n int) []string {
+	keys := make([]string, 0, n)
+	prev := ""
+	for i := 0; i < n; i++ {
+		key, err := Between(prev, "")
+		if err != nil {
+			// Не бывает на практике: пространство ключей "a0".."z..." огромно
+			panic(err)
+		}
+		keys = append(keys, key)
+		prev = key
+	}
+	return keys
+}
+
+// midpoint returns a fraction between a and b (b is unbounded when hasB is false)
+func midpoint(a, b string, hasB bool) string {
+	if hasB {
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + midpoint(rest, b[n:], true)
+		}
+	}
+
+	digitA := 0
+	if a != "" {
+		digitA = strings.IndexByte(digits, a[0])
+	}
+	digitB := len(digits)
+	if hasB {
+		digitB = strings.IndexByte(digits, b[0])
+	}
+	if digitB-digitA > 1 {
+		return string(digits[(digitA+digitB+1)/2])
+	}
+	if hasB && len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if a != "" {
+		rest = a[1:]
+	}
+	return string(digits[digitA]) + midpoint(rest, "", false)
+}
+
+func digitAt(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return '0'
+}
+
+func integerLength(head byte) int {
+	switch {
+	case head >= 'a' && head <= 'z':
+		return int(head-'a') + 2
+	case head >= 'A' && head <= 'Z':
+		return int('Z'-head) + 2
+	}
+	return 0
+}
+
+func integerPart(key string) string {
+	return key[:integerLength(key[0])]
+}
+
+func validate(key string) error {
+	if key == "" || key == smallestInteger {
+		return ErrInvalidKey
+	}
+	n := integerLength(key[0])
+	if n == 0 || n > len(key) {
+		return ErrInvalidKey
+	}
+	for i := 1; i < len(key); i++ {
+		if strings.IndexByte(digits, key[i]) < 0 {
+			return ErrInvalidKey
+		}
+	}
+	if len(key) > n && key[len(key)-1] == '0' {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+func incrementInteger(x string) (string, bool) {
+	head := x[0]
+	digs := []byte(x[1:])
+	carry := true
+	for i := len(digs) - 1; carry && i >= 0; i-- {
+		d := strings.IndexByte(digits, digs[i]) + 1
+		if d == len(digits) {
+			digs[i] = '0'
+		} else {
+			digs[i] = digits[d]
+			carry = false
+		}
+	}
+	if !carry {
+		return string(head) + string(digs), true
+	}
+	switch head {
+	case 'Z':
+		return "a0", true
+	case 'z':
+		return "", false
+	}
+	h := head + 1
+	if h > 'a' {
+		digs = append(digs, '0')
+	} else {
+		digs = digs[:len(digs)-1]
+	}
+	return string(h) + string(digs), true
+}
+
+func decrementInteger(x string) (string, bool) {
+	head := x[0]
+	digs := []byte(x[1:])
+	borrow := true
+	for i := len(digs) - 1; borrow && i >= 0; i-- {
+		d := strings.IndexByte(digits, digs[i]) - 1
+		if d == -1 {
+			digs[i] = digits[len(digits)-1]
+		} else {
+			digs[i] = digits[d]
+			borrow = false
+		}
+	}
+	if !borrow {
+		return string(head) + string(digs), true
+	}
+	switch head {
+	case 'a':
+		return "Z" + string(digits[len(digits)-1]), true
+	case 'A':
+		return "", false
+	}
+	h := head - 1
+	if h < 'Z' {
+		digs = append(digs, digits[len(digits)-1])
+	} else {
+		digs = digs[:len(digs)-1]
+	}
+	return string(h) + string(digs), true
+}
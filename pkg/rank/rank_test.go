@@ -0,0 +1,150 @@
+package rank
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+		err  error
+	}{
+		{"", "", "a0", nil},
+		{"", "a0", "Zz", nil},
+		{"", "Zz", "Zy", nil},
+		{"a0", "", "a1", nil},
+		{"a1", "", "a2", nil},
+		{"az", "", "b00", nil},
+		{"Zz", "", "a0", nil},
+		{"bzz", "", "c000", nil},
+		{"a0", "a1", "a0V", nil},
+		{"a1", "a2", "a1V", nil},
+		{"a0V", "a1", "a0l", nil},
+		{"Zz", "a0", "ZzV", nil},
+		{"Zz", "a1", "a0", nil},
+		{"", "Y00", "Xzzz", nil},
+		{"a0", "a0V", "a0G", nil},
+		{"a0", "a0G", "a08", nil},
+		{"b125", "b129", "b127", nil},
+		{"a0", "a1V", "a1", nil},
+		{"Zz", "a01", "a0", nil},
+		{"", "a0V", "a0", nil},
+		{"", "b999", "b99", nil},
+		// Самый маленький целый ключ сам недопустим, но перед следующим есть место
+		{"", "A000000000000000000000000001", "A000000000000000000000000000V", nil},
+		{"zzzzzzzzzzzzzzzzzzzzzzzzzzy", "", "zzzzzzzzzzzzzzzzzzzzzzzzzzz", nil},
+		{"zzzzzzzzzzzzzzzzzzzzzzzzzzz", "", "zzzzzzzzzzzzzzzzzzzzzzzzzzzV", nil},
+
+		{"", "A00000000000000000000000000", "", ErrInvalidKey},
+		{"a00", "", "", ErrInvalidKey},
+		{"a00", "a1", "", ErrInvalidKey},
+		{"0", "1", "", ErrInvalidKey},
+		{"a", "", "", ErrInvalidKey},
+		{"a0!", "", "", ErrInvalidKey},
+		{"a1", "a0", "", ErrOrder},
+		{"a1", "a1", "", ErrOrder},
+	}
+	for _, tt := range tests {
+		got, err := Between(tt.a, tt.b)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Between(%q, %q) error = %v, want %v", tt.a, tt.b, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Between(%q, %q): %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Between(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// Keys must stay strictly ordered however they are inserted
+func TestBetweenKeepsOrder(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	keys := []string{}
+	for i := 0; i < 2000; i++ {
+		pos := rnd.Intn(len(keys) + 1)
+		a, b := "", ""
+		if pos > 0 {
+			a = keys[pos-1]
+		}
+		if pos < len(keys) {
+			b = keys[pos]
+		}
+		key, err := Between(a, b)
+		if err != nil {
+			t.Fatalf("Between(%q, %q): %v", a, b, err)
+		}
+		if (a != "" && key <= a) || (b != "" && key >= b) {
+			t.Fatalf("Between(%q, %q) = %q is out of order", a, b, key)
+		}
+		if err := validate(key); err != nil {
+			t.Fatalf("Between(%q, %q) = %q is not a valid key", a, b, key)
+		}
+		keys = append(keys[:pos], append([]string{key}, keys[pos:]...)...)
+	}
+}
+
+// Repeated inserts at the front and back only grow the integer part
+func TestBetweenAtEnds(t *testing.T) {
+	first, last := "a0", "a0"
+	for i := 0; i < 1000; i++ {
+		var err error
+		if first, err = Between("", first); err != nil {
+			t.Fatal(err)
+		}
+		if last, err = Between(last, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(first) > 4 || len(last) > 4 {
+		t.Errorf("keys grew to %q and %q", first, last)
+	}
+}
+
+// Inserting into the same gap grows the key by about one character per
+// six halvings, so MaxLength is reached only after many inserts
+func TestBetweenSameGap(t *testing.T) {
+	a, b := "a0", "a1"
+	n := 0
+	for ; len(b) <= MaxLength; n++ {
+		key, err := Between(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = key
+	}
+	if n < 100 {
+		t.Errorf("only %d inserts before reaching MaxLength", n)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	keys := Sequence(500)
+	if len(keys) != 500 {
+		t.Fatalf("got %d keys", len(keys))
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Fatal("keys are not sorted")
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i] == keys[i-1] {
+			t.Fatalf("duplicate key %q", keys[i])
+		}
+	}
+	if keys[0] != "a0" || strings.Compare(keys[len(keys)-1], "b00") <= 0 {
+		t.Errorf("unexpected range %q..%q", keys[0], keys[len(keys)-1])
+	}
+	if len(Sequence(0)) != 0 {
+		t.Error("Sequence(0) is not empty")
+	}
+}
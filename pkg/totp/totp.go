@@ -0,0 +1,103 @@
+// Package totp implements time-based one-time passwords (RFC 6238) as used
+// by authenticator apps: HMAC-SHA1, six digits and a 30 second period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// Secret length in bytes, as recommended by RFC 4226
+	secretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random secret in unpadded base32, the form
+// authenticator apps expect
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Counter returns the number of the period t falls into
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the password for the period t falls into
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, Counter(t)), nil
+}
+
+// Validate checks passcode against the periods within skew of t and returns
+// the counter of the matching one, so that callers can refuse a code that
+// was already used. ok is false when nothing matches.
+func Validate(secret, passcode string, t time.Time, skew int) (counter int64, ok bool, err error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false, err
+	}
+	passcode = strings.ReplaceAll(passcode, " ", "")
+	if len(passcode) != Digits {
+		return 0, false, nil
+	}
+	now := Counter(t)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		if subtle.ConstantTimeCompare([]byte(passcode), []byte(code(key, now+i))) == 1 {
+			return now + i, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// URI returns the otpauth:// link that authenticator apps read from QR codes
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period/time.Second)))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "="))
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("totp: invalid secret: %w", err)
+	}
+	return key, nil
+}
+
+// code is HOTP (RFC 4226) with dynamic truncation
+func code(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
@@ -0,0 +1,130 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The ASCII seed "12345678901234567890" of RFC 4226 and RFC 6238 in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B, SHA1; the codes are the last six of the eight digits
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+// RFC 4226 appendix D
+func TestHOTP(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	key, err := decodeSecret(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for counter, w := range want {
+		if got := code(key, int64(counter)); got != w {
+			t.Errorf("code(%d) = %s, want %s", counter, got, w)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	at := func(periods int) string {
+		c, err := Code(rfcSecret, now.Add(time.Duration(periods)*Period))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		name     string
+		passcode string
+		skew     int
+		ok       bool
+		offset   int64
+	}{
+		{"current", at(0), 1, true, 0},
+		{"previous within skew", at(-1), 1, true, -1},
+		{"next within skew", at(1), 1, true, 1},
+		{"two behind with skew 1", at(-2), 1, false, 0},
+		{"two ahead with skew 1", at(2), 1, false, 0},
+		{"two behind with skew 2", at(-2), 2, true, -2},
+		{"previous without skew", at(-1), 0, false, 0},
+		{"spaces are ignored", at(0)[:3] + " " + at(0)[3:], 0, true, 0},
+		{"wrong code", "000000", 1, false, 0},
+		{"too short", at(0)[:5], 1, false, 0},
+		{"too long", at(0) + "0", 1, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok, err := Validate(rfcSecret, tt.passcode, now, tt.skew)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && counter != Counter(now)+tt.offset {
+				t.Errorf("counter = %d, want %d", counter, Counter(now)+tt.offset)
+			}
+		})
+	}
+}
+
+func TestSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != secretSize {
+		t.Errorf("secret has %d bytes, want %d", len(key), secretSize)
+	}
+	// Apps show secrets in groups, lower case or padded
+	spaced := strings.ToLower(rfcSecret[:4] + " " + rfcSecret[4:] + "====")
+	a, err := Code(spaced, time.Unix(59, 0))
+	if err != nil || a != "287082" {
+		t.Errorf("Code with %q = %s, %v", spaced, a, err)
+	}
+	if _, err := Code("not base32!", time.Now()); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Todo App", "bob@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Todo App:bob@example.com" {
+		t.Errorf("unexpected URI %s", u)
+	}
+	q := u.Query()
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "Todo App" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("unexpected query %s", u.RawQuery)
+	}
+}
@@ -1,10 +1,11 @@
 package utils
 
 import (
+	"RestAPI/internal/apperrors"
 	"RestAPI/internal/responses"
-	"errors"
 	"github.com/labstack/echo/v4"
 	"strconv"
+	"time"
 )
 
 func JSONResponse(c echo.Context, statusCode int, status, message string) error {
@@ -17,11 +18,30 @@ func JSONResponse(c echo.Context, statusCode int, status, message string) error
 func GetParam(c echo.Context, key string) (int, error) {
 	idParam := c.Param(key)
 	if idParam == "" {
-		return 0, errors.New("id parameter is missing")
+		return 0, apperrors.ErrInvalidID.WithMessage(key + " parameter is missing")
 	}
 	id, err := strconv.Atoi(idParam)
-	if err != nil {
-		return 0, errors.New("invalid id parameter")
+	if err != nil || id <= 0 {
+		return 0, apperrors.ErrInvalidID.WithMessage("invalid " + key + " parameter")
 	}
 	return id, nil
 }
+
+// GetAuthTime returns when the login session of the request signed in, the
+// zero time for tokens without it
+func GetAuthTime(c echo.Context) time.Time {
+	authTime, ok := c.Get("auth_time").(int64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(authTime, 0)
+}
+
+// GetUserID returns the authenticated user's ID stored by JWTMiddleware
+func GetUserID(c echo.Context) (int, error) {
+	userID, ok := c.Get("user_id").(float64) // JWT Claims возвращают float64 для чисел
+	if !ok {
+		return 0, apperrors.ErrUnauthorized
+	}
+	return int(userID), nil
+}
@@ -1,19 +1,69 @@
 package validator
 
 import (
+	"RestAPI/internal/apperrors"
 	"github.com/go-playground/validator"
+	"reflect"
+	"strings"
 )
 
 type CustomValidator struct {
 	Validator *validator.Validate
 }
 
+// Validate checks the struct and reports failures as a validation error
+// with one entry per invalid field, named after its JSON key.
 func (cv *CustomValidator) Validate(i interface{}) error {
-	return cv.Validator.Struct(i)
+	err := cv.Validator.Struct(i)
+	if err == nil {
+		return nil
+	}
+	validationErrors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return apperrors.ErrInvalidBody.Wrap(err)
+	}
+
+	fields := make([]apperrors.FieldError, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		fields = append(fields, apperrors.Field(fieldPath(fe), fe.Tag(), message(fe)))
+	}
+	return apperrors.Validation(fields...)
 }
 
 func NewValidator() *CustomValidator {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || name == "" {
+			return field.Name
+		}
+		return name
+	})
 	return &CustomValidator{
-		Validator: validator.New(),
+		Validator: v,
+	}
+}
+
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		return "must be at most " + fe.Param()
+	case "min":
+		return "must be at least " + fe.Param()
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	}
+	return "is invalid"
+}
+
+// fieldPath returns the JSON path of the field without the root struct name,
+// e.g. "operations[0].op"
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
 	}
+	return fe.Field()
 }